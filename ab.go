@@ -0,0 +1,277 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// ABResult describes how the edges of configuration B differ from those of configuration A for a single input file.
+// Configuration A is the reference, so precision is the fraction of B's edge pixels that A found as well.
+type ABResult struct {
+	Input     string  `json:"input"`
+	EdgesA    int     `json:"edges_a"`
+	EdgesB    int     `json:"edges_b"`
+	Agreement float64 `json:"pixel_agreement"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+	IoU       float64 `json:"iou"`
+	Differs   bool    `json:"differs,omitempty"`
+	Reason    string  `json:"reason,omitempty"`
+}
+
+// ABSummary aggregates the results of an A/B comparison, the means are taken over all files that were compared.
+type ABSummary struct {
+	Compared  int        `json:"compared"`
+	Differing int        `json:"differing"`
+	Failed    int        `json:"failed"`
+	Agreement float64    `json:"mean_pixel_agreement"`
+	Precision float64    `json:"mean_precision"`
+	Recall    float64    `json:"mean_recall"`
+	F1        float64    `json:"mean_f1"`
+	MinF1     float64    `json:"min_f1"`
+	IoU       float64    `json:"mean_iou"`
+	Files     []ABResult `json:"files"`
+}
+
+// ABConfiguration is one side of an A/B comparison. Edges are detected in process with the parameters of the preset
+// unless a binary is given, e.g. an older release of edgeefy, which is run with the parameters of the preset as flags.
+type ABConfiguration struct {
+	Preset string
+	Binary string
+	TmpDir string // directory the edge images of the binary are written to
+}
+
+// abCommand implements the ab subcommand, which detects the edges of all images in a directory with two configurations
+// and reports how well they agree per image and overall. It exits with an error if any image differs, so it can
+// guard an upgrade of edgeefy or its settings.
+func abCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("ab", flag.ExitOnError)
+	inputDirArgPtr := flags.String("input-dir", "", "path to directory of input files (required)")
+	presetAArgPtr := flags.String("a", "", "path to a JSON preset of the reference configuration as in batch parameters (optional, default: defaults)")
+	presetBArgPtr := flags.String("b", "", "path to a JSON preset of the compared configuration as in batch parameters (optional, default: defaults)")
+	binaryAArgPtr := flags.String("a-binary", "", "path to an edgeefy binary that produces the reference edges, presets may only set min, max, blur and sigma (optional, default: this program)")
+	binaryBArgPtr := flags.String("b-binary", "", "path to an edgeefy binary that produces the compared edges, presets may only set min, max, blur and sigma (optional, default: this program)")
+	toleranceArgPtr := flags.Int("tolerance", 1, "distance in pixels edges may be offset by and still count as found (optional, default: 1)")
+	minF1ArgPtr := flags.Float64("min-f1", 0.9, "F1 score below which an image counts as differing (optional, default: 0.9)")
+	summaryArgPtr := flags.String("summary", "", "path to write a JSON summary of the comparison to (optional)")
+	return flags, func() {
+		if *inputDirArgPtr == "" {
+			fmt.Println("No path to input directory specified, nothing to do.")
+			return
+		}
+		if *toleranceArgPtr < 0 {
+			fmt.Println("Invalid value for tolerance given, exiting.")
+			return
+		}
+		if *minF1ArgPtr < 0 || *minF1ArgPtr > 1 {
+			fmt.Println("Invalid value for min-f1 given, exiting.")
+			return
+		}
+		inputs, err := listInputFiles(*inputDirArgPtr)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		tmpDir, err := os.MkdirTemp("", "edgeefy-ab")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer os.RemoveAll(tmpDir)
+		a := ABConfiguration{*presetAArgPtr, *binaryAArgPtr, filepath.Join(tmpDir, "a")}
+		b := ABConfiguration{*presetBArgPtr, *binaryBArgPtr, filepath.Join(tmpDir, "b")}
+		summary := ABSummary{MinF1: 1, Files: []ABResult{}}
+		for _, input := range inputs {
+			result, err := compareConfigurations(input, a, b, *toleranceArgPtr)
+			if err != nil {
+				result.Reason = err.Error()
+				summary.Failed++
+				fmt.Printf("%s: %v\n", filepath.Base(input), err)
+			} else {
+				result.Differs = result.F1 < *minF1ArgPtr
+				summary.add(result)
+				marker := ""
+				if result.Differs {
+					marker = " (differs)"
+				}
+				fmt.Printf("%s: edges %d/%d, agreement %.4f, precision %.4f, recall %.4f, F1 %.4f, IoU %.4f%s\n",
+					filepath.Base(input), result.EdgesA, result.EdgesB, result.Agreement, result.Precision, result.Recall,
+					result.F1, result.IoU, marker)
+			}
+			summary.Files = append(summary.Files, result)
+		}
+		summary.finish()
+		fmt.Printf("compared %d, differing %d, failed %d\n", summary.Compared, summary.Differing, summary.Failed)
+		fmt.Printf("mean agreement %.4f, precision %.4f, recall %.4f, F1 %.4f (min %.4f), IoU %.4f\n",
+			summary.Agreement, summary.Precision, summary.Recall, summary.F1, summary.MinF1, summary.IoU)
+
+		if *summaryArgPtr != "" {
+			writeJSONFile(summary, *summaryArgPtr)
+		}
+		if summary.Differing > 0 || summary.Failed > 0 {
+			os.RemoveAll(tmpDir) // deferred calls don't run on exit
+			os.Exit(1)
+		}
+	}
+}
+
+// compareConfigurations detects the edges of the input file with both configurations and compares them.
+func compareConfigurations(input string, a, b ABConfiguration, tolerance int) (ABResult, error) {
+	result := ABResult{Input: input}
+	edgesA, err := a.detect(input)
+	if err != nil {
+		return result, fmt.Errorf("configuration a: %v", err)
+	}
+	edgesB, err := b.detect(input)
+	if err != nil {
+		return result, fmt.Errorf("configuration b: %v", err)
+	}
+	if len(edgesA) != len(edgesB) || len(edgesA[0]) != len(edgesB[0]) {
+		return result, errors.New("edge images differ in size")
+	}
+
+	agreement := compareEdges(edgesB, edgesA, tolerance)
+	result.EdgesA, result.EdgesB = countEdgePixels(edgesA), countEdgePixels(edgesB)
+	result.Agreement = float64(agreement.Matching) / float64(max(agreement.Pixels, 1))
+	result.Precision, result.Recall, result.F1 = agreement.Precision, agreement.Recall, agreement.F1()
+	result.IoU = edgeIoU(edgesB, edgesA)
+	return result, nil
+}
+
+// detect returns the edges of the input file according to the configuration.
+func (c ABConfiguration) detect(input string) ([][]GrayPixel, error) {
+	if c.Binary == "" {
+		detector, roi := NewDetector(true, 0.2, 0.6), image.Rectangle{}
+		if c.Preset != "" {
+			var err error
+			if detector, roi, err = (BatchOverride{"preset": c.Preset}).apply(detector); err != nil {
+				return nil, err
+			}
+		}
+		file, err := os.Open(input)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close() // opened for reading, no error checking needed
+		pixels, err := getPixelArray(file, "")
+		if err != nil {
+			return nil, err
+		}
+		if pixels, err = cropToRegion(pixels, roi); err != nil {
+			return nil, err
+		}
+		return samplesToPixels(DetectSamples(detector, pixelsToSamples(pixels), nil)), nil
+	}
+
+	if err := os.MkdirAll(c.TmpDir, 0755); err != nil {
+		return nil, err
+	}
+	output := batchOutputPath(input, c.TmpDir)
+	args := []string{"-input", input, "-output", output}
+	if c.Preset != "" {
+		presetArgs, err := presetFlags(c.Preset)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, presetArgs...)
+	}
+	if out, err := exec.Command(c.Binary, args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s failed: %v %s", c.Binary, err, bytes.TrimSpace(out))
+	}
+	file, err := os.Open(output)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // opened for reading, no error checking needed
+	return getPixelArray(file, "")
+}
+
+// AB_BINARY_FLAGS are the parameters of presets that are passed to binaries as flags of the same name. They are the
+// flags every release of edgeefy has.
+var AB_BINARY_FLAGS = []string{"min", "max", "blur", "sigma"}
+
+// presetFlags returns the parameters of the preset at the given path as flags of the command line, sorted by name.
+// Parameters binaries have no flag for, like the region of interest, give an error instead of being left out.
+func presetFlags(preset string) ([]string, error) {
+	values, err := readConfigFile(preset)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	for name, value := range values {
+		if !slices.Contains(AB_BINARY_FLAGS, name) {
+			return nil, fmt.Errorf("parameter %s of preset %s can't be passed to a binary", name, preset)
+		}
+		args = append(args, "-"+name+"="+value)
+	}
+	sort.Strings(args)
+	return args, nil
+}
+
+// countEdgePixels returns the number of pixels with a value above zero.
+func countEdgePixels(edges [][]GrayPixel) int {
+	var count int
+	for y := range edges {
+		for x := range edges[y] {
+			if edges[y][x].y > 0 {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// add adds the given result of a compared file to the summary.
+func (s *ABSummary) add(result ABResult) {
+	s.Compared++
+	if result.Differs {
+		s.Differing++
+	}
+	s.Agreement += result.Agreement
+	s.Precision += result.Precision
+	s.Recall += result.Recall
+	s.F1 += result.F1
+	s.MinF1 = min(s.MinF1, result.F1)
+	s.IoU += result.IoU
+}
+
+// finish turns the sums of the summary into means.
+func (s *ABSummary) finish() {
+	if s.Compared == 0 {
+		s.MinF1 = 0
+		return
+	}
+	n := float64(s.Compared)
+	s.Agreement /= n
+	s.Precision /= n
+	s.Recall /= n
+	s.F1 /= n
+	s.IoU /= n
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"log"
+	"os"
+)
+
+// parameters of before/after animations
+const (
+	ANIMATION_HOLD_DELAY = 100 // delay of the frames showing only one of the images in hundredths of a second
+	ANIMATION_WIPE_DELAY = 6   // delay of the frames of the wipe transition in hundredths of a second
+)
+
+// isValidAnimationMode checks whether the given name denotes a supported transition between the images.
+func isValidAnimationMode(mode string) bool {
+	return mode == "wipe" || mode == "toggle"
+}
+
+// compareCommand implements the compare subcommand, which writes an animated GIF switching between an image and its
+// edge image, e.g. to show the result of a detection in an issue or a chat. Flags may follow the two paths.
+func compareCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	var outputPath string
+	flags.StringVar(&outputPath, "output", "compare.gif", "path to output file (optional, default: compare.gif)")
+	flags.StringVar(&outputPath, "o", "compare.gif", "path to output file, short for -output (optional, default: compare.gif)")
+	modeArgPtr := flags.String("mode", "wipe", "transition between the images: wipe or toggle (optional, default: wipe)")
+	framesArgPtr := flags.Int("frames", 16, "number of frames of the wipe transition (optional, default: 16)")
+	sizeArgPtr := flags.Int("size", 480, "maximum width and height of the animation (optional, default: 480)")
+	return flags, func() {
+		// the paths are collected from between the flags
+		var paths []string
+		for rest := flags.Args(); len(rest) > 0; rest = flags.Args() {
+			paths = append(paths, rest[0])
+			parseCommandFlags(flags, rest[1:])
+		}
+
+		if len(paths) != 2 {
+			fmt.Println("No paths to original and edge image specified, nothing to do.")
+			return
+		}
+		if !isValidAnimationMode(*modeArgPtr) {
+			fmt.Println("Invalid value for animation mode given, exiting.")
+			return
+		}
+		if *framesArgPtr < 1 || *sizeArgPtr < 1 {
+			fmt.Println("Invalid value for animation size given, exiting.")
+			return
+		}
+
+		original, edges := openImage(paths[0], ""), openImage(paths[1], "")
+		if len(original) != len(edges) || len(original[0]) != len(edges[0]) {
+			fmt.Println("Images differ in size, exiting.")
+			return
+		}
+		original = downscalePixels(original, *sizeArgPtr)
+		edges = maxDownscalePixels(edges, *sizeArgPtr)
+
+		outFile, err := os.Create(outputPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer outFile.Close()
+		if err := gif.EncodeAll(outFile, compareAnimation(original, edges, *modeArgPtr, *framesArgPtr)); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// compareAnimation returns the animation between the given original and edge image of the same size. The toggle mode
+// alternates between both images, the wipe mode moves a red line over the original that reveals the edge image behind
+// it and back again.
+func compareAnimation(original, edges [][]GrayPixel, mode string, frames int) *gif.GIF {
+	width, height := len(original[0]), len(original)
+	animation := &gif.GIF{}
+	// frames after the first only hold the columns that changed since the previous frame
+	previous := -1
+	add := func(boundary, delay int) {
+		region := image.Rect(0, 0, width, height)
+		if previous >= 0 && mode != "toggle" {
+			region = image.Rect(min(previous, boundary), 0, min(max(previous, boundary)+1, width), height)
+		}
+		animation.Image = append(animation.Image, wipeFrame(original, edges, boundary, region))
+		animation.Delay = append(animation.Delay, delay)
+		animation.Disposal = append(animation.Disposal, gif.DisposalNone)
+		previous = boundary
+	}
+	if mode == "toggle" {
+		add(0, ANIMATION_HOLD_DELAY)
+		add(width, ANIMATION_HOLD_DELAY)
+		return animation
+	}
+	for i := 0; i <= frames; i++ {
+		delay := ANIMATION_WIPE_DELAY
+		if i == 0 || i == frames {
+			delay = ANIMATION_HOLD_DELAY
+		}
+		add(i*width/frames, delay)
+	}
+	for i := frames - 1; i > 0; i-- {
+		add(i*width/frames, ANIMATION_WIPE_DELAY)
+	}
+	return animation
+}
+
+// wipeFrame returns the given region of a frame showing the edge image left of the given column and the original from
+// it on. A red line marks the boundary unless it lies at the border of the image.
+func wipeFrame(original, edges [][]GrayPixel, boundary int, region image.Rectangle) *image.Paletted {
+	// the palette holds the grays except for the brightest one, which is replaced by the color of the line
+	palette := make(color.Palette, 256)
+	for i := range 255 {
+		palette[i] = color.Gray{uint8(i * 255 / 254)}
+	}
+	palette[255] = color.RGBA{255, 0, 0, 255}
+
+	frame := image.NewPaletted(region, palette)
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			value := original[y][x].y
+			if x < boundary {
+				value = edges[y][x].y
+			}
+			frame.SetColorIndex(x, y, uint8((int(value)*254+127)/255))
+			if x == boundary && boundary > 0 {
+				frame.SetColorIndex(x, y, 255)
+			}
+		}
+	}
+	return frame
+}
+
+// maxDownscalePixels reduces the given pixels like downscalePixels but takes the maximum of every block instead of the
+// mean, so that thin edges stay visible.
+func maxDownscalePixels(pixels [][]GrayPixel, maxDimension int) [][]GrayPixel {
+	height, width := len(pixels), len(pixels[0])
+	factor := (max(width, height) + maxDimension - 1) / maxDimension
+	if factor <= 1 {
+		return pixels
+	}
+
+	result := make([][]GrayPixel, (height+factor-1)/factor)
+	for y := range result {
+		result[y] = make([]GrayPixel, (width+factor-1)/factor)
+		for x := range result[y] {
+			var maximum GrayPixel
+			for i := y * factor; i < min((y+1)*factor, height); i++ {
+				for j := x * factor; j < min((x+1)*factor, width); j++ {
+					maximum.y, maximum.a = max(maximum.y, pixels[i][j].y), max(maximum.a, pixels[i][j].a)
+				}
+			}
+			result[y][x] = maximum
+		}
+	}
+	return result
+}
@@ -0,0 +1,92 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// OverflowAudit holds the number of pixels whose exact values at the stages of a detection were outside of the range
+// of the integer sample type and were saturated, which loses dynamic range. A sobel response of 8-bit samples can
+// reach more than five times the largest sample, so strong edges saturate the gradient long before the input does.
+type OverflowAudit struct {
+	Pixels    int           // number of pixels of the image
+	Limit     float64       // largest value of the sample type, infinity for floating point samples
+	Saturated map[Stage]int // number of saturated pixels of the audited stages
+}
+
+// AuditOverflow repeats the detection of the given samples like DetectSamples with floating point precision and counts
+// the pixels of the intensity, blur, gradient and suppression stages whose exact values lie outside of the range of the
+// sample type, which the detection with the sample type saturates. The stages are observed with OnStageComplete only,
+// so custom stages are audited like the built-in ones, and the OnStageComplete of the given detector is called with the
+// floating point buffers afterwards. Floating point samples never saturate.
+func AuditOverflow[T Sample](d *Detector, samples [][]T, valid [][]bool) OverflowAudit {
+	audit := OverflowAudit{Limit: sampleLimit[T](), Saturated: make(map[Stage]int)}
+	if len(samples) > 0 {
+		audit.Pixels = len(samples) * len(samples[0])
+	}
+	if math.IsInf(audit.Limit, 1) {
+		return audit
+	}
+	exact := d.Clone()
+	exact.OnStageComplete = func(stage Stage, buffer any) {
+		if stage <= STAGE_SUPPRESSION {
+			audit.Saturated[stage] = countSaturated(buffer.([][]float64), audit.Limit)
+		}
+		d.stageComplete(stage, buffer)
+	}
+	DetectSamples(exact, convertSamples[float64](samples), valid)
+	return audit
+}
+
+// countSaturated returns the number of the given exact values that lie outside of [0, limit].
+func countSaturated(values [][]float64, limit float64) int {
+	var count int
+	for y := range values {
+		for _, value := range values[y] {
+			if value < 0 || value > limit {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// String returns the audited stages in the order of the detection with the share of their saturated pixels.
+func (a OverflowAudit) String() string {
+	var lines []string
+	for stage := STAGE_INTENSITY; stage <= STAGE_POSTPROCESS; stage++ {
+		count, ok := a.Saturated[stage]
+		if !ok {
+			continue
+		}
+		var share float64
+		if a.Pixels > 0 {
+			share = 100 * float64(count) / float64(a.Pixels)
+		}
+		lines = append(lines, fmt.Sprintf("%-12s %d of %d pixels saturated at %g (%.2f%%)", stage.String()+":", count, a.Pixels, a.Limit, share))
+	}
+	if math.IsInf(a.Limit, 1) {
+		return "floating point samples don't saturate"
+	} else if len(lines) == 0 {
+		return "no stage was audited"
+	}
+	return strings.Join(lines, "\n")
+}
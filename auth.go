@@ -0,0 +1,192 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FLAG_API_KEY_NAME is the name the key given by the -api-key flag of the serve subcommand is accounted under. Keys
+// files can't use it as well.
+const FLAG_API_KEY_NAME = "api-key"
+
+// APIKey is a key that clients of the server authenticate with, either as bearer token or in the X-API-Key header.
+// Every key has a rate limit of its own.
+type APIKey struct {
+	Key   string  `json:"key"`
+	Rate  float64 `json:"rate"`  // requests per second, zero disables the limit
+	Burst int     `json:"burst"` // number of requests that may exceed the rate at once, at least one
+}
+
+// APIKeyUsage holds the usage of an API key since the server started.
+type APIKeyUsage struct {
+	Name     string `json:"name"`
+	Requests int64  `json:"requests"` // authenticated requests that were served
+	Limited  int64  `json:"limited"`  // requests rejected by the rate limit
+	BytesIn  int64  `json:"bytes_in"` // bytes of the request bodies that were read
+}
+
+// apiClient is the state of the client of an API key.
+type apiClient struct {
+	name                       string
+	key                        APIKey
+	mutex                      sync.Mutex // guards the token bucket
+	tokens                     float64
+	updated                    time.Time
+	requests, limited, bytesIn atomic.Int64
+}
+
+// Authenticator checks the API keys of the requests to the server and applies their rate limits. The keys are looked
+// up by their hash, so the time a lookup takes doesn't reveal how much of a key was right.
+type Authenticator struct {
+	clients map[[sha256.Size]byte]*apiClient
+}
+
+// apiClientKey is the key of the client of an authenticated request in its context.
+type apiClientKey struct{}
+
+// NewAuthenticator returns an authenticator for the given keys by name. Keys must not be empty or repeated.
+func NewAuthenticator(keys map[string]APIKey) (*Authenticator, error) {
+	a := &Authenticator{clients: make(map[[sha256.Size]byte]*apiClient)}
+	for _, name := range sortedKeys(keys) {
+		key := keys[name]
+		if key.Key == "" {
+			return nil, fmt.Errorf("key of %s is empty", name)
+		}
+		if key.Rate < 0 || key.Burst < 0 || math.IsInf(key.Rate, 0) || math.IsNaN(key.Rate) {
+			return nil, fmt.Errorf("invalid rate limit of %s", name)
+		}
+		hash := sha256.Sum256([]byte(key.Key))
+		if _, ok := a.clients[hash]; ok {
+			return nil, fmt.Errorf("key of %s is used more than once", name)
+		}
+		key.Burst = max(key.Burst, 1)
+		a.clients[hash] = &apiClient{name: name, key: key, tokens: float64(key.Burst), updated: time.Now()}
+	}
+	return a, nil
+}
+
+// readAPIKeys reads the API keys from a JSON object that maps names to keys with their rate limits.
+func readAPIKeys(path string) (map[string]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys map[string]APIKey
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&keys); err != nil {
+		return nil, fmt.Errorf("invalid API keys file %s: %v", path, err)
+	}
+	return keys, nil
+}
+
+// Wrap returns a handler that serves the requests with a valid API key within its rate limit by the given handler.
+// Requests without a valid key are answered with 401 Unauthorized, those exceeding the rate limit with 429 Too Many
+// Requests. The usage of the key is accounted.
+func (a *Authenticator) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := a.client(r)
+		if client == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="edgeefy"`)
+			http.Error(w, "missing or invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if wait := client.take(); wait > 0 {
+			client.limited.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		client.requests.Add(1)
+		r.Body = &countingReader{r.Body, &client.bytesIn}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiClientKey{}, client)))
+	})
+}
+
+// requestUsage returns the usage of the API key the given request was authenticated with.
+func requestUsage(r *http.Request) (APIKeyUsage, error) {
+	client, ok := r.Context().Value(apiClientKey{}).(*apiClient)
+	if !ok {
+		return APIKeyUsage{}, errors.New("authentication is disabled")
+	}
+	return client.usage(), nil
+}
+
+// client returns the client of the API key of the given request, nil if it has no valid key.
+func (a *Authenticator) client(r *http.Request) *apiClient {
+	key := r.Header.Get("X-API-Key")
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		key = token
+	}
+	if key == "" {
+		return nil
+	}
+	return a.clients[sha256.Sum256([]byte(key))]
+}
+
+// take takes a token from the bucket of the client. If there is none left the time until the next one is returned.
+func (c *apiClient) take() time.Duration {
+	if c.key.Rate == 0 {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	c.tokens = math.Min(float64(c.key.Burst), c.tokens+now.Sub(c.updated).Seconds()*c.key.Rate)
+	c.updated = now
+	if c.tokens < 1 {
+		return time.Duration((1 - c.tokens) / c.key.Rate * float64(time.Second))
+	}
+	c.tokens--
+	return 0
+}
+
+// usage returns the current usage of the client.
+func (c *apiClient) usage() APIKeyUsage {
+	return APIKeyUsage{c.name, c.requests.Load(), c.limited.Load(), c.bytesIn.Load()}
+}
+
+// countingReader adds the number of bytes read from the wrapped body to a counter.
+type countingReader struct {
+	io.ReadCloser
+	count *atomic.Int64
+}
+
+// Read reads from the wrapped body and counts the bytes.
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.count.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,198 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serveAuthenticated serves a request with the given body and headers, given as name and value pairs, by the given
+// handler wrapped by the authenticator.
+func serveAuthenticated(a *Authenticator, handler http.HandlerFunc, body []byte,
+	headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	a.Wrap(handler).ServeHTTP(w, r)
+	return w
+}
+
+// TestNewAuthenticator checks that keys that are empty, repeated or have invalid rate limits are rejected.
+func TestNewAuthenticator(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		keys  map[string]APIKey
+		valid bool
+	}{
+		{"valid", map[string]APIKey{"a": {Key: "secret-a", Rate: 2, Burst: 4}, "b": {Key: "secret-b"}}, true},
+		{"none", map[string]APIKey{}, true},
+		{"empty key", map[string]APIKey{"a": {Key: ""}}, false},
+		{"repeated key", map[string]APIKey{"a": {Key: "secret"}, "b": {Key: "secret"}}, false},
+		{"negative rate", map[string]APIKey{"a": {Key: "secret", Rate: -1}}, false},
+		{"negative burst", map[string]APIKey{"a": {Key: "secret", Rate: 1, Burst: -1}}, false},
+		{"infinite rate", map[string]APIKey{"a": {Key: "secret", Rate: math.Inf(1)}}, false},
+		{"NaN rate", map[string]APIKey{"a": {Key: "secret", Rate: math.NaN()}}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewAuthenticator(test.keys); (err == nil) != test.valid {
+				t.Errorf("expected valid %v, got error %v", test.valid, err)
+			}
+		})
+	}
+}
+
+// TestAuthenticatorKeys checks that requests are authenticated with the key of the bearer token, which takes
+// precedence, or the X-API-Key header, and that requests without a valid key are rejected.
+func TestAuthenticatorKeys(t *testing.T) {
+	a, err := NewAuthenticator(map[string]APIKey{"a": {Key: "secret-a"}, "b": {Key: "secret-b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		usage, err := requestUsage(r)
+		if err != nil {
+			t.Error(err)
+		}
+		io.WriteString(w, usage.Name)
+	}
+
+	for _, test := range []struct {
+		name    string
+		headers []string
+		status  int
+		client  string
+	}{
+		{"bearer", []string{"Authorization", "Bearer secret-a"}, http.StatusOK, "a"},
+		{"header", []string{"X-API-Key", "secret-b"}, http.StatusOK, "b"},
+		{"bearer overrides header", []string{"Authorization", "Bearer secret-a", "X-API-Key", "secret-b"},
+			http.StatusOK, "a"},
+		{"missing", nil, http.StatusUnauthorized, ""},
+		{"wrong key", []string{"X-API-Key", "secret-c"}, http.StatusUnauthorized, ""},
+		{"prefix of key", []string{"X-API-Key", "secret"}, http.StatusUnauthorized, ""},
+		{"name as key", []string{"X-API-Key", "a"}, http.StatusUnauthorized, ""},
+		{"empty bearer", []string{"Authorization", "Bearer ", "X-API-Key", "secret-b"}, http.StatusUnauthorized, ""},
+		{"other scheme", []string{"Authorization", "Basic secret-a"}, http.StatusUnauthorized, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := serveAuthenticated(a, handler, nil, test.headers...)
+			if w.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, w.Code)
+			}
+			if test.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("expected a WWW-Authenticate header")
+			}
+			if test.status == http.StatusOK && w.Body.String() != test.client {
+				t.Errorf("expected client %s, got %s", test.client, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestAuthenticatorRateLimit checks that the token bucket of a key lets its burst pass at once, rejects further
+// requests until tokens are refilled at its rate and accounts the rejected requests.
+func TestAuthenticatorRateLimit(t *testing.T) {
+	a, err := NewAuthenticator(map[string]APIKey{
+		"limited":   {Key: "secret-limited", Rate: 0.5, Burst: 2},
+		"unlimited": {Key: "secret-unlimited"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	limited := a.clients[sha256.Sum256([]byte("secret-limited"))]
+
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		w := serveAuthenticated(a, handler, nil, "X-API-Key", "secret-limited")
+		if w.Code != expected {
+			t.Fatalf("expected status %d of request %d, got %d", expected, i, w.Code)
+		}
+		// an empty bucket refills a token in two seconds at half a request per second
+		if expected == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "2" {
+			t.Errorf("expected to retry after 2 seconds, got %q", w.Header().Get("Retry-After"))
+		}
+	}
+	// refill as if a second had passed, half a token isn't enough for a request
+	limited.mutex.Lock()
+	limited.updated = limited.updated.Add(-time.Second)
+	limited.mutex.Unlock()
+	if w := serveAuthenticated(a, handler, nil, "X-API-Key", "secret-limited"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d with half a token, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	// refill as if a long time had passed, the bucket holds no more than the burst
+	limited.mutex.Lock()
+	limited.updated = limited.updated.Add(-time.Hour)
+	limited.mutex.Unlock()
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if w := serveAuthenticated(a, handler, nil, "X-API-Key", "secret-limited"); w.Code != expected {
+			t.Errorf("expected status %d of request %d after refilling, got %d", expected, i, w.Code)
+		}
+	}
+	if usage := limited.usage(); usage.Requests != 4 || usage.Limited != 3 {
+		t.Errorf("expected 4 requests and 3 limited, got %+v", usage)
+	}
+
+	for i := 0; i < 100; i++ {
+		if w := serveAuthenticated(a, handler, nil, "X-API-Key", "secret-unlimited"); w.Code != http.StatusOK {
+			t.Fatalf("expected unlimited key to pass, got status %d", w.Code)
+		}
+	}
+}
+
+// TestAuthenticatorBytesIn checks that the bytes of the request bodies that handlers read are accounted to the key of
+// the request, and that rejected requests aren't.
+func TestAuthenticatorBytesIn(t *testing.T) {
+	a, err := NewAuthenticator(map[string]APIKey{"a": {Key: "secret-a", Rate: 1, Burst: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := bytes.Repeat([]byte{'x'}, 100)
+	readAll := func(w http.ResponseWriter, r *http.Request) { io.Copy(io.Discard, r.Body) }
+	readPart := func(w http.ResponseWriter, r *http.Request) { io.CopyN(io.Discard, r.Body, 10) }
+	client := a.clients[sha256.Sum256([]byte("secret-a"))]
+
+	serveAuthenticated(a, readAll, body, "X-API-Key", "secret-a")
+	serveAuthenticated(a, readAll, body, "X-API-Key", "secret-b")
+	// the token of the burst is taken, this one is rate limited
+	serveAuthenticated(a, readAll, body, "X-API-Key", "secret-a")
+	if usage := client.usage(); usage.Requests != 1 || usage.Limited != 1 || usage.BytesIn != 100 {
+		t.Errorf("expected 1 request, 1 limited and 100 bytes, got %+v", usage)
+	}
+	client.mutex.Lock()
+	client.updated = client.updated.Add(-time.Second)
+	client.mutex.Unlock()
+	// bytes the handler doesn't read aren't counted
+	serveAuthenticated(a, readPart, body, "X-API-Key", "secret-a")
+	if usage := client.usage(); usage.Requests != 2 || usage.BytesIn != 110 {
+		t.Errorf("expected 2 requests and 110 bytes, got %+v", usage)
+	}
+
+	if _, err := requestUsage(httptest.NewRequest("GET", "/usage", nil)); err == nil {
+		t.Errorf("expected no usage of a request that wasn't authenticated")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"image"
+	"log"
+	"os"
+)
+
+// isValidAutocropMode checks whether the given mode names what is cropped to the edges: the edge image or the original.
+func isValidAutocropMode(mode string) bool {
+	return mode == "" || mode == "edges" || mode == "original"
+}
+
+// edgeBoundingBox returns the bounding box of the edges of the given edge image, enlarged by the given padding in
+// pixels and clipped to the image. Rows and columns with a lower ratio of edge pixels than the given density are not
+// taken into account, so that single specks don't extend the box. If no row or column qualifies the whole image is
+// returned.
+func edgeBoundingBox[T Sample](edges [][]T, density float64, padding int) image.Rectangle {
+	height, width := len(edges), len(edges[0])
+	rowCounts := make([]int, height)
+	columnCounts := make([]int, width)
+	for y := range edges {
+		for x, value := range edges[y] {
+			if value != 0 {
+				rowCounts[y]++
+				columnCounts[x]++
+			}
+		}
+	}
+
+	top, bottom := denseRange(rowCounts, density*float64(width))
+	left, right := denseRange(columnCounts, density*float64(height))
+	if top > bottom || left > right {
+		return image.Rect(0, 0, width, height)
+	}
+	box := image.Rect(left-padding, top-padding, right+1+padding, bottom+1+padding)
+	return box.Intersect(image.Rect(0, 0, width, height))
+}
+
+// denseRange returns the first and last index of the given counts that are positive and reach the given minimum. The
+// first index is larger than the last if there is no such count.
+func denseRange(counts []int, minimum float64) (int, int) {
+	first, last := len(counts), -1
+	for i, count := range counts {
+		if count > 0 && float64(count) >= minimum {
+			first, last = min(first, i), i
+		}
+	}
+	return first, last
+}
+
+// cropPixels returns the pixels inside the given rectangle.
+func cropPixels(pixels [][]GrayPixel, box image.Rectangle) [][]GrayPixel {
+	result := make([][]GrayPixel, box.Dy())
+	for y := range result {
+		result[y] = pixels[box.Min.Y+y][box.Min.X:box.Max.X]
+	}
+	return result
+}
+
+// writeCroppedOriginal writes the area of the original image at the given input path that corresponds to the given
+// rectangle of the edge image with the given size. The rectangle is scaled if the image was downscaled for detection.
+func writeCroppedOriginal(inputPath, rawFormat string, box image.Rectangle, width, height int, outputPath string) {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close() // opened for reading, no error checking needed
+	img, err := decodeInput(file, rawFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bounds := img.Bounds()
+	scaled := image.Rect(
+		box.Min.X*bounds.Dx()/width, box.Min.Y*bounds.Dy()/height,
+		box.Max.X*bounds.Dx()/width, box.Max.Y*bounds.Dy()/height,
+	).Add(bounds.Min)
+	cropped := image.NewRGBA(image.Rect(0, 0, scaled.Dx(), scaled.Dy()))
+	for y := 0; y < scaled.Dy(); y++ {
+		for x := 0; x < scaled.Dx(); x++ {
+			cropped.Set(x, y, img.At(scaled.Min.X+x, scaled.Min.Y+y))
+		}
+	}
+	writeColorImage(cropped, outputPath)
+}
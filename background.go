@@ -0,0 +1,165 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import "math"
+
+// enumeration type for denoting how the background of an image is estimated
+type BackgroundFilter int
+
+const (
+	ROLLING_BALL BackgroundFilter = iota
+	GAUSSIAN_BACKGROUND
+)
+
+// parseBackgroundFilter returns the background filter of the given name, ball or gaussian. The second return value is
+// false for unknown names.
+func parseBackgroundFilter(name string) (BackgroundFilter, bool) {
+	switch name {
+	case "ball":
+		return ROLLING_BALL, true
+	case "gaussian":
+		return GAUSSIAN_BACKGROUND, true
+	}
+	return ROLLING_BALL, false
+}
+
+// String returns the name of the background filter.
+func (f BackgroundFilter) String() string {
+	if f == GAUSSIAN_BACKGROUND {
+		return "gaussian"
+	}
+	return "ball"
+}
+
+// subtractBackground removes uneven illumination from the given samples by subtracting their background as configured
+// in the given detector. The rolling ball background lies below the samples, so the result starts at zero. The
+// gaussian background is subtracted around the mean of the samples, the result is clipped to the range of integer
+// sample types. Invalid pixels keep their value.
+func subtractBackground[T Sample](d *Detector, samples [][]T, valid [][]bool) [][]T {
+	values := convertSamples[float64](samples)
+	var background [][]float64
+	offset := 0.0
+	if d.BackgroundFilter == GAUSSIAN_BACKGROUND {
+		background = recursiveGaussianBlur(values, d.BackgroundRadius, valid, d.Workers)
+		count := 0
+		for y := range values {
+			for x, value := range values[y] {
+				if isValidPixel(valid, x, y) {
+					offset += value
+					count++
+				}
+			}
+		}
+		if count > 0 {
+			offset /= float64(count)
+		}
+	} else {
+		background = rollingBall(values, d.BackgroundRadius, valid, d.Workers)
+	}
+
+	result := make([][]T, len(samples))
+	parallelRows(len(samples), d.Workers, func(y int) {
+		result[y] = make([]T, len(samples[y]))
+		for x, value := range values[y] {
+			if isValidPixel(valid, x, y) {
+				result[y][x] = clampSample[T](value - background[y][x] + offset)
+			} else {
+				result[y][x] = samples[y][x]
+			}
+		}
+	})
+	return result
+}
+
+// rollingBall returns the background of the given values that a ball of the given radius rolled along their underside
+// reaches, which is the grayscale opening with the ball. The ball is approximated by a paraboloid of the same
+// curvature over a square of twice the radius, which makes the opening separable into passes along the rows and the
+// columns. Invalid pixels are left out.
+func rollingBall(values [][]float64, radius float64, valid [][]bool, workers int) [][]float64 {
+	// the height of the paraboloid at every distance from its center
+	profile := make([]float64, int(radius)+1)
+	for k := range profile {
+		profile[k] = float64(k*k) / (2 * radius)
+	}
+	// the ball is lowered as far as the values allow by an erosion, then it is traced by a dilation
+	eroded := parabolicPass(values, profile, valid, workers, false)
+	return parabolicPass(eroded, profile, valid, workers, true)
+}
+
+// parabolicPass erodes or dilates the given values with the paraboloid of the given profile, along the rows and then
+// along the columns. Invalid pixels don't take part and keep their value.
+func parabolicPass(values [][]float64, profile []float64, valid [][]bool, workers int, dilate bool) [][]float64 {
+	height, width := len(values), len(values[0])
+	// extreme returns the erosion or dilation of the line of values at the given index
+	extreme := func(line []float64, validLine []bool, i int) float64 {
+		result := line[i]
+		for k := 1; k < len(profile); k++ {
+			for _, j := range [2]int{i - k, i + k} {
+				if j < 0 || j >= len(line) || (validLine != nil && !validLine[j]) {
+					continue
+				}
+				if dilate {
+					result = math.Max(result, line[j]-profile[k])
+				} else {
+					result = math.Min(result, line[j]+profile[k])
+				}
+			}
+		}
+		return result
+	}
+
+	rows := make([][]float64, height)
+	parallelRows(height, workers, func(y int) {
+		rows[y] = make([]float64, width)
+		var validLine []bool
+		if valid != nil {
+			validLine = valid[y]
+		}
+		for x := range rows[y] {
+			rows[y][x] = values[y][x]
+			if isValidPixel(valid, x, y) {
+				rows[y][x] = extreme(values[y], validLine, x)
+			}
+		}
+	})
+	result := make([][]float64, height)
+	for y := range result {
+		result[y] = make([]float64, width)
+	}
+	parallelRows(width, workers, func(x int) {
+		column := make([]float64, height)
+		var validLine []bool
+		if valid != nil {
+			validLine = make([]bool, height)
+		}
+		for y := range column {
+			column[y] = rows[y][x]
+			if valid != nil {
+				validLine[y] = valid[y][x]
+			}
+		}
+		for y := range column {
+			result[y][x] = column[y]
+			if isValidPixel(valid, x, y) {
+				result[y][x] = extreme(column, validLine, y)
+			}
+		}
+	})
+	return result
+}
@@ -0,0 +1,186 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"math"
+)
+
+// parameters of the barcode region detection
+const (
+	BARCODE_CELL_SIZE     = 16   // edge length of the cells the statistics are collected in
+	BARCODE_MIN_DENSITY   = 0.15 // minimum ratio of edge pixels in a candidate cell
+	BARCODE_DOMINANCE     = 0.7  // minimum ratio of edge pixels in the dominant orientations of a candidate cell
+	BARCODE_MIN_SECONDARY = 0.2  // minimum ratio of each of the two perpendicular orientations of a 2D code cell
+	BARCODE_MIN_CELLS     = 4    // minimum number of cells of a reported region
+)
+
+// BarcodeRegion is a rectangular region that is likely to contain a barcode. Kind is "1d" for regions whose edges run
+// in a single orientation like linear barcodes and "2d" for regions with two perpendicular orientations like QR codes.
+type BarcodeRegion struct {
+	X      int     `json:"x"`
+	Y      int     `json:"y"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Kind   string  `json:"kind"`
+	Score  float64 `json:"score"`
+}
+
+// barcodesCommand implements the barcodes subcommand, which writes the candidate regions of an image as JSON.
+func barcodesCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("barcodes", flag.ExitOnError)
+	inputFileArgPtr := flags.String("input", "", "path to input file (required)")
+	outputFileArgPtr := flags.String("output", "barcodes.json", "path to JSON file for the regions (optional, default: barcodes.json)")
+	minThresholdArgPtr := flags.Float64("min", float64(0.2), "ratio of lower threshold (optional, default: 0.2)")
+	maxThresholdArgPtr := flags.Float64("max", float64(0.6), "ratio of upper threshold (optional, default: 0.6)")
+	return flags, func() {
+		if *inputFileArgPtr == "" {
+			fmt.Println("No path to input file specified, nothing to do.")
+			return
+		}
+		if !isValidRatioValue(*minThresholdArgPtr) || !isValidRatioValue(*maxThresholdArgPtr) {
+			fmt.Println("Invalid value for threshold ratio given, exiting.")
+			return
+		}
+
+		samples := pixelsToSamples(openImage(*inputFileArgPtr, ""))
+		detector := NewDetector(true, *minThresholdArgPtr, *maxThresholdArgPtr)
+		regions := FindBarcodeRegions(samples, DetectSamples(detector, copySamples(samples), nil))
+		if regions == nil {
+			regions = []BarcodeRegion{} // encode an empty array rather than null
+		}
+		writeJSONFile(regions, *outputFileArgPtr)
+	}
+}
+
+// FindBarcodeRegions proposes regions of the given image that are likely to contain barcodes or QR codes, based on the
+// given edge image of it. The image is divided into cells of BARCODE_CELL_SIZE pixels. A cell is a candidate if it is
+// densely covered by edges whose gradient orientations concentrate on one orientation or on two perpendicular ones.
+// Neighbouring candidate cells are merged and the bounding boxes of the merged regions are returned together with the
+// mean edge density as score.
+func FindBarcodeRegions[T Sample](samples [][]T, edges [][]T) []BarcodeRegion {
+	rows := (len(edges) + BARCODE_CELL_SIZE - 1) / BARCODE_CELL_SIZE
+	cols := (len(edges[0]) + BARCODE_CELL_SIZE - 1) / BARCODE_CELL_SIZE
+	kinds := make([][]string, rows)
+	densities := make([][]float64, rows)
+	for row := range kinds {
+		kinds[row] = make([]string, cols)
+		densities[row] = make([]float64, cols)
+		for col := range kinds[row] {
+			kinds[row][col], densities[row][col] = classifyBarcodeCell(samples, edges, col*BARCODE_CELL_SIZE, row*BARCODE_CELL_SIZE)
+		}
+	}
+
+	// merge 4-connected candidate cells
+	var regions []BarcodeRegion
+	visited := make([][]bool, rows)
+	for row := range visited {
+		visited[row] = make([]bool, cols)
+	}
+	for row := range kinds {
+		for col := range kinds[row] {
+			if kinds[row][col] == "" || visited[row][col] {
+				continue
+			}
+			cells := []image.Point{{col, row}}
+			visited[row][col] = true
+			for i := 0; i < len(cells); i++ {
+				c := cells[i]
+				for _, n := range []image.Point{{c.X + 1, c.Y}, {c.X - 1, c.Y}, {c.X, c.Y + 1}, {c.X, c.Y - 1}} {
+					if n.Y >= 0 && n.Y < rows && n.X >= 0 && n.X < cols && kinds[n.Y][n.X] != "" && !visited[n.Y][n.X] {
+						visited[n.Y][n.X] = true
+						cells = append(cells, n)
+					}
+				}
+			}
+			if len(cells) >= BARCODE_MIN_CELLS {
+				regions = append(regions, barcodeRegion(cells, kinds, densities, len(edges[0]), len(edges)))
+			}
+		}
+	}
+	return regions
+}
+
+// classifyBarcodeCell returns the kind of code the cell with the given top left corner looks like, or the empty
+// string if it isn't a candidate, together with its edge density.
+func classifyBarcodeCell[T Sample](samples [][]T, edges [][]T, left, top int) (string, float64) {
+	var histogram [4]float64 // orientations around 0, 45, 90 and 135 degrees
+	count, total := 0.0, 0.0
+	for y := top; y < min(top+BARCODE_CELL_SIZE, len(edges)); y++ {
+		for x := left; x < min(left+BARCODE_CELL_SIZE, len(edges[y])); x++ {
+			total++
+			if edges[y][x] == 0 {
+				continue
+			}
+			count++
+			histogram[int(math.Round(gradientOrientation(samples, x, y)/45))%4]++
+		}
+	}
+	density := count / total
+	if density < BARCODE_MIN_DENSITY {
+		return "", density
+	}
+	// perpendicular orientations are checked first, as one of them may also be dominant on its own
+	for bin := 0; bin < 2; bin++ {
+		first, second := histogram[bin]/count, histogram[bin+2]/count
+		if first+second >= BARCODE_DOMINANCE && first >= BARCODE_MIN_SECONDARY && second >= BARCODE_MIN_SECONDARY {
+			return "2d", density
+		}
+	}
+	for bin := range histogram {
+		if histogram[bin]/count >= BARCODE_DOMINANCE {
+			return "1d", density
+		}
+	}
+	return "", density
+}
+
+// gradientOrientation returns the orientation of the gradient at the given position in degrees within [0, 180).
+func gradientOrientation[T Sample](samples [][]T, x, y int) float64 {
+	pane := getSorroundingPixelMatrix(samples, y, x, 3, nil)
+	gx := convolve(pane, *newMatrix(3, 3, SOBEL_X))
+	gy := convolve(pane, *newMatrix(3, 3, SOBEL_Y))
+	angle := math.Atan2(gy, gx) * 180 / math.Pi
+	if angle < 0 {
+		angle += 180
+	}
+	return math.Mod(angle, 180)
+}
+
+// barcodeRegion returns the bounding box of the given cells clipped to the image size. The kind is the one most cells
+// have and the score is their mean edge density.
+func barcodeRegion(cells []image.Point, kinds [][]string, densities [][]float64, width, height int) BarcodeRegion {
+	bounds := image.Rect(cells[0].X, cells[0].Y, cells[0].X+1, cells[0].Y+1)
+	votes := make(map[string]int)
+	score := 0.0
+	for _, c := range cells {
+		bounds = bounds.Union(image.Rect(c.X, c.Y, c.X+1, c.Y+1))
+		votes[kinds[c.Y][c.X]]++
+		score += densities[c.Y][c.X] / float64(len(cells))
+	}
+	kind := "1d"
+	if votes["2d"] > votes["1d"] {
+		kind = "2d"
+	}
+	pixels := image.Rect(bounds.Min.X*BARCODE_CELL_SIZE, bounds.Min.Y*BARCODE_CELL_SIZE,
+		bounds.Max.X*BARCODE_CELL_SIZE, bounds.Max.Y*BARCODE_CELL_SIZE).Intersect(image.Rect(0, 0, width, height))
+	return BarcodeRegion{pixels.Min.X, pixels.Min.Y, pixels.Dx(), pixels.Dy(), kind, score}
+}
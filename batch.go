@@ -0,0 +1,302 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BATCH_MANIFEST_NAME is the name of the file in the output directory of batch mode that records the parameters every
+// edge image was produced with.
+const BATCH_MANIFEST_NAME = ".edgeefy-manifest.json"
+
+// BatchResult describes the outcome of processing a single file in batch mode.
+type BatchResult struct {
+	Input      string        // path of the input file
+	Output     string        // path of the written edge image
+	Err        error         // reason the file couldn't be processed, nil on success
+	Skipped    bool          // whether the existing output was up to date
+	Megapixels float64       // size of the input image
+	Duration   time.Duration // time spent on the file
+}
+
+// BatchSummary is the machine-readable report of a batch run.
+type BatchSummary struct {
+	Processed  int               `json:"processed"`
+	Skipped    int               `json:"skipped"`
+	Failed     int               `json:"failed"`
+	Megapixels float64           `json:"megapixels"`
+	WallTime   float64           `json:"wall_time"`
+	Files      []BatchFileReport `json:"files"`
+}
+
+// BatchFileReport is the entry of a single file in a batch summary, times are given in seconds.
+type BatchFileReport struct {
+	Input      string  `json:"input"`
+	Output     string  `json:"output,omitempty"`
+	Status     string  `json:"status"`
+	Reason     string  `json:"reason,omitempty"`
+	Megapixels float64 `json:"megapixels"`
+	Time       float64 `json:"time"`
+}
+
+// batchCommand implements the batch subcommand, which detects the edges of all images in a directory. Files that can't
+// be decoded are reported and skipped instead of aborting the run.
+func batchCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("batch", flag.ExitOnError)
+	blurFlagPtr := &blurFlag{enabled: true}
+	flags.Var(blurFlagPtr, "blur", "blur before edge detection: true, false, gaussian, box or none (optional, default: true = gaussian)")
+	inputDirArgPtr := flags.String("input-dir", "", "path to directory of input files (required)")
+	outputDirArgPtr := flags.String("output-dir", "edges", "path to directory for the edge images (optional, default: edges)")
+	minThresholdArgPtr := flags.Float64("min", float64(0.2), "ratio of lower threshold (optional, default: 0.2)")
+	maxThresholdArgPtr := flags.Float64("max", float64(0.6), "ratio of upper threshold (optional, default: 0.6)")
+	maxDimensionArgPtr := flags.Int("max-dimension", 0, "maximum width and height of input images, e.g. 8000 (optional, default: 0 = no limit)")
+	oversizeArgPtr := flags.String("oversize", "reject", "how to handle images exceeding the maximum dimension: reject or downscale (optional, default: reject)")
+	skipUnchangedFlagPtr := flags.Bool("skip-unchanged", false, "skip files whose output is newer than the input and was produced with the same parameters (optional, default: false)")
+	summaryArgPtr := flags.String("summary", "", "path to write a JSON summary of the run to (optional)")
+	contactSheetArgPtr := flags.String("contact-sheet", "", "path to write a contact sheet of all results to, .html or image (optional)")
+	parametersArgPtr := flags.String("parameters", "", "path to a CSV or JSON file of per-file parameters: input, min, max, blur, sigma, roi and preset (optional)")
+	return flags, func() {
+		if *inputDirArgPtr == "" {
+			fmt.Println("No path to input directory specified, nothing to do.")
+			return
+		}
+		if !isValidRatioValue(*minThresholdArgPtr) || !isValidRatioValue(*maxThresholdArgPtr) {
+			fmt.Println("Invalid value for threshold ratio given, exiting.")
+			return
+		}
+		if *maxDimensionArgPtr < 0 || !isValidOversizePolicy(*oversizeArgPtr) {
+			fmt.Println("Invalid value for size limit given, exiting.")
+			return
+		}
+		if *maxDimensionArgPtr > 0 && *oversizeArgPtr == "reject" {
+			setDecodeMaxDimension(*maxDimensionArgPtr)
+		}
+
+		inputs, err := listInputFiles(*inputDirArgPtr)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		overrides := make(map[string]BatchOverride)
+		if *parametersArgPtr != "" {
+			if overrides, err = readBatchOverrides(*parametersArgPtr); err != nil {
+				fmt.Printf("%v, exiting.\n", err)
+				return
+			}
+			for name := range overrides {
+				if !slices.Contains(inputs, filepath.Join(*inputDirArgPtr, filepath.FromSlash(name))) {
+					fmt.Printf("%s: no such input file in %s\n", name, *inputDirArgPtr)
+				}
+			}
+		}
+		if err := os.MkdirAll(*outputDirArgPtr, 0755); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		detector := NewDetector(blurFlagPtr.enabled, *minThresholdArgPtr, *maxThresholdArgPtr)
+		detector.BlurFilter = blurFlagPtr.filter
+		parameters := fmt.Sprintf("min=%g max=%g blur=%s", *minThresholdArgPtr, *maxThresholdArgPtr, blurFlagPtr)
+		manifestPath := filepath.Join(*outputDirArgPtr, BATCH_MANIFEST_NAME)
+		manifest := readBatchManifest(manifestPath)
+		var results []BatchResult
+		start := time.Now()
+		outputs := batchOutputPaths(inputs, *inputDirArgPtr, *outputDirArgPtr)
+		for i, input := range inputs {
+			result := BatchResult{Input: input, Output: outputs[i]}
+			outputName := filepath.Base(result.Output)
+			fileDetector, fileParameters, roi := detector, parameters, image.Rectangle{}
+			if override, ok := overrides[batchInputName(input, *inputDirArgPtr)]; ok {
+				fileParameters += " " + override.String()
+				if fileDetector, roi, err = override.apply(detector); err != nil {
+					fmt.Printf("%s: %v\n", input, err)
+					result.Err = err
+					delete(manifest, outputName)
+					results = append(results, result)
+					continue
+				}
+			}
+			if *skipUnchangedFlagPtr && manifest[outputName] == fileParameters && isNewer(result.Output, result.Input) {
+				result.Skipped = true
+				results = append(results, result)
+				continue
+			}
+			fileStart := time.Now()
+			result.Megapixels, result.Err = processBatchFile(fileDetector, result.Input, result.Output, roi, *maxDimensionArgPtr, *oversizeArgPtr)
+			result.Duration = time.Since(fileStart)
+			if result.Err != nil {
+				fmt.Printf("%s: %v\n", input, result.Err)
+				delete(manifest, outputName)
+			} else {
+				manifest[outputName] = fileParameters
+			}
+			results = append(results, result)
+		}
+		writeJSONFile(manifest, manifestPath)
+
+		if *summaryArgPtr != "" {
+			writeJSONFile(summarizeBatch(results, time.Since(start)), *summaryArgPtr)
+		}
+
+		if *contactSheetArgPtr != "" {
+			writeContactSheet(results, parameters, *contactSheetArgPtr)
+		}
+	}
+}
+
+// listInputFiles returns the paths of all regular files in the given directory in lexical order. Hidden files are
+// left out.
+func listInputFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// batchOutputPath returns the path of the edge image of the given input file in the given output directory, which is
+// a PNG file of the same base name.
+func batchOutputPath(input, outputDir string) string {
+	name := filepath.Base(input)
+	return filepath.Join(outputDir, strings.TrimSuffix(name, filepath.Ext(name))+".png")
+}
+
+// batchOutputPaths returns the paths of the edge images of the given input files in the given output directory, see
+// batchOutputPath. Inputs whose names only differ by their extension would overwrite each other, so their edge images
+// keep the extension in the name instead, e.g. img_jpg.png and img_png.png.
+func batchOutputPaths(inputs []string, inputDir, outputDir string) []string {
+	outputs := make([]string, len(inputs))
+	count := make(map[string]int)
+	for i, input := range inputs {
+		outputs[i] = batchOutputPath(input, outputDir)
+		count[outputs[i]]++
+	}
+	for i, input := range inputs {
+		if count[outputs[i]] > 1 {
+			name := batchInputName(input, inputDir)
+			name = strings.TrimSuffix(name, filepath.Ext(name)) + "_" + strings.TrimPrefix(filepath.Ext(name), ".")
+			outputs[i] = filepath.Join(outputDir, filepath.FromSlash(name)+".png")
+		}
+	}
+	return outputs
+}
+
+// batchInputName returns the path of the given input file relative to the given input directory with forward
+// slashes, which identifies the file in parameters files.
+func batchInputName(input, inputDir string) string {
+	name, err := filepath.Rel(inputDir, input)
+	if err != nil {
+		name = filepath.Base(input)
+	}
+	return filepath.ToSlash(name)
+}
+
+// readBatchManifest reads the manifest at the given path, which maps the names of edge images to the parameters they
+// were produced with. A missing or unreadable manifest gives an empty one, so all files are processed again.
+func readBatchManifest(path string) map[string]string {
+	manifest := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return manifest
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return make(map[string]string)
+	}
+	return manifest
+}
+
+// isNewer checks whether the file at the given path exists and was modified after the reference file.
+func isNewer(path, reference string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	referenceInfo, err := os.Stat(reference)
+	if err != nil {
+		return false
+	}
+	return info.ModTime().After(referenceInfo.ModTime())
+}
+
+// processBatchFile detects the edges of the input file and writes them to the output file. A non-empty region of
+// interest crops the image before detection. Images exceeding the given maximum dimension are handled according to
+// the given policy, a maximum of zero disables the limit. The size of the input image in megapixels is returned.
+func processBatchFile(detector *Detector, input, output string, roi image.Rectangle, maxDimension int, oversize string) (float64, error) {
+	if maxDimension > 0 && oversize == "reject" {
+		if err := checkDimensions(input, "", maxDimension); err != nil {
+			return 0, err
+		}
+	}
+	file, err := os.Open(input)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close() // opened for reading, no error checking needed
+	pixels, err := getPixelArray(file, "")
+	if err != nil {
+		return 0, err
+	}
+	megapixels := float64(len(pixels)*len(pixels[0])) / 1e6
+	if pixels, err = cropToRegion(pixels, roi); err != nil {
+		return megapixels, err
+	}
+	if maxDimension > 0 {
+		pixels = downscalePixels(pixels, maxDimension)
+	}
+	writeImage(samplesToPixels(DetectSamples(detector, pixelsToSamples(pixels), nil)), output)
+	return megapixels, nil
+}
+
+// summarizeBatch returns the summary of a batch run with the given results that took the given time.
+func summarizeBatch(results []BatchResult, wallTime time.Duration) BatchSummary {
+	summary := BatchSummary{WallTime: wallTime.Seconds(), Files: []BatchFileReport{}}
+	for _, result := range results {
+		report := BatchFileReport{Input: result.Input, Status: "processed", Megapixels: result.Megapixels,
+			Time: result.Duration.Seconds()}
+		if result.Skipped {
+			report.Output, report.Status = result.Output, "skipped"
+			summary.Skipped++
+		} else if result.Err != nil {
+			report.Status, report.Reason = "failed", result.Err.Error()
+			summary.Failed++
+		} else {
+			report.Output = result.Output
+			summary.Processed++
+		}
+		summary.Megapixels += result.Megapixels
+		summary.Files = append(summary.Files, report)
+	}
+	return summary
+}
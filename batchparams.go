@@ -0,0 +1,210 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// BATCH_OVERRIDE_NAMES are the parameters that can be set per file in batch mode. A preset names a JSON file of the
+// same form as config files whose values are applied before the other values of the file.
+var BATCH_OVERRIDE_NAMES = []string{"min", "max", "blur", "sigma", "roi", "preset"}
+
+// BatchOverride holds the parameters of a single file of a batch run by name, as given in a parameters file.
+type BatchOverride map[string]string
+
+// readBatchOverrides reads the per-file parameters of a batch run from the CSV or JSON file at the given path. CSV
+// files start with a header naming the columns, JSON files hold an array of objects. Every row needs an input column
+// with the path of the file relative to the input directory, the other columns are BATCH_OVERRIDE_NAMES and empty
+// values are ignored. Presets are resolved relative to the parameters file. The overrides are returned by input path.
+func readBatchOverrides(path string) (map[string]BatchOverride, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // opened for reading, no error checking needed
+
+	var rows []BatchOverride
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		rows, err = readBatchOverridesJSON(file)
+	} else {
+		rows, err = readBatchOverridesCSV(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameters file %s: %v", path, err)
+	}
+
+	overrides := make(map[string]BatchOverride, len(rows))
+	for i, row := range rows {
+		input := row["input"]
+		if input == "" {
+			return nil, fmt.Errorf("invalid parameters file %s: row %d has no input", path, i+1)
+		}
+		delete(row, "input")
+		for name, value := range row {
+			if !slices.Contains(BATCH_OVERRIDE_NAMES, name) {
+				return nil, fmt.Errorf("invalid parameters file %s: unknown parameter %s", path, name)
+			}
+			if value == "" {
+				delete(row, name)
+			}
+		}
+		if preset, ok := row["preset"]; ok && !filepath.IsAbs(preset) {
+			row["preset"] = filepath.Join(filepath.Dir(path), preset)
+		}
+		overrides[filepath.Clean(input)] = row
+	}
+	return overrides, nil
+}
+
+// readBatchOverridesCSV reads the rows of a CSV parameters file.
+func readBatchOverridesCSV(r io.Reader) ([]BatchOverride, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("missing header")
+	}
+	var rows []BatchOverride
+	for _, record := range records[1:] {
+		row := make(BatchOverride, len(record))
+		for i, value := range record {
+			row[strings.TrimSpace(records[0][i])] = strings.TrimSpace(value)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// readBatchOverridesJSON reads the rows of a JSON parameters file, values may be strings, numbers or booleans.
+func readBatchOverridesJSON(r io.Reader) ([]BatchOverride, error) {
+	var raw []map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+	rows := make([]BatchOverride, len(raw))
+	for i, object := range raw {
+		rows[i] = make(BatchOverride, len(object))
+		for name, value := range object {
+			text, ok := jsonFlagValue(value)
+			if !ok {
+				return nil, fmt.Errorf("invalid value for %s in row %d", name, i+1)
+			}
+			rows[i][name] = text
+		}
+	}
+	return rows, nil
+}
+
+// String returns the parameters of the override sorted by name, e.g. to record them in the manifest of a batch run.
+func (o BatchOverride) String() string {
+	var parameters []string
+	for name, value := range o {
+		parameters = append(parameters, name+"="+value)
+	}
+	sort.Strings(parameters)
+	return strings.Join(parameters, " ")
+}
+
+// apply returns a copy of the given detector with the parameters of the override and the region of interest, which is
+// empty if the whole image is processed.
+func (o BatchOverride) apply(detector *Detector) (*Detector, image.Rectangle, error) {
+	values := o
+	if preset, ok := o["preset"]; ok {
+		presetValues, err := readConfigFile(preset)
+		if err != nil {
+			return nil, image.Rectangle{}, err
+		}
+		values = make(BatchOverride, len(presetValues)+len(o))
+		for name, value := range presetValues {
+			if !slices.Contains(BATCH_OVERRIDE_NAMES, name) || name == "preset" {
+				return nil, image.Rectangle{}, fmt.Errorf("invalid parameter %s in preset %s", name, preset)
+			}
+			values[name] = value
+		}
+		for name, value := range o {
+			values[name] = value
+		}
+	}
+
+	d := detector.Clone()
+	blur := &blurFlag{enabled: d.Blur, filter: d.BlurFilter}
+	roi := &roiFlag{}
+	flags := flag.NewFlagSet("parameters", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.Float64Var(&d.MinRatio, "min", d.MinRatio, "")
+	flags.Float64Var(&d.MaxRatio, "max", d.MaxRatio, "")
+	flags.Var(blur, "blur", "")
+	flags.Float64Var(&d.Sigma, "sigma", d.Sigma, "")
+	flags.Var(roi, "roi", "")
+	flags.String("preset", "", "")
+	for name, value := range values {
+		if err := flags.Set(name, value); err != nil {
+			return nil, image.Rectangle{}, fmt.Errorf("invalid value for %s: %v", name, err)
+		}
+	}
+	d.Blur, d.BlurFilter = blur.enabled, blur.filter
+	if !isValidRatioValue(d.MinRatio) || !isValidRatioValue(d.MaxRatio) || d.Sigma < 0 {
+		return nil, image.Rectangle{}, errors.New("invalid value for parameters")
+	}
+	return d, roi.rect, nil
+}
+
+// cropToRegion crops the given pixels to the region of interest clipped to the image. An empty region leaves the pixels
+// unchanged, a region outside of the image gives an error.
+func cropToRegion(pixels [][]GrayPixel, roi image.Rectangle) ([][]GrayPixel, error) {
+	if roi.Empty() {
+		return pixels, nil
+	}
+	if roi = roi.Intersect(image.Rect(0, 0, len(pixels[0]), len(pixels))); roi.Empty() {
+		return nil, errors.New("region of interest outside of the image")
+	}
+	return cropPixels(pixels, roi), nil
+}
+
+// roiFlag is a region of interest given as x,y,width,height.
+type roiFlag struct {
+	rect image.Rectangle
+}
+
+// String returns the current value of the flag.
+func (f *roiFlag) String() string {
+	return fmt.Sprintf("%d,%d,%d,%d", f.rect.Min.X, f.rect.Min.Y, f.rect.Dx(), f.rect.Dy())
+}
+
+// Set parses the given value of the flag.
+func (f *roiFlag) Set(value string) error {
+	var x, y, width, height int
+	if _, err := fmt.Sscanf(value, "%d,%d,%d,%d", &x, &y, &width, &height); err != nil || width <= 0 || height <= 0 {
+		return errors.New("expected x,y,width,height")
+	}
+	f.rect = image.Rect(x, y, x+width, y+height)
+	return nil
+}
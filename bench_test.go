@@ -0,0 +1,133 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/deckarep/golang-set"
+)
+
+// BENCHMARK_SIZES are the edge lengths of the square images the stages are benchmarked on.
+var BENCHMARK_SIZES = []int{128, 512, 1024}
+
+// benchmarkSamples returns the fixture of the benchmarks, the disc of testPixels with gaussian noise of a fixed seed
+// so every run and machine works on the same image.
+func benchmarkSamples(size int) [][]uint8 {
+	return addNoise(pixelsToSamples(testPixels(size, size)), "gaussian", 5, rand.New(rand.NewSource(1)))
+}
+
+// benchmarkStage runs the given stage for every benchmark size. The prepare function returns the input of the stage
+// for the fixture of the given size, it is called again before every iteration if the stage works in place.
+func benchmarkStage[I any](b *testing.B, prepare func(size int) I, inPlace bool, stage func(input I)) {
+	for _, size := range BENCHMARK_SIZES {
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			input := prepare(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if inPlace && i > 0 {
+					b.StopTimer()
+					input = prepare(size)
+					b.StartTimer()
+				}
+				stage(input)
+			}
+		})
+	}
+}
+
+// gradientInput is the input of the stages following the gradient.
+type gradientInput struct {
+	magnitude  [][]uint8
+	directions [][]float64
+	low, high  float64
+}
+
+// prepareGradient returns the fixture of the given size after blur and gradient, with the thresholds of the default
+// parameters. Suppression is applied as well if suppressed is set.
+func prepareGradient(size int, suppressed bool) gradientInput {
+	detector := NewDetector(true, 0.2, 0.6)
+	magnitude, directions := sobel(blurSamples(detector, benchmarkSamples(size), nil), nil, detector.Workers)
+	if suppressed {
+		magnitude = nonMaximumSuppression(magnitude, directions, detector.Workers)
+	}
+	low, high := ratioThresholds(magnitude, detector.MinRatio, detector.MaxRatio, detector.Percentile)
+	return gradientInput{magnitude, directions, low, high}
+}
+
+// BenchmarkBlur measures the default gaussian blur.
+func BenchmarkBlur(b *testing.B) {
+	detector := NewDetector(true, 0.2, 0.6)
+	benchmarkStage(b, benchmarkSamples, false, func(samples [][]uint8) {
+		blurSamples(detector, samples, nil)
+	})
+}
+
+// BenchmarkSobel measures the gradient magnitude and direction.
+func BenchmarkSobel(b *testing.B) {
+	detector := NewDetector(true, 0.2, 0.6)
+	prepare := func(size int) [][]uint8 { return blurSamples(detector, benchmarkSamples(size), nil) }
+	benchmarkStage(b, prepare, false, func(samples [][]uint8) {
+		sobel(samples, nil, detector.Workers)
+	})
+}
+
+// BenchmarkNonMaximumSuppression measures the thinning of the gradient magnitude.
+func BenchmarkNonMaximumSuppression(b *testing.B) {
+	prepare := func(size int) gradientInput { return prepareGradient(size, false) }
+	benchmarkStage(b, prepare, false, func(input gradientInput) {
+		nonMaximumSuppression(input.magnitude, input.directions, 1)
+	})
+}
+
+// BenchmarkThreshold measures the computation of the thresholds and the double threshold.
+func BenchmarkThreshold(b *testing.B) {
+	prepare := func(size int) gradientInput { return prepareGradient(size, true) }
+	benchmarkStage(b, prepare, true, func(input gradientInput) {
+		low, high := ratioThresholds(input.magnitude, 0.2, 0.6, 1)
+		doublethreshold(input.magnitude, high, low)
+	})
+}
+
+// BenchmarkTracking measures the hysteresis tracking of thresholded pixels.
+func BenchmarkTracking(b *testing.B) {
+	type trackingInput struct {
+		magnitude    [][]uint8
+		strong, weak mapset.Set
+	}
+	prepare := func(size int) trackingInput {
+		input := prepareGradient(size, true)
+		strong, weak := doublethreshold(input.magnitude, input.high, input.low)
+		return trackingInput{input.magnitude, strong, weak}
+	}
+	benchmarkStage(b, prepare, true, func(input trackingInput) {
+		edgeTracking(input.magnitude, input.strong, input.weak)
+	})
+}
+
+// BenchmarkDetect measures a complete detection with the default parameters.
+func BenchmarkDetect(b *testing.B) {
+	detector := NewDetector(true, 0.2, 0.6)
+	prepare := func(size int) [][]GrayPixel { return samplesToPixels(benchmarkSamples(size)) }
+	benchmarkStage(b, prepare, false, func(pixels [][]GrayPixel) {
+		detector.Detect(pixels)
+	})
+}
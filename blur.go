@@ -0,0 +1,265 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// IIR_SIGMA_THRESHOLD is the standard deviation above which the gaussian blur is computed recursively instead of by
+// convolution with a kernel, the cost of the recursive filter doesn't depend on sigma.
+const IIR_SIGMA_THRESHOLD = 3.0
+
+// enumeration type for denoting the filter used for blurring
+type BlurFilter int
+
+const (
+	GAUSSIAN BlurFilter = iota
+	BOX
+)
+
+// blurFlag is the value of the blur command line flag. It is a boolean flag that alternatively accepts the name of a
+// filter, so -blur, -blur=false and -blur=box are all valid.
+type blurFlag struct {
+	enabled bool
+	filter  BlurFilter
+}
+
+// String returns the current value of the flag.
+func (f *blurFlag) String() string {
+	if !f.enabled {
+		return "false"
+	}
+	if f.filter == BOX {
+		return "box"
+	}
+	return "gaussian"
+}
+
+// Set parses the given value of the flag.
+func (f *blurFlag) Set(value string) error {
+	switch value {
+	case "gaussian":
+		f.enabled, f.filter = true, GAUSSIAN
+	case "box":
+		f.enabled, f.filter = true, BOX
+	case "none":
+		f.enabled = false
+	default:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("expected true, false, gaussian, box or none")
+		}
+		f.enabled, f.filter = enabled, GAUSSIAN
+	}
+	return nil
+}
+
+// IsBoolFlag allows giving the flag without value.
+func (f *blurFlag) IsBoolFlag() bool {
+	return true
+}
+
+// boxBlur blurs the given image by repeatedly averaging the pixels in a square window of the given radius. Three
+// passes approximate a gaussian blur closely enough for edge detection. The window sums are taken from an integral
+// image, so the cost per pixel doesn't depend on the radius. Only valid pixels are averaged, invalid pixels keep their
+// value. All passes are computed with floating point precision and converted back to the sample type at the end.
+func boxBlur[T Sample](pixels [][]T, radius, passes int, valid [][]bool, workers int) [][]T {
+	height := len(pixels)
+	values := make([][]float64, height)
+	for y := range pixels {
+		values[y] = make([]float64, len(pixels[y]))
+		for x := range pixels[y] {
+			values[y][x] = float64(pixels[y][x])
+		}
+	}
+
+	for pass := 0; pass < passes; pass++ {
+		sums, counts := integralImage(values, valid)
+		next := make([][]float64, height)
+		parallelRows(height, workers, func(y int) {
+			next[y] = make([]float64, len(values[y]))
+			boxBlurRow(values, sums, counts, valid, radius, y, next[y])
+		})
+		values = next
+	}
+
+	result := make([][]T, height)
+	for y := range values {
+		result[y] = make([]T, len(values[y]))
+		for x := range values[y] {
+			result[y][x] = saturate[T](values[y][x])
+		}
+	}
+	return result
+}
+
+// boxBlurRow computes the row with the given index of the next pass of boxBlur from the integral images of the current
+// pass and stores it in the given row. It doesn't allocate memory, so real-time detection shares it with boxBlur.
+func boxBlurRow(values, sums, counts [][]float64, valid [][]bool, radius, y int, next []float64) {
+	height, width := len(values), len(values[y])
+	minY, maxY := max(0, y-radius), min(height, y+radius+1)
+	for x := 0; x < width; x++ {
+		if !isValidPixel(valid, x, y) {
+			next[x] = values[y][x]
+			continue
+		}
+		minX, maxX := max(0, x-radius), min(width, x+radius+1)
+		sum := sums[maxY][maxX] - sums[minY][maxX] - sums[maxY][minX] + sums[minY][minX]
+		count := counts[maxY][maxX] - counts[minY][maxX] - counts[maxY][minX] + counts[minY][minX]
+		next[x] = sum / count
+	}
+}
+
+// integralImage returns the summed area tables of the valid pixel values and of the number of valid pixels. Entry
+// (y, x) holds the sum over all pixels above and left of (y, x), so the tables have one more row and column than the
+// image.
+func integralImage(values [][]float64, valid [][]bool) ([][]float64, [][]float64) {
+	height := len(values)
+	width := len(values[0])
+	sums := make([][]float64, height+1)
+	counts := make([][]float64, height+1)
+	for y := range sums {
+		sums[y] = make([]float64, width+1)
+		counts[y] = make([]float64, width+1)
+	}
+	integrateInto(values, valid, sums, counts)
+	return sums, counts
+}
+
+// integrateInto computes the summed area tables of integralImage into the given tables, which have to be zero in the
+// first row and column. It doesn't allocate memory, so real-time detection shares it with boxBlur.
+func integrateInto(values [][]float64, valid [][]bool, sums, counts [][]float64) {
+	height := len(values)
+	width := len(values[0])
+	for y := 0; y < height; y++ {
+		var rowSum, rowCount float64
+		for x := 0; x < width; x++ {
+			if isValidPixel(valid, x, y) {
+				rowSum += values[y][x]
+				rowCount++
+			}
+			sums[y+1][x+1] = sums[y][x+1] + rowSum
+			counts[y+1][x+1] = counts[y][x+1] + rowCount
+		}
+	}
+}
+
+// gaussianKernel returns the normalized gaussian kernel with the given standard deviation. The kernel covers three
+// standard deviations to either side.
+func gaussianKernel(sigma float64) vector {
+	radius := int(math.Ceil(3 * sigma))
+	values := make([]float64, 2*radius+1)
+	for i := range values {
+		d := float64(i - radius)
+		values[i] = math.Exp(-d * d / (2 * sigma * sigma))
+	}
+	return normalizeVec(*newVector(values))
+}
+
+// recursiveGaussianBlur approximates a gaussian blur with the given standard deviation by the recursive filter of
+// Young and van Vliet, which is applied forwards and backwards along all rows and then all columns. Invalid pixels are
+// left out by filtering the mask along with the image and normalizing the result with it, they keep their value.
+func recursiveGaussianBlur[T Sample](pixels [][]T, sigma float64, valid [][]bool, workers int) [][]T {
+	height := len(pixels)
+	width := len(pixels[0])
+	values := make([][]float64, height)
+	weights := make([][]float64, height)
+	for y := range pixels {
+		values[y] = make([]float64, width)
+		weights[y] = make([]float64, width)
+		for x := range pixels[y] {
+			if isValidPixel(valid, x, y) {
+				values[y][x] = float64(pixels[y][x])
+				weights[y][x] = 1
+			}
+		}
+	}
+
+	coefficients := youngVanVlietCoefficients(sigma)
+	parallelRows(height, workers, func(y int) {
+		recursiveFilter(values[y], coefficients)
+		recursiveFilter(weights[y], coefficients)
+	})
+	parallelRows(width, workers, func(x int) {
+		column := make([]float64, height)
+		for _, plane := range [][][]float64{values, weights} {
+			for y := range column {
+				column[y] = plane[y][x]
+			}
+			recursiveFilter(column, coefficients)
+			for y := range column {
+				plane[y][x] = column[y]
+			}
+		}
+	})
+
+	result := make([][]T, height)
+	for y := range pixels {
+		result[y] = make([]T, width)
+		for x := range pixels[y] {
+			if isValidPixel(valid, x, y) && weights[y][x] > 0 {
+				result[y][x] = saturate[T](values[y][x] / weights[y][x])
+			} else {
+				result[y][x] = pixels[y][x]
+			}
+		}
+	}
+	return result
+}
+
+// youngVanVlietCoefficients returns the coefficients B, b1, b2 and b3 of the recursive gaussian filter for the given
+// standard deviation, with b1 to b3 already divided by b0.
+func youngVanVlietCoefficients(sigma float64) [4]float64 {
+	var q float64
+	if sigma >= 2.5 {
+		q = 0.98711*sigma - 0.96330
+	} else {
+		q = 3.97156 - 4.14554*math.Sqrt(1-0.26891*sigma)
+	}
+	q2, q3 := q*q, q*q*q
+	b0 := 1.57825 + 2.44413*q + 1.4281*q2 + 0.422205*q3
+	b1 := (2.44413*q + 2.85619*q2 + 1.26661*q3) / b0
+	b2 := -(1.4281*q2 + 1.26661*q3) / b0
+	b3 := 0.422205 * q3 / b0
+	return [4]float64{1 - (b1 + b2 + b3), b1, b2, b3}
+}
+
+// recursiveFilter applies the recursive gaussian filter with the given coefficients to the values in place, first
+// forwards and then backwards. The values beyond both ends are taken to equal the values at the ends.
+func recursiveFilter(values []float64, coefficients [4]float64) {
+	n := len(values)
+	if n == 0 {
+		return
+	}
+	B, b1, b2, b3 := coefficients[0], coefficients[1], coefficients[2], coefficients[3]
+	w1, w2, w3 := values[0], values[0], values[0]
+	for i := 0; i < n; i++ {
+		w := B*values[i] + b1*w1 + b2*w2 + b3*w3
+		values[i] = w
+		w1, w2, w3 = w, w1, w2
+	}
+	w1, w2, w3 = values[n-1], values[n-1], values[n-1]
+	for i := n - 1; i >= 0; i-- {
+		w := B*values[i] + b1*w1 + b2*w2 + b3*w3
+		values[i] = w
+		w1, w2, w3 = w, w1, w2
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"math"
+)
+
+// burstCommand implements the burst subcommand, which detects the edges of a handheld photo burst. The frames are
+// aligned to the first one and averaged, which reduces the noise of the frames before the detection without blurring
+// edges.
+func burstCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("burst", flag.ExitOnError)
+	outputFileArgPtr := flags.String("output", "burst.png", "path to output file (optional, default: burst.png)")
+	averageFileArgPtr := flags.String("average", "", "path to write the averaged frames to (optional)")
+	alignArgPtr := flags.Int("align", 16, "compensate camera shifts of up to N pixels between frames (optional, default: 16, 0 = off)")
+	minThresholdArgPtr := flags.Float64("min", float64(0.2), "ratio of lower threshold (optional, default: 0.2)")
+	maxThresholdArgPtr := flags.Float64("max", float64(0.6), "ratio of upper threshold (optional, default: 0.6)")
+	return flags, func() {
+		if flags.NArg() == 0 {
+			fmt.Println("No path to input files specified, nothing to do.")
+			return
+		}
+		if !isValidRatioValue(*minThresholdArgPtr) || !isValidRatioValue(*maxThresholdArgPtr) {
+			fmt.Println("Invalid value for threshold ratio given, exiting.")
+			return
+		}
+		if *alignArgPtr < 0 {
+			fmt.Println("Invalid value for alignment range given, exiting.")
+			return
+		}
+
+		frames := make([][][]uint8, flags.NArg())
+		for i, path := range flags.Args() {
+			frames[i] = pixelsToSamples(openImage(path, ""))
+			if len(frames[i]) != len(frames[0]) || len(frames[i][0]) != len(frames[0][0]) {
+				fmt.Println("Frames differ in size, exiting.")
+				return
+			}
+		}
+
+		detector := NewDetector(true, *minThresholdArgPtr, *maxThresholdArgPtr)
+		shifts := make([]image.Point, len(frames))
+		if *alignArgPtr > 0 {
+			shifts = AlignFrames(frames, *alignArgPtr, detector.Workers)
+			for i, shift := range shifts[1:] {
+				fmt.Printf("%s: camera shift %v\n", flags.Arg(i+1), shift)
+			}
+		}
+		// the average is detected with 8-bit samples like single images so that the thresholds have the same effect
+		average := roundSamples(AverageFrames(frames, shifts))
+		if *averageFileArgPtr != "" {
+			writeImage(samplesToPixels(average), *averageFileArgPtr)
+		}
+		writeImage(samplesToPixels(DetectSamples(detector, average, nil)), *outputFileArgPtr)
+	}
+}
+
+// AlignFrames returns the shifts of at most maxShift pixels in both directions by which the content of the given
+// frames is displaced against the first frame, whose shift is zero.
+func AlignFrames[T Sample](frames [][][]T, maxShift, workers int) []image.Point {
+	shifts := make([]image.Point, len(frames))
+	for i := 1; i < len(frames); i++ {
+		shifts[i] = estimateTranslation(frames[0], frames[i], maxShift, workers)
+	}
+	return shifts
+}
+
+// AverageFrames returns the mean of the given frames after compensating their shifts against the first frame. Every
+// pixel is averaged over the frames that cover it after the shift, the first frame covers all of them.
+func AverageFrames[T Sample](frames [][][]T, shifts []image.Point) [][]float64 {
+	height, width := len(frames[0]), len(frames[0][0])
+	average := make([][]float64, height)
+	for y := range average {
+		average[y] = make([]float64, width)
+		for x := range average[y] {
+			sum, count := 0.0, 0
+			for i, frame := range frames {
+				srcX, srcY := x+shifts[i].X, y+shifts[i].Y
+				if srcY < 0 || srcY >= height || srcX < 0 || srcX >= width {
+					continue
+				}
+				sum += float64(frame[srcY][srcX])
+				count++
+			}
+			average[y][x] = sum / float64(count)
+		}
+	}
+	return average
+}
+
+// roundSamples returns the given gray values from 0 to 255 rounded to 8-bit samples.
+func roundSamples(values [][]float64) [][]uint8 {
+	samples := make([][]uint8, len(values))
+	for y := range values {
+		samples[y] = make([]uint8, len(values[y]))
+		for x, value := range values[y] {
+			samples[y][x] = uint8(math.Round(math.Min(math.Max(value, 0), 255)))
+		}
+	}
+	return samples
+}
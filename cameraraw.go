@@ -0,0 +1,171 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+)
+
+// CR2_MAGIC are the magic bytes of CR2 files, which are checked before other TIFF based camera RAW files as they carry
+// their own signature.
+const CR2_MAGIC = "II*\x00\x10\x00\x00\x00CR"
+
+// TIFF tags that locate embedded JPEG images
+const (
+	TAG_SUB_IFDS    = 0x014a
+	TAG_JPEG_OFFSET = 0x0201
+	TAG_JPEG_LENGTH = 0x0202
+	TAG_EXIF_IFD    = 0x8769
+)
+
+// cameraRAWDecoders returns the decoders for CR2, NEF, ARW and other TIFF based camera RAW files. If useDcraw is set
+// and dcraw is installed the files are developed by dcraw, otherwise the largest embedded JPEG preview is decoded.
+func cameraRAWDecoders(useDcraw bool) []Decoder {
+	decode := func(r io.Reader) (image.Image, error) {
+		return decodeCameraRAW(r, useDcraw)
+	}
+	decodeConfig := func(r io.Reader) (image.Config, error) {
+		img, err := decode(r)
+		if err != nil {
+			return image.Config{}, err
+		}
+		return image.Config{ColorModel: img.ColorModel(), Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}, nil
+	}
+	return []Decoder{
+		{"cr2", CR2_MAGIC, decode, decodeConfig},
+		{"raw-le", TIFF_LE_MAGIC, decode, decodeConfig},
+		{"raw-be", TIFF_BE_MAGIC, decode, decodeConfig},
+	}
+}
+
+// decodeCameraRAW decodes a TIFF based camera RAW file on a best-effort basis, see cameraRAWDecoders.
+func decodeCameraRAW(r io.Reader, useDcraw bool) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if useDcraw {
+		if _, err := exec.LookPath("dcraw"); err == nil {
+			return developDcraw(data)
+		}
+	}
+	return decodeRAWPreview(data)
+}
+
+// developDcraw develops the given RAW file with dcraw using the white balance of the camera.
+func developDcraw(data []byte) (image.Image, error) {
+	file, err := os.CreateTemp("", "edgeefy-*.raw")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	output, err := exec.Command("dcraw", "-c", "-w", file.Name()).Output()
+	if err != nil {
+		return nil, err
+	}
+	return decodePNM(bytes.NewReader(output))
+}
+
+// decodeRAWPreview decodes the largest JPEG image embedded in the given TIFF based file. Candidates that can't be
+// decoded, like the lossless JPEG raw data of CR2 files, are skipped.
+func decodeRAWPreview(data []byte) (image.Image, error) {
+	previews, err := findEmbeddedJPEGs(data)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(previews, func(i, j int) bool { return len(previews[i]) > len(previews[j]) })
+	for _, preview := range previews {
+		if img, err := jpeg.Decode(bytes.NewReader(preview)); err == nil {
+			return img, nil
+		}
+	}
+	return nil, errors.New("no decodable preview found in RAW file")
+}
+
+// findEmbeddedJPEGs walks all image file directories of the given TIFF data including sub and EXIF directories and
+// returns the JPEG streams they reference.
+func findEmbeddedJPEGs(data []byte) ([][]byte, error) {
+	if len(data) < 8 {
+		return nil, errors.New("invalid TIFF header")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if string(data[:2]) == "MM" {
+		order = binary.BigEndian
+	}
+
+	var previews [][]byte
+	// addPreview adds the stream at the given position if it is a JPEG stream
+	addPreview := func(offset, length uint32) {
+		end := uint64(offset) + uint64(length)
+		if length > 2 && end <= uint64(len(data)) && data[offset] == 0xff && data[offset+1] == 0xd8 {
+			previews = append(previews, data[offset:end])
+		}
+	}
+
+	visited := make(map[uint32]bool)
+	pending := []uint32{order.Uint32(data[4:])}
+	for len(pending) > 0 {
+		offset := pending[0]
+		pending = pending[1:]
+		if offset == 0 || visited[offset] || uint64(offset)+2 > uint64(len(data)) {
+			continue
+		}
+		visited[offset] = true
+
+		tags := make(map[uint16][]uint32)
+		count := int(order.Uint16(data[offset:]))
+		entries := int(offset) + 2
+		if entries+12*count+4 > len(data) {
+			continue // truncated directory
+		}
+		for i := 0; i < count; i++ {
+			entry := data[entries+12*i:]
+			tags[order.Uint16(entry)] = readTIFFValues(data, entry, order)
+		}
+		pending = append(pending, order.Uint32(data[entries+12*count:]))
+		pending = append(pending, tags[TAG_SUB_IFDS]...)
+		pending = append(pending, tags[TAG_EXIF_IFD]...)
+
+		if len(tags[TAG_JPEG_OFFSET]) == 1 && len(tags[TAG_JPEG_LENGTH]) == 1 {
+			addPreview(tags[TAG_JPEG_OFFSET][0], tags[TAG_JPEG_LENGTH][0])
+		}
+		compression := tags[TAG_COMPRESSION]
+		if len(compression) == 1 && (compression[0] == 6 || compression[0] == 7) &&
+			len(tags[TAG_STRIP_OFFSETS]) == 1 && len(tags[TAG_STRIP_BYTE_COUNTS]) == 1 {
+			addPreview(tags[TAG_STRIP_OFFSETS][0], tags[TAG_STRIP_BYTE_COUNTS][0])
+		}
+	}
+	return previews, nil
+}
@@ -15,49 +15,44 @@
 // You should have received a copy of the GNU General Public License
 // along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
 
-package main
+package edgeefy
 
 import (
 	"errors"
 	"github.com/deckarep/golang-set"
-	"gonum.org/v1/gonum/mat"
-	"gonum.org/v1/gonum/stat/combin"
 	"image"
 	"math"
-)
-
-// enumeration type for denoting vertical or horizontal orientation
-type direction int
-const (
-	HORIZONTAL direction = iota
-	VERTICAL
+	"sort"
 )
 
 var SOBEL_X = []float64{1, 0, -1, 2, 0, -2, 1, 0, -1} // matrix values for sobel filter (x-component)
 var SOBEL_Y = []float64{1, 2, 1, 0, 0, 0, -1, -2, -1} // matrix values for sobel filter (y-component)
 
 func CannyEdgeDetect(pixels [][]GrayPixel, blur bool, minRatio, maxRatio float64) [][]GrayPixel {
-	if blur {
-		pixels = gaussianBlur(pixels, 5)
-	}
-	pixels, angles := sobel(pixels)
-	pixels = nonMaximumSuppression(pixels, angles)
-	max := maxPixelValue(pixels)
-	high := maxRatio*float64(max)
-	low := minRatio*float64(max)
-	strong, weak := doublethreshold(pixels , high, low)
-	edgeTracking(pixels, strong, weak)
-
-	return pixels
+	return NewDetector(blur, minRatio, maxRatio).Detect(pixels)
+}
+
+// CannyEdgeDetectMasked performs the same edge detection as CannyEdgeDetect but only takes pixels into account that are
+// marked as valid in the given mask. Invalid pixels are excluded from blurring and gradient computation and never
+// become edges. A nil mask marks all pixels as valid.
+func CannyEdgeDetectMasked(pixels [][]GrayPixel, valid [][]bool, blur bool, minRatio, maxRatio float64) [][]GrayPixel {
+	return NewDetector(blur, minRatio, maxRatio).DetectMasked(pixels, valid)
 }
 
 // edgeTracking is a function that iterates through the pixels given by the weak pixel set. It is checked whether a
 // weak pixel is neighbour with a pixel from the strong set. If that is the case the weak pixel is added to the strong
-// set. During the process all weak pixels are blackened out from the GrayPixel image.
-func edgeTracking(pixels [][]GrayPixel, strong, weak mapset.Set) {
-	// iterate over set of weak pixels
-	weakIter := weak.Iterator()
-	for weakPixel := range weakIter.C {
+// set. During the process all weak pixels are blackened out from the image. The weak pixels are visited in
+// row major order so the result doesn't depend on the iteration order of the set.
+func edgeTracking[T Sample](pixels [][]T, strong, weak mapset.Set) {
+	// sort set of weak pixels
+	weakPixels := weak.ToSlice()
+	sort.Slice(weakPixels, func(i, j int) bool {
+		p := weakPixels[i].(image.Point)
+		q := weakPixels[j].(image.Point)
+		return p.Y < q.Y || (p.Y == q.Y && p.X < q.X)
+	})
+	// iterate over weak pixels
+	for _, weakPixel := range weakPixels {
 		weakPoint := weakPixel.(image.Point)
 		// check if weak pixel has strong pixel as neighbour
 		neighbours := getAdjacentPixels(pixels, weakPoint.X, weakPoint.Y)
@@ -68,14 +63,14 @@ func edgeTracking(pixels [][]GrayPixel, strong, weak mapset.Set) {
 		// blacken out the weak pixel
 		x := weakPoint.X
 		y := weakPoint.Y
-		pixels[y][x].y = uint8(0)
+		pixels[y][x] = 0
 	}
 }
 
-// getAdjacentPixels returns all neigbouring pixels for a position given by x and y in the given GrayPixel image. Hereby
+// getAdjacentPixels returns all neigbouring pixels for a position given by x and y in the given image. Hereby
 // the boundaries of the image are taken into account, e.g. the pixel at position (0,0) has only three neighbour pixels.
 // The neighbouring pixels are returned in row major order in form of a set.
-func getAdjacentPixels(pixels [][]GrayPixel, x, y int) mapset.Set {
+func getAdjacentPixels[T Sample](pixels [][]T, x, y int) mapset.Set {
 	result := mapset.NewSet()
 	height := len(pixels)
 	width := len(pixels[0])
@@ -98,19 +93,19 @@ func getAdjacentPixels(pixels [][]GrayPixel, x, y int) mapset.Set {
 // doublethreshold compares every pixel of the given two-dimensional image with the two given thresholds and sorts them
 // into two result sets. One for pixels that are above the high threshold (strong edges) and one for pixels of weak
 // edges that fall between the high and low threshold.
-func doublethreshold(pixels [][]GrayPixel, high, low float64) (mapset.Set, mapset.Set) {
+func doublethreshold[T Sample](pixels [][]T, high, low float64) (mapset.Set, mapset.Set) {
 	strong := mapset.NewSet()
 	weak := mapset.NewSet()
 	// iterate through image pixels and compare with threshold values
 	for y:=0; y<len(pixels); y++ {
 		for x:=0; x<len(pixels[0]); x++ {
-			pixVal := float64(pixels[y][x].y)
+			pixVal := float64(pixels[y][x])
 			if pixVal > high {
 				strong.Add(image.Point{x, y})
 			} else if (high > pixVal) && (pixVal > low) {
 				weak.Add(image.Point{x, y})
 			} else {
-				pixels[y][x].y = uint8(0)
+				pixels[y][x] = 0
 			}
 		}
 	}
@@ -119,98 +114,163 @@ func doublethreshold(pixels [][]GrayPixel, high, low float64) (mapset.Set, mapse
 }
 
 // nonMaximumSuppression performs a filter that isolates the maximum pixels in local areas so that detected edges get
-// thin and clearly outlined.
-func nonMaximumSuppression(pixels [][]GrayPixel, directions [][]float64) [][]GrayPixel {
+// thin and clearly outlined. The rows are processed by the given number of workers.
+func nonMaximumSuppression[T Sample](pixels [][]T, directions [][]float64, workers int) [][]T {
 	// panic if the two given arrays don't have identical dimensions
 	if (len(pixels) != len(directions)) || (len(pixels[0]) != len(directions[0])) {
 		panic(errors.New("dimensions of pixel and direction array must match"))
 	}
-	var result [][]GrayPixel
+	result := make([][]T, len(pixels))
 	// iterate over pixels and evaluate corresponding directions values
-	for y:=0; y<len(pixels); y++ {
-		var resultRow []GrayPixel
-		for x:=0; x<len(pixels[0]); x++ {
-			r := pixels[y][x]
-			p, q := getPixelInGradientDirection(pixels, directions, x, y)
-			if (p.y > r.y) || (q.y > r.y) {	// suppress the pixel by making it black
-				resultRow = append(resultRow, GrayPixel{uint8(0), uint8(255)})
-			} else {	// keep value of the pixel
-				resultRow = append(resultRow, r)
-			}
-		}
-		result = append(result, resultRow)
-	}
+	parallelRows(len(pixels), workers, func(y int) {
+		result[y] = make([]T, len(pixels[y]))
+		suppressRow(pixels, directions, y, result[y])
+	})
 
 	return result
 }
 
+// suppressRow performs the non-maximum suppression of the row with the given index and stores it in the given row of
+// the result. It doesn't allocate memory, so real-time detection shares it with nonMaximumSuppression.
+func suppressRow[T Sample](pixels [][]T, directions [][]float64, y int, result []T) {
+	for x:=0; x<len(pixels[y]); x++ {
+		r := pixels[y][x]
+		p, q := getPixelInGradientDirection(pixels, directions, x, y)
+		if (p > r) || (q > r) {	// suppress the pixel by making it black
+			result[x] = 0
+		} else {	// keep value of the pixel
+			result[x] = r
+		}
+	}
+}
+
 // sobel performs the sobel edge detection filter method on the given image. In addition it returns the gradient
-// directions of all pixels as a two-dimensional array of degree values.
-func sobel(pixels [][]GrayPixel) ([][]GrayPixel, [][]float64){
-	var result [][]GrayPixel
-	var directions [][]float64
-	// build sobel filter kernels
-	sobel_X := *mat.NewDense(3, 3, SOBEL_X)
-	sobel_Y := *mat.NewDense(3, 3, SOBEL_Y)
+// directions of all pixels as a two-dimensional array of degree values. Pixels that are not marked in the given mask
+// get a gradient magnitude of zero. The rows are processed by the given number of workers.
+func sobel[T Sample](pixels [][]T, valid [][]bool, workers int) ([][]T, [][]float64){
+	result := make([][]T, len(pixels))
+	directions := make([][]float64, len(pixels))
 	// apply the two kernels to all pixels
-	for y:=0; y<len(pixels); y++ {
-		var resultRow []GrayPixel
-		var angleRow []float64
-		for x:=0; x<len(pixels[y]); x++ {
-			var angle float64
-			// get matrices with sorrounding pixel values
-			imagePane := getSorroundingPixelMatrix(pixels, y, x, 3)
-			// convolve with kernel for x and y direction
-			sobelRes_X := convolve(imagePane, sobel_X)
-			sobelRes_Y := convolve(imagePane, sobel_Y)
-			// combine results
-			combinedRes := uint8(math.Sqrt(math.Pow(sobelRes_X, 2) + math.Pow(sobelRes_Y, 2)))
-			resultRow = append(resultRow, GrayPixel{combinedRes, uint8(255)})
-			// calculate gradient direction
-			if (sobelRes_X == float64(0)) || (sobelRes_Y == float64(0)) {
-				angle = float64(0)
-			} else {
-				angle = math.Atan(sobelRes_Y / sobelRes_X)
+	parallelRows(len(pixels), workers, func(y int) {
+		result[y] = make([]T, len(pixels[y]))
+		directions[y] = make([]float64, len(pixels[y]))
+		sobelRow(pixels, valid, y, result[y], directions[y])
+	})
+
+	return result, directions
+}
+
+// sobelRow computes the gradient magnitude and directions of the row with the given index like sobel and stores them
+// in the given rows of the results. The sorrounding pixels are taken like getSorroundingPixelMatrix does, but without
+// allocating memory, so real-time detection shares it with sobel.
+func sobelRow[T Sample](pixels [][]T, valid [][]bool, y int, magnitude []T, directions []float64) {
+	height := len(pixels)
+	width := len(pixels[0])
+	for x:=0; x<len(pixels[y]); x++ {
+		var angle float64
+		// convolve the sorrounding pixels with the kernels for x and y direction
+		var sobelRes_X, sobelRes_Y float64
+		for i:=0; i<3; i++ {
+			curY := mirrorIndex(y-1+i, y, height)
+			for j:=0; j<3; j++ {
+				curX := mirrorIndex(x-1+j, x, width)
+				value := float64(pixels[curY][curX])
+				if !isValidPixel(valid, curX, curY) {	// use the center pixel in place of invalid ones
+					value = float64(pixels[y][x])
+				}
+				sobelRes_X += value * SOBEL_X[3*i+j]
+				sobelRes_Y += value * SOBEL_Y[3*i+j]
 			}
-			angle = angle * (180/math.Pi)	// convert from radians to degree
-			angleRow = append(angleRow, angle)
 		}
-		result = append(result, resultRow)
-		directions = append(directions, angleRow)
+		// combine results
+		combinedRes := saturate[T](math.Sqrt(math.Pow(sobelRes_X, 2) + math.Pow(sobelRes_Y, 2)))
+		if !isValidPixel(valid, x, y) {	// invalid pixels never carry a gradient
+			combinedRes = 0
+		}
+		magnitude[x] = combinedRes
+		// calculate gradient direction
+		if (sobelRes_X == float64(0)) || (sobelRes_Y == float64(0)) {
+			angle = float64(0)
+		} else {
+			angle = math.Atan(sobelRes_Y / sobelRes_X)
+		}
+		directions[x] = angle * (180/math.Pi)	// convert from radians to degree
 	}
-
-	return result, directions
 }
 
 // gaussianBlur performs a gaussian blur filtering on the given image by using a kernel of the given size. Note that the
-// kernel size must be odd, otherwise the function will panic. The blurred image is returned.
-func gaussianBlur(pixels [][]GrayPixel, kernelSize uint) [][]GrayPixel {
+// kernel size must be odd, otherwise the function will panic. Pixels that are not marked in the given mask are left
+// out of the blur. The rows are processed by the given number of workers. The blurred image is returned.
+func gaussianBlur[T Sample](pixels [][]T, kernelSize uint, valid [][]bool, workers int) [][]T {
 	if kernelSize%2 == 0 { // we only allow odd kernel sizes, panic if it is even
 		panic(errors.New("size of kernel must be odd"))
 	}
-	var result [][]GrayPixel
 	kernel := getPascalTriangleRow(kernelSize - 1) // to get n kernel elements we need the (n-1)th row
 	kernel = normalizeVec(kernel)                  // normalize kernel so we don't change brightness of the pixels
+	return kernelBlur(pixels, kernel, valid, workers)
+}
+
+// kernelBlur applies the given normalized one-dimensional kernel of odd length to the given image in horizontal and
+// vertical direction. Pixels that are not marked in the given mask are left out of the blur. The rows are processed
+// by the given number of workers. The blurred image is returned.
+func kernelBlur[T Sample](pixels [][]T, kernel vector, valid [][]bool, workers int) [][]T {
+	result := make([][]T, len(pixels))
+	weights := make([]float64, kernel.Len())
+	for i := range weights {
+		weights[i] = kernel.At(i, 0)
+	}
 	// iterate over each pixel of the image and apply the gaussian kernel
-	for y := 0; y < len(pixels); y++ {
-		var resultRow []GrayPixel
-		for x := 0; x < len(pixels[y]); x++ {
-			vecVert := getPixelVector(pixels, y, x, kernel.Len(), VERTICAL)
-			vecHor := getPixelVector(pixels, y, x, kernel.Len(), HORIZONTAL)
-			verticalSum := innerProduct(vecVert, kernel)
-			horizontalSum := innerProduct(vecHor, kernel)
-			combinedRes := uint8(math.Sqrt(verticalSum*verticalSum + horizontalSum*horizontalSum))	// combine both sums
-			resultRow = append(resultRow, GrayPixel{combinedRes, 255})
+	parallelRows(len(pixels), workers, func(y int) {
+		result[y] = make([]T, len(pixels[y]))
+		kernelBlurRow(pixels, weights, valid, y, result[y])
+	})
+
+	return result
+}
+
+// kernelBlurRow blurs the row with the given index with the given kernel weights like kernelBlur and stores it in the
+// given row of the result. Pixels beyond the borders are mirrored at the blurred pixel and pixels that are not marked
+// in the given mask are replaced by it. It doesn't allocate memory, so real-time detection shares it with kernelBlur.
+func kernelBlurRow[T Sample](pixels [][]T, weights []float64, valid [][]bool, y int, result []T) {
+	padding := len(weights) / 2
+	height := len(pixels)
+	width := len(pixels[y])
+	for x := 0; x < width; x++ {
+		var verticalSum, horizontalSum float64
+		for i, weight := range weights {
+			curY := mirrorIndex(y-padding+i, y, height)
+			value := float64(pixels[curY][x])
+			if !isValidPixel(valid, x, curY) { // use the center pixel in place of invalid ones
+				value = float64(pixels[y][x])
+			}
+			verticalSum += value * weight
+		}
+		for i, weight := range weights {
+			curX := mirrorIndex(x-padding+i, x, width)
+			value := float64(pixels[y][curX])
+			if !isValidPixel(valid, curX, y) {
+				value = float64(pixels[y][x])
+			}
+			horizontalSum += value * weight
 		}
-		result = append(result, resultRow)
+		result[x] = saturate[T](math.Sqrt(verticalSum*verticalSum + horizontalSum*horizontalSum))	// combine both sums
 	}
+}
 
-	return result
+// mirrorIndex returns the index of the sample that is used at the given index of a row or column of the given length
+// for the pixel at the given center, indices beyond the borders are mirrored at the center.
+func mirrorIndex(i, center, length int) int {
+	if i < 0 {
+		return center - i
+	} else if i >= length {
+		return center - (i - length + 1)
+	}
+	return i
 }
 
-// getPixelInGradientDirection requires an array of GrayPixel and their corresponding gradient directions. It returns
+// getPixelInGradientDirection requires an array of pixels and their corresponding gradient directions. It returns
 // the pixels that lie in the gradient direction of the pixel with the given x and y coordinates.
-func getPixelInGradientDirection(pixels [][]GrayPixel, directions [][]float64, x, y int) (p, q GrayPixel) {
+func getPixelInGradientDirection[T Sample](pixels [][]T, directions [][]float64, x, y int) (p, q T) {
 	var pY, pX, qY, qX int
 	height := len(pixels)
 	width := len(pixels[0])
@@ -253,14 +313,15 @@ func getPixelInGradientDirection(pixels [][]GrayPixel, directions [][]float64, x
 
 // getSorroundingPixelMatrix returns a matrix that contains the pixels sorrounding the pixel at the given location. The
 // resulting matrix is a square with the width defined by the length parameter and is centered at the given pixel
-// location. Note that this function panics if the given length is an even number.
-func getSorroundingPixelMatrix(pixels [][]GrayPixel, posY, posX int, length int) mat.Dense {
+// location. Pixels that are not marked in the given mask are replaced by the center pixel so they don't contribute to
+// gradients. Note that this function panics if the given length is an even number.
+func getSorroundingPixelMatrix[T Sample](pixels [][]T, posY, posX int, length int, valid [][]bool) matrix {
 	if length%2 == 0 { // length must be an odd number
 		panic(errors.New("length must be odd number"))
 	}
 
 	var values []float64 // return values
-	var currentPixel GrayPixel
+	var currentPixel T
 	padding := (length / 2) // how much pixels to left, right, top and bottom we need
 	// get limits for loop indices
 	minX := posX - padding
@@ -289,85 +350,21 @@ func getSorroundingPixelMatrix(pixels [][]GrayPixel, posY, posX int, length int)
 			} else {
 				curX = x
 			}
-			// append pixel value
+			// append pixel value, use the center pixel in place of invalid ones
 			currentPixel = pixels[curY][curX]
-			values = append(values, float64(currentPixel.y))
-		}
-	}
-
-	return *mat.NewDense(length, length, values)
-}
-
-// getPixelVector returns a vector of given length from the given [][]GrayPixel. The pixels are taken from the
-// position given by x and y and from the nearby area as denoted by the direction parameter. In case of border pixels
-// pixel values mirrored from inside the image are used instead. The fact that an equal amount of pixels is to be
-// returned from the left and right side of the given position requires the length parameter to be an odd number. In
-// cases of length being an even number the function panics.
-func getPixelVector(pixels [][]GrayPixel, posY, posX int, length int, dir direction) mat.VecDense {
-	if length%2 == 0 { // length must be an odd number
-		panic(errors.New("length must be odd number"))
-	}
-
-	var values []float64 // return values
-	var currentPixel GrayPixel
-	padding := (length / 2) // how much pixels to either the left and right or top and bottom we need
-
-	switch dir {
-	case HORIZONTAL:
-		minX := posX - padding
-		maxX := posX + padding
-		for i := minX; i <= maxX; i++ {
-			rowLength := len(pixels[posY])
-			if i < 0 { // left border pixels
-				currentPixel = pixels[posY][posX+abs(i)]
-			} else if i >= rowLength { // right border pixels
-				overlap := i - rowLength + 1 // add 1 because array length is bigger than last valid index
-				currentPixel = pixels[posY][posX-overlap]
-			} else { // non-border pixels
-				currentPixel = pixels[posY][i]
-			}
-			values = append(values, float64(currentPixel.y))
-
-		}
-	case VERTICAL:
-		minY := posY - padding
-		maxY := posY + padding
-		for i := minY; i <= maxY; i++ {
-			columnLength := len(pixels)
-			if i < 0 { // top border pixels
-				currentPixel = pixels[posY+abs(i)][posX]
-			} else if i >= columnLength { // bottom border pixels
-				overlap := i - columnLength + 1 // add 1 because array length is bigger than last valid index
-				currentPixel = pixels[posY-overlap][posX]
-			} else { // non-border pixels
-				currentPixel = pixels[i][posX]
+			if !isValidPixel(valid, curX, curY) {
+				currentPixel = pixels[posY][posX]
 			}
-			values = append(values, float64(currentPixel.y))
+			values = append(values, float64(currentPixel))
 		}
 	}
 
-	return *mat.NewVecDense(len(values), values)
-}
-
-// innerProduct calculates the inner product of the two given vectors. This means that the result is the sum of the
-// products of the first elements of both vectors and the sum of the second elements of both vectors and so on. Note
-// that this function panics if the length of both given vectors is not equal.
-func innerProduct(pixels, kernel mat.VecDense) float64 {
-	if pixels.Len() != kernel.Len() { // vectors must have equal length
-		panic(errors.New("length of given vectors must be equal"))
-	}
-
-	var result float64 = 0
-	for i := 0; i < pixels.Len(); i++ {
-		result += pixels.At(i, 0) * kernel.At(i, 0)
-	}
-
-	return result
+	return *newMatrix(length, length, values)
 }
 
 // convolve returns the result of the convolution operation with the two given matrices. Note that this function will
 // panic if the dimensions of the matrices are not identical.
-func convolve(m1, m2 mat.Dense) float64 {
+func convolve(m1, m2 matrix) float64 {
 	row_1, col_1 := m1.Dims()
 	row_2, col_2 := m2.Dims()
 	if row_1 != row_2 || col_1 != col_2 {
@@ -387,37 +384,39 @@ func convolve(m1, m2 mat.Dense) float64 {
 }
 
 // getPascalTriangleRow returns the row of a pascal triangle with the given index in the form of a dense column vector.
-func getPascalTriangleRow(index uint) mat.VecDense {
+func getPascalTriangleRow(index uint) vector {
 	size := int(index + 1)          // we need an array that is 1 bigger than the index of the requested row
 	values := make([]float64, size) // array to store row values
 	// calculate the row values via the binomial coefficient
 	for i := 0; i < size; i++ {
-		values[i] = float64(combin.Binomial(int(index), i))
+		values[i] = float64(binomial(int(index), i))
 	}
 	// return row as dense vector
-	result := mat.NewVecDense(size, values)
+	result := newVector(values)
 	return *result
 }
 
 // normalizeVec normalizes a given vector by summing up the elements and returning a new vector with an element sum of 1.
-func normalizeVec(v mat.VecDense) mat.VecDense {
+func normalizeVec(v vector) vector {
 	// calculate the sum of all vector elements
 	var sum float64 = 0
 	for i := 0; i < v.Len(); i++ {
 		sum += v.At(i, 0)
 	}
 	// create result vector that is given vector divided by sum
-	var result mat.VecDense
-	result.ScaleVec(1/sum, v.SliceVec(0, v.Len()))
-	return result
+	values := make([]float64, v.Len())
+	for i := range values {
+		values[i] = v.At(i, 0) * (1 / sum)
+	}
+	return *newVector(values)
 }
 
-// maxPixelValue returns the maximum pixel value of the given two-dimensional GrayPixel array.
-func maxPixelValue(pixels [][]GrayPixel) uint8 {
-	var max uint8 = 0
+// maxPixelValue returns the maximum pixel value of the given two-dimensional pixel array.
+func maxPixelValue[T Sample](pixels [][]T) T {
+	var max T = 0
 	for y:=0; y<len(pixels); y++ {
 		for x:=0; x<len(pixels[0]); x++ {
-			pixVal := pixels[y][x].y
+			pixVal := pixels[y][x]
 			if pixVal > max {
 				max = pixVal
 			}
@@ -427,6 +426,12 @@ func maxPixelValue(pixels [][]GrayPixel) uint8 {
 	return max
 }
 
+// isValidPixel checks whether the pixel at the given position is marked as valid in the given mask. A nil mask marks
+// all pixels as valid.
+func isValidPixel(valid [][]bool, x, y int) bool {
+	return valid == nil || valid[y][x]
+}
+
 // abs returns the absolute value of the given int.
 func abs(x int) int {
 	if x < 0 {
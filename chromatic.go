@@ -0,0 +1,168 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"image"
+)
+
+// DetectChannels performs canny edge detection on the red, green and blue channels of the given image separately and
+// returns their union with the strongest response of the channels at every pixel. Edges of regions that differ only in
+// hue, which vanish in the luma, are found this way. If FringeDistance is set, edges caused by chromatic aberration of
+// the lens are suppressed, see suppressFringes.
+func (d *Detector) DetectChannels(img image.Image) [][]GrayPixel {
+	bounds := img.Bounds()
+	var channels [3][][]float64
+	for c := range channels {
+		channels[c] = make([][]float64, bounds.Dy())
+	}
+	parallelRows(bounds.Dy(), d.Workers, func(y int) {
+		for c := range channels {
+			channels[c][y] = make([]float64, bounds.Dx())
+		}
+		for x := 0; x < bounds.Dx(); x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			channels[0][y][x], channels[1][y][x], channels[2][y][x] = float64(r), float64(g), float64(b)
+		}
+	})
+	var edges [3][][]float64
+	for c := range channels {
+		edges[c] = DetectSamples(d, channels[c], nil)
+	}
+	if d.FringeDistance > 0 {
+		suppressFringes(edges[:], d.FringeDistance, d.Workers)
+	}
+	return samplesToPixels(mergeChannels(edges[:], d.Workers))
+}
+
+// suppressFringes removes the edge pixels of the given channels that only one channel has and that lie within the given
+// distance of an edge pixel of another channel, unless they are the middle one of the offset copies of the edge.
+// Lateral chromatic aberration shifts the channels against each other by a pixel or two, which turns a single edge into
+// parallel ones of which all but one are only found in a single channel. Of these copies the one nearest to the edges
+// of all other channels is kept, so an edge whose channels are all offset doesn't vanish. The edges of all channels are
+// checked against the original edges of the others, so the result doesn't depend on their order.
+func suppressFringes(edges [][][]float64, distance, workers int) {
+	if len(edges) == 0 {
+		return
+	}
+	height := len(edges[0])
+	// collect the pixels before removing any so that fringes of two channels don't shield each other
+	fringes := make([][][]bool, len(edges))
+	for c := range edges {
+		fringes[c] = make([][]bool, height)
+	}
+	parallelRows(height, workers, func(y int) {
+		for c := range edges {
+			fringes[c][y] = make([]bool, len(edges[c][y]))
+			for x, strength := range edges[c][y] {
+				fringes[c][y][x] = strength > 0 && isFringe(edges, c, x, y, distance)
+			}
+		}
+	})
+	parallelRows(height, workers, func(y int) {
+		for c := range edges {
+			for x, fringe := range fringes[c][y] {
+				if fringe {
+					edges[c][y][x] = 0
+				}
+			}
+		}
+	})
+}
+
+// isFringe checks whether the edge pixel at the given position of the given channel is missing from all other channels
+// while one of them has an edge pixel within the given distance that is nearer to the edges of the remaining channels.
+// Ties are resolved in favour of green, the channel lenses are focused for, and then of the lower channel, so exactly
+// one of the copies of an edge is kept.
+func isFringe(edges [][][]float64, channel, x, y, distance int) bool {
+	for c := range edges {
+		if c != channel && edges[c][y][x] > 0 {
+			return false
+		}
+	}
+	spread := channelSpread(edges, channel, x, y, distance)
+	for c := range edges {
+		if c == channel {
+			continue
+		}
+		u, v, ok := nearestEdge(edges[c], x, y, distance)
+		if !ok {
+			continue
+		}
+		other := channelSpread(edges, c, u, v, distance)
+		if other < spread || (other == spread && fringePriority(c) < fringePriority(channel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// channelSpread returns the sum of the distances from the given pixel of the given channel to the nearest edge pixels
+// of the other channels. Channels without an edge pixel within the given distance count one more than it.
+func channelSpread(edges [][][]float64, channel, x, y, distance int) int {
+	spread := 0
+	for c := range edges {
+		if c == channel {
+			continue
+		}
+		u, v, ok := nearestEdge(edges[c], x, y, distance)
+		if !ok {
+			spread += distance + 1
+			continue
+		}
+		spread += max(abs(u-x), abs(v-y))
+	}
+	return spread
+}
+
+// nearestEdge returns the position of the edge pixel of the given edges nearest to the given position within the given
+// distance, measured as the larger of the offsets along both axes, and reports whether there is one.
+func nearestEdge(edges [][]float64, x, y, distance int) (int, int, bool) {
+	for d := 0; d <= distance; d++ {
+		for v := max(0, y-d); v <= min(len(edges)-1, y+d); v++ {
+			for u := max(0, x-d); u <= min(len(edges[v])-1, x+d); u++ {
+				if max(abs(u-x), abs(v-y)) == d && edges[v][u] > 0 {
+					return u, v, true
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// fringePriority returns the rank of the given channel when copies of an edge are equally near, lower ranks are kept.
+func fringePriority(channel int) int {
+	if channel == 1 {
+		return 0
+	}
+	return channel + 1
+}
+
+// mergeChannels returns the strongest edge response of the given channels at every pixel.
+func mergeChannels(edges [][][]float64, workers int) [][]float64 {
+	merged := make([][]float64, len(edges[0]))
+	parallelRows(len(merged), workers, func(y int) {
+		merged[y] = make([]float64, len(edges[0][y]))
+		for c := range edges {
+			for x, strength := range edges[c][y] {
+				merged[y][x] = max(merged[y][x], strength)
+			}
+		}
+	})
+	return merged
+}
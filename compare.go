@@ -0,0 +1,95 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import "fmt"
+
+// compareOpenCV runs OpenCV's canny implementation on the given pixels with parameters equivalent to the given
+// detector and returns its edge image. It is only available if the program is built with the gocv build tag.
+var compareOpenCV func(pixels [][]GrayPixel, d *Detector) ([][]GrayPixel, error)
+
+// EdgeAgreement describes how well an edge image matches a reference edge image.
+type EdgeAgreement struct {
+	Pixels    int     // number of compared pixels
+	Matching  int     // number of pixels that are edge or non-edge in both images
+	Precision float64 // fraction of edge pixels that have a reference edge pixel within the tolerance
+	Recall    float64 // fraction of reference edge pixels that have an edge pixel within the tolerance
+}
+
+// compareEdges compares the given edge image with the reference. Edge pixels are all pixels with a value above zero.
+// For precision and recall an edge pixel counts as found if the other image has an edge pixel at most tolerance pixels
+// away in both directions, which accounts for edges that are detected with a small offset.
+func compareEdges(edges, reference [][]GrayPixel, tolerance int) EdgeAgreement {
+	var agreement EdgeAgreement
+	var edgeCount, edgeFound, referenceCount, referenceFound int
+	for y := range edges {
+		for x := range edges[y] {
+			isEdge := edges[y][x].y > 0
+			isReference := reference[y][x].y > 0
+			agreement.Pixels++
+			if isEdge == isReference {
+				agreement.Matching++
+			}
+			if isEdge {
+				edgeCount++
+				if hasEdgeNear(reference, x, y, tolerance) {
+					edgeFound++
+				}
+			}
+			if isReference {
+				referenceCount++
+				if hasEdgeNear(edges, x, y, tolerance) {
+					referenceFound++
+				}
+			}
+		}
+	}
+	if edgeCount > 0 {
+		agreement.Precision = float64(edgeFound) / float64(edgeCount)
+	}
+	if referenceCount > 0 {
+		agreement.Recall = float64(referenceFound) / float64(referenceCount)
+	}
+	return agreement
+}
+
+// hasEdgeNear checks whether there is an edge pixel at most tolerance pixels away from the given position.
+func hasEdgeNear(edges [][]GrayPixel, x, y, tolerance int) bool {
+	for i := max(0, y-tolerance); i <= min(len(edges)-1, y+tolerance); i++ {
+		for j := max(0, x-tolerance); j <= min(len(edges[i])-1, x+tolerance); j++ {
+			if edges[i][j].y > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// F1 returns the harmonic mean of precision and recall.
+func (a EdgeAgreement) F1() float64 {
+	if a.Precision+a.Recall == 0 {
+		return 0
+	}
+	return 2 * a.Precision * a.Recall / (a.Precision + a.Recall)
+}
+
+// String formats the agreement as human readable report.
+func (a EdgeAgreement) String() string {
+	return fmt.Sprintf("pixel agreement: %.4f (%d of %d)\nprecision: %.4f\nrecall: %.4f\nF1: %.4f",
+		float64(a.Matching)/float64(max(a.Pixels, 1)), a.Matching, a.Pixels, a.Precision, a.Recall, a.F1())
+}
@@ -0,0 +1,283 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// flagValues maps the names of flags that take one of a fixed set of values to these values, for shell completions.
+var flagValues = map[string][]string{
+	"autocrop":      {"edges", "original"},
+	"blur":          {"true", "false", "gaussian", "box", "none"},
+	"fits-scale":    {"linear", "log", "zscale"},
+	"frames":        {"separate", "apng", "tiff"},
+	"mask-mode":     {"labeled", "separate"},
+	"mode":          {"wipe", "toggle"},
+	"oversize":      {"reject", "downscale"},
+	"response":      {"log", "dog"},
+	"stack":         {"mean", "median"},
+	"temporal-mode": {"vote", "average"},
+	"tile-layout":   {"dzi", "xyz"},
+	"tone-map":      {"reinhard", "drago"},
+}
+
+// COMPLETION_SHELLS are the shells completion scripts are generated for.
+var COMPLETION_SHELLS = []string{"bash", "zsh", "fish"}
+
+// parseCommandFlags parses the flags of a subcommand from the given arguments, mistyped flags are reported together
+// with the closest defined flag.
+func parseCommandFlags(flags *flag.FlagSet, args []string) {
+	checkFlags(flags, args)
+	flags.Parse(args)
+}
+
+// checkFlags exits the program if the given arguments contain a flag that isn't defined in the given set and a defined
+// flag with a similar name exists, which is suggested instead. The arguments are scanned up to the first non-flag
+// argument like the flag package does, other errors are left to the flag package.
+func checkFlags(flags *flag.FlagSet, args []string) {
+	for i := 0; i < len(args); i++ {
+		if len(args[i]) < 2 || args[i][0] != '-' || args[i] == "--" {
+			return
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		f := flags.Lookup(name)
+		if f == nil {
+			var names []string
+			flags.VisitAll(func(f *flag.Flag) {
+				names = append(names, f.Name)
+			})
+			if suggestion := closestName(name, names); suggestion != "" {
+				fmt.Fprintf(flags.Output(), "flag provided but not defined: -%s\nDid you mean -%s?\n", name, suggestion)
+				os.Exit(2)
+			}
+			return
+		}
+		if boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool }); hasValue || (ok && boolFlag.IsBoolFlag()) {
+			continue
+		}
+		i++ // the value is the next argument
+	}
+}
+
+// closestName returns the name of the given candidates with the smallest edit distance to the given name, or the empty
+// string if none of them is close enough to be a likely typo.
+func closestName(name string, candidates []string) string {
+	best, bestDistance := "", len(name)/3+1
+	for _, candidate := range candidates {
+		if distance := editDistance(name, candidate); distance <= bestDistance && (best == "" || distance < editDistance(name, best)) {
+			best = candidate
+		}
+	}
+	return best
+}
+
+// editDistance returns the edit distance of the given strings, counting insertions, deletions, substitutions and
+// transpositions of adjacent characters as one edit each.
+func editDistance(a, b string) int {
+	distances := make([][]int, len(a)+1)
+	for i := range distances {
+		distances[i] = make([]int, len(b)+1)
+		distances[i][0] = i
+	}
+	for j := range distances[0] {
+		distances[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			distances[i][j] = min(distances[i-1][j]+1, distances[i][j-1]+1, distances[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				distances[i][j] = min(distances[i][j], distances[i-2][j-2]+1)
+			}
+		}
+	}
+	return distances[len(a)][len(b)]
+}
+
+// completionCommand holds what the completion scripts need to know about a command: its name, which is empty for the
+// edge detection itself, a short description and its flags.
+type completionCommand struct {
+	name    string
+	summary string
+	flags   []*flag.Flag
+}
+
+// completionCommands returns the edge detection with the given flags and all subcommands sorted by name.
+func completionCommands(mainFlags *flag.FlagSet) []completionCommand {
+	collect := func(flags *flag.FlagSet) []*flag.Flag {
+		var result []*flag.Flag
+		flags.VisitAll(func(f *flag.Flag) {
+			result = append(result, f)
+		})
+		return result
+	}
+	commands := []completionCommand{{"", "", collect(mainFlags)}}
+	for name, command := range subcommands {
+		flags, _ := command.Flags()
+		commands = append(commands, completionCommand{name, command.Summary, collect(flags)})
+	}
+	commands = append(commands,
+		completionCommand{"config", "print the resolved configuration, --explain adds the sources", commands[0].flags},
+		completionCommand{"completion", "print the shell completion script for bash, zsh or fish", nil})
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].name < commands[j].name
+	})
+	return commands
+}
+
+// flagCompletion returns how the value of the given flag is completed: the fixed values it takes, "file" or "dir" for
+// paths and nothing for other values. Paths are recognized by the convention of the usage texts.
+func flagCompletion(f *flag.Flag) []string {
+	if values, ok := flagValues[f.Name]; ok {
+		return values
+	}
+	if strings.HasPrefix(f.Usage, "directory") || strings.Contains(f.Usage, " directory ") {
+		return []string{"dir"}
+	}
+	if strings.HasPrefix(f.Usage, "path to") || strings.Contains(f.Usage, "path of") {
+		return []string{"file"}
+	}
+	return nil
+}
+
+// writeCompletion writes the completion script for the given shell, one of COMPLETION_SHELLS. The zsh script uses the
+// bash completion through bashcompinit.
+func writeCompletion(w io.Writer, shell string, mainFlags *flag.FlagSet) {
+	commands := completionCommands(mainFlags)
+	switch shell {
+	case "fish":
+		writeFishCompletion(w, commands)
+	case "zsh":
+		fmt.Fprintln(w, "autoload -U +X bashcompinit && bashcompinit")
+		writeBashCompletion(w, commands)
+	default:
+		writeBashCompletion(w, commands)
+	}
+}
+
+// writeBashCompletion writes the completion script for bash.
+func writeBashCompletion(w io.Writer, commands []completionCommand) {
+	var names []string
+	for _, command := range commands[1:] {
+		names = append(names, command.name)
+	}
+	fmt.Fprintln(w, "_edgeefy() {")
+	fmt.Fprintln(w, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\" cmd=\"\" flags=\"\"")
+	fmt.Fprintln(w, "    [[ ${COMP_CWORD} -gt 1 ]] && cmd=\"${COMP_WORDS[1]}\"")
+	fmt.Fprintln(w, "    case \"$cmd\" in")
+	for _, command := range commands[1:] {
+		fmt.Fprintf(w, "        %s) ;;\n", command.name)
+	}
+	fmt.Fprintln(w, "        *) cmd=\"\" ;;")
+	fmt.Fprintln(w, "    esac")
+
+	// values of flags
+	fmt.Fprintln(w, "    case \"$cmd $prev\" in")
+	for _, command := range commands {
+		for _, f := range command.flags {
+			switch completion := flagCompletion(f); {
+			case len(completion) == 0:
+				continue
+			case completion[0] == "file":
+				fmt.Fprintf(w, "        \"%s -%s\") COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", command.name, f.Name)
+			case completion[0] == "dir":
+				fmt.Fprintf(w, "        \"%s -%s\") COMPREPLY=($(compgen -d -- \"$cur\")); return ;;\n", command.name, f.Name)
+			default:
+				fmt.Fprintf(w, "        \"%s -%s\") COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); return ;;\n", command.name, f.Name, strings.Join(completion, " "))
+			}
+		}
+	}
+	fmt.Fprintf(w, "        \"completion %s\") COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); return ;;\n", "completion", strings.Join(COMPLETION_SHELLS, " "))
+	fmt.Fprintln(w, "    esac")
+
+	// names of flags
+	fmt.Fprintln(w, "    case \"$cmd\" in")
+	for _, command := range commands {
+		var flagNames []string
+		for _, f := range command.flags {
+			flagNames = append(flagNames, "-"+f.Name)
+		}
+		if command.name == "config" {
+			flagNames = append(flagNames, "--explain")
+		}
+		pattern := command.name
+		if pattern == "" {
+			pattern = "\"\""
+		}
+		fmt.Fprintf(w, "        %s) flags=\"%s\" ;;\n", pattern, strings.Join(flagNames, " "))
+	}
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, "    if [[ \"$cur\" == -* ]]; then")
+	fmt.Fprintln(w, "        COMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))")
+	fmt.Fprintln(w, "    elif [[ ${COMP_CWORD} -eq 1 ]]; then")
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintln(w, "    else")
+	fmt.Fprintln(w, "        COMPREPLY=($(compgen -f -- \"$cur\"))")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _edgeefy edgeefy")
+}
+
+// writeFishCompletion writes the completion script for fish.
+func writeFishCompletion(w io.Writer, commands []completionCommand) {
+	var names []string
+	for _, command := range commands[1:] {
+		names = append(names, command.name)
+	}
+	for _, command := range commands[1:] {
+		fmt.Fprintf(w, "complete -c edgeefy -n '__fish_use_subcommand' -f -a %s -d %s\n", command.name, fishQuote(command.summary))
+	}
+	for _, command := range commands {
+		condition := "not __fish_seen_subcommand_from " + strings.Join(names, " ")
+		if command.name != "" {
+			condition = "__fish_seen_subcommand_from " + command.name
+		}
+		for _, f := range command.flags {
+			description := fishQuote(strings.SplitN(f.Usage, " (", 2)[0])
+			boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+			switch completion := flagCompletion(f); {
+			case ok && boolFlag.IsBoolFlag():
+				fmt.Fprintf(w, "complete -c edgeefy -n '%s' -o %s -f -d %s\n", condition, f.Name, description)
+			case len(completion) == 0:
+				fmt.Fprintf(w, "complete -c edgeefy -n '%s' -o %s -x -d %s\n", condition, f.Name, description)
+			case completion[0] == "file":
+				fmt.Fprintf(w, "complete -c edgeefy -n '%s' -o %s -r -F -d %s\n", condition, f.Name, description)
+			case completion[0] == "dir":
+				fmt.Fprintf(w, "complete -c edgeefy -n '%s' -o %s -x -a '(__fish_complete_directories)' -d %s\n", condition, f.Name, description)
+			default:
+				fmt.Fprintf(w, "complete -c edgeefy -n '%s' -o %s -x -a '%s' -d %s\n", condition, f.Name, strings.Join(completion, " "), description)
+			}
+		}
+	}
+	fmt.Fprintln(w, "complete -c edgeefy -n '__fish_seen_subcommand_from config' -l explain -d 'add the source of every value'")
+	fmt.Fprintf(w, "complete -c edgeefy -n '__fish_seen_subcommand_from completion' -x -a '%s'\n", strings.Join(COMPLETION_SHELLS, " "))
+}
+
+// fishQuote returns the given text as single quoted fish string.
+func fishQuote(text string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(text, `\`, `\\`), "'", `\'`) + "'"
+}
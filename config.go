@@ -0,0 +1,161 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CONFIG_ENV_PREFIX is the prefix of the environment variables that set flags, e.g. EDGEEFY_MIN sets -min.
+const CONFIG_ENV_PREFIX = "EDGEEFY_"
+
+// ConfigValue is the resolved value of a flag together with its source: default, config, env or flag.
+type ConfigValue struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// resolveConfiguration sets the flags of the given set that weren't given on the command line from the config file at
+// the given path and from environment variables. Environment variables take precedence over the config file, an empty
+// path reads the config file given by the variable EDGEEFY_CONFIG if it is set. The source of every flag is returned.
+func resolveConfiguration(flags *flag.FlagSet, configPath string) (map[string]string, error) {
+	sources := make(map[string]string)
+	flags.VisitAll(func(f *flag.Flag) {
+		sources[f.Name] = "default"
+	})
+	flags.Visit(func(f *flag.Flag) {
+		sources[f.Name] = "flag"
+	})
+
+	if configPath == "" {
+		configPath = os.Getenv(CONFIG_ENV_PREFIX + "CONFIG")
+	}
+	if configPath != "" {
+		values, err := readConfigFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range sortedKeys(values) {
+			if _, ok := sources[name]; !ok {
+				return nil, fmt.Errorf("unknown flag %s in config file %s", name, configPath)
+			}
+			if sources[name] == "flag" {
+				continue
+			}
+			if err := flags.Set(name, values[name]); err != nil {
+				return nil, fmt.Errorf("invalid value for %s in config file %s", name, configPath)
+			}
+			sources[name] = "config"
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(configEnvName(f.Name))
+		if !ok || sources[f.Name] == "flag" || err != nil {
+			return
+		}
+		if flags.Set(f.Name, value) != nil {
+			err = errors.New("invalid value for environment variable " + configEnvName(f.Name))
+			return
+		}
+		sources[f.Name] = "env"
+	})
+	return sources, err
+}
+
+// readConfigFile reads a config file, a JSON object that maps the names of flags to their values. Values may be given
+// as strings, numbers or booleans.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	values, err := configValues(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%v in config file %s", err, path)
+	}
+	return values, nil
+}
+
+// configValues converts the values of a JSON object that maps the names of flags to their values to the strings the
+// flags are set with. Values may be given as strings, numbers or booleans.
+func configValues(raw map[string]interface{}) (map[string]string, error) {
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		text, ok := jsonFlagValue(value)
+		if !ok {
+			return nil, fmt.Errorf("invalid value for %s", name)
+		}
+		values[name] = text
+	}
+	return values, nil
+}
+
+// jsonFlagValue returns the string a flag is set with for the given decoded JSON value, or false if the value is no
+// string, number or boolean. Numbers are written without exponent, which integer flags don't accept.
+func jsonFlagValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// configEnvName returns the name of the environment variable that sets the flag with the given name.
+func configEnvName(name string) string {
+	return CONFIG_ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// writeConfiguration writes the values of all flags of the given set except the path of the config file as JSON. The
+// output can be used as config file. If explain is set every value is written together with its source.
+func writeConfiguration(w io.Writer, flags *flag.FlagSet, sources map[string]string, explain bool) error {
+	values := make(map[string]interface{})
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		var value interface{} = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			value = getter.Get()
+		}
+		if explain {
+			values[f.Name] = ConfigValue{value, sources[f.Name]}
+		} else {
+			values[f.Name] = value
+		}
+	})
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(values)
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// layout of image contact sheets
+const (
+	THUMBNAIL_SIZE    = 160 // maximum edge length of a thumbnail in pixels
+	THUMBNAIL_SPACING = 8   // space between thumbnails in pixels
+	CONTACT_COLUMNS   = 8   // number of thumbnails per row
+)
+
+// writeContactSheet writes an overview of the given batch results to the file at the given path. HTML sheets show
+// every edge image with its file name and the detection parameters and list failed files with the reason. For all
+// other extensions an image is written that holds thumbnails of the edge images in the order of the results.
+func writeContactSheet(results []BatchResult, parameters string, path string) {
+	if filepath.Ext(path) != ".html" {
+		writeColorImage(contactSheetImage(results), path)
+		return
+	}
+
+	outFile, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer outFile.Close()
+	if err := writeContactSheetHTML(outFile, results, parameters, filepath.Dir(path)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeContactSheetHTML writes the HTML contact sheet of the given results. The edge images are referenced relative
+// to the given directory the sheet is written to.
+func writeContactSheetHTML(w io.Writer, results []BatchResult, parameters string, dir string) error {
+	if _, err := fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>edgeefy results</title>\n"+
+		"<style>figure { display: inline-block; margin: 8px; } img { max-width: %dpx; max-height: %dpx; }</style>\n"+
+		"</head>\n<body>\n<p>%s</p>\n", THUMBNAIL_SIZE, THUMBNAIL_SIZE, html.EscapeString(parameters)); err != nil {
+		return err
+	}
+	for _, result := range results {
+		name := html.EscapeString(filepath.Base(result.Input))
+		var err error
+		if result.Err != nil {
+			_, err = fmt.Fprintf(w, "<figure><figcaption>%s<br>failed: %s</figcaption></figure>\n",
+				name, html.EscapeString(result.Err.Error()))
+		} else {
+			src := result.Output
+			if rel, relErr := filepath.Rel(dir, result.Output); relErr == nil {
+				src = rel
+			}
+			_, err = fmt.Fprintf(w, "<figure><a href=\"%s\"><img src=\"%s\" alt=\"%s\"></a><figcaption>%s</figcaption></figure>\n",
+				html.EscapeString(filepath.ToSlash(src)), html.EscapeString(filepath.ToSlash(src)), name, name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "</body>\n</html>")
+	return err
+}
+
+// contactSheetImage returns an image with thumbnails of the edge images of all successfully processed results laid
+// out in rows of CONTACT_COLUMNS. Thumbnails are reduced by max pooling so thin edges stay visible.
+func contactSheetImage(results []BatchResult) *image.Gray {
+	var thumbnails []*image.Gray
+	for _, result := range results {
+		if result.Err == nil {
+			levels := pyramidLevels(getImageFromArray(openImage(result.Output, "")), THUMBNAIL_SIZE)
+			thumbnails = append(thumbnails, levels[len(levels)-1])
+		}
+	}
+
+	cell := THUMBNAIL_SIZE + THUMBNAIL_SPACING
+	columns := min(len(thumbnails), CONTACT_COLUMNS)
+	rows := (len(thumbnails) + CONTACT_COLUMNS - 1) / CONTACT_COLUMNS
+	sheet := image.NewGray(image.Rect(0, 0, max(columns*cell+THUMBNAIL_SPACING, 1), max(rows*cell+THUMBNAIL_SPACING, 1)))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.Gray{64}), image.Point{}, draw.Src)
+	for i, thumbnail := range thumbnails {
+		origin := image.Pt(THUMBNAIL_SPACING+(i%CONTACT_COLUMNS)*cell, THUMBNAIL_SPACING+(i/CONTACT_COLUMNS)*cell)
+		draw.Draw(sheet, thumbnail.Bounds().Add(origin), thumbnail, image.Point{}, draw.Src)
+	}
+	return sheet
+}
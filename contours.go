@@ -0,0 +1,242 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isValidMaskMode checks whether the given name denotes a supported way of writing region masks.
+func isValidMaskMode(mode string) bool {
+	return mode == "labeled" || mode == "separate"
+}
+
+// FindClosedRegions detects the regions of the edge image that are completely enclosed by edges. A region is a set of
+// 4-connected non-edge pixels that doesn't touch the image border. Every region with at least minArea pixels gets a
+// label starting at one, the edge pixels bordering a region are assigned to it as well so that the label covers the
+// filled contour. All other pixels get the label zero. The labels and the number of regions are returned.
+func FindClosedRegions[T Sample](edges [][]T, minArea int) ([][]int, int) {
+	height := len(edges)
+	labels := make([][]int, height)
+	for y := range edges {
+		labels[y] = make([]int, len(edges[y]))
+	}
+	visited := make([][]bool, height)
+	for y := range edges {
+		visited[y] = make([]bool, len(edges[y]))
+	}
+
+	count := 0
+	for y := range edges {
+		for x := range edges[y] {
+			if edges[y][x] > 0 || visited[y][x] {
+				continue
+			}
+			// collect the region by flood filling the non-edge pixels
+			region := []image.Point{{x, y}}
+			visited[y][x] = true
+			open := false
+			for i := 0; i < len(region); i++ {
+				p := region[i]
+				if p.Y == 0 || p.Y == height-1 || p.X == 0 || p.X == len(edges[p.Y])-1 {
+					open = true // region leaks out of the image, it is not enclosed
+				}
+				for _, n := range []image.Point{{p.X + 1, p.Y}, {p.X - 1, p.Y}, {p.X, p.Y + 1}, {p.X, p.Y - 1}} {
+					if n.Y < 0 || n.Y >= height || n.X < 0 || n.X >= len(edges[n.Y]) {
+						continue
+					}
+					if edges[n.Y][n.X] == 0 && !visited[n.Y][n.X] {
+						visited[n.Y][n.X] = true
+						region = append(region, n)
+					}
+				}
+			}
+			if open || len(region) < minArea {
+				continue
+			}
+
+			// label the region together with its bordering edge pixels
+			count++
+			for _, p := range region {
+				for i := p.Y - 1; i <= p.Y+1; i++ {
+					for j := p.X - 1; j <= p.X+1; j++ {
+						if edges[i][j] > 0 && labels[i][j] == 0 {
+							labels[i][j] = count
+						}
+					}
+				}
+				labels[p.Y][p.X] = count
+			}
+		}
+	}
+
+	return labels, count
+}
+
+// labelImage returns a 16-bit grayscale image in which every pixel holds the label of its region.
+func labelImage(labels [][]int) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, len(labels[0]), len(labels)))
+	for y := range labels {
+		for x := range labels[y] {
+			img.SetGray16(x, y, color.Gray16{uint16(labels[y][x])})
+		}
+	}
+	return img
+}
+
+// regionMask returns a binary image in which the pixels of the region with the given label are white.
+func regionMask(labels [][]int, label int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, len(labels[0]), len(labels)))
+	for y := range labels {
+		for x := range labels[y] {
+			if labels[y][x] == label {
+				img.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return img
+}
+
+// writeRegionMasks writes the regions given by the labels to disc. In labeled mode a single 16-bit image holding the
+// labels is written to the given path, in separate mode every region is written as binary mask to its own file whose
+// name is derived from the path by appending the region number.
+func writeRegionMasks(labels [][]int, count int, path string, mode string) {
+	if mode == "labeled" {
+		writeColorImage(labelImage(labels), path)
+		return
+	}
+	for label := 1; label <= count; label++ {
+		writeColorImage(regionMask(labels, label), framePath(path, label))
+	}
+}
+
+// Contour is an ordered chain of pixel positions along an edge. A closed contour returns to its first point.
+type Contour struct {
+	Points []image.Point
+	Closed bool
+}
+
+// TraceContours follows the edges of the given edge image and returns them as ordered chains of pixel positions. Every
+// edge pixel belongs to exactly one contour. Tracing starts at segment endpoints so open segments are followed from
+// one end to the other, edges without endpoints are traced as closed contours.
+func TraceContours[T Sample](edges [][]T) []Contour {
+	visited := make([][]bool, len(edges))
+	for y := range edges {
+		visited[y] = make([]bool, len(edges[y]))
+	}
+
+	var contours []Contour
+	// start at endpoints first, then pick up the remaining loops
+	starts := findEndpoints(edges)
+	for y := range edges {
+		for x := range edges[y] {
+			if edges[y][x] > 0 {
+				starts = append(starts, image.Point{x, y})
+			}
+		}
+	}
+	for _, start := range starts {
+		if visited[start.Y][start.X] {
+			continue
+		}
+		contour := Contour{Points: []image.Point{start}}
+		visited[start.Y][start.X] = true
+		for current := start; ; {
+			next, found := nextContourPixel(edges, visited, current)
+			if !found {
+				break
+			}
+			visited[next.Y][next.X] = true
+			contour.Points = append(contour.Points, next)
+			current = next
+		}
+		last := contour.Points[len(contour.Points)-1]
+		contour.Closed = len(contour.Points) > 2 && abs(last.X-start.X) <= 1 && abs(last.Y-start.Y) <= 1
+		contours = append(contours, contour)
+	}
+
+	return contours
+}
+
+// nextContourPixel returns an unvisited edge pixel next to the given position. Direct neighbours are preferred over
+// diagonal ones so the traced chain doesn't skip pixels.
+func nextContourPixel[T Sample](edges [][]T, visited [][]bool, p image.Point) (image.Point, bool) {
+	neighbours := []image.Point{
+		{p.X + 1, p.Y}, {p.X, p.Y + 1}, {p.X - 1, p.Y}, {p.X, p.Y - 1},
+		{p.X + 1, p.Y + 1}, {p.X - 1, p.Y + 1}, {p.X - 1, p.Y - 1}, {p.X + 1, p.Y - 1},
+	}
+	for _, n := range neighbours {
+		if n.Y < 0 || n.Y >= len(edges) || n.X < 0 || n.X >= len(edges[n.Y]) {
+			continue
+		}
+		if edges[n.Y][n.X] > 0 && !visited[n.Y][n.X] {
+			return n, true
+		}
+	}
+	return p, false
+}
+
+// writeContoursSVG writes the given contours as SVG document of the given size with strokes of the given width. Open
+// contours become polylines and closed contours become polygons.
+func writeContoursSVG(w io.Writer, contours []Contour, width, height int, strokeWidth float64) error {
+	if _, err := fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		width, height, width, height); err != nil {
+		return err
+	}
+	for _, contour := range contours {
+		element := "polyline"
+		if contour.Closed {
+			element = "polygon"
+		}
+		var points []string
+		for _, p := range contour.Points {
+			points = append(points, fmt.Sprintf("%d,%d", p.X, p.Y))
+		}
+		if _, err := fmt.Fprintf(w, "  <%s points=\"%s\" fill=\"none\" stroke=\"black\" stroke-width=\"%g\"/>\n",
+			element, strings.Join(points, " "), strokeWidth); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "</svg>")
+	return err
+}
+
+// writeContours writes the given contours with strokes of the given width to the file at the given path. PNG and JPEG
+// files get anti-aliased line art, all other files SVG.
+func writeContours(contours []Contour, width, height int, strokeWidth float64, path string) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg":
+		writeImage(lineArt(rasterizeContours(contours, width, height, strokeWidth)), path)
+		return
+	}
+	outFile, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer outFile.Close()
+	if err := writeContoursSVG(outFile, contours, width, height, strokeWidth); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readDefectList reads the positions of defect pixels of a sensor from the file at the given path. Every line holds the
+// x and y coordinate of a pixel separated by whitespace or a comma, empty lines and lines starting with # are skipped.
+func readDefectList(path string) ([]image.Point, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var defects []image.Point
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d of defect list: expected x and y coordinate", line)
+		}
+		x, errX := strconv.Atoi(fields[0])
+		y, errY := strconv.Atoi(fields[1])
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("line %d of defect list: coordinates must be integers", line)
+		}
+		defects = append(defects, image.Point{x, y})
+	}
+	return defects, scanner.Err()
+}
+
+// FindDefects returns the positions of isolated outliers of the given samples such as the hot and dead pixels of a
+// sensor. A pixel is an outlier if it is brighter than the brightest or darker than the darkest of its valid neighbours
+// by more than the given multiple of the estimated noise of the samples. Edges and textures always have neighbours on
+// both sides of the value of a pixel, so only single pixels standing out from their surroundings are found.
+func FindDefects[T Sample](samples [][]T, valid [][]bool, threshold float64) []image.Point {
+	limit := threshold * EstimateNoise(samples, valid).Sigma
+	var defects []image.Point
+	for y := range samples {
+		for x, value := range samples[y] {
+			if !isValidPixel(valid, x, y) {
+				continue
+			}
+			low, high := math.Inf(1), math.Inf(-1)
+			for v := max(0, y-1); v <= min(len(samples)-1, y+1); v++ {
+				for u := max(0, x-1); u <= min(len(samples[v])-1, x+1); u++ {
+					if (u != x || v != y) && isValidPixel(valid, u, v) {
+						low, high = math.Min(low, float64(samples[v][u])), math.Max(high, float64(samples[v][u]))
+					}
+				}
+			}
+			if float64(value) > high+limit || float64(value) < low-limit {
+				defects = append(defects, image.Point{x, y})
+			}
+		}
+	}
+	return defects
+}
+
+// maskDefects returns the given mask with the defect pixels of the detector marked as invalid, which excludes them
+// from the blur and the gradients so a single hot pixel doesn't turn into the cross of edges the kernels spread it to.
+// The given mask isn't modified, a nil mask marks all pixels as valid.
+func maskDefects[T Sample](d *Detector, samples [][]T, valid [][]bool) [][]bool {
+	defects := d.DefectPixels
+	if d.DefectThreshold > 0 {
+		defects = append(FindDefects(samples, valid, d.DefectThreshold), defects...)
+	}
+	masked := make([][]bool, len(samples))
+	for y := range masked {
+		masked[y] = make([]bool, len(samples[y]))
+		for x := range masked[y] {
+			masked[y][x] = isValidPixel(valid, x, y)
+		}
+	}
+	for _, p := range defects {
+		if p.Y >= 0 && p.Y < len(masked) && p.X >= 0 && p.X < len(masked[p.Y]) {
+			masked[p.Y][p.X] = false
+		}
+	}
+	return masked
+}
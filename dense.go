@@ -0,0 +1,51 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import "gonum.org/v1/gonum/mat"
+
+// FromDense returns the values of the given matrix as two-dimensional array of samples. The rows of the matrix become
+// the rows of the image.
+func FromDense(m *mat.Dense) [][]float64 {
+	rows, _ := m.Dims()
+	samples := make([][]float64, rows)
+	for y := range samples {
+		samples[y] = mat.Row(nil, y, m)
+	}
+	return samples
+}
+
+// ToDense returns the given two-dimensional array of samples as matrix with one row per image row.
+func ToDense[T Sample](samples [][]T) *mat.Dense {
+	m := mat.NewDense(len(samples), len(samples[0]), nil)
+	for y := range samples {
+		for x := range samples[y] {
+			m.Set(y, x, float64(samples[y][x]))
+		}
+	}
+	return m
+}
+
+// DetectDense performs canny edge detection on the values of the given matrix, e.g. measurements that never existed
+// as encoded image. The values are processed with full floating point precision. The returned matrix holds the
+// gradient magnitude at edge pixels and zero everywhere else.
+func (d *Detector) DetectDense(m *mat.Dense) *mat.Dense {
+	return ToDense(DetectSamples(d, FromDense(m), nil))
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"fmt"
+	"os"
+)
+
+// edgeDensity returns the ratio of edge pixels in the given edge image.
+func edgeDensity[T Sample](edges [][]T) float64 {
+	count := 0
+	for y := range edges {
+		for _, value := range edges[y] {
+			if value != 0 {
+				count++
+			}
+		}
+	}
+	return float64(count) / float64(len(edges)*len(edges[0]))
+}
+
+// checkEdgeDensity exits the program with an error if the given edge density lies outside of the expected range from
+// lower to upper, e.g. for blank scans or frames that are nothing but noise.
+func checkEdgeDensity(density, lower, upper float64) {
+	if density < lower || density > upper {
+		fmt.Printf("Edge density %.4f is outside of the expected range [%g, %g], exiting.\n", density, lower, upper)
+		os.Exit(1)
+	}
+}
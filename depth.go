@@ -0,0 +1,81 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"image/color"
+	"io"
+	"log"
+	"os"
+)
+
+// openDepthImage opens the image given by a path string and returns its samples with full 16-bit precision as a
+// two-dimensional array. If a raw format is given the file is read as headerless raw frame of that format.
+func openDepthImage(path string, rawFormat string) [][]uint16 {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close() // opened for reading, no error checking needed
+
+	depth, err := getDepthArray(file, rawFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return depth
+}
+
+// getDepthArray reads the given file as an image and returns a two-dimensional array of 16-bit gray values. Unlike
+// getPixelArray no precision is lost for 16-bit images, 8-bit images are scaled up to the 16-bit range.
+func getDepthArray(file io.Reader, rawFormat string) ([][]uint16, error) {
+	var depthArr [][]uint16
+
+	// load the image from given file and determine image bounds
+	img, err := decodeInput(file, rawFormat)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+
+	// build depth array row by row from image data
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		var row []uint16
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray := color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y
+			row = append(row, gray)
+		}
+		depthArr = append(depthArr, row)
+	}
+
+	return depthArr, nil
+}
+
+// depthMask returns a mask of the valid pixels of the given depth values. Pixels equal to the given sentinel value are
+// marked as invalid.
+func depthMask(depth [][]uint16, invalid uint16) [][]bool {
+	valid := make([][]bool, len(depth))
+	for y := range depth {
+		valid[y] = make([]bool, len(depth[y]))
+		for x := range depth[y] {
+			valid[y][x] = depth[y][x] != invalid
+		}
+	}
+
+	return valid
+}
@@ -0,0 +1,133 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+)
+
+// DESKEW_ANGLE_STEP is the resolution in degrees of the skew angle estimation.
+const DESKEW_ANGLE_STEP = 0.1
+
+// deskewCommand implements the deskew subcommand. The dominant angle of the edges of a scanned document is estimated by
+// the hough transform and the image is rotated so that these edges become horizontal.
+func deskewCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("deskew", flag.ExitOnError)
+	inputFileArgPtr := flags.String("input", "", "path to input file (required)")
+	outputFileArgPtr := flags.String("output", "deskewed.png", "path to output file (optional, default: deskewed.png)")
+	maxAngleArgPtr := flags.Float64("max-angle", 15, "maximum skew angle in degrees that is corrected (optional, default: 15)")
+	minThresholdArgPtr := flags.Float64("min", float64(0.2), "ratio of lower threshold (optional, default: 0.2)")
+	maxThresholdArgPtr := flags.Float64("max", float64(0.6), "ratio of upper threshold (optional, default: 0.6)")
+	return flags, func() {
+		if *inputFileArgPtr == "" {
+			fmt.Println("No path to input file specified, nothing to do.")
+			return
+		}
+		if !isValidRatioValue(*minThresholdArgPtr) || !isValidRatioValue(*maxThresholdArgPtr) {
+			fmt.Println("Invalid value for threshold ratio given, exiting.")
+			return
+		}
+		if *maxAngleArgPtr <= 0 || *maxAngleArgPtr >= 45 {
+			fmt.Println("Invalid value for maximum skew angle given, exiting.")
+			return
+		}
+
+		file, err := os.Open(*inputFileArgPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close() // opened for reading, no error checking needed
+		img, err := decodeInput(file, "")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		detector := NewDetector(true, *minThresholdArgPtr, *maxThresholdArgPtr)
+		edges := DetectSamples(detector, pixelsToSamples(imageToPixelArray(img)), nil)
+		angle, found := estimateSkew(edges, *maxAngleArgPtr, detector.Workers)
+		if found {
+			fmt.Printf("skew angle: %.1f degrees\n", angle)
+		} else {
+			fmt.Println("no skew found, the image has no edges")
+		}
+		writeColorImage(rotateImage(img, -angle, color.White), *outputFileArgPtr)
+	}
+}
+
+// estimateSkew returns the angle in degrees by which the horizontal edges of the given edge image are rotated
+// clockwise, searching angles up to the given maximum in both directions. Without edges zero and false are returned.
+func estimateSkew[T Sample](edges [][]T, maxAngle float64, workers int) (float64, bool) {
+	var thetas []float64
+	steps := int(maxAngle / DESKEW_ANGLE_STEP)
+	for i := -steps; i <= steps; i++ {
+		thetas = append(thetas, 90+float64(i)*DESKEW_ANGLE_STEP)
+	}
+	angle, found := dominantLineAngle(edges, thetas, workers)
+	if !found {
+		return 0, false
+	}
+	return angle - 90, true
+}
+
+// rotateImage rotates the given image clockwise by the given angle in degrees around its center. The result has the
+// size of the given image, areas that are not covered by the rotated image are filled with the given background color.
+// The pixels are interpolated bilinearly.
+func rotateImage(img image.Image, angle float64, background color.Color) *image.RGBA {
+	bounds := img.Bounds()
+	result := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	centerX := float64(bounds.Dx()-1) / 2
+	centerY := float64(bounds.Dy()-1) / 2
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			// find the source position by rotating back
+			dx, dy := float64(x)-centerX, float64(y)-centerY
+			srcX := cos*dx + sin*dy + centerX
+			srcY := -sin*dx + cos*dy + centerY
+			result.SetRGBA(x, y, sampleBilinear(img, srcX, srcY, background))
+		}
+	}
+	return result
+}
+
+// sampleBilinear returns the color of the given image at the given position relative to the image bounds,
+// interpolated bilinearly between the four surrounding pixels. Pixels outside of the image take the background color.
+func sampleBilinear(img image.Image, x, y float64, background color.Color) color.RGBA {
+	bounds := img.Bounds()
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+	var sum [4]float64
+	for i, weight := range []float64{(1 - fx) * (1 - fy), fx * (1 - fy), (1 - fx) * fy, fx * fy} {
+		px, py := x0+i%2, y0+i/2
+		r, g, b, a := background.RGBA()
+		if px >= 0 && px < bounds.Dx() && py >= 0 && py < bounds.Dy() {
+			r, g, b, a = img.At(bounds.Min.X+px, bounds.Min.Y+py).RGBA()
+		}
+		sum[0] += weight * float64(r)
+		sum[1] += weight * float64(g)
+		sum[2] += weight * float64(b)
+		sum[3] += weight * float64(a)
+	}
+	return color.RGBA{uint8(sum[0]/257 + 0.5), uint8(sum[1]/257 + 0.5), uint8(sum[2]/257 + 0.5), uint8(sum[3]/257 + 0.5)}
+}
@@ -0,0 +1,268 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"runtime"
+	"sync"
+)
+
+// Detector holds the parameters of the canny edge detection. The stages of the detection are run concurrently by the
+// configured number of workers. The result only depends on the image and the other parameters: it is guaranteed to be
+// identical for every number of workers. Detectors created by NewDetector keep the filter kernels they build in a
+// cache so that processing many images with the same parameters doesn't rebuild them. A Detector is safe for
+// concurrent use by multiple goroutines as long as its parameters are not changed meanwhile: every detection works on
+// its own buffers and the kernel cache is synchronized. Use Clone to change parameters while detections are running.
+type Detector struct {
+	Blur     bool    // perform blur before edge detection
+	MinRatio float64 // ratio of the lower threshold to the maximum gradient
+	MaxRatio float64 // ratio of the upper threshold to the maximum gradient
+	Workers  int     // number of goroutines per stage, values below 1 use one goroutine per CPU
+	// percentile of the non-zero gradients that the threshold ratios refer to instead of the maximum gradient, values
+	// outside of (0, 1) use the maximum
+	Percentile float64
+	// take MinRatio and MaxRatio as multiples of the standard deviation of the noise of the image instead, which is
+	// estimated from the image. Thresholds given in noise transfer between images of different exposure and contrast.
+	NoiseThresholds bool
+	// minimum number of neighbouring edge pixels an edge pixel needs to be kept, zero keeps all edge pixels
+	Despeckle int
+	// maximum distance between segment endpoints that are connected, zero disables gap bridging
+	BridgeDistance float64
+	// maximum deviation in degrees between the direction of a segment and the gap that is bridged
+	BridgeAngle float64
+	// maximum offset in pixels between the channels of DetectChannels up to which an edge found in only one channel
+	// next to an edge of another channel is suppressed as fringe of chromatic aberration, zero keeps all edges
+	FringeDistance int
+	// filter used for blurring, the box filter is much faster on large images
+	BlurFilter BlurFilter
+	// number of passes of the box filter, three passes approximate a gaussian
+	BoxPasses int
+	// standard deviation of the gaussian filter, zero uses a 5x5 binomial kernel
+	Sigma float64
+	// positions of defect pixels of the sensor, e.g. from a defect list of the camera, which are excluded from the
+	// detection like pixels outside of a mask
+	DefectPixels []image.Point
+	// multiple of the estimated noise by which isolated pixels have to stand out from all of their neighbours to be
+	// excluded from the detection as hot or dead pixels in addition to DefectPixels, see FindDefects. Zero disables the
+	// detection of defects.
+	DefectThreshold float64
+	// coefficients k1, k2, ... of the radial falloff 1 + k1 r^2 + k2 r^4 + ... of the brightness caused by the lens,
+	// which the image is divided by before the detection so edges near the corners aren't suppressed. The radius r is
+	// the distance from the center relative to half of the diagonal. Nil disables the correction.
+	Vignetting []float64
+	// fit the coefficients of the falloff to every image instead of using Vignetting, see FitVignetting
+	FitVignetting bool
+	// radius in pixels of the background that is subtracted from the image before the detection to remove uneven
+	// illumination, e.g. of microscopy images. It has to exceed the size of the objects whose edges are detected, zero
+	// disables the subtraction.
+	BackgroundRadius float64
+	// filter the background is estimated with, a rolling ball or a gaussian blur with the radius as standard deviation
+	BackgroundFilter BackgroundFilter
+	// take the gradients of log(1+I) instead of the intensity I, which equalizes the edge response of dark and bright
+	// regions of images with a wide dynamic range
+	LogIntensity bool
+	// intensity of a color that images passed to DetectImage are converted with, e.g. to use only the green channel.
+	// The range of the values doesn't matter as the thresholds are relative. Nil uses the luma of color.GrayModel.
+	Intensity func(color.Color) float64
+	// hook called after every stage of a detection with the stage and its result, a [][]T of the sample type of the
+	// detection. The buffer may be modified in place to change the input of the next stage, e.g. to apply a custom
+	// mask after blurring, or inspected to collect metrics. It must not be kept after the hook returns as later stages
+	// may reuse it, the buffer of the intensity stage is the input of the detection unless the logarithm is taken.
+	// Detections running concurrently call the hook concurrently.
+	OnStageComplete func(stage Stage, buffer any)
+	// custom implementations of single stages, nil uses the built-in one. Custom stages work on floating point samples,
+	// so the samples are converted from and to the sample type of the detection around them. The blurrer is only
+	// used if Blur is set.
+	Blurrer          Blurrer
+	GradientOperator GradientOperator
+	Suppressor       Suppressor
+	Thresholder      Thresholder
+	Tracker          Tracker
+	// factors of the same size as the image the thresholds are multiplied with per pixel, e.g. to detect more detail in
+	// regions of interest. Nil keeps the thresholds global, see ParameterMapFactors.
+	ThresholdFactors [][]float64
+	// weights of the same size as the image the edge strength after non-maximum suppression is multiplied with before
+	// the thresholds are applied, e.g. from an external saliency model. The edges keep the weighted strength, nil
+	// disables weighting.
+	EdgeWeights [][]float64
+
+	kernels *kernelCache // shared by copies of the detector, nil disables caching
+}
+
+// kernelKey identifies a filter kernel by the parameters it is built from.
+type kernelKey struct {
+	kind  string
+	size  uint
+	sigma float64
+}
+
+// kernelCache holds filter kernels that have already been built. It is safe for concurrent use, the cached kernels
+// must not be modified.
+type kernelCache struct {
+	mutex   sync.Mutex
+	kernels map[kernelKey]vector
+}
+
+// NewDetector returns a Detector with the given parameters that uses one worker per CPU.
+func NewDetector(blur bool, minRatio, maxRatio float64) *Detector {
+	return &Detector{Blur: blur, MinRatio: minRatio, MaxRatio: maxRatio, Workers: runtime.NumCPU(), Percentile: 1, BridgeAngle: 30, BoxPasses: 3,
+		kernels: &kernelCache{kernels: make(map[kernelKey]vector)}}
+}
+
+// Clone returns a copy of the detector whose parameters can be changed without affecting the original, e.g. to run
+// detections with different thresholds concurrently. The copy shares the kernel cache with the original.
+func (d *Detector) Clone() *Detector {
+	clone := *d
+	return &clone
+}
+
+// kernel returns the kernel with the given key from the cache of the detector. If it isn't cached yet it is built by
+// the given function and stored.
+func (d *Detector) kernel(key kernelKey, build func() vector) vector {
+	if d.kernels == nil {
+		return build()
+	}
+	d.kernels.mutex.Lock()
+	defer d.kernels.mutex.Unlock()
+	kernel, ok := d.kernels.kernels[key]
+	if !ok {
+		kernel = build()
+		d.kernels.kernels[key] = kernel
+	}
+	return kernel
+}
+
+// binomialKernel returns the normalized binomial kernel of the given odd size, which approximates a gaussian.
+func (d *Detector) binomialKernel(size uint) vector {
+	if size%2 == 0 { // we only allow odd kernel sizes, panic if it is even
+		panic(errors.New("size of kernel must be odd"))
+	}
+	return d.kernel(kernelKey{kind: "binomial", size: size}, func() vector {
+		return normalizeVec(getPascalTriangleRow(size - 1)) // to get n kernel elements we need the (n-1)th row
+	})
+}
+
+// gaussianKernel returns the normalized gaussian kernel with the given standard deviation.
+func (d *Detector) gaussianKernel(sigma float64) vector {
+	return d.kernel(kernelKey{kind: "gaussian", sigma: sigma}, func() vector {
+		return gaussianKernel(sigma)
+	})
+}
+
+// Detect performs canny edge detection on the given pixels and returns the edge image.
+func (d *Detector) Detect(pixels [][]GrayPixel) [][]GrayPixel {
+	return d.DetectMasked(pixels, nil)
+}
+
+// DetectImage performs canny edge detection on the given image, which is converted to intensities by the Intensity
+// function of the detector. Custom intensities are processed with floating point precision.
+func (d *Detector) DetectImage(img image.Image) [][]GrayPixel {
+	if d.Intensity == nil {
+		return d.Detect(imageToPixelArray(img))
+	}
+	bounds := img.Bounds()
+	samples := make([][]float64, bounds.Dy())
+	parallelRows(len(samples), d.Workers, func(y int) {
+		samples[y] = make([]float64, bounds.Dx())
+		for x := range samples[y] {
+			samples[y][x] = d.Intensity(img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	})
+	return samplesToPixels(DetectSamples(d, samples, nil))
+}
+
+// DetectMasked performs canny edge detection on the pixels that are marked as valid in the given mask. Invalid pixels
+// are excluded from blurring and gradient computation and never become edges. A nil mask marks all pixels as valid.
+func (d *Detector) DetectMasked(pixels [][]GrayPixel, valid [][]bool) [][]GrayPixel {
+	return samplesToPixels(DetectSamples(d, pixelsToSamples(pixels), valid))
+}
+
+// VerifyDeterministic runs the detection on the given pixels once with a single worker and once with the configured
+// number of workers (at least two) and checks whether both results are identical.
+func (d *Detector) VerifyDeterministic(pixels [][]GrayPixel, valid [][]bool) bool {
+	return verifyDeterministic(d, pixelsToSamples(pixels), valid)
+}
+
+// verifyDeterministic is the implementation of VerifyDeterministic for all sample types.
+func verifyDeterministic[T Sample](d *Detector, samples [][]T, valid [][]bool) bool {
+	serial := *d
+	serial.Workers = 1
+	parallel := *d
+	if parallel.Workers < 2 {
+		parallel.Workers = max(2, runtime.NumCPU())
+	}
+
+	first := DetectSamples(&serial, copySamples(samples), valid)
+	second := DetectSamples(&parallel, copySamples(samples), valid)
+	return equalSamples(first, second)
+}
+
+// parallelRows calls process for every row index below height. The rows are split into contiguous bands that are
+// processed concurrently by the given number of workers, values below 1 use one worker per CPU. The function returns
+// when all rows have been processed.
+func parallelRows(height, workers int, process func(y int)) {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	if height == 0 {
+		return
+	}
+	band := (height + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < height; start += band {
+		end := min(start+band, height)
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for y := start; y < end; y++ {
+				process(y)
+			}
+		}(start, end)
+	}
+	wg.Wait()
+}
+
+// copySamples returns a deep copy of the given two-dimensional array.
+func copySamples[T Sample](samples [][]T) [][]T {
+	result := make([][]T, len(samples))
+	for y := range samples {
+		result[y] = append([]T(nil), samples[y]...)
+	}
+	return result
+}
+
+// equalSamples checks whether the two given two-dimensional arrays have identical dimensions and values.
+func equalSamples[T Sample](a, b [][]T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for y := range a {
+		if len(a[y]) != len(b[y]) {
+			return false
+		}
+		for x := range a[y] {
+			if a[y][x] != b[y][x] {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,197 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+)
+
+// testPixels returns an image of the given size showing a bright disc on a dark background.
+func testPixels(width, height int) [][]GrayPixel {
+	pixels := make([][]GrayPixel, height)
+	for y := range pixels {
+		pixels[y] = make([]GrayPixel, width)
+		for x := range pixels[y] {
+			dx, dy := x-width/2, y-height/2
+			if 4*(dx*dx+dy*dy) < min(width, height)*min(width, height) {
+				pixels[y][x] = GrayPixel{200, 255}
+			} else {
+				pixels[y][x] = GrayPixel{30, 255}
+			}
+		}
+	}
+	return pixels
+}
+
+// equalPixels checks whether the two given pixel arrays are identical.
+func equalPixels(a, b [][]GrayPixel) bool {
+	return equalSamples(pixelsToSamples(a), pixelsToSamples(b))
+}
+
+// TestDetectorConcurrentUse runs detections on a shared detector from several goroutines. Run with -race to check
+// that detections don't share state.
+func TestDetectorConcurrentUse(t *testing.T) {
+	detector := NewDetector(true, 0.2, 0.6)
+	detector.Workers = 2
+	pixels := testPixels(64, 48)
+	expected := detector.Detect(pixels)
+
+	var wg sync.WaitGroup
+	results := make([][][]GrayPixel, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = detector.Detect(pixels)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		if !equalPixels(result, expected) {
+			t.Errorf("result of goroutine %d differs from serial result", i)
+		}
+	}
+}
+
+// TestDetectorConcurrentKernels runs detections with different blur parameters concurrently on clones sharing the
+// kernel cache.
+func TestDetectorConcurrentKernels(t *testing.T) {
+	detector := NewDetector(true, 0.2, 0.6)
+	pixels := testPixels(64, 48)
+
+	var wg sync.WaitGroup
+	for _, sigma := range []float64{0, 0.8, 1, 1.5, 2, 5} {
+		for i := 0; i < 2; i++ {
+			clone := detector.Clone()
+			clone.Sigma = sigma
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				clone.Detect(pixels)
+			}()
+		}
+	}
+	wg.Wait()
+
+	if len(detector.kernels.kernels) != 5 {
+		t.Errorf("expected 5 cached kernels, got %d", len(detector.kernels.kernels))
+	}
+}
+
+// TestDetectorClone checks that changing the parameters of a clone doesn't affect the original.
+func TestDetectorClone(t *testing.T) {
+	detector := NewDetector(true, 0.2, 0.6)
+	clone := detector.Clone()
+	clone.MinRatio = 0.1
+	clone.Blur = false
+
+	if detector.MinRatio != 0.2 || !detector.Blur {
+		t.Errorf("changing the clone modified the original detector")
+	}
+	if clone.kernels != detector.kernels {
+		t.Errorf("clone doesn't share the kernel cache")
+	}
+}
+
+// TestRealtimeDetector checks that the real-time detector finds the same edges as the detector it is created from and
+// doesn't allocate memory per frame.
+func TestRealtimeDetector(t *testing.T) {
+	pixels := testPixels(64, 48)
+	frame := pixelsToSamples(pixels)
+	for i, configure := range []func(d *Detector){
+		func(d *Detector) {},
+		func(d *Detector) { d.Blur = false },
+		func(d *Detector) { d.Sigma = 1.5 },
+		func(d *Detector) { d.BlurFilter = BOX },
+		func(d *Detector) { d.Percentile = 0.9 },
+		func(d *Detector) { d.Workers = 1 },
+	} {
+		detector := NewDetector(true, 0.2, 0.6)
+		detector.Workers = 3
+		configure(detector)
+		realtime, err := NewRealtimeDetector(detector, 64, 48)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edges, err := realtime.Detect(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalSamples(edges, pixelsToSamples(detector.Detect(pixels))) {
+			t.Errorf("edges of configuration %d differ from the detector", i)
+		}
+		if allocs := testing.AllocsPerRun(10, func() { realtime.Detect(frame) }); allocs > 0 {
+			t.Errorf("detection of configuration %d allocates %g times per frame", i, allocs)
+		}
+		if stats := realtime.Latency(); stats.Frames != 12 || stats.P50 > stats.P99 || stats.P99 > stats.Max {
+			t.Errorf("unexpected latency statistics %+v", stats)
+		}
+		realtime.Close()
+	}
+}
+
+// TestDetectChannelsFringes checks that suppressing the fringes of chromatic aberration keeps one copy of an edge whose
+// channels are all offset against each other, and removes the other copies.
+func TestDetectChannelsFringes(t *testing.T) {
+	// a vertical step whose red, green and blue channels rise at x = 19, 20 and 21
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.RGBA{A: 255}
+			if x >= 19 {
+				c.R = 255
+			}
+			if x >= 20 {
+				c.G = 255
+			}
+			if x >= 21 {
+				c.B = 255
+			}
+			img.Set(x, y, c)
+		}
+	}
+	count := func(edges [][]GrayPixel) (columns map[int]int) {
+		columns = map[int]int{}
+		for y := range edges {
+			for x, pixel := range edges[y] {
+				if pixel.y > 0 {
+					columns[x]++
+				}
+			}
+		}
+		return columns
+	}
+	detector := NewDetector(true, 0.2, 0.6)
+	if columns := count(detector.DetectChannels(img)); len(columns) != 3 {
+		t.Fatalf("expected an edge per channel without suppression, got columns %v", columns)
+	}
+	detector.FringeDistance = 2
+	columns := count(detector.DetectChannels(img))
+	if len(columns) != 1 {
+		t.Fatalf("expected a single edge with suppression, got columns %v", columns)
+	}
+	for _, pixels := range columns {
+		if pixels != 40 {
+			t.Errorf("expected the kept edge to span all rows, got %d pixels", pixels)
+		}
+	}
+}
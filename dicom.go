@@ -0,0 +1,504 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DICOM_MAGIC is the pattern used to detect DICOM files: a 128 byte preamble followed by the "DICM" prefix.
+var DICOM_MAGIC = strings.Repeat("?", 128) + "DICM"
+
+// transfer syntaxes that can be decoded
+const (
+	TS_IMPLICIT_LITTLE = "1.2.840.10008.1.2"
+	TS_EXPLICIT_LITTLE = "1.2.840.10008.1.2.1"
+	TS_EXPLICIT_BIG    = "1.2.840.10008.1.2.2"
+	TS_JPEG_BASELINE   = "1.2.840.10008.1.2.4.50"
+	TS_RLE_LOSSLESS    = "1.2.840.10008.1.2.5"
+)
+
+// tags of the data elements that are evaluated, encoded as group<<16 | element
+const (
+	tagTransferSyntax   = 0x00020010
+	tagSamplesPerPixel  = 0x00280002
+	tagPhotometric      = 0x00280004
+	tagRows             = 0x00280010
+	tagColumns          = 0x00280011
+	tagBitsAllocated    = 0x00280100
+	tagBitsStored       = 0x00280101
+	tagPixelRepr        = 0x00280103
+	tagWindowCenter     = 0x00281050
+	tagWindowWidth      = 0x00281051
+	tagRescaleIntercept = 0x00281052
+	tagRescaleSlope     = 0x00281053
+	tagPixelData        = 0x7FE00010
+	tagItem             = 0xFFFEE000
+	tagItemDelim        = 0xFFFEE00D
+	tagSequenceDelim    = 0xFFFEE0DD
+)
+
+// UNDEFINED_LENGTH marks data elements whose end is given by a delimitation item.
+const UNDEFINED_LENGTH = 0xFFFFFFFF
+
+// dicomHeader holds the attributes of a DICOM data set that are needed to interpret its pixel data.
+type dicomHeader struct {
+	transferSyntax  string
+	samplesPerPixel int
+	photometric     string
+	rows, columns   int
+	bitsAllocated   int
+	bitsStored      int
+	pixelRepr       int
+	windowCenter    float64
+	windowWidth     float64
+	slope           float64
+	intercept       float64
+	pixelData       []byte
+	encapsulated    bool
+}
+
+// dicomParser reads data elements from the raw bytes of a DICOM file.
+type dicomParser struct {
+	data     []byte
+	pos      int
+	order    binary.ByteOrder
+	explicit bool
+}
+
+// decodeDICOM decodes the first frame of a DICOM file and returns it as a 16-bit grayscale image. The modality values
+// of the pixels are mapped to the 16-bit range by the window given by center and width. If width is not positive the
+// window stored in the file is used, or the full range of values if the file doesn't contain one.
+func decodeDICOM(r io.Reader, center, width float64) (image.Image, error) {
+	header, err := parseDICOM(r, true)
+	if err != nil {
+		return nil, err
+	}
+	values, err := header.modalityValues()
+	if err != nil {
+		return nil, err
+	}
+
+	// determine the window to apply
+	if width <= 0 {
+		center, width = header.windowCenter, header.windowWidth
+	}
+	if width <= 0 {
+		min, max := math.Inf(1), math.Inf(-1)
+		for _, v := range values {
+			min = math.Min(min, v)
+			max = math.Max(max, v)
+		}
+		center, width = (min+max)/2, max-min+1
+	}
+
+	// map modality values through the window into the 16-bit range
+	img := image.NewGray16(image.Rect(0, 0, header.columns, header.rows))
+	for i, v := range values {
+		gray := uint16(math.Round(applyWindow(v, center, width) * math.MaxUint16))
+		if header.photometric == "MONOCHROME1" { // minimum value is displayed as white
+			gray = math.MaxUint16 - gray
+		}
+		img.SetGray16(i%header.columns, i/header.columns, color.Gray16{gray})
+	}
+
+	return img, nil
+}
+
+// decodeDICOMConfig returns the dimensions and color model of a DICOM image without decoding the pixel data.
+func decodeDICOMConfig(r io.Reader) (image.Config, error) {
+	header, err := parseDICOM(r, false)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.Gray16Model, Width: header.columns, Height: header.rows}, nil
+}
+
+// applyWindow maps the given value linearly to the range [0, 1] as defined for the DICOM VOI LUT function LINEAR.
+func applyWindow(v, center, width float64) float64 {
+	if width <= 1 {
+		if v > center-0.5 {
+			return 1
+		}
+		return 0
+	}
+	result := (v-(center-0.5))/(width-1) + 0.5
+	return math.Max(0, math.Min(1, result))
+}
+
+// parseDICOM reads the data elements of a DICOM file and collects the attributes of the image. If wantPixels is false
+// parsing stops as soon as the pixel data is reached.
+func parseDICOM(r io.Reader, wantPixels bool) (*dicomHeader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 132 || string(data[128:132]) != "DICM" {
+		return nil, errors.New("dicom: missing DICM prefix")
+	}
+	header := &dicomHeader{samplesPerPixel: 1, slope: 1}
+	// the file meta information is always encoded in explicit VR little endian
+	p := &dicomParser{data: data, pos: 132, order: binary.LittleEndian, explicit: true}
+	for p.pos+2 <= len(data) && p.order.Uint16(data[p.pos:]) == 0x0002 {
+		tag, _, length, err := p.readElementHeader()
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.readValue(length)
+		if err != nil {
+			return nil, err
+		}
+		if tag == tagTransferSyntax {
+			header.transferSyntax = trimDICOMString(value)
+		}
+	}
+
+	// switch to the encoding of the data set
+	switch header.transferSyntax {
+	case TS_IMPLICIT_LITTLE:
+		p.explicit = false
+	case TS_EXPLICIT_LITTLE, TS_JPEG_BASELINE, TS_RLE_LOSSLESS:
+	case TS_EXPLICIT_BIG:
+		p.order = binary.BigEndian
+	default:
+		return nil, errors.New("dicom: unsupported transfer syntax " + header.transferSyntax)
+	}
+
+	// read the data set up to the pixel data
+	for p.pos < len(data) {
+		tag, _, length, err := p.readElementHeader()
+		if err != nil {
+			return nil, err
+		}
+		if tag == tagPixelData {
+			if !wantPixels {
+				break
+			}
+			header.encapsulated = length == UNDEFINED_LENGTH
+			if header.encapsulated {
+				header.pixelData, err = p.readFragments()
+			} else {
+				header.pixelData, err = p.readValue(length)
+			}
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		if length == UNDEFINED_LENGTH {
+			if err := p.skipUndefined(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		value, err := p.readValue(length)
+		if err != nil {
+			return nil, err
+		}
+		header.setAttribute(tag, value, p.order)
+	}
+
+	if header.rows <= 0 || header.columns <= 0 {
+		return nil, errors.New("dicom: missing image dimensions")
+	}
+	if wantPixels && header.pixelData == nil {
+		return nil, errors.New("dicom: missing pixel data")
+	}
+	if wantPixels {
+		if err := checkDecodeSize(header.columns, header.rows); err != nil {
+			return nil, err
+		}
+	}
+
+	return header, nil
+}
+
+// setAttribute stores the value of the given data element in the header if it is one of the evaluated attributes.
+func (h *dicomHeader) setAttribute(tag uint32, value []byte, order binary.ByteOrder) {
+	switch tag {
+	case tagSamplesPerPixel:
+		h.samplesPerPixel = readDICOMUint16(value, order)
+	case tagPhotometric:
+		h.photometric = trimDICOMString(value)
+	case tagRows:
+		h.rows = readDICOMUint16(value, order)
+	case tagColumns:
+		h.columns = readDICOMUint16(value, order)
+	case tagBitsAllocated:
+		h.bitsAllocated = readDICOMUint16(value, order)
+	case tagBitsStored:
+		h.bitsStored = readDICOMUint16(value, order)
+	case tagPixelRepr:
+		h.pixelRepr = readDICOMUint16(value, order)
+	case tagWindowCenter:
+		h.windowCenter = readDICOMDecimal(value)
+	case tagWindowWidth:
+		h.windowWidth = readDICOMDecimal(value)
+	case tagRescaleIntercept:
+		h.intercept = readDICOMDecimal(value)
+	case tagRescaleSlope:
+		h.slope = readDICOMDecimal(value)
+	}
+}
+
+// modalityValues returns the values of the first frame in row major order after applying the rescale slope and
+// intercept.
+func (h *dicomHeader) modalityValues() ([]float64, error) {
+	if h.samplesPerPixel != 1 {
+		return nil, errors.New("dicom: only single sample (grayscale) images are supported")
+	}
+	count := h.rows * h.columns
+	values := make([]float64, count)
+
+	// compressed JPEG data can be handed to the image library
+	if h.transferSyntax == TS_JPEG_BASELINE {
+		img, _, err := image.Decode(bytes.NewReader(h.pixelData))
+		if err != nil {
+			return nil, err
+		}
+		for i := range values {
+			gray := color.GrayModel.Convert(img.At(i%h.columns, i/h.columns)).(color.Gray).Y
+			values[i] = float64(gray)*h.slope + h.intercept
+		}
+		return values, nil
+	}
+
+	if h.bitsAllocated != 8 && h.bitsAllocated != 16 {
+		return nil, errors.New("dicom: unsupported number of bits allocated")
+	}
+	bytesPerSample := h.bitsAllocated / 8
+	raw := h.pixelData
+	order := binary.ByteOrder(binary.LittleEndian)
+	if h.transferSyntax == TS_EXPLICIT_BIG {
+		order = binary.BigEndian
+	}
+	if h.transferSyntax == TS_RLE_LOSSLESS {
+		var err error
+		if raw, err = decodeDICOMRLE(raw, count, bytesPerSample); err != nil {
+			return nil, err
+		}
+		order = binary.BigEndian // segments are ordered from the most to the least significant byte
+	}
+	if len(raw) < count*bytesPerSample {
+		return nil, errors.New("dicom: pixel data too short")
+	}
+
+	bitsStored := h.bitsStored
+	if bitsStored <= 0 || bitsStored > h.bitsAllocated {
+		bitsStored = h.bitsAllocated
+	}
+	mask := uint32(1)<<uint(bitsStored) - 1
+	for i := range values {
+		var sample uint32
+		if bytesPerSample == 1 {
+			sample = uint32(raw[i])
+		} else {
+			sample = uint32(order.Uint16(raw[2*i:]))
+		}
+		sample &= mask
+		v := float64(sample)
+		if h.pixelRepr == 1 && sample&(1<<uint(bitsStored-1)) != 0 { // two's complement
+			v -= float64(uint32(1) << uint(bitsStored))
+		}
+		values[i] = v*h.slope + h.intercept
+	}
+
+	return values, nil
+}
+
+// decodeDICOMRLE decodes a frame compressed with the DICOM RLE scheme. Each byte plane of the samples is stored in its
+// own segment, the most significant byte first. The result holds the samples in big endian byte order.
+func decodeDICOMRLE(data []byte, count, bytesPerSample int) ([]byte, error) {
+	if len(data) < 64 {
+		return nil, errors.New("dicom: invalid RLE header")
+	}
+	segments := int(binary.LittleEndian.Uint32(data))
+	if segments != bytesPerSample {
+		return nil, errors.New("dicom: unexpected number of RLE segments")
+	}
+	result := make([]byte, count*bytesPerSample)
+	for s := 0; s < segments; s++ {
+		start := int(binary.LittleEndian.Uint32(data[4+4*s:]))
+		end := len(data)
+		if s+1 < segments {
+			end = int(binary.LittleEndian.Uint32(data[8+4*s:]))
+		}
+		if start < 64 || start > end || end > len(data) {
+			return nil, errors.New("dicom: invalid RLE segment offset")
+		}
+		// unpack the PackBits encoded segment into every bytesPerSample-th byte of the result
+		segment := data[start:end]
+		out := s
+		for i := 0; i < len(segment) && out < len(result); {
+			n := int(int8(segment[i]))
+			i++
+			switch {
+			case n >= 0: // literal run of n+1 bytes
+				for j := 0; j <= n && i < len(segment) && out < len(result); j++ {
+					result[out] = segment[i]
+					out += bytesPerSample
+					i++
+				}
+			case n != -128: // replicate the next byte 1-n times
+				if i >= len(segment) {
+					break
+				}
+				for j := 0; j < 1-n && out < len(result); j++ {
+					result[out] = segment[i]
+					out += bytesPerSample
+				}
+				i++
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// readElementHeader reads the tag, value representation and value length of the next data element. For implicit VR
+// encodings and delimitation items the returned value representation is empty.
+func (p *dicomParser) readElementHeader() (uint32, string, uint32, error) {
+	if p.pos+8 > len(p.data) {
+		return 0, "", 0, io.ErrUnexpectedEOF
+	}
+	group := p.order.Uint16(p.data[p.pos:])
+	element := p.order.Uint16(p.data[p.pos+2:])
+	tag := uint32(group)<<16 | uint32(element)
+	p.pos += 4
+
+	// items and delimiters never carry a value representation
+	if group == 0xFFFE || !p.explicit {
+		length := p.order.Uint32(p.data[p.pos:])
+		p.pos += 4
+		return tag, "", length, nil
+	}
+
+	vr := string(p.data[p.pos : p.pos+2])
+	p.pos += 2
+	switch vr {
+	case "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UC", "UN", "UR", "UT", "UV":
+		// two reserved bytes followed by a 32 bit length
+		if p.pos+6 > len(p.data) {
+			return 0, "", 0, io.ErrUnexpectedEOF
+		}
+		length := p.order.Uint32(p.data[p.pos+2:])
+		p.pos += 6
+		return tag, vr, length, nil
+	default:
+		length := uint32(p.order.Uint16(p.data[p.pos:]))
+		p.pos += 2
+		return tag, vr, length, nil
+	}
+}
+
+// readValue returns the next length bytes of the data.
+func (p *dicomParser) readValue(length uint32) ([]byte, error) {
+	if uint64(p.pos)+uint64(length) > uint64(len(p.data)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	value := p.data[p.pos : p.pos+int(length)]
+	p.pos += int(length)
+	return value, nil
+}
+
+// skipUndefined skips the items of a sequence with undefined length up to and including its delimitation item.
+func (p *dicomParser) skipUndefined() error {
+	for p.pos < len(p.data) {
+		tag, _, length, err := p.readElementHeader()
+		if err != nil {
+			return err
+		}
+		switch {
+		case tag == tagSequenceDelim || tag == tagItemDelim:
+			return nil
+		case length == UNDEFINED_LENGTH: // nested item or sequence
+			if err := p.skipUndefined(); err != nil {
+				return err
+			}
+		default:
+			if _, err := p.readValue(length); err != nil {
+				return err
+			}
+		}
+	}
+
+	return io.ErrUnexpectedEOF
+}
+
+// readFragments reads encapsulated pixel data and returns the concatenated fragments of the frames. The basic offset
+// table in the first item is skipped.
+func (p *dicomParser) readFragments() ([]byte, error) {
+	var result []byte
+	first := true
+	for p.pos < len(p.data) {
+		tag, _, length, err := p.readElementHeader()
+		if err != nil {
+			return nil, err
+		}
+		if tag == tagSequenceDelim {
+			return result, nil
+		}
+		if tag != tagItem {
+			return nil, errors.New("dicom: invalid encapsulated pixel data")
+		}
+		fragment, err := p.readValue(length)
+		if err != nil {
+			return nil, err
+		}
+		if !first {
+			result = append(result, fragment...)
+		}
+		first = false
+	}
+
+	return nil, io.ErrUnexpectedEOF
+}
+
+// readDICOMUint16 interprets the given value as unsigned short.
+func readDICOMUint16(value []byte, order binary.ByteOrder) int {
+	if len(value) < 2 {
+		return 0
+	}
+	return int(order.Uint16(value))
+}
+
+// readDICOMDecimal interprets the given value as decimal or integer string. For multi-valued attributes only the first
+// value is returned.
+func readDICOMDecimal(value []byte) float64 {
+	s := strings.SplitN(trimDICOMString(value), "\\", 2)[0]
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// trimDICOMString removes the padding of a DICOM string value.
+func trimDICOMString(value []byte) string {
+	return strings.TrimRight(string(value), " \x00")
+}
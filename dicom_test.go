@@ -0,0 +1,408 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"strings"
+	"testing"
+)
+
+// testDICOM describes a DICOM file for the tests, see encode.
+type testDICOM struct {
+	transferSyntax            string
+	columns, rows             int
+	bitsAllocated             int
+	bitsStored                int // left out if zero
+	pixelRepr                 int
+	photometric               string // left out if empty
+	slope, intercept          string // left out if empty
+	windowCenter, windowWidth string // left out if empty
+	samples                   []uint16
+}
+
+// encode returns the DICOM file of the image. The samples are encoded as the transfer syntax requires: in the byte
+// order of the data set, compressed by RLE, or as baseline JPEG of 8-bit samples.
+func (d testDICOM) encode() []byte {
+	var file bytes.Buffer
+	file.Write(make([]byte, 128))
+	file.WriteString("DICM")
+	writeTestDICOMElement(&file, binary.LittleEndian, true, tagTransferSyntax, "UI", []byte(d.transferSyntax))
+
+	order, explicit := binary.AppendByteOrder(binary.LittleEndian), d.transferSyntax != TS_IMPLICIT_LITTLE
+	if d.transferSyntax == TS_EXPLICIT_BIG {
+		order = binary.BigEndian
+	}
+	us := func(v int) []byte { return order.AppendUint16(nil, uint16(v)) }
+	element := func(tag uint32, vr string, value []byte) {
+		writeTestDICOMElement(&file, order, explicit, tag, vr, value)
+	}
+	element(tagSamplesPerPixel, "US", us(1))
+	if d.photometric != "" {
+		element(tagPhotometric, "CS", []byte(d.photometric))
+	}
+	element(tagRows, "US", us(d.rows))
+	element(tagColumns, "US", us(d.columns))
+	element(tagBitsAllocated, "US", us(d.bitsAllocated))
+	if d.bitsStored > 0 {
+		element(tagBitsStored, "US", us(d.bitsStored))
+	}
+	element(tagPixelRepr, "US", us(d.pixelRepr))
+	for _, attribute := range []struct {
+		tag   uint32
+		value string
+	}{
+		{tagWindowCenter, d.windowCenter},
+		{tagWindowWidth, d.windowWidth},
+		{tagRescaleIntercept, d.intercept},
+		{tagRescaleSlope, d.slope},
+	} {
+		if attribute.value != "" {
+			element(attribute.tag, "DS", []byte(attribute.value))
+		}
+	}
+
+	bytesPerSample := max(d.bitsAllocated/8, 1)
+	switch d.transferSyntax {
+	case TS_RLE_LOSSLESS:
+		writeTestDICOMFragments(&file, order, testDICOMRLE(d.samples, bytesPerSample))
+	case TS_JPEG_BASELINE:
+		img := image.NewGray(image.Rect(0, 0, d.columns, d.rows))
+		for i, sample := range d.samples {
+			img.Pix[i] = uint8(sample)
+		}
+		var encoded bytes.Buffer
+		jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 100})
+		writeTestDICOMFragments(&file, order, encoded.Bytes())
+	default:
+		var data []byte
+		for _, sample := range d.samples {
+			if bytesPerSample == 1 {
+				data = append(data, uint8(sample))
+			} else {
+				data = order.AppendUint16(data, sample)
+			}
+		}
+		element(tagPixelData, "OW", data)
+	}
+	return file.Bytes()
+}
+
+// writeTestDICOMElement writes the data element of the given tag and value, padded to an even length.
+func writeTestDICOMElement(file *bytes.Buffer, order binary.AppendByteOrder, explicit bool, tag uint32, vr string,
+	value []byte) {
+	if len(value)%2 != 0 {
+		value = append(value, 0)
+	}
+	file.Write(order.AppendUint16(order.AppendUint16(nil, uint16(tag>>16)), uint16(tag&0xFFFF)))
+	switch {
+	case !explicit:
+		file.Write(order.AppendUint32(nil, uint32(len(value))))
+	case vr == "OB" || vr == "OW":
+		file.WriteString(vr + "\x00\x00")
+		file.Write(order.AppendUint32(nil, uint32(len(value))))
+	default:
+		file.WriteString(vr)
+		file.Write(order.AppendUint16(nil, uint16(len(value))))
+	}
+	file.Write(value)
+}
+
+// writeTestDICOMFragments writes encapsulated pixel data of an empty offset table and the given fragment.
+func writeTestDICOMFragments(file *bytes.Buffer, order binary.AppendByteOrder, fragment []byte) {
+	file.Write(order.AppendUint16(order.AppendUint16(nil, uint16(tagPixelData>>16)), uint16(tagPixelData&0xFFFF)))
+	file.WriteString("OB\x00\x00")
+	file.Write(order.AppendUint32(nil, UNDEFINED_LENGTH))
+	for _, item := range [][]byte{nil, fragment} {
+		file.Write(order.AppendUint16(order.AppendUint16(nil, 0xFFFE), 0xE000))
+		file.Write(order.AppendUint32(nil, uint32(len(item))))
+		file.Write(item)
+	}
+	file.Write(order.AppendUint16(order.AppendUint16(nil, 0xFFFE), 0xE0DD))
+	file.Write(order.AppendUint32(nil, 0))
+}
+
+// testDICOMRLE compresses the given samples with the DICOM RLE scheme. Every byte plane is a segment of its own,
+// which alternates replicate runs of the first two bytes with literal runs of the rest, so both kinds are decoded.
+func testDICOMRLE(samples []uint16, bytesPerSample int) []byte {
+	data := make([]byte, 64)
+	binary.LittleEndian.PutUint32(data, uint32(bytesPerSample))
+	for s := 0; s < bytesPerSample; s++ {
+		binary.LittleEndian.PutUint32(data[4+4*s:], uint32(len(data)))
+		plane := make([]byte, len(samples))
+		for i, sample := range samples {
+			plane[i] = uint8(sample >> (8 * (bytesPerSample - 1 - s)))
+		}
+		for i := 0; i < len(plane); {
+			if i+1 < len(plane) && plane[i] == plane[i+1] {
+				n := 2
+				for i+n < len(plane) && plane[i+n] == plane[i] && n < 128 {
+					n++
+				}
+				data = append(data, uint8(1-n), plane[i])
+				i += n
+				continue
+			}
+			n := min(len(plane)-i, 128)
+			data = append(data, uint8(n-1))
+			data = append(data, plane[i:i+n]...)
+			i += n
+		}
+	}
+	return data
+}
+
+// decodeTestDICOM parses the given DICOM file and returns its modality values.
+func decodeTestDICOM(data []byte) ([]float64, error) {
+	header, err := parseDICOM(bytes.NewReader(data), true)
+	if err != nil {
+		return nil, err
+	}
+	return header.modalityValues()
+}
+
+// equalValues checks whether the given values match the expected ones within the given tolerance.
+func equalValues(values, expected []float64, tolerance float64) bool {
+	if len(values) != len(expected) {
+		return false
+	}
+	for i := range values {
+		if math.Abs(values[i]-expected[i]) > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// TestDecodeDICOMTransferSyntaxes decodes the same image in all transfer syntaxes that are supported and checks that
+// others are rejected.
+func TestDecodeDICOMTransferSyntaxes(t *testing.T) {
+	samples := []uint16{0, 0, 0, 1, 2, 300, 4000, 65535, 65535, 65535, 7, 7}
+	expected := make([]float64, len(samples))
+	for i, sample := range samples {
+		expected[i] = float64(sample)
+	}
+	for _, test := range []struct {
+		name, transferSyntax string
+	}{
+		{"implicit little endian", TS_IMPLICIT_LITTLE},
+		{"explicit little endian", TS_EXPLICIT_LITTLE},
+		{"explicit big endian", TS_EXPLICIT_BIG},
+		{"RLE lossless", TS_RLE_LOSSLESS},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			file := testDICOM{transferSyntax: test.transferSyntax, columns: 4, rows: 3, bitsAllocated: 16,
+				samples: samples}
+			values, err := decodeTestDICOM(file.encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalValues(values, expected, 0) {
+				t.Errorf("expected %v, got %v", expected, values)
+			}
+		})
+	}
+
+	t.Run("JPEG baseline", func(t *testing.T) {
+		flat := []uint16{120, 120, 120, 120, 120, 120, 120, 120}
+		file := testDICOM{transferSyntax: TS_JPEG_BASELINE, columns: 4, rows: 2, bitsAllocated: 8, samples: flat,
+			slope: "2"}
+		values, err := decodeTestDICOM(file.encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the compression may shift the values a little, the slope applies to the decoded ones
+		if expected := []float64{240, 240, 240, 240, 240, 240, 240, 240}; !equalValues(values, expected, 4) {
+			t.Errorf("expected %v, got %v", expected, values)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		file := testDICOM{transferSyntax: "1.2.840.10008.1.2.4.90", columns: 4, rows: 3, bitsAllocated: 16,
+			samples: samples}
+		if _, err := decodeTestDICOM(file.encode()); err == nil || !strings.Contains(err.Error(), "transfer syntax") {
+			t.Errorf("expected the JPEG 2000 transfer syntax to be rejected, got %v", err)
+		}
+	})
+}
+
+// TestDecodeDICOMBitDepths checks the interpretation of the allocated and stored bits and the pixel representation.
+func TestDecodeDICOMBitDepths(t *testing.T) {
+	for _, test := range []struct {
+		name                                 string
+		bitsAllocated, bitsStored, pixelRepr int
+		samples                              []uint16
+		expected                             []float64 // nil if the file is rejected
+	}{
+		{"8 bits", 8, 8, 0, []uint16{0, 1, 128, 255}, []float64{0, 1, 128, 255}},
+		{"8 bits signed", 8, 8, 1, []uint16{0, 1, 128, 255}, []float64{0, 1, -128, -1}},
+		{"16 bits", 16, 16, 0, []uint16{0, 1, 32768, 65535}, []float64{0, 1, 32768, 65535}},
+		{"16 bits signed", 16, 16, 1, []uint16{0, 1, 32768, 65535}, []float64{0, 1, -32768, -1}},
+		{"12 of 16 bits", 16, 12, 0, []uint16{0x0001, 0x0FFF, 0xF800, 0x1234}, []float64{1, 4095, 2048, 0x234}},
+		{"12 of 16 bits signed", 16, 12, 1, []uint16{0x0001, 0x0FFF, 0xF800, 0x07FF}, []float64{1, -1, -2048, 2047}},
+		{"bits stored missing", 16, 0, 0, []uint16{0, 1, 4096, 65535}, []float64{0, 1, 4096, 65535}},
+		{"bits stored too large", 8, 16, 0, []uint16{0, 1, 128, 255}, []float64{0, 1, 128, 255}},
+		{"32 bits", 32, 32, 0, []uint16{0, 1, 2, 3}, nil},
+		{"12 bits allocated", 12, 12, 0, []uint16{0, 1, 2, 3}, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			file := testDICOM{transferSyntax: TS_EXPLICIT_LITTLE, columns: 2, rows: 2,
+				bitsAllocated: test.bitsAllocated, bitsStored: test.bitsStored, pixelRepr: test.pixelRepr,
+				samples: test.samples}
+			values, err := decodeTestDICOM(file.encode())
+			if test.expected == nil {
+				if err == nil {
+					t.Errorf("expected %d bits allocated to be rejected, got %v", test.bitsAllocated, values)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalValues(values, test.expected, 0) {
+				t.Errorf("expected %v, got %v", test.expected, values)
+			}
+		})
+	}
+}
+
+// TestDecodeDICOMRescale checks that the rescale slope and intercept map the samples to modality values, and that the
+// window maps those to the gray values of the image.
+func TestDecodeDICOMRescale(t *testing.T) {
+	samples := []uint16{0, 1000, 2000, 4000}
+	for _, test := range []struct {
+		name, slope, intercept string
+		expected               []float64
+	}{
+		{"identity", "", "", []float64{0, 1000, 2000, 4000}},
+		{"intercept", "", "-1024", []float64{-1024, -24, 976, 2976}},
+		{"slope and intercept", "0.5", "-1024", []float64{-1024, -524, -24, 976}},
+		{"padded", " 2 ", "10 ", []float64{10, 2010, 4010, 8010}},
+		{"multiple values", "2\\4", "1\\3", []float64{1, 2001, 4001, 8001}},
+		{"invalid intercept", "1", "abc", []float64{0, 1000, 2000, 4000}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			file := testDICOM{transferSyntax: TS_EXPLICIT_LITTLE, columns: 2, rows: 2, bitsAllocated: 16,
+				slope: test.slope, intercept: test.intercept, samples: samples}
+			values, err := decodeTestDICOM(file.encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalValues(values, test.expected, 1e-9) {
+				t.Errorf("expected %v, got %v", test.expected, values)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		name                      string
+		photometric               string
+		windowCenter, windowWidth string
+		center, width             float64
+		expected                  []uint16
+	}{
+		// without a window the full range of values is shown
+		{"full range", "", "", "", 0, 0, []uint16{8, 16392, 32776, 65535}},
+		{"stored window", "", "0", "2001", 0, 0, []uint16{16, 32784, 65535, 65535}},
+		{"given window", "", "0", "2001", 2000, 4001, []uint16{0, 8, 16392, 49159}},
+		{"inverted", "MONOCHROME1", "0", "2001", 0, 0, []uint16{65519, 32751, 0, 0}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			file := testDICOM{transferSyntax: TS_EXPLICIT_LITTLE, columns: 2, rows: 2, bitsAllocated: 16,
+				photometric: test.photometric, windowCenter: test.windowCenter, windowWidth: test.windowWidth,
+				intercept: "-1000", samples: samples}
+			img, err := decodeDICOM(bytes.NewReader(file.encode()), test.center, test.width)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, expected := range test.expected {
+				if gray := img.At(i%2, i/2).(color.Gray16).Y; gray != expected {
+					t.Errorf("pixel %d: expected %d, got %d", i, expected, gray)
+				}
+			}
+		})
+	}
+}
+
+// TestDecodeDICOMHeaders checks that truncated files and headers announcing more than the file or the decoders hold
+// are rejected, while the configuration only needs the data set up to the pixel data.
+func TestDecodeDICOMHeaders(t *testing.T) {
+	file := testDICOM{transferSyntax: TS_EXPLICIT_LITTLE, columns: 4, rows: 3, bitsAllocated: 16,
+		samples: make([]uint16, 12)}
+	data := file.encode()
+	pixelData := bytes.LastIndex(data, []byte{0xE0, 0x7F, 0x10, 0x00})
+	for length := 0; length < len(data); length++ {
+		if _, err := decodeTestDICOM(data[:length]); err == nil {
+			t.Errorf("accepted file truncated to %d of %d bytes", length, len(data))
+		}
+	}
+	// the configuration only needs the data set up to the pixel data, but no element may be cut off
+	for _, test := range []struct {
+		length   int
+		complete bool
+	}{
+		{len(data), true},
+		{pixelData, true},
+		{pixelData - 1, false},
+		{pixelData - 20, true}, // up to the columns
+		{pixelData - 30, false},
+	} {
+		if _, err := decodeDICOMConfig(bytes.NewReader(data[:test.length])); (err == nil) != test.complete {
+			t.Errorf("configuration of file truncated to %d of %d bytes: %v", test.length, len(data), err)
+		}
+	}
+
+	for _, test := range []struct {
+		name   string
+		modify func(d *testDICOM)
+		patch  func(data []byte) []byte
+		err    string // part of the expected error
+	}{
+		{"oversized dimensions", func(d *testDICOM) { d.columns, d.rows = 65535, 65535 }, nil, "exceeds"},
+		{"short pixel data", func(d *testDICOM) { d.samples = d.samples[:11] }, nil, "too short"},
+		{"missing dimensions", func(d *testDICOM) { d.columns = 0 }, nil, "dimensions"},
+		{"missing prefix", nil, func(data []byte) []byte { return data[1:] }, "DICM"},
+		{"value beyond the end", nil, func(data []byte) []byte {
+			// the length of the pixel data announces more bytes than the file holds
+			binary.LittleEndian.PutUint32(data[pixelData+8:], 0xFFFFFFF0)
+			return data
+		}, "unexpected EOF"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			modified := file
+			modified.samples = append([]uint16{}, file.samples...)
+			if test.modify != nil {
+				test.modify(&modified)
+			}
+			data := modified.encode()
+			if test.patch != nil {
+				data = test.patch(data)
+			}
+			if _, err := decodeDICOM(bytes.NewReader(data), 0, 0); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
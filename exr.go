@@ -0,0 +1,338 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+)
+
+// compression methods of OpenEXR files that can be read
+const (
+	EXR_NO_COMPRESSION   = 0
+	EXR_RLE_COMPRESSION  = 1
+	EXR_ZIPS_COMPRESSION = 2
+	EXR_ZIP_COMPRESSION  = 3
+)
+
+// pixel types of OpenEXR channels
+const (
+	EXR_UINT  = 0
+	EXR_HALF  = 1
+	EXR_FLOAT = 2
+)
+
+// exrChannel describes a channel of an OpenEXR image.
+type exrChannel struct {
+	name      string
+	pixelType int32
+}
+
+// exrHeader holds the attributes of an OpenEXR file that are needed to read the pixels.
+type exrHeader struct {
+	channels      []exrChannel // sorted by name as the channels are stored
+	compression   byte
+	width, height int
+	minY          int32
+}
+
+// size returns the number of bytes a value of the channel occupies.
+func (c exrChannel) size() int {
+	if c.pixelType == EXR_HALF {
+		return 2
+	}
+	return 4
+}
+
+// readEXRHeader reads the magic bytes, version and header attributes of a single part scanline OpenEXR file.
+func readEXRHeader(r *bufio.Reader) (exrHeader, error) {
+	var header exrHeader
+	start := make([]byte, 8)
+	if _, err := io.ReadFull(r, start); err != nil {
+		return header, err
+	}
+	if string(start[:4]) != EXR_MAGIC {
+		return header, errors.New("exr: invalid magic bytes")
+	}
+	if flags := binary.LittleEndian.Uint32(start[4:]) >> 8; flags&0x02 != 0 || flags&0x10 != 0 {
+		return header, errors.New("exr: tiled and multi-part files are not supported")
+	}
+
+	hasDataWindow := false
+	for {
+		name, err := r.ReadString(0)
+		if err != nil {
+			return header, err
+		}
+		if name == "\x00" {
+			break
+		}
+		if _, err := r.ReadString(0); err != nil { // attribute type, the names determine the types already
+			return header, err
+		}
+		var size int32
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return header, err
+		}
+		if size < 0 || size > 1<<20 {
+			return header, errors.New("exr: invalid attribute size")
+		}
+		value := make([]byte, size)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return header, err
+		}
+
+		switch name[:len(name)-1] {
+		case "channels":
+			header.channels = parseEXRChannels(value)
+		case "compression":
+			if len(value) != 1 {
+				return header, errors.New("exr: invalid compression attribute")
+			}
+			header.compression = value[0]
+		case "dataWindow":
+			if len(value) != 16 {
+				return header, errors.New("exr: invalid data window")
+			}
+			minX, minY := int32(binary.LittleEndian.Uint32(value)), int32(binary.LittleEndian.Uint32(value[4:]))
+			maxX, maxY := int32(binary.LittleEndian.Uint32(value[8:])), int32(binary.LittleEndian.Uint32(value[12:]))
+			header.width, header.height, header.minY = int(maxX-minX)+1, int(maxY-minY)+1, minY
+			hasDataWindow = true
+		}
+	}
+	if !hasDataWindow || header.width <= 0 || header.height <= 0 || len(header.channels) == 0 {
+		return header, errors.New("exr: missing or invalid header attributes")
+	}
+	if header.compression > EXR_ZIP_COMPRESSION {
+		return header, errors.New("exr: unsupported compression, only none, rle, zips and zip are supported")
+	}
+	return header, nil
+}
+
+// parseEXRChannels parses the value of a chlist attribute. Channels with subsampling are not expected.
+func parseEXRChannels(value []byte) []exrChannel {
+	var channels []exrChannel
+	for len(value) > 0 && value[0] != 0 {
+		end := bytes.IndexByte(value, 0)
+		if end < 0 || len(value) < end+17 {
+			break
+		}
+		channels = append(channels, exrChannel{string(value[:end]), int32(binary.LittleEndian.Uint32(value[end+1:]))})
+		value = value[end+17:]
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].name < channels[j].name })
+	return channels
+}
+
+// decodeEXRConfig returns the dimensions of an OpenEXR file.
+func decodeEXRConfig(r io.Reader) (image.Config, error) {
+	header, err := readEXRHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.Gray16Model, Width: header.width, Height: header.height}, nil
+}
+
+// decodeEXR reads the pixels of a scanline OpenEXR file and returns their luminance. The luminance is taken from the
+// Y channel if there is one and computed from the R, G and B channels otherwise. Images with other channels use the
+// first channel.
+func decodeEXR(r io.Reader) ([][]float64, error) {
+	br := bufio.NewReader(r)
+	header, err := readEXRHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDecodeSize(header.width, header.height); err != nil {
+		return nil, err
+	}
+
+	linesPerChunk := 1
+	if header.compression == EXR_ZIP_COMPRESSION {
+		linesPerChunk = 16
+	}
+	chunks := (header.height + linesPerChunk - 1) / linesPerChunk
+	// the chunks are read in file order, so the offset table isn't needed
+	if _, err := br.Discard(8 * chunks); err != nil {
+		return nil, err
+	}
+
+	lineSize := 0
+	for _, channel := range header.channels {
+		lineSize += channel.size() * header.width
+	}
+	values := make(map[string][][]float64)
+	for _, channel := range header.channels {
+		values[channel.name] = make([][]float64, header.height)
+	}
+	filled := make([]bool, header.height)
+	for chunk := 0; chunk < chunks; chunk++ {
+		var prefix struct {
+			Y    int32
+			Size int32
+		}
+		if err := binary.Read(br, binary.LittleEndian, &prefix); err != nil {
+			return nil, err
+		}
+		first := int(prefix.Y) - int(header.minY)
+		lines := min(linesPerChunk, header.height-first)
+		if first < 0 || lines <= 0 || prefix.Size < 0 || int(prefix.Size) > lineSize*lines || filled[first] {
+			return nil, errors.New("exr: invalid chunk")
+		}
+		data := make([]byte, prefix.Size)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		if data, err = decompressEXRChunk(data, header.compression, lineSize*lines); err != nil {
+			return nil, err
+		}
+
+		// every line holds the values of all channels one after another
+		for line := 0; line < lines; line++ {
+			offset := line * lineSize
+			for _, channel := range header.channels {
+				row := make([]float64, header.width)
+				for x := range row {
+					row[x] = exrValue(data[offset:], channel.pixelType)
+					offset += channel.size()
+				}
+				values[channel.name][first+line] = row
+			}
+			filled[first+line] = true
+		}
+	}
+	// chunks are located by their y coordinate, so a repeated chunk leaves others out
+	for _, ok := range filled {
+		if !ok {
+			return nil, errors.New("exr: missing chunks")
+		}
+	}
+
+	if y, ok := values["Y"]; ok {
+		return y, nil
+	}
+	red, hasRed := values["R"]
+	green, hasGreen := values["G"]
+	blue, hasBlue := values["B"]
+	if !hasRed || !hasGreen || !hasBlue {
+		return values[header.channels[0].name], nil
+	}
+	luminance := make([][]float64, header.height)
+	for y := range luminance {
+		luminance[y] = make([]float64, header.width)
+		for x := range luminance[y] {
+			luminance[y][x] = hdrLuminance(red[y][x], green[y][x], blue[y][x])
+		}
+	}
+	return luminance, nil
+}
+
+// decompressEXRChunk returns the uncompressed data of a chunk. Data that didn't get smaller by compression is stored
+// uncompressed. After decompression of RLE and ZIP data a predictor is reversed and the bytes are interleaved again.
+func decompressEXRChunk(data []byte, compression byte, size int) ([]byte, error) {
+	if compression == EXR_NO_COMPRESSION || len(data) == size {
+		return data, nil
+	}
+	var decoded []byte
+	if compression == EXR_RLE_COMPRESSION {
+		for i := 0; i < len(data); {
+			count := int(int8(data[i]))
+			if count < 0 {
+				end := i + 1 - count
+				if end > len(data) {
+					return nil, errors.New("exr: invalid run length")
+				}
+				decoded = append(decoded, data[i+1:end]...)
+				i = end
+				continue
+			}
+			if i+1 >= len(data) {
+				return nil, errors.New("exr: invalid run length")
+			}
+			decoded = append(decoded, bytes.Repeat(data[i+1:i+2], count+1)...)
+			i += 2
+		}
+	} else {
+		reader, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if decoded, err = io.ReadAll(io.LimitReader(reader, int64(size)+1)); err != nil {
+			return nil, err
+		}
+	}
+	if len(decoded) != size {
+		return nil, errors.New("exr: unexpected size of decompressed data")
+	}
+
+	// reverse the predictor, then merge the two halves holding the even and the odd bytes
+	for i := 1; i < len(decoded); i++ {
+		decoded[i] = decoded[i-1] + decoded[i] - 128
+	}
+	result := make([]byte, size)
+	half := (size + 1) / 2
+	for i := range result {
+		if i%2 == 0 {
+			result[i] = decoded[i/2]
+		} else {
+			result[i] = decoded[half+i/2]
+		}
+	}
+	return result, nil
+}
+
+// exrValue returns the value of the given pixel type at the start of the given data.
+func exrValue(data []byte, pixelType int32) float64 {
+	switch pixelType {
+	case EXR_HALF:
+		return halfToFloat(binary.LittleEndian.Uint16(data))
+	case EXR_FLOAT:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data)))
+	default:
+		return float64(binary.LittleEndian.Uint32(data))
+	}
+}
+
+// halfToFloat converts an IEEE 754 half precision number to float64.
+func halfToFloat(half uint16) float64 {
+	sign := 1.0
+	if half&0x8000 != 0 {
+		sign = -1
+	}
+	exponent := int(half>>10) & 0x1f
+	mantissa := float64(half & 0x3ff)
+	switch exponent {
+	case 0:
+		return sign * math.Ldexp(mantissa, -24) // subnormal numbers
+	case 0x1f:
+		if mantissa != 0 {
+			return math.NaN()
+		}
+		return sign * math.Inf(1)
+	}
+	return sign * math.Ldexp(1+mantissa/1024, exponent-15)
+}
@@ -0,0 +1,284 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+// testEXRChannel is a channel of an OpenEXR file for the tests, whose values are encoded by the given function.
+type testEXRChannel struct {
+	name      string
+	pixelType int32
+	value     func(x, y int) []byte
+}
+
+// testEXRFloat returns a function encoding the given values as 32-bit floating point numbers.
+func testEXRFloat(value func(x, y int) float64) func(x, y int) []byte {
+	return func(x, y int) []byte {
+		return binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(value(x, y))))
+	}
+}
+
+// testEXR returns a single part scanline OpenEXR file of the given channels, which have to be sorted by name, with
+// its data window starting at row minY. The chunks are compressed with the given method unless that doesn't make
+// them smaller.
+func testEXR(width, height, minY int, compression byte, channels []testEXRChannel) []byte {
+	file := []byte(EXR_MAGIC)
+	file = binary.LittleEndian.AppendUint32(file, 2)
+	attribute := func(name, kind string, value []byte) {
+		file = append(file, name+"\x00"+kind+"\x00"...)
+		file = binary.LittleEndian.AppendUint32(file, uint32(len(value)))
+		file = append(file, value...)
+	}
+	var list []byte
+	for _, channel := range channels {
+		list = append(list, channel.name+"\x00"...)
+		list = binary.LittleEndian.AppendUint32(list, uint32(channel.pixelType))
+		list = append(list, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0) // linear, reserved and sampling
+	}
+	attribute("channels", "chlist", append(list, 0))
+	attribute("compression", "compression", []byte{compression})
+	var window []byte
+	for _, value := range []int{0, minY, width - 1, minY + height - 1} {
+		window = binary.LittleEndian.AppendUint32(window, uint32(int32(value)))
+	}
+	attribute("dataWindow", "box2i", window)
+	file = append(file, 0)
+
+	linesPerChunk := 1
+	if compression == EXR_ZIP_COMPRESSION {
+		linesPerChunk = 16
+	}
+	chunks := (height + linesPerChunk - 1) / linesPerChunk
+	file = append(file, make([]byte, 8*chunks)...) // the offsets aren't read
+	for first := 0; first < height; first += linesPerChunk {
+		var data []byte
+		for y := first; y < min(first+linesPerChunk, height); y++ {
+			for _, channel := range channels {
+				for x := 0; x < width; x++ {
+					data = append(data, channel.value(x, y)...)
+				}
+			}
+		}
+		if compressed := compressTestEXRChunk(data, compression); len(compressed) < len(data) {
+			data = compressed
+		}
+		file = binary.LittleEndian.AppendUint32(file, uint32(int32(minY+first)))
+		file = binary.LittleEndian.AppendUint32(file, uint32(len(data)))
+		file = append(file, data...)
+	}
+	return file
+}
+
+// compressTestEXRChunk compresses the given data of a chunk like the OpenEXR library: the even and the odd bytes are
+// split into two halves, the differences of successive bytes are taken and the result is run-length encoded or
+// deflated.
+func compressTestEXRChunk(data []byte, compression byte) []byte {
+	if compression == EXR_NO_COMPRESSION {
+		return data
+	}
+	split := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i += 2 {
+		split = append(split, data[i])
+	}
+	for i := 1; i < len(data); i += 2 {
+		split = append(split, data[i])
+	}
+	predicted := make([]byte, len(split))
+	for i := range split {
+		predicted[i] = split[i]
+		if i > 0 {
+			predicted[i] = split[i] - split[i-1] + 128
+		}
+	}
+
+	var compressed []byte
+	if compression == EXR_RLE_COMPRESSION {
+		for i := 0; i < len(predicted); {
+			run := 1
+			for i+run < len(predicted) && predicted[i+run] == predicted[i] && run < 128 {
+				run++
+			}
+			if run >= 3 {
+				compressed = append(compressed, uint8(run-1), predicted[i])
+				i += run
+				continue
+			}
+			literal := 1
+			for i+literal < len(predicted) && literal < 127 &&
+				!(i+literal+2 < len(predicted) && predicted[i+literal] == predicted[i+literal+1] &&
+					predicted[i+literal] == predicted[i+literal+2]) {
+				literal++
+			}
+			compressed = append(compressed, uint8(-int8(literal)))
+			compressed = append(compressed, predicted[i:i+literal]...)
+			i += literal
+		}
+		return compressed
+	}
+	var deflated bytes.Buffer
+	writer := zlib.NewWriter(&deflated)
+	writer.Write(predicted)
+	writer.Close()
+	return deflated.Bytes()
+}
+
+// TestDecodeEXRCompressions decodes an RGB image of floating point values with every compression that can be read.
+// The image has more rows than a chunk of ZIP compression holds, and the flat blue channel compresses well while
+// the noisy red channel doesn't.
+func TestDecodeEXRCompressions(t *testing.T) {
+	width, height := 32, 20
+	red := func(x, y int) float64 { return float64((x*7919+y*104729)%1000) / 7 }
+	green := func(x, y int) float64 { return float64(y) / 4 }
+	blue := func(x, y int) float64 { return 0.5 }
+	channels := []testEXRChannel{
+		{"B", EXR_FLOAT, testEXRFloat(blue)},
+		{"G", EXR_FLOAT, testEXRFloat(green)},
+		{"R", EXR_FLOAT, testEXRFloat(red)},
+	}
+	uncompressed := testEXR(width, height, -3, EXR_NO_COMPRESSION, channels)
+	for _, test := range []struct {
+		name        string
+		compression byte
+	}{
+		{"none", EXR_NO_COMPRESSION},
+		{"rle", EXR_RLE_COMPRESSION},
+		{"zips", EXR_ZIPS_COMPRESSION},
+		{"zip", EXR_ZIP_COMPRESSION},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			file := testEXR(width, height, -3, test.compression, channels)
+			if test.compression != EXR_NO_COMPRESSION && len(file) >= len(uncompressed) {
+				t.Fatalf("expected the chunks to get smaller by compression")
+			}
+			luminance, err := decodeEXR(bytes.NewReader(file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(luminance) != height || len(luminance[0]) != width {
+				t.Fatalf("expected %dx%d values, got %dx%d", width, height, len(luminance[0]), len(luminance))
+			}
+			for y := range luminance {
+				for x, value := range luminance[y] {
+					r, g, b := float64(float32(red(x, y))), float64(float32(green(x, y))), blue(x, y)
+					if expected := hdrLuminance(r, g, b); value != expected {
+						t.Fatalf("pixel %d,%d: expected %g, got %g", x, y, expected, value)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestDecodeEXRChannels checks that the luminance is taken from the Y channel if there is one and from the first
+// channel if there are neither Y nor R, G and B, and that half and unsigned integer values are converted.
+func TestDecodeEXRChannels(t *testing.T) {
+	half := func(values ...uint16) func(x, y int) []byte {
+		return func(x, y int) []byte { return binary.LittleEndian.AppendUint16(nil, values[x]) }
+	}
+	for _, test := range []struct {
+		name     string
+		channels []testEXRChannel
+		expected []float64
+	}{
+		{"luminance", []testEXRChannel{
+			{"B", EXR_HALF, half(0, 0, 0, 0)},
+			{"Y", EXR_HALF, half(0x3C00, 0xC000, 0x3555, 0x0001)},
+		}, []float64{1, -2, 0.333251953125, math.Ldexp(1, -24)}},
+		{"first channel", []testEXRChannel{
+			{"A", EXR_UINT, func(x, y int) []byte { return binary.LittleEndian.AppendUint32(nil, uint32(x*1000000)) }},
+			{"Z", EXR_HALF, half(0x7C00, 0x7C00, 0x7C00, 0x7C00)},
+		}, []float64{0, 1000000, 2000000, 3000000}},
+		{"green only", []testEXRChannel{
+			{"G", EXR_HALF, half(0x7C00, 0xFC00, 0x4000, 0x0000)},
+		}, []float64{math.Inf(1), math.Inf(-1), 2, 0}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			luminance, err := decodeEXR(bytes.NewReader(testEXR(4, 1, 0, EXR_NO_COMPRESSION, test.channels)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for x, expected := range test.expected {
+				if luminance[0][x] != expected {
+					t.Errorf("expected %v, got %v", test.expected, luminance[0])
+					break
+				}
+			}
+		})
+	}
+	if value := halfToFloat(0x7E00); !math.IsNaN(value) {
+		t.Errorf("expected NaN for half 0x7E00, got %g", value)
+	}
+}
+
+// TestDecodeEXRInvalid checks that files the decoder can't read are rejected instead of being read partially.
+func TestDecodeEXRInvalid(t *testing.T) {
+	channels := []testEXRChannel{{"Y", EXR_FLOAT, testEXRFloat(func(x, y int) float64 { return float64(x + y) })}}
+	valid := testEXR(4, 3, 0, EXR_NO_COMPRESSION, channels)
+	// offset of the first chunk, each chunk holds a prefix of 8 bytes and a row of 16 bytes
+	chunks := len(valid) - 3*(8+16)
+	for _, test := range []struct {
+		name string
+		data []byte
+		err  string // part of the expected error
+	}{
+		{"tiled", func() []byte {
+			data := bytes.Clone(valid)
+			data[5] |= 0x02
+			return data
+		}(), "tiled"},
+		{"piz compression", testEXR(4, 3, 0, 4, channels), "unsupported compression"},
+		{"missing chunk", func() []byte {
+			// the second chunk repeats the first one
+			data := bytes.Clone(valid)
+			copy(data[chunks+24:chunks+48], data[chunks:chunks+24])
+			return data
+		}(), "invalid chunk"},
+		{"chunk beyond the window", func() []byte {
+			data := bytes.Clone(valid)
+			binary.LittleEndian.PutUint32(data[chunks+48:], 3)
+			return data
+		}(), "invalid chunk"},
+		{"oversized chunk", func() []byte {
+			data := bytes.Clone(valid)
+			binary.LittleEndian.PutUint32(data[chunks+4:], 17)
+			return data
+		}(), "invalid chunk"},
+		{"truncated", valid[:len(valid)-1], "EOF"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeEXR(bytes.NewReader(test.data)); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+	if _, err := decompressEXRChunk([]byte{5, 1}, EXR_RLE_COMPRESSION, 16); err == nil {
+		t.Errorf("expected a run of the wrong size to be rejected")
+	}
+	if _, err := decompressEXRChunk([]byte{0xFE, 1}, EXR_RLE_COMPRESSION, 16); err == nil {
+		t.Errorf("expected a literal beyond the data to be rejected")
+	}
+}
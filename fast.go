@@ -0,0 +1,171 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"io"
+)
+
+// FAST_ARC_LENGTH is the number of contiguous circle pixels that must be brighter or darker than the center pixel.
+const FAST_ARC_LENGTH = 9
+
+// offsets of the 16 pixels on a bresenham circle of radius 3 around the candidate pixel, in clockwise order
+var FAST_CIRCLE = []image.Point{
+	{0, -3}, {1, -3}, {2, -2}, {3, -1}, {3, 0}, {3, 1}, {2, 2}, {1, 3},
+	{0, 3}, {-1, 3}, {-2, 2}, {-3, 1}, {-3, 0}, {-3, -1}, {-2, -2}, {-1, -3},
+}
+
+// Corner is a data structure to represent a detected corner by its position and its response score.
+type Corner struct {
+	X     int `json:"x"`
+	Y     int `json:"y"`
+	Score int `json:"score"`
+}
+
+// FastCorners detects corners in the given grayscale image with the FAST segment test. A pixel is considered a corner
+// if at least FAST_ARC_LENGTH contiguous pixels on the circle around it are all brighter or all darker than the pixel
+// itself by more than the given threshold. If nms is set only corners whose score is maximal in their 3x3 neighbourhood
+// are returned. Pixels closer than three pixels to the image border are never reported as corners.
+func FastCorners(pixels [][]GrayPixel, threshold int, nms bool) []Corner {
+	height := len(pixels)
+	if height == 0 {
+		return nil
+	}
+	width := len(pixels[0])
+	// compute the score of every pixel, a score of zero means the pixel is no corner
+	scores := make([][]int, height)
+	for y := range scores {
+		scores[y] = make([]int, width)
+	}
+	for y := 3; y < height-3; y++ {
+		for x := 3; x < width-3; x++ {
+			scores[y][x] = fastScore(pixels, x, y, threshold)
+		}
+	}
+
+	var corners []Corner
+	for y := 3; y < height-3; y++ {
+		for x := 3; x < width-3; x++ {
+			score := scores[y][x]
+			if score == 0 {
+				continue
+			}
+			if nms && !isLocalMaximum(scores, x, y) {
+				continue
+			}
+			corners = append(corners, Corner{x, y, score})
+		}
+	}
+
+	return corners
+}
+
+// fastScore performs the segment test for the pixel at the given position. If the pixel is a corner the sum of the
+// absolute differences between the center and the circle pixels that exceed the threshold is returned, otherwise the
+// result is zero.
+func fastScore(pixels [][]GrayPixel, x, y int, threshold int) int {
+	center := int(pixels[y][x].y)
+	var diffs [16]int
+	for i, offset := range FAST_CIRCLE {
+		diffs[i] = int(pixels[y+offset.Y][x+offset.X].y) - center
+	}
+	if !hasContiguousArc(diffs, threshold, 1) && !hasContiguousArc(diffs, threshold, -1) {
+		return 0
+	}
+
+	score := 0
+	for _, d := range diffs {
+		if abs(d) > threshold {
+			score += abs(d) - threshold
+		}
+	}
+
+	return score
+}
+
+// hasContiguousArc checks whether the given circle differences contain FAST_ARC_LENGTH contiguous values that exceed
+// the threshold in the direction denoted by sign (1 for brighter, -1 for darker). The circle wraps around.
+func hasContiguousArc(diffs [16]int, threshold int, sign int) bool {
+	run := 0
+	for i := 0; i < len(diffs)+FAST_ARC_LENGTH-1; i++ {
+		if sign*diffs[i%len(diffs)] > threshold {
+			run++
+			if run >= FAST_ARC_LENGTH {
+				return true
+			}
+		} else {
+			run = 0
+		}
+	}
+
+	return false
+}
+
+// isLocalMaximum checks whether the score at the given position is not exceeded by any score in its 3x3
+// neighbourhood. Ties are resolved in favour of the pixel that comes first in row major order.
+func isLocalMaximum(scores [][]int, x, y int) bool {
+	score := scores[y][x]
+	for i := y - 1; i <= y+1; i++ {
+		for j := x - 1; j <= x+1; j++ {
+			if i < 0 || i >= len(scores) || j < 0 || j >= len(scores[i]) || (i == y && j == x) {
+				continue
+			}
+			other := scores[i][j]
+			if other > score || (other == score && (i < y || (i == y && j < x))) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// writeCornersJSON writes the given corners as a JSON array to the given writer.
+func writeCornersJSON(corners []Corner, w io.Writer) error {
+	if corners == nil {
+		corners = []Corner{} // encode an empty array rather than null
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(corners)
+}
+
+// annotateCorners returns a color image of the given grayscale pixels in which every corner is marked by a small red
+// cross.
+func annotateCorners(pixels [][]GrayPixel, corners []Corner) *image.RGBA {
+	bounds := image.Rect(0, 0, len(pixels[0]), len(pixels))
+	img := image.NewRGBA(bounds)
+	for y := 0; y < len(pixels); y++ {
+		for x := 0; x < len(pixels[y]); x++ {
+			v := pixels[y][x].y
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	marker := color.RGBA{255, 0, 0, 255}
+	for _, c := range corners {
+		for d := -2; d <= 2; d++ {
+			img.SetRGBA(c.X+d, c.Y, marker) // pixels outside the bounds are ignored by SetRGBA
+			img.SetRGBA(c.X, c.Y+d, marker)
+		}
+	}
+
+	return img
+}
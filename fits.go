@@ -0,0 +1,314 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FITS_MAGIC is the pattern used to detect FITS files, every FITS file starts with the SIMPLE keyword.
+const FITS_MAGIC = "SIMPLE  ="
+
+// sizes of the FITS header structures
+const (
+	FITS_BLOCK_SIZE = 2880
+	FITS_CARD_SIZE  = 80
+)
+
+// parameters of the zscale algorithm as used by IRAF and ds9
+const (
+	ZSCALE_SAMPLES   = 1000
+	ZSCALE_CONTRAST  = 0.25
+	ZSCALE_REJECTION = 2.5
+	ZSCALE_MAX_ITER  = 5
+)
+
+// fitsHeader holds the keywords of a FITS primary header that are needed to read the image data.
+type fitsHeader struct {
+	bitpix        int
+	width, height int
+	bzero, bscale float64
+	blank         int64
+	hasBlank      bool
+}
+
+// decodeFITS reads the primary image of a FITS file and returns it as a 16-bit grayscale image. The physical values
+// are mapped to the 16-bit range by the given scaling method, which is one of linear, log or zscale. Blank and NaN
+// values are mapped to black. As FITS images start at the bottom row, the rows are flipped.
+func decodeFITS(r io.Reader, scale string) (image.Image, error) {
+	br := bufio.NewReader(r)
+	header, err := readFitsHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDecodeSize(header.width, header.height); err != nil {
+		return nil, err
+	}
+	values, err := readFitsData(br, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// determine the interval that is mapped to the output range
+	var low, high float64
+	switch scale {
+	case "zscale":
+		low, high = zscaleLimits(values)
+	case "linear", "log":
+		low, high = math.Inf(1), math.Inf(-1)
+		for _, v := range values {
+			if !math.IsNaN(v) {
+				low = math.Min(low, v)
+				high = math.Max(high, v)
+			}
+		}
+	default:
+		return nil, errors.New("fits: unknown scaling " + scale)
+	}
+
+	img := image.NewGray16(image.Rect(0, 0, header.width, header.height))
+	for i, v := range values {
+		var normalized float64
+		if !math.IsNaN(v) && high > low {
+			normalized = math.Max(0, math.Min(1, (v-low)/(high-low)))
+			if scale == "log" { // logarithmic stretch as used by ds9
+				normalized = math.Log10(1000*normalized+1) / math.Log10(1000)
+			}
+		}
+		x := i % header.width
+		y := header.height - 1 - i/header.width
+		img.SetGray16(x, y, color.Gray16{uint16(math.Round(normalized * math.MaxUint16))})
+	}
+
+	return img, nil
+}
+
+// decodeFITSConfig returns the dimensions and color model of a FITS image without reading the data.
+func decodeFITSConfig(r io.Reader) (image.Config, error) {
+	header, err := readFitsHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.Gray16Model, Width: header.width, Height: header.height}, nil
+}
+
+// readFitsHeader reads the header blocks of the primary HDU up to and including the block with the END keyword.
+func readFitsHeader(r io.Reader) (*fitsHeader, error) {
+	header := &fitsHeader{bscale: 1}
+	naxis := -1
+	block := make([]byte, FITS_BLOCK_SIZE)
+	for end := false; !end; {
+		if _, err := io.ReadFull(r, block); err != nil {
+			return nil, err
+		}
+		for i := 0; i < FITS_BLOCK_SIZE; i += FITS_CARD_SIZE {
+			card := string(block[i : i+FITS_CARD_SIZE])
+			keyword := strings.TrimSpace(card[:8])
+			if keyword == "END" {
+				end = true
+				break
+			}
+			if card[8:10] != "= " {
+				continue // comment or history card
+			}
+			value := fitsCardValue(card[10:])
+			switch keyword {
+			case "BITPIX":
+				header.bitpix, _ = strconv.Atoi(value)
+			case "NAXIS":
+				naxis, _ = strconv.Atoi(value)
+			case "NAXIS1":
+				header.width, _ = strconv.Atoi(value)
+			case "NAXIS2":
+				header.height, _ = strconv.Atoi(value)
+			case "BZERO":
+				header.bzero, _ = parseFitsFloat(value)
+			case "BSCALE":
+				header.bscale, _ = parseFitsFloat(value)
+			case "BLANK":
+				header.blank, _ = strconv.ParseInt(value, 10, 64)
+				header.hasBlank = true
+			}
+		}
+	}
+
+	if naxis < 2 || header.width <= 0 || header.height <= 0 {
+		return nil, errors.New("fits: primary HDU contains no two-dimensional image")
+	}
+	switch header.bitpix {
+	case 8, 16, 32, 64, -32, -64:
+	default:
+		return nil, errors.New("fits: invalid BITPIX value")
+	}
+
+	return header, nil
+}
+
+// fitsCardValue returns the value of a header card with the inline comment and string quotes removed.
+func fitsCardValue(field string) string {
+	field = strings.TrimSpace(field)
+	if strings.HasPrefix(field, "'") {
+		if end := strings.Index(field[1:], "'"); end >= 0 {
+			return strings.TrimSpace(field[1 : end+1])
+		}
+	}
+	if comment := strings.Index(field, "/"); comment >= 0 {
+		field = field[:comment]
+	}
+	return strings.TrimSpace(field)
+}
+
+// parseFitsFloat parses a floating point value of a header card, whose exponent may also be marked by D as in
+// Fortran.
+func parseFitsFloat(value string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(strings.ToUpper(value), "D", "E", 1), 64)
+}
+
+// readFitsData reads the first plane of the image data and returns the physical values (BZERO + BSCALE * value) in
+// file order. Blank values are returned as NaN.
+func readFitsData(r io.Reader, header *fitsHeader) ([]float64, error) {
+	count := header.width * header.height
+	bytesPerValue := abs(header.bitpix) / 8
+	raw := make([]byte, count*bytesPerValue)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, err
+	}
+
+	values := make([]float64, count)
+	for i := range values {
+		b := raw[i*bytesPerValue:]
+		var v float64
+		var integer int64
+		isInteger := true
+		switch header.bitpix {
+		case 8:
+			integer = int64(b[0])
+		case 16:
+			integer = int64(int16(binary.BigEndian.Uint16(b)))
+		case 32:
+			integer = int64(int32(binary.BigEndian.Uint32(b)))
+		case 64:
+			integer = int64(binary.BigEndian.Uint64(b))
+		case -32:
+			v = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+			isInteger = false
+		case -64:
+			v = math.Float64frombits(binary.BigEndian.Uint64(b))
+			isInteger = false
+		}
+		if isInteger {
+			if header.hasBlank && integer == header.blank {
+				values[i] = math.NaN()
+				continue
+			}
+			v = float64(integer)
+		}
+		values[i] = header.bzero + header.bscale*v
+	}
+
+	return values, nil
+}
+
+// zscaleLimits determines display limits with the zscale algorithm: a line is fitted to a sorted sample of the values
+// with iterative rejection of outliers and the limits are placed around the median according to the slope of the line.
+func zscaleLimits(values []float64) (float64, float64) {
+	// take an evenly spaced sample of the valid values
+	step := len(values)/ZSCALE_SAMPLES + 1
+	var samples []float64
+	for i := 0; i < len(values); i += step {
+		if !math.IsNaN(values[i]) {
+			samples = append(samples, values[i])
+		}
+	}
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	sort.Float64s(samples)
+	n := len(samples)
+	zmin, zmax := samples[0], samples[n-1]
+	median := samples[n/2]
+	if n < 5 {
+		return zmin, zmax
+	}
+
+	// fit a line through the samples, rejecting outliers on every iteration
+	used := make([]bool, n)
+	for i := range used {
+		used[i] = true
+	}
+	var slope, intercept float64
+	for iter := 0; iter < ZSCALE_MAX_ITER; iter++ {
+		var sumX, sumY, sumXX, sumXY, count float64
+		for i, s := range samples {
+			if used[i] {
+				x := float64(i)
+				sumX += x
+				sumY += s
+				sumXX += x * x
+				sumXY += x * s
+				count++
+			}
+		}
+		denominator := count*sumXX - sumX*sumX
+		if count < 2 || denominator == 0 {
+			break
+		}
+		slope = (count*sumXY - sumX*sumY) / denominator
+		intercept = (sumY - slope*sumX) / count
+
+		// reject samples that deviate more than the allowed number of standard deviations
+		var sumSq float64
+		for i, s := range samples {
+			if used[i] {
+				residual := s - (intercept + slope*float64(i))
+				sumSq += residual * residual
+			}
+		}
+		sigma := math.Sqrt(sumSq / count)
+		rejected := 0
+		for i, s := range samples {
+			if used[i] && math.Abs(s-(intercept+slope*float64(i))) > ZSCALE_REJECTION*sigma {
+				used[i] = false
+				rejected++
+			}
+		}
+		if rejected == 0 {
+			break
+		}
+	}
+
+	slope /= ZSCALE_CONTRAST
+	low := math.Max(zmin, median-float64(n/2)*slope)
+	high := math.Min(zmax, median+float64(n-n/2)*slope)
+	if high <= low {
+		return zmin, zmax
+	}
+
+	return low, high
+}
@@ -0,0 +1,199 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+// testFITS returns a FITS file of the given header cards, which are given as keyword and value, followed by the
+// END card and the given data. The header and the data are padded to full blocks.
+func testFITS(cards [][2]string, data string) []byte {
+	file := fmt.Sprintf("%-80s", "SIMPLE  =                    T / literal test file")
+	for _, card := range cards {
+		file += fmt.Sprintf("%-8s= %20s%50s", card[0], card[1], "")
+	}
+	file += fmt.Sprintf("%-80s", "END")
+	file += strings.Repeat(" ", (FITS_BLOCK_SIZE-len(file)%FITS_BLOCK_SIZE)%FITS_BLOCK_SIZE)
+	file += data
+	file += strings.Repeat("\x00", (FITS_BLOCK_SIZE-len(data)%FITS_BLOCK_SIZE)%FITS_BLOCK_SIZE)
+	return []byte(file)
+}
+
+// readTestFITS reads the header and the physical values of the given FITS file.
+func readTestFITS(data []byte) ([]float64, error) {
+	r := bytes.NewReader(data)
+	header, err := readFitsHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return readFitsData(r, header)
+}
+
+// TestDecodeFITSBitpix reads the values of 2x1 images of all BITPIX values, which are stored big endian.
+func TestDecodeFITSBitpix(t *testing.T) {
+	for _, test := range []struct {
+		bitpix   string
+		data     string
+		expected []float64 // nil if the file is rejected
+	}{
+		{"8", "\x00\xff", []float64{0, 255}},
+		{"16", "\x80\x00\x7f\xff", []float64{-32768, 32767}},
+		{"32", "\xff\xff\xff\xfe\x00\x01\x00\x00", []float64{-2, 65536}},
+		{"64", "\xff\xff\xff\xff\xff\xff\xff\xff\x00\x00\x00\x01\x00\x00\x00\x00", []float64{-1, 1 << 32}},
+		{"-32", "\x3f\xc0\x00\x00\xc1\x20\x00\x00", []float64{1.5, -10}},
+		{"-64", "\x3f\xf8\x00\x00\x00\x00\x00\x00\x40\x59\x00\x00\x00\x00\x00\x00", []float64{1.5, 100}},
+		{"12", "\x00\x00\x00", nil},
+		{"0", "", nil},
+	} {
+		t.Run("BITPIX "+test.bitpix, func(t *testing.T) {
+			file := testFITS([][2]string{{"BITPIX", test.bitpix}, {"NAXIS", "2"}, {"NAXIS1", "2"}, {"NAXIS2", "1"}},
+				test.data)
+			values, err := readTestFITS(file)
+			if test.expected == nil {
+				if err == nil {
+					t.Errorf("expected BITPIX %s to be rejected, got %v", test.bitpix, values)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalValues(values, test.expected, 0) {
+				t.Errorf("expected %v, got %v", test.expected, values)
+			}
+		})
+	}
+}
+
+// TestDecodeFITSScaling checks that BZERO and BSCALE map the stored values to physical values, and that blank values
+// are left out of the scaling to the gray values of the image.
+func TestDecodeFITSScaling(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		cards    [][2]string
+		expected []float64
+	}{
+		{"unscaled", nil, []float64{-32768, -1, 0, 32767}},
+		{"unsigned", [][2]string{{"BZERO", "32768"}}, []float64{0, 32767, 32768, 65535}},
+		{"scaled", [][2]string{{"BZERO", "-10.5"}, {"BSCALE", "0.5"}}, []float64{-16394.5, -11, -10.5, 16373}},
+		{"exponent", [][2]string{{"BZERO", "1.0E2"}, {"BSCALE", "2.0D0"}}, []float64{-65436, 98, 100, 65634}},
+		{"comments", [][2]string{{"BZERO", "1 / offset"}, {"BSCALE", "2 / factor"}},
+			[]float64{-65535, -1, 1, 65535}},
+		{"blank", [][2]string{{"BLANK", "-1"}, {"BZERO", "5"}}, []float64{-32763, math.NaN(), 5, 32772}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cards := append([][2]string{{"BITPIX", "16"}, {"NAXIS", "2"}, {"NAXIS1", "2"}, {"NAXIS2", "2"}},
+				test.cards...)
+			values, err := readTestFITS(testFITS(cards, "\x80\x00\xff\xff\x00\x00\x7f\xff"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, expected := range test.expected {
+				if values[i] != expected && !(math.IsNaN(expected) && math.IsNaN(values[i])) {
+					t.Errorf("expected %v, got %v", test.expected, values)
+					break
+				}
+			}
+		})
+	}
+
+	// the bottom row comes first, the blank value is black and the others are scaled linearly
+	file := testFITS([][2]string{{"BITPIX", "8"}, {"NAXIS", "2"}, {"NAXIS1", "2"}, {"NAXIS2", "2"}, {"BLANK", "0"},
+		{"BZERO", "-1"}, {"BSCALE", "2"}}, "\x00\x01\x02\x03")
+	img, err := decodeFITS(bytes.NewReader(file), "linear")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []uint16{32768, 65535, 0, 0} {
+		if gray := img.At(i%2, i/2).(color.Gray16).Y; gray != expected {
+			t.Errorf("pixel %d: expected %d, got %d", i, expected, gray)
+		}
+	}
+	if _, err := decodeFITS(bytes.NewReader(file), "sqrt"); err == nil {
+		t.Errorf("expected an unknown scaling to be rejected")
+	}
+}
+
+// TestDecodeFITSAxes checks that only images of at least two axes are accepted, of which the first plane is read,
+// and that headers announcing more data than the file or the decoders hold are rejected.
+func TestDecodeFITSAxes(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		cards    [][2]string
+		data     string
+		expected []float64 // nil if the file is rejected
+	}{
+		{"two axes", [][2]string{{"NAXIS", "2"}, {"NAXIS1", "3"}, {"NAXIS2", "1"}}, "\x01\x02\x03",
+			[]float64{1, 2, 3}},
+		{"three axes", [][2]string{{"NAXIS", "3"}, {"NAXIS1", "2"}, {"NAXIS2", "1"}, {"NAXIS3", "2"}},
+			"\x01\x02\x03\x04", []float64{1, 2}},
+		{"one axis", [][2]string{{"NAXIS", "1"}, {"NAXIS1", "3"}}, "\x01\x02\x03", nil},
+		{"no axes", [][2]string{{"NAXIS", "0"}}, "", nil},
+		{"NAXIS missing", [][2]string{{"NAXIS1", "3"}, {"NAXIS2", "1"}}, "\x01\x02\x03", nil},
+		{"empty axis", [][2]string{{"NAXIS", "2"}, {"NAXIS1", "3"}, {"NAXIS2", "0"}}, "", nil},
+		{"negative axis", [][2]string{{"NAXIS", "2"}, {"NAXIS1", "-3"}, {"NAXIS2", "1"}}, "", nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := readTestFITS(testFITS(append([][2]string{{"BITPIX", "8"}}, test.cards...), test.data))
+			if test.expected == nil {
+				if err == nil {
+					t.Errorf("expected the header to be rejected, got %v", values)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalValues(values, test.expected, 0) {
+				t.Errorf("expected %v, got %v", test.expected, values)
+			}
+		})
+	}
+
+	// a header of more than one block
+	cards := [][2]string{{"BITPIX", "8"}, {"NAXIS", "2"}, {"NAXIS1", "2"}, {"NAXIS2", "1"}}
+	for i := 0; i < 40; i++ {
+		cards = append(cards, [2]string{fmt.Sprint("KEY", i), "'filler'"})
+	}
+	if values, err := readTestFITS(testFITS(cards, "\x07\x09")); err != nil || !equalValues(values, []float64{7, 9}, 0) {
+		t.Errorf("expected the values after a header of two blocks, got %v, %v", values, err)
+	}
+
+	file := testFITS([][2]string{{"BITPIX", "16"}, {"NAXIS", "2"}, {"NAXIS1", "100000"}, {"NAXIS2", "100000"}}, "")
+	if config, err := decodeFITSConfig(bytes.NewReader(file)); err != nil || config.Width != 100000 {
+		t.Errorf("expected the configuration of the header, got %+v, %v", config, err)
+	}
+	if _, err := decodeFITS(bytes.NewReader(file), "linear"); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("expected the oversized image to be rejected, got %v", err)
+	}
+	truncated := testFITS([][2]string{{"BITPIX", "16"}, {"NAXIS", "2"}, {"NAXIS1", "2000"}, {"NAXIS2", "2"}}, "")
+	if _, err := decodeFITS(bytes.NewReader(truncated), "linear"); err == nil {
+		t.Errorf("expected the truncated data to be rejected")
+	}
+	if _, err := decodeFITSConfig(bytes.NewReader(file[:FITS_BLOCK_SIZE-1])); err == nil {
+		t.Errorf("expected the truncated header to be rejected")
+	}
+}
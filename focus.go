@@ -0,0 +1,100 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// FocusMeasure holds the statistics of the gradients of an image that describe how sharp it is. Blurred images have
+// small gradients and a laplacian that barely varies.
+type FocusMeasure struct {
+	LaplacianVariance float64 // variance of the laplacian, the score that is compared to the threshold
+	MeanGradient      float64 // mean magnitude of the sobel gradients
+}
+
+// focusCommand implements the focus subcommand, which reports the focus measure of the given image files and marks
+// those scoring below the threshold as blurry, e.g. to cull out-of-focus shots.
+func focusCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("focus", flag.ExitOnError)
+	thresholdArgPtr := flags.Float64("threshold", 100, "variance of the laplacian below which an image is reported as blurry (optional, default: 100)")
+	workersArgPtr := flags.Int("workers", runtime.NumCPU(), "number of concurrent workers (optional, default: number of CPUs)")
+	return flags, func() {
+		if flags.NArg() == 0 {
+			fmt.Println("No path to input file specified, nothing to do.")
+			return
+		}
+		if *thresholdArgPtr < 0 || *workersArgPtr < 1 {
+			fmt.Println("Invalid value for focus measure given, exiting.")
+			return
+		}
+
+		for _, path := range flags.Args() {
+			fmt.Println(path)
+			measure, err := measureFocusFile(path, *workersArgPtr)
+			if err != nil {
+				fmt.Printf("  error: %v\n", err)
+				continue
+			}
+			fmt.Printf("  laplacian variance: %.2f\n", measure.LaplacianVariance)
+			fmt.Printf("  mean gradient: %.2f\n", measure.MeanGradient)
+			if measure.LaplacianVariance < *thresholdArgPtr {
+				fmt.Println("  blurry")
+			} else {
+				fmt.Println("  sharp")
+			}
+		}
+	}
+}
+
+// measureFocusFile returns the focus measure of the image at the given path.
+func measureFocusFile(path string, workers int) (FocusMeasure, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FocusMeasure{}, err
+	}
+	defer file.Close() // opened for reading, no error checking needed
+	pixels, err := getPixelArray(file, "")
+	if err != nil {
+		return FocusMeasure{}, err
+	}
+	return MeasureFocus(convertSamples[float64](pixelsToSamples(pixels)), workers), nil
+}
+
+// MeasureFocus returns the focus measure of the given samples, the rows are processed by the given number of workers.
+func MeasureFocus(samples [][]float64, workers int) FocusMeasure {
+	response := laplacian(samples, workers)
+	magnitudes, _ := sobel(samples, nil, workers)
+	var measure FocusMeasure
+	sum, sumSquares, count := 0.0, 0.0, 0.0
+	for y := range response {
+		for x, value := range response[y] {
+			sum += value
+			sumSquares += value * value
+			measure.MeanGradient += magnitudes[y][x]
+			count++
+		}
+	}
+	mean := sum / count
+	measure.LaplacianVariance = sumSquares/count - mean*mean
+	measure.MeanGradient /= count
+	return measure
+}
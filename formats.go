@@ -0,0 +1,41 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+// FormatOptions are the settings of the decoders of scientific, high dynamic range and camera RAW images, which are
+// left out of builds with the edgeefy_minimal tag.
+type FormatOptions struct {
+	WindowCenter float64 // window center of DICOM images, zero takes it from the file
+	WindowWidth  float64 // window width of DICOM images, zero takes it from the file
+	FITSScale    string  // scaling of FITS images: linear, log or zscale
+	ToneMap      string  // tone-mapping of Radiance HDR and OpenEXR images: reinhard or drago
+	Dcraw        bool    // develop camera RAW images with dcraw if installed instead of decoding the embedded preview
+}
+
+// DEFAULT_FORMAT_OPTIONS are the settings of the built-in decoders.
+var DEFAULT_FORMAT_OPTIONS = FormatOptions{FITSScale: "linear", ToneMap: "reinhard"}
+
+// isValidFitsScale checks whether the given name denotes a supported FITS scaling method.
+func isValidFitsScale(scale string) bool {
+	return scale == "linear" || scale == "log" || scale == "zscale"
+}
+
+// isValidToneMap checks whether the given name denotes a supported tone-mapping operator.
+func isValidToneMap(operator string) bool {
+	return operator == "reinhard" || operator == "drago"
+}
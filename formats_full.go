@@ -0,0 +1,40 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"image"
+	"io"
+)
+
+// formatDecoders returns the decoders of DICOM, FITS, camera RAW and high dynamic range images with the given
+// settings.
+func formatDecoders(options FormatOptions) []Decoder {
+	decoders := []Decoder{
+		{"dicom", DICOM_MAGIC, func(r io.Reader) (image.Image, error) {
+			return decodeDICOM(r, options.WindowCenter, options.WindowWidth)
+		}, decodeDICOMConfig},
+		{"fits", FITS_MAGIC, func(r io.Reader) (image.Image, error) {
+			return decodeFITS(r, options.FITSScale)
+		}, decodeFITSConfig},
+	}
+	decoders = append(decoders, cameraRAWDecoders(options.Dcraw)...)
+	return append(decoders, hdrDecoders(options.ToneMap)...)
+}
@@ -0,0 +1,30 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build edgeefy_minimal
+
+package edgeefy
+
+// formatDecoders returns no decoders, minimal builds only read the formats of the standard library and PNM images.
+func formatDecoders(options FormatOptions) []Decoder {
+	return nil
+}
+
+// openHDR returns nil, minimal builds don't read high dynamic range images.
+func openHDR(path string, operator string) [][]float64 {
+	return nil
+}
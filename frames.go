@@ -0,0 +1,351 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// PNG_SIGNATURE is the byte sequence every PNG (and APNG) file starts with.
+const PNG_SIGNATURE = "\x89PNG\r\n\x1a\n"
+
+// Frame is a single image of a multi-frame input together with its display duration in hundredths of a second and the
+// time in seconds at which it is shown. The pixels of frames returned by openFrames are nil until they are decoded.
+type Frame struct {
+	Pixels [][]GrayPixel
+	Delay  int
+	Time   float64
+}
+
+// FrameSelection selects the frames of a multi-frame input that are processed.
+type FrameSelection struct {
+	Start    float64 // time in seconds at which the selected range starts
+	Duration float64 // length in seconds of the selected range, zero selects everything up to the end
+	Every    int     // only every n-th frame of the range is selected
+}
+
+// ALL_FRAMES selects every frame of a multi-frame input.
+var ALL_FRAMES = FrameSelection{0, 0, 1}
+
+// isValidFramesMode checks whether the given name denotes a supported way of writing multi-frame results.
+func isValidFramesMode(mode string) bool {
+	return mode == "separate" || mode == "apng" || mode == "tiff"
+}
+
+// openFrames opens the file given by a path string and returns its selected frames if it is an animated GIF together
+// with the loop count of the animation and a function that decodes the pixels of a frame. For files that are no GIF
+// images nil is returned.
+func openFrames(path string, selection FrameSelection) ([]Frame, int, func(i int) [][]GrayPixel) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close() // opened for reading, no error checking needed
+
+	// only GIF images can hold several frames, leave everything else to the single image path
+	reader := bufio.NewReader(file)
+	magic, err := reader.Peek(6)
+	if err != nil || (string(magic) != "GIF87a" && string(magic) != "GIF89a") {
+		return nil, 0, nil
+	}
+	animation, err := gif.DecodeAll(reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	frames, source := getGIFFrames(animation, selection)
+
+	return frames, animation.LoopCount, source.decode
+}
+
+// gifFrameSource composes the frames of an animated GIF onto the full canvas one after another. As GIF frames may only
+// cover a part of the canvas and depend on the disposal of their predecessors, every frame up to a selected one has to
+// be composed, but only the selected ones are converted to grayscale.
+type gifFrameSource struct {
+	animation *gif.GIF
+	selected  []int // indices of the selected frames among the frames of the animation
+	canvas    *image.RGBA
+	previous  *image.RGBA
+	next      int // index of the next frame of the animation to compose
+}
+
+// getGIFFrames returns the timing of the selected frames of an animated GIF and the source their pixels are decoded
+// from. The delays of skipped frames within the selected range are added to the preceding selected frame so the timing
+// of the animation is kept.
+func getGIFFrames(animation *gif.GIF, selection FrameSelection) ([]Frame, *gifFrameSource) {
+	bounds := image.Rect(0, 0, animation.Config.Width, animation.Config.Height)
+	source := &gifFrameSource{animation: animation, canvas: image.NewRGBA(bounds), previous: image.NewRGBA(bounds)}
+	frames := []Frame{}
+	elapsed, rangeIndex := 0, 0 // time in hundredths of a second before the current frame, index within the range
+	for i := range animation.Image {
+		time := float64(elapsed) / 100
+		if selection.Duration > 0 && time >= selection.Start+selection.Duration {
+			break
+		}
+		elapsed += animation.Delay[i]
+		if time >= selection.Start {
+			if rangeIndex%selection.Every == 0 {
+				frames = append(frames, Frame{nil, animation.Delay[i], time})
+				source.selected = append(source.selected, i)
+			} else {
+				frames[len(frames)-1].Delay += animation.Delay[i]
+			}
+			rangeIndex++
+		}
+	}
+
+	return frames, source
+}
+
+// decode returns the gray values of the selected frame with the given index. Frames have to be decoded in order, as
+// every frame is composed onto the canvas left by its predecessors.
+func (s *gifFrameSource) decode(frame int) [][]GrayPixel {
+	for ; s.next <= s.selected[frame]; s.next++ {
+		img := s.animation.Image[s.next]
+		disposal := byte(gif.DisposalNone)
+		if s.next < len(s.animation.Disposal) {
+			disposal = s.animation.Disposal[s.next]
+		}
+		if disposal == gif.DisposalPrevious {
+			copy(s.previous.Pix, s.canvas.Pix)
+		}
+		draw.Draw(s.canvas, img.Bounds(), img, img.Bounds().Min, draw.Over)
+		if s.next == s.selected[frame] {
+			pixels := imageToPixelArray(s.canvas)
+			s.dispose(img, disposal)
+			s.next++
+			return pixels
+		}
+		s.dispose(img, disposal)
+	}
+	panic("frames of an animation must be decoded in order")
+}
+
+// dispose prepares the canvas for the frame following the given one according to its disposal method.
+func (s *gifFrameSource) dispose(img image.Image, disposal byte) {
+	switch disposal {
+	case gif.DisposalBackground:
+		draw.Draw(s.canvas, img.Bounds(), image.Transparent, image.Point{}, draw.Src)
+	case gif.DisposalPrevious:
+		copy(s.canvas.Pix, s.previous.Pix)
+	}
+}
+
+// writeFrames writes the given frames to disc. In separate mode every frame is written to its own file whose name is
+// derived from the path string by appending the frame number. In apng mode all frames are written to a single animated
+// PNG at the given path, in tiff mode to the pages of a multi-page TIFF file in the order of the frames. TIFF has no
+// timing, so the delays are only kept by APNG.
+func writeFrames(frames []Frame, loopCount int, path string, mode string) {
+	if mode == "separate" {
+		for i, frame := range frames {
+			writeImage(frame.Pixels, framePath(path, i))
+		}
+		return
+	}
+
+	outFile, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer outFile.Close()
+	if mode == "tiff" {
+		err = writeMultiPageTIFF(outFile, frameLayers(frames))
+	} else {
+		err = writeAPNG(outFile, frames, loopCount)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// frameLayers returns the given frames as 8-bit pages of a multi-page TIFF file named after their frame number.
+func frameLayers(frames []Frame) []TIFFLayer {
+	layers := make([]TIFFLayer, len(frames))
+	for i, frame := range frames {
+		values := make([][]float64, len(frame.Pixels))
+		for y := range frame.Pixels {
+			values[y] = make([]float64, len(frame.Pixels[y]))
+			for x, pixel := range frame.Pixels[y] {
+				values[y][x] = float64(pixel.y)
+			}
+		}
+		layers[i] = TIFFLayer{fmt.Sprintf("frame %04d", i), values, false}
+	}
+	return layers
+}
+
+// processFrames calls process for the frames with indices 0 to count-1, running up to the given number of frames
+// concurrently, and calls emit for every frame in order as soon as it and all its predecessors are processed. A frame
+// only starts once fewer than that number of frames are processed or waiting to be emitted, which bounds the memory
+// held by intermediate results. Before a frame starts, decode is called for it, in order and only once the frame has
+// a slot, so the input isn't held for frames that wait.
+func processFrames(count, concurrency int, decode func(i int), process func(i int), emit func(i int)) {
+	if concurrency < 1 {
+		concurrency = runtime.NumCPU()
+	}
+	slots := make(chan struct{}, concurrency)
+	done := make([]chan struct{}, count)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	go func() {
+		for i := 0; i < count; i++ {
+			slots <- struct{}{}
+			decode(i)
+			go func(i int) {
+				process(i)
+				close(done[i])
+			}(i)
+		}
+	}()
+	for i := 0; i < count; i++ {
+		<-done[i]
+		emit(i)
+		<-slots
+	}
+}
+
+// framePath returns the path of the file for the frame with the given index, e.g. out_0003.jpg for out.jpg.
+func framePath(path string, index int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s_%04d%s", strings.TrimSuffix(path, ext), index, ext)
+}
+
+// writeAPNG writes the given frames as animated PNG. The delays of the frames are kept and the loop count follows the
+// GIF convention: 0 loops forever, -1 plays the animation once and n repeats it n times.
+func writeAPNG(w io.Writer, frames []Frame, loopCount int) error {
+	if len(frames) == 0 {
+		return errors.New("apng: no frames to write")
+	}
+	plays := uint32(0)
+	if loopCount < 0 {
+		plays = 1
+	} else if loopCount > 0 {
+		plays = uint32(loopCount + 1)
+	}
+
+	if _, err := io.WriteString(w, PNG_SIGNATURE); err != nil {
+		return err
+	}
+	sequence := uint32(0)
+	for i, frame := range frames {
+		// let the png package do the encoding and reuse its chunks
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, getImageFromArray(frame.Pixels)); err != nil {
+			return err
+		}
+		chunks, err := readPNGChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			if err := writePNGChunk(w, "IHDR", chunks["IHDR"][0]); err != nil {
+				return err
+			}
+			acTL := make([]byte, 8)
+			binary.BigEndian.PutUint32(acTL[0:], uint32(len(frames)))
+			binary.BigEndian.PutUint32(acTL[4:], plays)
+			if err := writePNGChunk(w, "acTL", acTL); err != nil {
+				return err
+			}
+		}
+
+		// frame control chunk: full canvas frames, delay in hundredths of a second
+		fcTL := make([]byte, 26)
+		binary.BigEndian.PutUint32(fcTL[0:], sequence)
+		binary.BigEndian.PutUint32(fcTL[4:], uint32(len(frame.Pixels[0])))
+		binary.BigEndian.PutUint32(fcTL[8:], uint32(len(frame.Pixels)))
+		binary.BigEndian.PutUint16(fcTL[20:], uint16(frame.Delay))
+		binary.BigEndian.PutUint16(fcTL[22:], 100)
+		sequence++
+		if err := writePNGChunk(w, "fcTL", fcTL); err != nil {
+			return err
+		}
+
+		// the first frame is the default image, all other frames are stored in frame data chunks
+		for _, data := range chunks["IDAT"] {
+			if i == 0 {
+				err = writePNGChunk(w, "IDAT", data)
+			} else {
+				fdAT := make([]byte, 4, 4+len(data))
+				binary.BigEndian.PutUint32(fdAT, sequence)
+				sequence++
+				err = writePNGChunk(w, "fdAT", append(fdAT, data...))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return writePNGChunk(w, "IEND", nil)
+}
+
+// readPNGChunks splits an encoded PNG image into its chunks and returns the chunk data grouped by chunk type.
+func readPNGChunks(data []byte) (map[string][][]byte, error) {
+	if !bytes.HasPrefix(data, []byte(PNG_SIGNATURE)) {
+		return nil, errors.New("png: invalid signature")
+	}
+	chunks := make(map[string][][]byte)
+	for pos := len(PNG_SIGNATURE); pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		if pos+12+length > len(data) {
+			return nil, errors.New("png: truncated chunk")
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		chunks[chunkType] = append(chunks[chunkType], data[pos+8:pos+8+length])
+		pos += 12 + length
+	}
+
+	return chunks, nil
+}
+
+// writePNGChunk writes a single PNG chunk of the given type including length and checksum.
+func writePNGChunk(w io.Writer, chunkType string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], chunkType)
+	checksum := crc32.NewIEEE()
+	checksum.Write(header[4:])
+	checksum.Write(data)
+	footer := make([]byte, 4)
+	binary.BigEndian.PutUint32(footer, checksum.Sum32())
+
+	for _, part := range [][]byte{header, data, footer} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
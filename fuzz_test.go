@@ -0,0 +1,147 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bytes"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// FUZZ_MAX_DIMENSION is the largest width and height the fuzz targets let decoders allocate pixels for. Decoders have
+// to reject larger images before allocating them, whatever dimensions the header announces.
+const FUZZ_MAX_DIMENSION = 1 << 10
+
+// fuzzImages returns small images in the formats the standard library can encode and the headers of other formats as
+// seeds of the decoder targets. The headers are given literally, as minimal builds leave out their decoders.
+func fuzzImages(f *testing.F) [][]byte {
+	img := getImageFromArray(testPixels(16, 12))
+	var pngData, jpegData, gifData bytes.Buffer
+	if err := png.Encode(&pngData, img); err != nil {
+		f.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegData, img, nil); err != nil {
+		f.Fatal(err)
+	}
+	if err := gif.Encode(&gifData, img, nil); err != nil {
+		f.Fatal(err)
+	}
+	return [][]byte{
+		pngData.Bytes(), jpegData.Bytes(), gifData.Bytes(),
+		[]byte("P5\n4 2\n255\n\x00\x40\x80\xff\xff\x80\x40\x00"),
+		[]byte("P6 1 1 255 \xff\x00\x00"),
+		[]byte("SIMPLE  =                    T"),
+		[]byte("#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y 1 +X 1\n\x80\x80\x80\x81"),
+		[]byte("II*\x00\x08\x00\x00\x00\x00\x00"),
+		[]byte("\x76\x2f\x31\x01"),
+		append(make([]byte, 128), "DICM"...),
+	}
+}
+
+// FuzzGetPixelArray decodes arbitrary bytes with the registered decoders. Decoding must not panic, images beyond the
+// maximum dimension must be rejected and images that decode must have the size their header announces.
+func FuzzGetPixelArray(f *testing.F) {
+	for _, data := range fuzzImages(f) {
+		f.Add(data)
+	}
+	f.Add([]byte("P5\n100000 100000\n255\n\x00"))
+	f.Add([]byte("#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y 2000 +X 2000\n\x80\x80\x80\x81"))
+	setDecodeMaxDimension(FUZZ_MAX_DIMENSION)
+	f.Cleanup(func() { setDecodeMaxDimension(DECODE_MAX_DIMENSION) })
+	f.Fuzz(func(t *testing.T, data []byte) {
+		config, _, err := DecodeImageConfig(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		pixels, err := getPixelArray(bytes.NewReader(data), "")
+		if config.Width > FUZZ_MAX_DIMENSION || config.Height > FUZZ_MAX_DIMENSION {
+			if err == nil {
+				t.Errorf("decoded image of %dx%d beyond the maximum dimension", config.Width, config.Height)
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+		if len(pixels) != config.Height || (len(pixels) > 0 && len(pixels[0]) != config.Width) {
+			t.Errorf("decoded image of %dx%d, header announced %dx%d", len(pixels[0]), len(pixels), config.Width, config.Height)
+		}
+	})
+}
+
+// FuzzDecodeRaw decodes arbitrary bytes as raw frame of an arbitrary format. The frame buffer must not grow beyond
+// the data that is actually read, however large the announced dimensions are.
+func FuzzDecodeRaw(f *testing.F) {
+	f.Add("4x2:gray8", []byte("\x00\x40\x80\xff\xff\x80\x40\x00"))
+	f.Add("2x2:gray16", []byte("\x00\x00\xff\xff\x00\x80\x80\x00"))
+	f.Add("100000x100000:rgb24", []byte("\xff\x00\x00"))
+	f.Fuzz(func(t *testing.T, format string, data []byte) {
+		if parsed, err := parseRawFormat(format); err == nil && parsed.width*parsed.height > FUZZ_MAX_DIMENSION*FUZZ_MAX_DIMENSION {
+			// the frame can't be complete, it must fail without allocating the whole frame
+			if _, err := getPixelArray(bytes.NewReader(data), format); err == nil {
+				t.Errorf("decoded incomplete frame of format %s from %d bytes", format, len(data))
+			}
+			return
+		}
+		getPixelArray(bytes.NewReader(data), format)
+	})
+}
+
+// FuzzDetect runs detections of arbitrary images with arbitrary parameters. Detections of parameters that pass the
+// validation must not panic and must keep the size of the image.
+func FuzzDetect(f *testing.F) {
+	f.Add(uint8(16), []byte("\x00\x40\x80\xff\xff\x80\x40\x00\x10\x20\x30\x40\x50\x60\x70\x80"), 0.2, 0.6, 0.0, true, uint8(GAUSSIAN), 0, 0.0, 1.0)
+	f.Add(uint8(3), []byte("\xff\x00\xff\x00\xff\x00\xff\x00\xff"), 0.0, 1.0, 2.5, true, uint8(BOX), 2, 3.0, 0.5)
+	f.Fuzz(func(t *testing.T, width uint8, data []byte, minRatio, maxRatio, sigma float64, blur bool, filter uint8,
+		despeckle int, bridgeDistance, percentile float64) {
+		if width == 0 || len(data) < int(width) {
+			return
+		}
+		height := min(len(data)/int(width), 256)
+		pixels := make([][]GrayPixel, height)
+		for y := range pixels {
+			pixels[y] = make([]GrayPixel, width)
+			for x := range pixels[y] {
+				pixels[y][x] = GrayPixel{data[y*int(width)+x], 255}
+			}
+		}
+
+		detector := NewDetector(blur, minRatio, maxRatio)
+		detector.Sigma = sigma
+		detector.BlurFilter = BlurFilter(filter % 2)
+		detector.Despeckle = despeckle
+		detector.BridgeDistance = bridgeDistance
+		detector.Percentile = percentile
+		detector.Workers = 2
+		for _, issue := range detector.Validate(int(width), height, nil) {
+			if issue.Severity == ERROR {
+				return
+			}
+		}
+		// keep the runtime of an iteration bounded, large values are accepted but slow by design
+		if sigma > 64 || bridgeDistance > 64 {
+			return
+		}
+		edges := detector.Detect(pixels)
+		if len(edges) != height || len(edges[0]) != int(width) {
+			t.Errorf("edges of %dx%d for image of %dx%d", len(edges[0]), len(edges), width, height)
+		}
+	})
+}
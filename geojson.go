@@ -0,0 +1,151 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WorldFile holds the affine transformation of an ESRI world file that maps pixel positions to map coordinates.
+type WorldFile struct {
+	A, D, B, E, C, F float64 // parameters in the order they appear in the file
+}
+
+// IDENTITY_WORLD_FILE maps pixel positions to map coordinates unchanged.
+var IDENTITY_WORLD_FILE = WorldFile{A: 1, E: 1}
+
+// Transform returns the map coordinates of the center of the pixel at the given column and row.
+func (w WorldFile) Transform(col, row int) (float64, float64) {
+	x := w.A*float64(col) + w.B*float64(row) + w.C
+	y := w.D*float64(col) + w.E*float64(row) + w.F
+	return x, y
+}
+
+// readWorldFile parses the six lines of a world file.
+func readWorldFile(r io.Reader) (WorldFile, error) {
+	var world WorldFile
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return world, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 6 {
+		return world, errors.New("world file must contain six values")
+	}
+	values := make([]float64, 6)
+	for i := range values {
+		if values[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return world, err
+		}
+	}
+	return WorldFile{values[0], values[1], values[2], values[3], values[4], values[5]}, nil
+}
+
+// openWorldFile reads the world file at the given path. If the path is empty the sidecar world file of the given
+// image is used if it exists, e.g. scan.jgw or scan.jpgw for scan.jpg, or scan.wld. Without any world file the
+// identity transformation is returned so coordinates stay in pixels.
+func openWorldFile(path string, imagePath string) WorldFile {
+	if path == "" {
+		ext := filepath.Ext(imagePath)
+		base := strings.TrimSuffix(imagePath, ext)
+		candidates := []string{base + ".wld", imagePath + "w"}
+		if len(ext) == 4 { // .jpg -> .jgw
+			candidates = append([]string{base + ext[:2] + ext[3:] + "w"}, candidates...)
+		}
+		for _, candidate := range candidates {
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+		}
+		if path == "" {
+			return IDENTITY_WORLD_FILE
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close() // opened for reading, no error checking needed
+	world, err := readWorldFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return world
+}
+
+// geoJSONFeature is a single feature of a GeoJSON feature collection.
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// geoJSONGeometry is the geometry of a GeoJSON feature.
+type geoJSONGeometry struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+// writeContoursGeoJSON writes the given contours as GeoJSON feature collection of LineStrings. The pixel positions are
+// transformed to map coordinates by the given world file. Closed contours repeat their first position at the end.
+func writeContoursGeoJSON(w io.Writer, contours []Contour, world WorldFile) error {
+	features := []geoJSONFeature{}
+	for _, contour := range contours {
+		points := contour.Points
+		if contour.Closed && len(points) > 0 {
+			points = append(append(points[:0:0], points...), points[0])
+		}
+		if len(points) < 2 {
+			continue // a LineString needs two positions
+		}
+		var coordinates [][2]float64
+		for _, p := range points {
+			x, y := world.Transform(p.X, p.Y)
+			coordinates = append(coordinates, [2]float64{x, y})
+		}
+		features = append(features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   geoJSONGeometry{"LineString", coordinates},
+			Properties: map[string]interface{}{"closed": contour.Closed},
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(map[string]interface{}{"type": "FeatureCollection", "features": features})
+}
+
+// writeGeoJSON writes the given contours as GeoJSON to the file at the given path.
+func writeGeoJSON(contours []Contour, world WorldFile, path string) {
+	outFile, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer outFile.Close()
+	if err := writeContoursGeoJSON(outFile, contours, world); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/slaufmann/edgeefy
+
+go 1.22
+
+require (
+	github.com/deckarep/golang-set v1.8.0
+	go.etcd.io/bbolt v1.3.10
+	gonum.org/v1/gonum v0.12.0
+)
+
+require golang.org/x/sys v0.9.0 // indirect
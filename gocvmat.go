@@ -0,0 +1,87 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build gocv
+
+package edgeefy
+
+import (
+	"errors"
+	"image/color"
+
+	"gocv.io/x/gocv"
+)
+
+// MatToPixels converts the given gocv.Mat to a two-dimensional array of GrayPixel objects. Single channel matrices
+// are taken over as gray values, three and four channel matrices are read in OpenCV's BGR and BGRA order and converted
+// to gray like decoded images. Only 8-bit matrices are supported. The data of the matrix is read directly without
+// encoding it as image first.
+func MatToPixels(m gocv.Mat) ([][]GrayPixel, error) {
+	channels := m.Channels()
+	if m.Type() != gocv.MatTypeCV8UC1 && m.Type() != gocv.MatTypeCV8UC3 && m.Type() != gocv.MatTypeCV8UC4 {
+		return nil, errors.New("only 8-bit matrices with one, three or four channels are supported")
+	}
+	data, err := m.DataPtrUint8()
+	if err != nil {
+		return nil, err // not continuous in memory
+	}
+
+	width := m.Cols()
+	pixels := make([][]GrayPixel, m.Rows())
+	for y := range pixels {
+		pixels[y] = make([]GrayPixel, width)
+		for x := range pixels[y] {
+			i := (y*width + x) * channels
+			switch channels {
+			case 1:
+				pixels[y][x] = GrayPixel{data[i], 255}
+			case 3:
+				pixels[y][x] = rgbaToGrayPixel(color.RGBA{data[i+2], data[i+1], data[i], 255})
+			default:
+				pixels[y][x] = rgbaToGrayPixel(color.NRGBA{data[i+2], data[i+1], data[i], data[i+3]})
+			}
+		}
+	}
+	return pixels, nil
+}
+
+// PixelsToMat converts the given pixels to a single channel 8-bit gocv.Mat holding the gray values. The caller is
+// responsible for closing the returned matrix.
+func PixelsToMat(pixels [][]GrayPixel) (gocv.Mat, error) {
+	return gocv.NewMatFromBytes(len(pixels), len(pixels[0]), gocv.MatTypeCV8UC1, pixelsToBytes(pixels))
+}
+
+// pixelsToBytes returns the gray values of the given pixels row by row.
+func pixelsToBytes(pixels [][]GrayPixel) []byte {
+	data := make([]byte, 0, len(pixels)*len(pixels[0]))
+	for y := range pixels {
+		for x := range pixels[y] {
+			data = append(data, pixels[y][x].y)
+		}
+	}
+	return data
+}
+
+// DetectMat performs canny edge detection with the given detector on a gocv.Mat and returns the edge image as single
+// channel 8-bit matrix, which the caller has to close.
+func (d *Detector) DetectMat(m gocv.Mat) (gocv.Mat, error) {
+	pixels, err := MatToPixels(m)
+	if err != nil {
+		return gocv.NewMat(), err
+	}
+	return PixelsToMat(d.Detect(pixels))
+}
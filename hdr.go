@@ -0,0 +1,270 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+)
+
+// magic bytes of high dynamic range formats
+const (
+	RADIANCE_MAGIC = "#?"
+	EXR_MAGIC      = "\x76\x2f\x31\x01"
+)
+
+// parameters of the tone-mapping operators
+const (
+	REINHARD_KEY  = 0.18 // key value the log-average luminance is mapped to
+	DRAGO_BIAS    = 0.85 // bias of the logarithmic base, lower values compress highlights more
+	HDR_LOG_DELTA = 1e-6 // offset that avoids the logarithm of zero luminance
+)
+
+// hdrDecoders returns the decoders for Radiance HDR and OpenEXR files. The luminance of the images is tone-mapped by
+// the given operator and returned as 16-bit grayscale image.
+func hdrDecoders(operator string) []Decoder {
+	decoder := func(read func(io.Reader) ([][]float64, error)) func(io.Reader) (image.Image, error) {
+		return func(r io.Reader) (image.Image, error) {
+			luminance, err := read(r)
+			if err != nil {
+				return nil, err
+			}
+			return toneMappedImage(toneMap(luminance, operator)), nil
+		}
+	}
+	return []Decoder{
+		{"hdr", RADIANCE_MAGIC, decoder(decodeRadiance), decodeRadianceConfig},
+		{"exr", EXR_MAGIC, decoder(decodeEXR), decodeEXRConfig},
+	}
+}
+
+// openHDR reads the luminance of the image at the given path if it is a Radiance HDR or OpenEXR file and returns it
+// tone-mapped by the given operator with values from 0 to 1. For other files nil is returned.
+func openHDR(path string, operator string) [][]float64 {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close() // opened for reading, no error checking needed
+
+	reader := bufio.NewReader(file)
+	var luminance [][]float64
+	if magic, _ := reader.Peek(len(EXR_MAGIC)); string(magic) == EXR_MAGIC {
+		luminance, err = decodeEXR(reader)
+	} else if magic, _ := reader.Peek(len(RADIANCE_MAGIC)); string(magic) == RADIANCE_MAGIC {
+		luminance, err = decodeRadiance(reader)
+	} else {
+		return nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return toneMap(luminance, operator)
+}
+
+// toneMappedImage returns the given tone-mapped luminance with values from 0 to 1 as 16-bit grayscale image.
+func toneMappedImage(values [][]float64) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, len(values[0]), len(values)))
+	for y := range values {
+		for x, value := range values[y] {
+			img.SetGray16(x, y, color.Gray16{uint16(math.Round(math.Min(math.Max(value, 0), 1) * math.MaxUint16))})
+		}
+	}
+	return img
+}
+
+// toneMap compresses the given scene luminance to display values from 0 to 1. Both operators are global: reinhard
+// maps the log-average luminance to REINHARD_KEY and compresses with L/(1+L), drago compresses logarithmically with a
+// base that adapts to the brightness of every pixel. Negative and non-finite values are treated as zero.
+func toneMap(luminance [][]float64, operator string) [][]float64 {
+	// the log-average luminance describes the brightness the eye adapts to
+	logSum, maximum, count := 0.0, 0.0, 0
+	for y := range luminance {
+		for x, value := range luminance[y] {
+			if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+				luminance[y][x], value = 0, 0
+			}
+			logSum += math.Log(HDR_LOG_DELTA + value)
+			maximum = math.Max(maximum, value)
+			count++
+		}
+	}
+	average := math.Exp(logSum / float64(count))
+	if maximum == 0 {
+		// an all black image has no range to compress, drago would divide by zero
+		operator = "reinhard"
+	}
+
+	result := make([][]float64, len(luminance))
+	for y := range luminance {
+		result[y] = make([]float64, len(luminance[y]))
+		for x, value := range luminance[y] {
+			scaled := value / average
+			if operator == "drago" {
+				scaledMax := maximum / average
+				exponent := math.Log(DRAGO_BIAS) / math.Log(0.5)
+				base := math.Log(2 + 8*math.Pow(scaled/scaledMax, exponent))
+				result[y][x] = math.Log1p(scaled) / base / math.Log10(scaledMax+1)
+			} else {
+				scaled *= REINHARD_KEY
+				result[y][x] = scaled / (1 + scaled)
+			}
+		}
+	}
+	return result
+}
+
+// hdrLuminance returns the luminance of a linear RGB color with the Rec. 709 primaries.
+func hdrLuminance(r, g, b float64) float64 {
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
+
+// radianceHeader holds the properties of a Radiance HDR file that are needed to read the pixels.
+type radianceHeader struct {
+	width, height int
+}
+
+// readRadianceHeader reads the header lines and the resolution line of a Radiance HDR file. Only the standard
+// orientation with rows from top to bottom and columns from left to right is supported.
+func readRadianceHeader(r *bufio.Reader) (radianceHeader, error) {
+	var header radianceHeader
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return header, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return header, errors.New("hdr: unsupported format " + strings.TrimPrefix(line, "FORMAT="))
+		}
+	}
+	resolution, err := r.ReadString('\n')
+	if err != nil {
+		return header, err
+	}
+	if _, err := fmt.Sscanf(resolution, "-Y %d +X %d", &header.height, &header.width); err != nil {
+		return header, errors.New("hdr: unsupported orientation")
+	}
+	if header.width <= 0 || header.height <= 0 {
+		return header, errors.New("hdr: invalid dimensions")
+	}
+	return header, nil
+}
+
+// decodeRadianceConfig returns the dimensions of a Radiance HDR file.
+func decodeRadianceConfig(r io.Reader) (image.Config, error) {
+	header, err := readRadianceHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.Gray16Model, Width: header.width, Height: header.height}, nil
+}
+
+// decodeRadiance reads the pixels of a Radiance HDR file and returns their luminance. Scanlines are either run-length
+// encoded per component or stored as flat RGBE values.
+func decodeRadiance(r io.Reader) ([][]float64, error) {
+	br := bufio.NewReader(r)
+	header, err := readRadianceHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDecodeSize(header.width, header.height); err != nil {
+		return nil, err
+	}
+
+	luminance := make([][]float64, header.height)
+	scanline := make([]byte, 4*header.width)
+	for y := range luminance {
+		if err := readRadianceScanline(br, scanline, header.width); err != nil {
+			return nil, err
+		}
+		luminance[y] = make([]float64, header.width)
+		for x := range luminance[y] {
+			rgbe := scanline[4*x : 4*x+4]
+			if rgbe[3] == 0 {
+				continue
+			}
+			scale := math.Ldexp(1, int(rgbe[3])-(128+8))
+			luminance[y][x] = hdrLuminance(float64(rgbe[0])*scale, float64(rgbe[1])*scale, float64(rgbe[2])*scale)
+		}
+	}
+	return luminance, nil
+}
+
+// readRadianceScanline reads a scanline of RGBE values into the given buffer. Run-length encoded scanlines start with
+// two bytes of value two followed by the width, their four components are encoded one after another.
+func readRadianceScanline(r *bufio.Reader, scanline []byte, width int) error {
+	start, err := r.Peek(4)
+	if err != nil {
+		return err
+	}
+	if width < 8 || width > 0x7fff || start[0] != 2 || start[1] != 2 || int(start[2])<<8|int(start[3]) != width {
+		_, err := io.ReadFull(r, scanline)
+		return err
+	}
+	r.Discard(4)
+	for component := 0; component < 4; component++ {
+		for x := 0; x < width; {
+			count, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if count > 128 {
+				// a run of a single value
+				value, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				for n := 0; n < int(count)-128; n++ {
+					if x >= width {
+						return errors.New("hdr: run exceeds scanline")
+					}
+					scanline[4*x+component] = value
+					x++
+				}
+				continue
+			}
+			// literal values
+			if count == 0 || x+int(count) > width {
+				return errors.New("hdr: invalid run length")
+			}
+			for n := 0; n < int(count); n++ {
+				value, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				scanline[4*x+component] = value
+				x++
+			}
+		}
+	}
+	return nil
+}
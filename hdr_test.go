@@ -0,0 +1,193 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// testRadiance returns a Radiance HDR file of the given RGBE pixels. With rle set the scanlines are run-length encoded
+// per component, runs of at least three equal values as runs and everything else as literals.
+func testRadiance(pixels [][][4]byte, rle bool) []byte {
+	file := fmt.Sprintf("#?RADIANCE\n# test file\nFORMAT=32-bit_rle_rgbe\nEXPOSURE=1.0\n\n-Y %d +X %d\n",
+		len(pixels), len(pixels[0]))
+	data := []byte(file)
+	for _, row := range pixels {
+		if !rle {
+			for _, pixel := range row {
+				data = append(data, pixel[:]...)
+			}
+			continue
+		}
+		data = append(data, 2, 2, uint8(len(row)>>8), uint8(len(row)))
+		for component := 0; component < 4; component++ {
+			for x := 0; x < len(row); {
+				run := 1
+				for x+run < len(row) && row[x+run][component] == row[x][component] && run < 127 {
+					run++
+				}
+				if run >= 3 {
+					data = append(data, uint8(128+run), row[x][component])
+					x += run
+					continue
+				}
+				literal := 1
+				for x+literal < len(row) && literal < 128 && !(x+literal+2 < len(row) &&
+					row[x+literal][component] == row[x+literal+1][component] &&
+					row[x+literal][component] == row[x+literal+2][component]) {
+					literal++
+				}
+				data = append(data, uint8(literal))
+				for _, pixel := range row[x : x+literal] {
+					data = append(data, pixel[component])
+				}
+				x += literal
+			}
+		}
+	}
+	return data
+}
+
+// rgbeLuminance returns the luminance of the given RGBE pixel.
+func rgbeLuminance(pixel [4]byte) float64 {
+	if pixel[3] == 0 {
+		return 0
+	}
+	scale := math.Ldexp(1, int(pixel[3])-136)
+	return hdrLuminance(float64(pixel[0])*scale, float64(pixel[1])*scale, float64(pixel[2])*scale)
+}
+
+// TestDecodeRadiance decodes images of flat and run-length encoded scanlines. Scanlines narrower than eight pixels are
+// always flat.
+func TestDecodeRadiance(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		width, height int
+		rle           bool
+	}{
+		{"flat", 12, 3, false},
+		{"run-length encoded", 12, 3, true},
+		{"long runs", 300, 2, true},
+		{"narrow", 4, 3, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pixels := make([][][4]byte, test.height)
+			for y := range pixels {
+				pixels[y] = make([][4]byte, test.width)
+				for x := range pixels[y] {
+					switch {
+					case x < test.width/3: // runs of all components
+						pixels[y][x] = [4]byte{128, 64, 32, uint8(128 + y)}
+					case x%4 == 0: // black, whatever the mantissas
+						pixels[y][x] = [4]byte{200, 100, 50, 0}
+					default:
+						pixels[y][x] = [4]byte{uint8(x * 17), uint8(255 - x), uint8(x * y), uint8(120 + x%16)}
+					}
+				}
+			}
+			file := testRadiance(pixels, test.rle)
+			if flat := testRadiance(pixels, false); test.rle && len(file) >= len(flat) {
+				t.Fatalf("expected the scanlines to get smaller by run-length encoding")
+			}
+			luminance, err := decodeRadiance(bytes.NewReader(file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y := range pixels {
+				for x, pixel := range pixels[y] {
+					if expected := rgbeLuminance(pixel); luminance[y][x] != expected {
+						t.Fatalf("pixel %d,%d: expected %g, got %g", x, y, expected, luminance[y][x])
+					}
+				}
+			}
+			config, err := decodeRadianceConfig(bytes.NewReader(file))
+			if err != nil || config.Width != test.width || config.Height != test.height {
+				t.Errorf("expected a configuration of %dx%d, got %+v, %v", test.width, test.height, config, err)
+			}
+		})
+	}
+
+	// one in RGBE is the mantissa 128 with the exponent 129
+	luminance, err := decodeRadiance(bytes.NewReader(testRadiance([][][4]byte{{{128, 128, 128, 129}}}, false)))
+	if err != nil || luminance[0][0] != 1 {
+		t.Errorf("expected a luminance of one, got %v, %v", luminance, err)
+	}
+}
+
+// TestDecodeRadianceInvalid checks that files the decoder can't read are rejected instead of being read partially.
+func TestDecodeRadianceInvalid(t *testing.T) {
+	header := "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y 1 +X 8\n"
+	valid := testRadiance([][][4]byte{make([][4]byte, 8)}, true)
+	for _, test := range []struct {
+		name string
+		data string
+		err  string // part of the expected error
+	}{
+		{"xyz format", "#?RADIANCE\nFORMAT=32-bit_rle_xyze\n\n-Y 1 +X 8\n", "unsupported format"},
+		{"flipped", "#?RADIANCE\n\n+Y 1 +X 8\n", "orientation"},
+		{"transposed", "#?RADIANCE\n\n-X 8 +Y 1\n", "orientation"},
+		{"empty", "#?RADIANCE\n\n-Y 0 +X 8\n", "dimensions"},
+		{"oversized", "#?RADIANCE\n\n-Y 100000 +X 100000\n", "exceeds"},
+		{"run beyond the scanline", header + "\x02\x02\x00\x08\x89\x00", "run exceeds"},
+		{"literal beyond the scanline", header + "\x02\x02\x00\x08\x09", "invalid run length"},
+		{"empty literal", header + "\x02\x02\x00\x08\x00", "invalid run length"},
+		{"truncated scanline", string(valid[:len(valid)-1]), "EOF"},
+		{"truncated flat scanline", "#?RADIANCE\n\n-Y 1 +X 2\n\x01\x02\x03\x04\x05", "EOF"},
+		{"truncated header", "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n", "EOF"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeRadiance(strings.NewReader(test.data))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// TestToneMap checks the operators on an image of constant luminance, which reinhard maps to its key and drago to one
+// as it is the maximum, and that invalid values are treated as zero.
+func TestToneMap(t *testing.T) {
+	for _, operator := range []string{"reinhard", "drago"} {
+		luminance := [][]float64{{4, 4}, {4, 4}}
+		mapped := toneMap(luminance, operator)
+		expected := REINHARD_KEY / (1 + REINHARD_KEY)
+		if operator == "drago" {
+			expected = 1
+		}
+		for y := range mapped {
+			for x, value := range mapped[y] {
+				if math.Abs(value-expected) > 1e-6 {
+					t.Errorf("%s: pixel %d,%d: expected %g, got %g", operator, x, y, expected, value)
+				}
+			}
+		}
+
+		mapped = toneMap([][]float64{{math.NaN(), math.Inf(1), -1, 0}}, operator)
+		for x, value := range mapped[0] {
+			if value != 0 {
+				t.Errorf("%s: expected invalid value %d to be mapped to zero, got %g", operator, x, value)
+			}
+		}
+	}
+}
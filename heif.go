@@ -0,0 +1,75 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build heif
+
+package edgeefy
+
+import (
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/strukturag/libheif/go/heif"
+)
+
+// HEIF_BRANDS are the brands in the file type box of HEIF files that are decoded, heic is used by iPhones.
+var HEIF_BRANDS = []string{"heic", "heix", "hevc", "mif1", "msf1"}
+
+func init() {
+	for _, brand := range HEIF_BRANDS {
+		RegisterDecoder(Decoder{"heif-" + brand, "????ftyp" + brand, decodeHEIF, decodeHEIFConfig})
+	}
+}
+
+// openHEIF reads a HEIF file from the given reader and returns the handle of its primary image.
+func openHEIF(r io.Reader) (*heif.ImageHandle, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := heif.NewContext()
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.ReadFromMemory(data); err != nil {
+		return nil, err
+	}
+	return ctx.GetPrimaryImageHandle()
+}
+
+// decodeHEIF decodes the primary image of a HEIF file with libheif.
+func decodeHEIF(r io.Reader) (image.Image, error) {
+	handle, err := openHEIF(r)
+	if err != nil {
+		return nil, err
+	}
+	img, err := handle.DecodeImage(heif.ColorspaceUndefined, heif.ChromaUndefined, nil)
+	if err != nil {
+		return nil, err
+	}
+	return img.GetImage()
+}
+
+// decodeHEIFConfig returns the dimensions of the primary image of a HEIF file.
+func decodeHEIFConfig(r io.Reader) (image.Config, error) {
+	handle, err := openHEIF(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.RGBAModel, Width: handle.GetWidth(), Height: handle.GetHeight()}, nil
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import "math"
+
+// houghAccumulator performs the hough transform of the given edge image for the given line angles in degrees. Lines
+// are described by their normal form rho = x*cos(theta) + y*sin(theta), so an angle of 90 degrees denotes horizontal
+// lines. For every angle the votes of the edge pixels are counted per distance rho in steps of one pixel, the distances
+// are offset by the length of the image diagonal so that all indices are positive.
+func houghAccumulator[T Sample](edges [][]T, thetas []float64, workers int) [][]int {
+	diagonal := int(math.Ceil(math.Hypot(float64(len(edges)), float64(len(edges[0])))))
+	accumulator := make([][]int, len(thetas))
+	parallelRows(len(thetas), workers, func(i int) {
+		votes := make([]int, 2*diagonal+1)
+		sin, cos := math.Sincos(thetas[i] * math.Pi / 180)
+		for y := range edges {
+			for x := range edges[y] {
+				if edges[y][x] > 0 {
+					rho := int(math.Round(float64(x)*cos + float64(y)*sin))
+					votes[rho+diagonal]++
+				}
+			}
+		}
+		accumulator[i] = votes
+	})
+	return accumulator
+}
+
+// dominantLineAngle returns the angle in degrees among the given ones along which the edges of the image are aligned
+// best. An angle scores by the sum of the squared votes of its accumulator row, so angles at which many edge pixels
+// fall onto few lines are preferred over angles with the same number of votes spread across many lines. If the image
+// has no edges no angle scores and false is returned.
+func dominantLineAngle[T Sample](edges [][]T, thetas []float64, workers int) (float64, bool) {
+	accumulator := houghAccumulator(edges, thetas, workers)
+	best := 0
+	bestScore := -1.0
+	for i, votes := range accumulator {
+		score := 0.0
+		for _, v := range votes {
+			score += float64(v) * float64(v)
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if bestScore <= 0 {
+		return 0, false
+	}
+	return thetas[best], true
+}
@@ -0,0 +1,178 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+)
+
+// INSPECT_BYTES_PER_PIXEL is a rough estimate of the memory in bytes that the detection holds per pixel of an image
+// with 8-bit samples, including the decoded image, the intermediate results and the gradient directions.
+const INSPECT_BYTES_PER_PIXEL = 24
+
+// EXIF_HEADER_SIZE is the number of bytes at the start of a file that are searched for EXIF data.
+const EXIF_HEADER_SIZE = 1 << 16
+
+// TAG_ORIENTATION is the TIFF tag that holds the EXIF orientation of an image.
+const TAG_ORIENTATION = 0x0112
+
+// inspectCommand implements the inspect subcommand, which reports the properties of the given image files that matter
+// for processing them without decoding the image data.
+func inspectCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	maxDimensionArgPtr := flags.Int("max-dimension", 8000, "maximum width and height images are checked against (optional, default: 8000)")
+	return flags, func() {
+		if flags.NArg() == 0 {
+			fmt.Println("No path to input file specified, nothing to do.")
+			return
+		}
+		if *maxDimensionArgPtr <= 0 {
+			fmt.Println("Invalid value for maximum dimension given, exiting.")
+			return
+		}
+
+		for _, path := range flags.Args() {
+			fmt.Println(path)
+			if err := inspectImage(os.Stdout, path, *maxDimensionArgPtr); err != nil {
+				fmt.Printf("  error: %v\n", err)
+			}
+		}
+	}
+}
+
+// inspectImage writes the properties of the image at the given path to the given writer.
+func inspectImage(w io.Writer, path string, maxDimension int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close() // opened for reading, no error checking needed
+	header, err := io.ReadAll(io.LimitReader(file, EXIF_HEADER_SIZE))
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	config, format, err := DecodeImageConfig(file)
+	if err != nil {
+		return err
+	}
+
+	model, depth := describeColorModel(config.ColorModel)
+	pixels := config.Width * config.Height
+	fmt.Fprintf(w, "  format: %s\n", format)
+	fmt.Fprintf(w, "  dimensions: %dx%d (%.1f megapixels)\n", config.Width, config.Height, float64(pixels)/1e6)
+	fmt.Fprintf(w, "  color model: %s\n", model)
+	fmt.Fprintf(w, "  bit depth: %d\n", depth)
+	if orientation := exifOrientation(header); orientation > 0 {
+		fmt.Fprintf(w, "  exif orientation: %d\n", orientation)
+	} else {
+		fmt.Fprintln(w, "  exif orientation: none")
+	}
+	fmt.Fprintf(w, "  estimated memory: %.1f MB\n", float64(pixels*INSPECT_BYTES_PER_PIXEL)/(1<<20))
+	if config.Width > maxDimension || config.Height > maxDimension {
+		fmt.Fprintf(w, "  exceeds maximum dimension of %d\n", maxDimension)
+	} else {
+		fmt.Fprintln(w, "  within limits")
+	}
+	return nil
+}
+
+// describeColorModel returns the name of the given color model together with the number of bits per sample.
+func describeColorModel(model color.Model) (string, int) {
+	switch model {
+	case color.GrayModel:
+		return "gray", 8
+	case color.Gray16Model:
+		return "gray16", 16
+	case color.RGBAModel:
+		return "rgba", 8
+	case color.RGBA64Model:
+		return "rgba64", 16
+	case color.NRGBAModel:
+		return "nrgba", 8
+	case color.NRGBA64Model:
+		return "nrgba64", 16
+	case color.YCbCrModel:
+		return "ycbcr", 8
+	case color.CMYKModel:
+		return "cmyk", 8
+	}
+	if palette, ok := model.(color.Palette); ok {
+		if len(palette) == 0 {
+			return "paletted", 8 // e.g. GIF images with local color tables only
+		}
+		return fmt.Sprintf("paletted (%d colors)", len(palette)), 8
+	}
+	return "unknown", 0
+}
+
+// exifOrientation returns the EXIF orientation stored in the APP1 segment of the given JPEG data, or zero if there is
+// none.
+func exifOrientation(data []byte) int {
+	if !bytes.HasPrefix(data, []byte("\xff\xd8")) {
+		return 0
+	}
+	// walk the marker segments up to the start of the image data
+	for offset := 2; offset+4 <= len(data) && data[offset] == 0xff; {
+		marker := data[offset+1]
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if marker == 0xda || offset+2+length > len(data) {
+			break
+		}
+		segment := data[offset+4 : offset+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		offset += 2 + length
+	}
+	return 0
+}
+
+// tiffOrientation returns the orientation tag of the first image file directory of the given TIFF data, or zero if it
+// is missing.
+func tiffOrientation(data []byte) int {
+	if len(data) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if string(data[:2]) == "MM" {
+		order = binary.BigEndian
+	}
+	offset := int(order.Uint32(data[4:]))
+	if offset+2 > len(data) {
+		return 0
+	}
+	count := int(order.Uint16(data[offset:]))
+	for i := 0; i < count && offset+2+12*(i+1) <= len(data); i++ {
+		entry := data[offset+2+12*i:]
+		if order.Uint16(entry) == TAG_ORIENTATION {
+			if values := readTIFFValues(data, entry, order); len(values) == 1 {
+				return int(values[0])
+			}
+		}
+	}
+	return 0
+}
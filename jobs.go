@@ -0,0 +1,452 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// JOB_CALLBACK_TIMEOUT is the time a webhook callback may take before it is given up.
+const JOB_CALLBACK_TIMEOUT = 10 * time.Second
+
+// JOB_RETRY_BACKOFF is the delay before the first retry of a failed job, it doubles with every further attempt up to
+// JOB_RETRY_MAX_BACKOFF.
+const JOB_RETRY_BACKOFF = time.Second
+
+// JOB_RETRY_MAX_BACKOFF is the longest delay before a retry of a failed job.
+const JOB_RETRY_MAX_BACKOFF = 5 * time.Minute
+
+// JOB_EXPIRY_INTERVAL is the longest time between two checks for finished jobs whose results expired.
+const JOB_EXPIRY_INTERVAL = time.Minute
+
+// ErrQueueFull is returned for jobs submitted while the queue holds as many jobs or bytes as it can.
+var ErrQueueFull = errors.New("job queue is full")
+
+// enumeration of the states of a job
+const (
+	JOB_QUEUED  = "queued"
+	JOB_RUNNING = "running"
+	JOB_DONE    = "done"
+	JOB_FAILED  = "failed"
+)
+
+// Job is the asynchronous detection of an uploaded image with a session. Its fields are guarded by the mutex of the
+// queue it belongs to.
+type Job struct {
+	ID       string
+	Session  *Session
+	Callback string // URL the description of the job is posted to when it is finished, empty for none
+	Status   string
+	Error    string // error of the last attempt
+	Created  time.Time
+	Finished time.Time
+	Attempts int       // number of times the job was started
+	RetryAt  time.Time // time a failed job is retried at, zero unless it waits for a retry
+
+	data   []byte // uploaded image, released once the job is finished
+	result []byte // edge image encoded as PNG
+}
+
+// JobInfo describes a job in the responses of the server and the webhook callbacks.
+type JobInfo struct {
+	ID       string     `json:"id"`
+	Session  string     `json:"session"`
+	Status   string     `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Attempts int        `json:"attempts"`
+	RetryAt  *time.Time `json:"retry_at,omitempty"`
+	Result   string     `json:"result,omitempty"` // path of the edge image once the job is done
+}
+
+// JobQueue processes the jobs submitted to the server with a fixed number of workers. At most the given number of jobs
+// wait to be processed, finished jobs are kept until their results expire. The uploaded images and results the queue
+// holds are limited in bytes, the results of the oldest finished jobs are dropped early to make room for new jobs.
+// Failed jobs are retried with exponential backoff up to the given number of times. With a job store every change of
+// a job is saved, so the jobs are restored when the server restarts. The changes are written in the order they were
+// made by a writer of its own, so writing them never holds up the queue. It is safe for concurrent use.
+type JobQueue struct {
+	mutex    sync.Mutex
+	jobs     map[string]*Job
+	pending  chan *Job
+	bytes    int64         // size of the uploaded images and results held by the jobs
+	maxBytes int64         // maximum of bytes
+	ttl      time.Duration // time finished jobs are kept
+	retries  int           // number of times a failed job is retried
+	store    *JobStore     // nil keeps the jobs in memory only
+	client   *http.Client  // client of the webhook callbacks to hosts of the allow list
+	public   *http.Client  // client of the webhook callbacks to all other hosts, which only connects to public addresses
+	allow    []string      // hosts callbacks may be posted to even if their addresses are private
+	writes   []jobWrite    // changes of jobs that wait to be written to the store, in the order they were made
+	written  *sync.Cond    // signals the writer of the store that there are changes, uses the mutex
+}
+
+// jobWrite is a change of a job that waits to be written to the store: the state of the job with the uploaded image
+// unless it is nil, or the removal of the job.
+type jobWrite struct {
+	record       jobRecord
+	data, result []byte
+	delete       bool
+}
+
+// permanentError is an error of a job that fails the same way on every attempt, such as an image that can't be
+// decoded. Jobs that fail with it aren't retried.
+type permanentError struct {
+	error
+}
+
+// NewJobQueue returns a queue that holds up to size waiting jobs and the given number of bytes of uploaded images and
+// results, processes them with the given number of workers, retries failed jobs the given number of times and keeps
+// finished jobs for the given time. Webhook callbacks are only posted to public addresses unless their host is one of
+// the given hosts. The jobs of the given store, if any, are restored: unfinished jobs are queued again with the
+// session of their name, jobs whose session doesn't exist anymore fail.
+func NewJobQueue(size, workers int, maxBytes int64, ttl time.Duration, retries int, allow []string, store *JobStore,
+	sessions *SessionStore) (*JobQueue, error) {
+	// callbacks are never redirected, the target of a redirect wouldn't be checked against the allow list
+	noRedirects := func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	dialer := &net.Dialer{Control: dialPublicOnly}
+	q := &JobQueue{jobs: make(map[string]*Job), maxBytes: maxBytes, ttl: ttl, retries: retries, store: store,
+		client: &http.Client{Timeout: JOB_CALLBACK_TIMEOUT, CheckRedirect: noRedirects},
+		public: &http.Client{Timeout: JOB_CALLBACK_TIMEOUT, CheckRedirect: noRedirects,
+			Transport: &http.Transport{DialContext: dialer.DialContext, Proxy: nil}},
+		allow: allow}
+	q.written = sync.NewCond(&q.mutex)
+	var restored, lost []*Job
+	if store != nil {
+		err := store.Load(func(record jobRecord, data, result []byte) {
+			job := &Job{ID: record.ID, Session: sessions.Get(record.Session), Callback: record.Callback,
+				Status: record.Status, Error: record.Error, Created: record.Created, Finished: record.Finished,
+				Attempts: record.Attempts, RetryAt: record.RetryAt, data: data, result: result}
+			if job.Session == nil {
+				job.Session = &Session{Name: record.Session} // keeps the name for the description
+			}
+			q.jobs[job.ID] = job
+			if !job.Finished.IsZero() {
+				return
+			}
+			if sessions.Get(record.Session) == nil || data == nil {
+				lost = append(lost, job)
+				return
+			}
+			job.Status = JOB_QUEUED // jobs that were running when the server stopped start over
+			restored = append(restored, job)
+		})
+		for _, job := range q.jobs {
+			q.bytes += int64(len(job.data) + len(job.result))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the lost jobs are finished before the writer starts, which takes the changes under the mutex, and the store
+	// can't be written while it is read
+	for _, job := range lost {
+		q.finish(job, nil, errors.New("no such session "+job.Session.Name+" after restart"))
+	}
+	if store != nil {
+		go q.write()
+	}
+	// restored jobs are queued even if there are more of them than the queue holds
+	q.pending = make(chan *Job, max(size, len(restored)))
+	for _, job := range restored {
+		q.schedule(job)
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	go func() {
+		for range time.Tick(min(ttl, JOB_EXPIRY_INTERVAL)) {
+			q.mutex.Lock()
+			q.expire(0)
+			q.mutex.Unlock()
+		}
+	}()
+	return q, nil
+}
+
+// Submit queues the detection of the given encoded image with the given session and returns the new job. With a
+// callback URL given the description of the job is posted to it once the job is finished.
+func (q *JobQueue) Submit(session *Session, data []byte, callback string) (*Job, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	job := &Job{ID: hex.EncodeToString(id), Session: session, Callback: callback, Status: JOB_QUEUED, Created: time.Now(),
+		data: data}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.expire(int64(len(data))); q.bytes+int64(len(data)) > q.maxBytes {
+		return nil, ErrQueueFull
+	}
+	select {
+	case q.pending <- job:
+	default:
+		return nil, ErrQueueFull
+	}
+	q.jobs[job.ID] = job
+	q.bytes += int64(len(data))
+	q.save(job, data)
+	return job, nil
+}
+
+// Info returns the description of the job with the given ID and reports whether it exists.
+func (q *JobQueue) Info(id string) (JobInfo, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return JobInfo{}, false
+	}
+	return job.info(), true
+}
+
+// Result returns the edge image of the job with the given ID encoded as PNG, nil if the job isn't done, and reports
+// whether the job exists.
+func (q *JobQueue) Result(id string) ([]byte, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	return job.result, true
+}
+
+// info returns the description of the job, the mutex of its queue must be held.
+func (j *Job) info() JobInfo {
+	info := JobInfo{ID: j.ID, Session: j.Session.Name, Status: j.Status, Error: j.Error, Created: j.Created,
+		Attempts: j.Attempts}
+	if !j.Finished.IsZero() {
+		finished := j.Finished
+		info.Finished = &finished
+	}
+	if !j.RetryAt.IsZero() {
+		retryAt := j.RetryAt
+		info.RetryAt = &retryAt
+	}
+	if j.Status == JOB_DONE {
+		info.Result = "/jobs/" + j.ID + "/result"
+	}
+	return info
+}
+
+// expire removes the finished jobs that are older than the time jobs are kept. If the given number of bytes doesn't
+// fit into the queue afterwards, the oldest finished jobs are removed as well until it does. The mutex must be held.
+func (q *JobQueue) expire(needed int64) {
+	var finished []*Job
+	for _, job := range q.jobs {
+		if !job.Finished.IsZero() {
+			finished = append(finished, job)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].Finished.Before(finished[j].Finished) })
+	for _, job := range finished {
+		if time.Since(job.Finished) <= q.ttl && q.bytes+needed <= q.maxBytes {
+			break
+		}
+		delete(q.jobs, job.ID)
+		q.bytes -= int64(len(job.result))
+		if q.store != nil {
+			q.writes = append(q.writes, jobWrite{record: jobRecord{ID: job.ID}, delete: true})
+			q.written.Signal()
+		}
+	}
+}
+
+// work processes pending jobs, it never returns.
+func (q *JobQueue) work() {
+	for job := range q.pending {
+		q.mutex.Lock()
+		job.Status, job.RetryAt = JOB_RUNNING, time.Time{}
+		job.Attempts++
+		data := job.data
+		q.save(job, nil)
+		q.mutex.Unlock()
+
+		result, err := detectJob(job.Session, data)
+		q.mutex.Lock()
+		var permanent permanentError
+		if err != nil && !errors.As(err, &permanent) && job.Attempts <= q.retries {
+			job.Status, job.Error = JOB_QUEUED, err.Error()
+			job.RetryAt = time.Now().Add(retryBackoff(job.Attempts))
+			q.save(job, nil)
+			q.schedule(job)
+			q.mutex.Unlock()
+			continue
+		}
+		info := q.finish(job, result, err)
+		q.mutex.Unlock()
+
+		if job.Callback != "" {
+			q.notify(job.Callback, info)
+		}
+	}
+}
+
+// retryBackoff returns the delay before the retry of a job that failed the given number of attempts. The shift is
+// clamped, so plenty of retries don't overflow the delay.
+func retryBackoff(attempts int) time.Duration {
+	return min(JOB_RETRY_BACKOFF<<min(attempts-1, 16), JOB_RETRY_MAX_BACKOFF)
+}
+
+// schedule queues the given job again, after its retry time if it has one. The mutex must be held or the workers not
+// be running yet.
+func (q *JobQueue) schedule(job *Job) {
+	delay := time.Until(job.RetryAt)
+	if delay <= 0 {
+		select {
+		case q.pending <- job:
+			return
+		default:
+		}
+	}
+	// the job waits outside of the queue, so a full queue doesn't block
+	time.AfterFunc(max(delay, 0), func() {
+		q.pending <- job
+	})
+}
+
+// finish marks the given job as done with the given result or as failed with the given error and returns its
+// description. The mutex must be held.
+func (q *JobQueue) finish(job *Job, result []byte, err error) JobInfo {
+	if err != nil {
+		job.Status, job.Error = JOB_FAILED, err.Error()
+	} else {
+		job.Status, job.Error, job.result = JOB_DONE, "", result
+	}
+	q.bytes += int64(len(job.result) - len(job.data))
+	job.Finished, job.data = time.Now(), nil
+	q.save(job, nil)
+	return job.info()
+}
+
+// save queues the current state of the given job for the store, if there is one, together with the given uploaded
+// image unless it is nil. The mutex must be held.
+func (q *JobQueue) save(job *Job, data []byte) {
+	if q.store == nil {
+		return
+	}
+	record := jobRecord{job.ID, job.Session.Name, job.Callback, job.Status, job.Error, job.Created, job.Finished,
+		job.Attempts, job.RetryAt}
+	q.writes = append(q.writes, jobWrite{record: record, data: data, result: job.result})
+	q.written.Signal()
+}
+
+// write writes the queued changes of jobs to the store without holding the mutex, it never returns. Failures are
+// logged, the jobs go on regardless.
+func (q *JobQueue) write() {
+	for {
+		q.mutex.Lock()
+		for len(q.writes) == 0 {
+			q.written.Wait()
+		}
+		writes := q.writes
+		q.writes = nil
+		q.mutex.Unlock()
+
+		for _, write := range writes {
+			var err error
+			if write.delete {
+				err = q.store.Delete(write.record.ID)
+			} else {
+				err = q.store.Save(write.record, write.data, write.result)
+			}
+			if err != nil {
+				log.Printf("job %s: store: %v", write.record.ID, err)
+			}
+		}
+	}
+}
+
+// detectJob decodes the given image, detects its edges with the given session and returns them encoded as PNG. Jobs
+// wait for the session even if it drops frames, as they aren't live. Images that can't be decoded and sessions that
+// were closed meanwhile fail with a permanentError.
+func detectJob(session *Session, data []byte) ([]byte, error) {
+	img, err := decodeInput(bytes.NewReader(data), "")
+	if err != nil {
+		return nil, permanentError{err}
+	}
+	edges, err := session.detect(img, true)
+	if errors.Is(err, ErrSessionClosed) {
+		return nil, permanentError{err}
+	} else if err != nil {
+		return nil, err
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, getImageFromArray(samplesToPixels(edges))); err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
+
+// notify posts the given description of a finished job to the given callback URL. Unless the host of the URL is on the
+// allow list, the callback is only posted to public addresses, so it can't reach services of the server's network.
+// Failures are logged, the job is finished regardless.
+func (q *JobQueue) notify(callback string, info JobInfo) {
+	body, err := json.Marshal(info)
+	if err != nil {
+		log.Printf("job %s: callback: %v", info.ID, err)
+		return
+	}
+	client := q.public
+	if u, err := url.Parse(callback); err == nil && slices.Contains(q.allow, u.Hostname()) {
+		client = q.client
+	}
+	response, err := client.Post(callback, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("job %s: callback: %v", info.ID, err)
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		log.Printf("job %s: callback: %s", info.ID, response.Status)
+	}
+}
+
+// dialPublicOnly refuses connections to loopback, private, link-local and other non-public addresses, which covers
+// the metadata services of cloud providers. It is checked for the resolved address of every connection, so host names
+// that resolve to such addresses are refused as well.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return fmt.Errorf("callback to non-public address %s refused", host)
+	}
+	return nil
+}
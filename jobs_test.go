@@ -0,0 +1,137 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"image/png"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitFor polls the given condition until it holds and fails the test if it doesn't within a few seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// testPNG returns the test image of the given size encoded as PNG.
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, getImageFromArray(testPixels(width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return encoded.Bytes()
+}
+
+// TestJobQueueRestart checks that the jobs of a store are restored: unfinished jobs of existing sessions are detected,
+// those of removed sessions fail, and both outcomes are written back to the store. Run with -race it also checks that
+// failing the lost jobs doesn't race with the writer of the store.
+func TestJobQueueRestart(t *testing.T) {
+	store, err := OpenJobStore(filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	created := time.Now()
+	for _, record := range []jobRecord{
+		{ID: "lost", Session: "removed", Status: JOB_QUEUED, Created: created},
+		{ID: "restored", Session: DEFAULT_SESSION, Status: JOB_RUNNING, Created: created, Attempts: 1},
+	} {
+		if err := store.Save(record, testPNG(t, 32, 24), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sessions := NewSessionStore()
+	session, _ := NewSession(DEFAULT_SESSION, nil)
+	sessions.Put(session)
+	q, err := NewJobQueue(1, 1, 1<<20, time.Hour, 0, nil, store, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the store is polled before the queue, whose mutex would order the restore before the writer even if it raced
+	waitFor(t, "the store", func() bool {
+		records := make(map[string]jobRecord)
+		if err := store.Load(func(record jobRecord, _, _ []byte) { records[record.ID] = record }); err != nil {
+			t.Fatal(err)
+		}
+		// the done job is the last change, so the writer is idle once it is stored
+		return records["lost"].Status == JOB_FAILED && records["restored"].Status == JOB_DONE
+	})
+	if info, _ := q.Info("lost"); info.Status != JOB_FAILED || !strings.Contains(info.Error, "after restart") {
+		t.Errorf("expected the job of the removed session to fail, got %+v", info)
+	}
+	if info, _ := q.Info("restored"); info.Status != JOB_DONE {
+		t.Errorf("expected the restored job to be done, got %+v", info)
+	}
+	if result, _ := q.Result("restored"); result == nil {
+		t.Errorf("expected a result of the restored job")
+	} else if _, err := png.Decode(bytes.NewReader(result)); err != nil {
+		t.Errorf("result isn't a PNG image: %v", err)
+	}
+}
+
+// TestJobQueueClosedSession checks that jobs of a session that was closed meanwhile fail without being retried.
+func TestJobQueueClosedSession(t *testing.T) {
+	q, err := NewJobQueue(1, 1, 1<<20, time.Hour, 3, nil, nil, NewSessionStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _ := NewSession(DEFAULT_SESSION, nil)
+	session.Close()
+	job, err := q.Submit(session, testPNG(t, 32, 24), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the job", func() bool {
+		info, _ := q.Info(job.ID)
+		return info.Finished != nil
+	})
+	if info, _ := q.Info(job.ID); info.Status != JOB_FAILED || info.Attempts != 1 {
+		t.Errorf("expected the job to fail after one attempt, got %+v", info)
+	}
+}
+
+// TestRetryBackoff checks that the delay before retries doubles up to its maximum without overflowing.
+func TestRetryBackoff(t *testing.T) {
+	for _, test := range []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, JOB_RETRY_BACKOFF},
+		{2, 2 * JOB_RETRY_BACKOFF},
+		{4, 8 * JOB_RETRY_BACKOFF},
+		{20, JOB_RETRY_MAX_BACKOFF},
+		{64, JOB_RETRY_MAX_BACKOFF},
+		{1000, JOB_RETRY_MAX_BACKOFF},
+	} {
+		if backoff := retryBackoff(test.attempts); backoff != test.expected {
+			t.Errorf("expected a backoff of %v after %d attempts, got %v", test.expected, test.attempts, backoff)
+		}
+	}
+}
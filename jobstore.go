@@ -0,0 +1,178 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// buckets of the job store, the records of the jobs and their uploaded images and results by job ID and the parameters
+// of the sessions created over the API by name
+var (
+	JOB_RECORDS_BUCKET  = []byte("jobs")
+	JOB_DATA_BUCKET     = []byte("data")
+	JOB_RESULTS_BUCKET  = []byte("results")
+	JOB_SESSIONS_BUCKET = []byte("sessions")
+)
+
+// jobRecord is the state of a job as it is kept in the job store.
+type jobRecord struct {
+	ID       string    `json:"id"`
+	Session  string    `json:"session"`
+	Callback string    `json:"callback,omitempty"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	Finished time.Time `json:"finished"`
+	Attempts int       `json:"attempts"`
+	RetryAt  time.Time `json:"retry_at"`
+}
+
+// JobStore keeps the jobs of the server in a bolt database, so queued jobs survive restarts. The sessions created over
+// the API are kept as well, so the restored jobs find them.
+type JobStore struct {
+	db *bolt.DB
+}
+
+// OpenJobStore opens the job store in the database file at the given path, which is created if it doesn't exist.
+func OpenJobStore(path string) (*JobStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{JOB_RECORDS_BUCKET, JOB_DATA_BUCKET, JOB_RESULTS_BUCKET, JOB_SESSIONS_BUCKET} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &JobStore{db}, nil
+}
+
+// Close closes the database of the store.
+func (s *JobStore) Close() error {
+	return s.db.Close()
+}
+
+// Save stores the given record. The uploaded image and the result are stored unless they are nil, the image is
+// removed once the job is finished.
+func (s *JobStore) Save(record jobRecord, data, result []byte) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		id := []byte(record.ID)
+		if err := tx.Bucket(JOB_RECORDS_BUCKET).Put(id, encoded); err != nil {
+			return err
+		}
+		if data != nil {
+			if err := tx.Bucket(JOB_DATA_BUCKET).Put(id, data); err != nil {
+				return err
+			}
+		}
+		if result != nil {
+			if err := tx.Bucket(JOB_RESULTS_BUCKET).Put(id, result); err != nil {
+				return err
+			}
+		}
+		if !record.Finished.IsZero() {
+			return tx.Bucket(JOB_DATA_BUCKET).Delete(id)
+		}
+		return nil
+	})
+}
+
+// Delete removes the job with the given ID from the store.
+func (s *JobStore) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{JOB_RECORDS_BUCKET, JOB_DATA_BUCKET, JOB_RESULTS_BUCKET} {
+			if err := tx.Bucket(name).Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Load calls the given function with every stored record together with its uploaded image and result, which are nil
+// if they aren't stored. The slices are copied out of the database and may be kept.
+func (s *JobStore) Load(load func(record jobRecord, data, result []byte)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		data, results := tx.Bucket(JOB_DATA_BUCKET), tx.Bucket(JOB_RESULTS_BUCKET)
+		return tx.Bucket(JOB_RECORDS_BUCKET).ForEach(func(id, encoded []byte) error {
+			var record jobRecord
+			if err := json.Unmarshal(encoded, &record); err != nil {
+				return err
+			}
+			load(record, copyBytes(data.Get(id)), copyBytes(results.Get(id)))
+			return nil
+		})
+	})
+}
+
+// SaveSession stores the given parameters of the session with the given name, replacing those stored before.
+func (s *JobStore) SaveSession(name string, parameters map[string]string) error {
+	encoded, err := json.Marshal(parameters)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(JOB_SESSIONS_BUCKET).Put([]byte(name), encoded)
+	})
+}
+
+// DeleteSession removes the session with the given name from the store.
+func (s *JobStore) DeleteSession(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(JOB_SESSIONS_BUCKET).Delete([]byte(name))
+	})
+}
+
+// LoadSessions calls the given function with the name and parameters of every stored session.
+func (s *JobStore) LoadSessions(load func(name string, parameters map[string]string)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(JOB_SESSIONS_BUCKET).ForEach(func(name, encoded []byte) error {
+			var parameters map[string]string
+			if err := json.Unmarshal(encoded, &parameters); err != nil {
+				return err
+			}
+			load(string(name), parameters)
+			return nil
+		})
+	})
+}
+
+// copyBytes returns a copy of the given slice, nil for nil.
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
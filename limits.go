@@ -0,0 +1,142 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"sync/atomic"
+)
+
+const (
+	// DECODE_MAX_DIMENSION is the largest width and height decoders allocate pixels for unless a smaller maximum is set
+	// with setDecodeMaxDimension.
+	DECODE_MAX_DIMENSION = 1 << 16
+	// DECODE_MAX_PIXELS is the largest number of pixels decoders allocate memory for.
+	DECODE_MAX_PIXELS = 1 << 28
+)
+
+// decodeMaxDimension holds the largest width and height decoders allocate pixels for.
+var decodeMaxDimension atomic.Int64
+
+func init() {
+	decodeMaxDimension.Store(DECODE_MAX_DIMENSION)
+}
+
+// setDecodeMaxDimension sets the largest width and height decoders allocate pixels for to the given maximum, which
+// is capped at DECODE_MAX_DIMENSION. Callers that reject oversized images set this to their -max-dimension, so the
+// decoders fail early instead of relying on a separate check of the header.
+func setDecodeMaxDimension(maxDimension int) {
+	decodeMaxDimension.Store(int64(min(maxDimension, DECODE_MAX_DIMENSION)))
+}
+
+// checkDecodeSize returns an error if an image of the given dimensions exceeds the limits of decoders. Decoders call
+// this with the dimensions of the header before they allocate the pixels.
+func checkDecodeSize(width, height int) error {
+	maxDimension := int(decodeMaxDimension.Load())
+	if width > maxDimension || height > maxDimension {
+		return fmt.Errorf("image size %dx%d exceeds maximum dimension of %d", width, height, maxDimension)
+	}
+	if width*height > DECODE_MAX_PIXELS {
+		return fmt.Errorf("image size %dx%d exceeds maximum of %d pixels", width, height, DECODE_MAX_PIXELS)
+	}
+	return nil
+}
+
+// isValidOversizePolicy checks whether the given name denotes a supported way of handling images that exceed the
+// maximum dimension.
+func isValidOversizePolicy(policy string) bool {
+	return policy == "reject" || policy == "downscale"
+}
+
+// checkDimensions returns an error if the width or height of the image at the given path exceeds the given maximum.
+// Only the header of the image is decoded, for raw frames the dimensions are taken from the given raw format.
+func checkDimensions(path string, rawFormat string, maxDimension int) error {
+	var width, height int
+	if rawFormat != "" {
+		format, err := parseRawFormat(rawFormat)
+		if err != nil {
+			return err
+		}
+		width, height = format.width, format.height
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close() // opened for reading, no error checking needed
+		config, _, err := DecodeImageConfig(file)
+		if err != nil {
+			return err
+		}
+		width, height = config.Width, config.Height
+	}
+	if width > maxDimension || height > maxDimension {
+		return fmt.Errorf("image size %dx%d exceeds maximum dimension of %d", width, height, maxDimension)
+	}
+	return nil
+}
+
+// downscalePixels reduces the given pixels by the smallest integer factor that brings width and height down to at most
+// the given maximum. Every resulting pixel is the mean of the block of pixels it covers. Pixels that already fit are
+// returned unchanged.
+func downscalePixels(pixels [][]GrayPixel, maxDimension int) [][]GrayPixel {
+	height, width := len(pixels), len(pixels[0])
+	factor := (max(width, height) + maxDimension - 1) / maxDimension
+	if factor <= 1 {
+		return pixels
+	}
+
+	result := make([][]GrayPixel, (height+factor-1)/factor)
+	for y := range result {
+		result[y] = make([]GrayPixel, (width+factor-1)/factor)
+		for x := range result[y] {
+			var sumY, sumA, count int
+			for i := y * factor; i < min((y+1)*factor, height); i++ {
+				for j := x * factor; j < min((x+1)*factor, width); j++ {
+					sumY += int(pixels[i][j].y)
+					sumA += int(pixels[i][j].a)
+					count++
+				}
+			}
+			result[y][x] = GrayPixel{uint8((sumY + count/2) / count), uint8((sumA + count/2) / count)}
+		}
+	}
+	return result
+}
+
+// downscaleDefects maps the given defect pixels of an image of the given size to the pixels of the copy downscalePixels
+// returns for the given maximum. The pixels of the copy that a defect contributes to are defects themselves, blocks
+// with several defects give a single one. Defects of images that already fit are returned unchanged.
+func downscaleDefects(defects []image.Point, width, height, maxDimension int) []image.Point {
+	factor := (max(width, height) + maxDimension - 1) / maxDimension
+	if factor <= 1 || defects == nil {
+		return defects
+	}
+	seen := make(map[image.Point]bool)
+	scaled := []image.Point{}
+	for _, p := range defects {
+		q := image.Pt(p.X/factor, p.Y/factor)
+		if !seen[q] {
+			seen[q] = true
+			scaled = append(scaled, q)
+		}
+	}
+	return scaled
+}
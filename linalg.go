@@ -0,0 +1,63 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"errors"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat/combin"
+)
+
+// vector and matrix are the types the kernels and image panes of the pipeline are held in. Default builds use gonum,
+// builds with the edgeefy_minimal tag use the plain implementation of linalg_minimal.go.
+type (
+	vector = mat.VecDense
+	matrix = mat.Dense
+)
+
+// newVector returns a vector holding the given values.
+func newVector(values []float64) *vector {
+	return mat.NewVecDense(len(values), values)
+}
+
+// newMatrix returns a matrix of the given size holding the given values in row major order.
+func newMatrix(rows, cols int, values []float64) *matrix {
+	return mat.NewDense(rows, cols, values)
+}
+
+// binomial returns the binomial coefficient of n over k.
+func binomial(n, k int) int {
+	return combin.Binomial(n, k)
+}
+
+// solveLinear returns the solution x of the square linear system a x = b given by the rows of a. An error is returned
+// if the system is singular.
+func solveLinear(a [][]float64, b []float64) ([]float64, error) {
+	m := mat.NewDense(len(a), len(a), nil)
+	for i, row := range a {
+		m.SetRow(i, row)
+	}
+	var solution mat.VecDense
+	if err := solution.SolveVec(m, mat.NewVecDense(len(b), b)); err != nil {
+		return nil, errors.New("singular system")
+	}
+	return solution.RawVector().Data, nil
+}
@@ -0,0 +1,125 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build edgeefy_minimal
+
+package edgeefy
+
+import (
+	"errors"
+	"math"
+)
+
+// vector is a column vector in place of gonum's VecDense, providing the methods the pipeline uses.
+type vector struct {
+	values []float64
+}
+
+// matrix is a dense matrix in place of gonum's Dense, providing the methods the pipeline uses.
+type matrix struct {
+	rows, cols int
+	values     []float64
+}
+
+// newVector returns a vector holding the given values.
+func newVector(values []float64) *vector {
+	return &vector{values}
+}
+
+// newMatrix returns a matrix of the given size holding the given values in row major order.
+func newMatrix(rows, cols int, values []float64) *matrix {
+	if values == nil {
+		values = make([]float64, rows*cols)
+	}
+	return &matrix{rows, cols, values}
+}
+
+// Len returns the number of elements of the vector.
+func (v *vector) Len() int {
+	return len(v.values)
+}
+
+// At returns the element at row i, j must be zero.
+func (v *vector) At(i, j int) float64 {
+	return v.values[i]
+}
+
+// rawVector mirrors the part of the raw vector of gonum that the pipeline uses.
+type rawVector struct {
+	Data []float64
+}
+
+// RawVector returns the elements of the vector, they are shared with the vector.
+func (v *vector) RawVector() rawVector {
+	return rawVector{v.values}
+}
+
+// Dims returns the number of rows and columns of the matrix.
+func (m *matrix) Dims() (int, int) {
+	return m.rows, m.cols
+}
+
+// At returns the element at row i and column j.
+func (m *matrix) At(i, j int) float64 {
+	return m.values[i*m.cols+j]
+}
+
+// binomial returns the binomial coefficient of n over k.
+func binomial(n, k int) int {
+	result := 1
+	for i := 1; i <= k; i++ {
+		result = result * (n - k + i) / i
+	}
+	return result
+}
+
+// solveLinear returns the solution x of the square linear system a x = b given by the rows of a by gaussian
+// elimination with partial pivoting. An error is returned if the system is singular.
+func solveLinear(a [][]float64, b []float64) ([]float64, error) {
+	n := len(a)
+	rows := make([][]float64, n)
+	for i := range rows {
+		rows[i] = append(append([]float64(nil), a[i]...), b[i])
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for i := col + 1; i < n; i++ {
+			if math.Abs(rows[i][col]) > math.Abs(rows[pivot][col]) {
+				pivot = i
+			}
+		}
+		if math.Abs(rows[pivot][col]) < 1e-12 {
+			return nil, errors.New("singular system")
+		}
+		rows[col], rows[pivot] = rows[pivot], rows[col]
+		for i := col + 1; i < n; i++ {
+			factor := rows[i][col] / rows[col][col]
+			for j := col; j <= n; j++ {
+				rows[i][j] -= factor * rows[col][j]
+			}
+		}
+	}
+	x := make([]float64, n)
+	for i := n - 1; i >= 0; i-- {
+		sum := rows[i][n]
+		for j := i + 1; j < n; j++ {
+			sum -= rows[i][j] * x[j]
+		}
+		x[i] = sum / rows[i][i]
+	}
+	return x, nil
+}
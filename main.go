@@ -15,9 +15,12 @@
 // You should have received a copy of the GNU General Public License
 // along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
 
-package main
+package edgeefy
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"image"
@@ -26,8 +29,12 @@ import (
 	"image/png"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 )
 
 // GrayPixel is a data structure to represent the gray and alpha value of a pixel.
@@ -36,42 +43,738 @@ type GrayPixel struct {
 	a uint8
 }
 
-func main() {
+// Command is a subcommand of edgeefy. Run parses the flags of the subcommand from the given arguments.
+type Command struct {
+	// defines the flags of the subcommand and returns them together with the function that runs the subcommand once
+	// they are parsed. Defining the flags must not have side effects, as shell completions collect them.
+	Flags   func() (*flag.FlagSet, func())
+	Summary string // short description for shell completions
+}
+
+// subcommands maps the names of the subcommands to their implementations, which parse their own flags.
+var subcommands = map[string]Command{
+	"deskew":     {deskewCommand, "straighten scanned documents by their dominant edge angle"},
+	"rectify":    {rectifyCommand, "correct the perspective of the largest quadrilateral"},
+	"barcodes":   {barcodesCommand, "find regions likely to contain barcodes or QR codes"},
+	"motion":     {motionCommand, "write the moving edges between two frames"},
+	"batch":      {batchCommand, "detect edges of all images in a directory"},
+	"inspect":    {inspectCommand, "report properties of images without decoding them"},
+	"robustness": {robustnessCommand, "compare edges of an image under injected noise"},
+	"focus":      {focusCommand, "report how sharp images are"},
+	"suggest":    {suggestCommand, "recommend thresholds and blur for images"},
+	"noise":      {noiseCommand, "report the estimated noise of images"},
+	"stereo":     {stereoCommand, "report edges found in only one image of a stereo pair"},
+	"burst":      {burstCommand, "align and average a photo burst before detecting edges"},
+	"pyramid":    {pyramidCommand, "write the levels of the gaussian or laplacian pyramid"},
+	"thumbnail":  {thumbnailCommand, "write a thumbnail cropped to the most detailed region"},
+	"compare":    {compareCommand, "write an animated GIF switching between an image and its edges"},
+	"ab":         {abCommand, "compare the edges of two configurations or binaries on a directory"},
+	"latency":    {latencyCommand, "measure the per-frame latency of real-time detection"},
+	"stream":     {streamCommand, "detect edges of a live stream of PGM frames"},
+}
+
+// Main runs the command line of edgeefy with the arguments of the process. The edgeefy command in cmd/edgeefy does
+// nothing else, other programs can embed the detector by importing this package.
+func Main() {
+	// run a subcommand if one is given
+	if len(os.Args) > 1 {
+		if command, ok := subcommands[os.Args[1]]; ok {
+			flags, run := command.Flags()
+			parseCommandFlags(flags, os.Args[2:])
+			run()
+			return
+		}
+	}
 	// define command line flags
-	blurFlagPtr := flag.Bool("blur", true, "perform gaussian blur before edge detection (optional, default: true)")
+	blurFlagPtr := &blurFlag{enabled: true}
+	flag.Var(blurFlagPtr, "blur", "blur before edge detection: true, false, gaussian, box or none, e.g. -blur=box (optional, default: true = gaussian)")
+	sigmaArgPtr := flag.Float64("sigma", 0, "standard deviation of the gaussian blur, large values are filtered recursively (optional, default: 0 = 5x5 kernel)")
+	boxPassesArgPtr := flag.Int("box-passes", 3, "number of passes of the box blur (optional, default: 3)")
 	inputFileArgPtr := flag.String("input", "", "path to input file (required)")
 	outputFileArgPtr := flag.String("output", "out.jpg", "path to output file (optional, default: out.jpg")
 	minThresholdArgPtr := flag.Float64("min", float64(0.2), "ratio of lower threshold (optional, default: 0.2")
 	maxThresholdArgPtr := flag.Float64("max", float64(0.6), "ratio of upper threshold (optional, default: 0.6")
-	// parse command line flags and arguments
-	flag.Parse()
+	fastFlagPtr := flag.Bool("fast", false, "detect FAST corners instead of edges (optional, default: false)")
+	fastThresholdArgPtr := flag.Int("fast-threshold", 20, "intensity difference threshold for FAST corners (optional, default: 20)")
+	fastNmsFlagPtr := flag.Bool("fast-nms", true, "apply non-maximum suppression to FAST corners (optional, default: true)")
+	depthFlagPtr := flag.Bool("depth", false, "treat input as 16-bit depth map with invalid pixels (optional, default: false)")
+	invalidArgPtr := flag.Uint("invalid", 0, "depth value that marks pixels without data (optional, default: 0)")
+	windowCenterArgPtr := flag.Float64("window-center", 0, "window center for DICOM input (optional, default: from file)")
+	windowWidthArgPtr := flag.Float64("window-width", 0, "window width for DICOM input (optional, default: from file)")
+	fitsScaleArgPtr := flag.String("fits-scale", "linear", "scaling of FITS input: linear, log or zscale (optional, default: linear)")
+	inputRawArgPtr := flag.String("input-raw", "", "read input as headerless raw frame, e.g. 640x480:gray8 (optional, formats: gray8, gray16, rgb24)")
+	framesArgPtr := flag.String("frames", "separate", "how to write results of animated input: separate, apng or tiff (optional, default: separate)")
+	temporalArgPtr := flag.Int("temporal", 0, "smooth edges of animated input over N frames (optional, default: 0 = off)")
+	temporalModeArgPtr := flag.String("temporal-mode", "vote", "how to smooth edges over frames: vote or average (optional, default: vote)")
+	sceneCutsFileArgPtr := flag.String("scene-cuts", "", "path to write scene cuts of animated input to as JSON (optional)")
+	cutThresholdArgPtr := flag.Float64("cut-threshold", float64(0.6), "edge change ratio between frames that marks a scene cut (optional, default: 0.6)")
+	workersArgPtr := flag.Int("workers", runtime.NumCPU(), "number of concurrent workers per stage (optional, default: number of CPUs)")
+	startArgPtr := flag.Float64("start", 0, "time in seconds of animated input to start processing at (optional, default: 0)")
+	durationArgPtr := flag.Float64("duration", 0, "length in seconds of animated input to process (optional, default: 0 = until the end)")
+	everyArgPtr := flag.Int("every", 1, "process only every N-th frame of animated input (optional, default: 1)")
+	frameWorkersArgPtr := flag.Int("frame-workers", runtime.NumCPU(), "number of frames of animated input processed concurrently (optional, default: number of CPUs)")
+	verifyFlagPtr := flag.Bool("verify-deterministic", false, "check that results don't depend on the number of workers (optional, default: false)")
+	floatFlagPtr := flag.Bool("float", false, "run detection on floating point values so gradients are not quantized (optional, default: false)")
+	defectsFileArgPtr := flag.String("defects", "", "path to a list of defect pixels of the sensor, one x,y position per line, excluded from the detection (optional)")
+	defectThresholdArgPtr := flag.Float64("defect-threshold", 0, "exclude isolated pixels standing out from all neighbours by this multiple of the noise as hot or dead pixels (optional, default: 0 = off)")
+	vignettingArgPtr := flag.String("vignetting", "", "correct the radial falloff of the brightness by the lens: fit or the coefficients k1,k2 of 1 + k1 r^2 + k2 r^4, e.g. -0.3,0.05 (optional)")
+	backgroundRadiusArgPtr := flag.Float64("background-radius", 0, "subtract the background of this radius in pixels, larger than the objects, to remove uneven illumination (optional, default: 0 = off)")
+	backgroundArgPtr := flag.String("background", "ball", "how the subtracted background is estimated: ball for a rolling ball or gaussian (optional, default: ball)")
+	channelsFlagPtr := flag.Bool("channels", false, "detect edges on the red, green and blue channels separately and merge them, which finds edges between regions that differ only in hue (optional, default: false)")
+	fringeDistanceArgPtr := flag.Int("fringe-distance", 0, "with -channels keep only one of the copies of an edge up to this many pixels apart that chromatic aberration of the lens shifted between the channels (optional, default: 0 = off)")
+	logIntensityFlagPtr := flag.Bool("log-intensity", false, "take gradients of log(1+I) to equalize dark and bright regions (optional, default: false)")
+	percentileArgPtr := flag.Float64("percentile", 1, "percentile of gradients the threshold ratios refer to (optional, default: 1 = maximum)")
+	noiseThresholdsFlagPtr := flag.Bool("noise-thresholds", false, "take min and max as multiples of the estimated standard deviation of the image noise, e.g. -min 2 -max 5 (optional, default: false)")
+	despeckleArgPtr := flag.Int("despeckle", 0, "remove edge pixels with less than N neighbouring edge pixels (optional, default: 0 = off)")
+	bridgeDistanceArgPtr := flag.Float64("bridge-distance", 0, "connect segment endpoints closer than this distance in pixels (optional, default: 0 = off)")
+	bridgeAngleArgPtr := flag.Float64("bridge-angle", 30, "angular tolerance in degrees for bridging gaps (optional, default: 30)")
+	parameterMapArgPtr := flag.String("parameter-map", "", "path to an image whose brighter regions get lower thresholds (optional)")
+	parameterStrengthArgPtr := flag.Float64("parameter-strength", 1, "thresholds of white and black regions of the parameter map are divided and multiplied by 2^strength (optional, default: 1)")
+	weightMapArgPtr := flag.String("weight-map", "", "path to an image, e.g. a saliency map, whose brightness weights the edge strength before thresholding (optional)")
+	mmapFlagPtr := flag.Bool("mmap", false, "memory-map uncompressed 8-bit PGM, BMP or TIFF input and detect it in strips to reduce memory use, writes only the edge image as PNG (optional, default: false)")
+	spillFlagPtr := flag.Bool("spill", false, "keep the input and intermediate results in temporary files and detect in strips to need little memory, writes only the edge image as PNG (optional, default: false)")
+	auditOverflowFlagPtr := flag.Bool("audit-overflow", false, "report how many pixels of 8 or 16-bit samples saturated at every stage, which runs the detection twice (optional, default: false)")
+	reportFileArgPtr := flag.String("report", "", "path to write a standalone HTML report with the stages, histograms and timings to (optional)")
+	masksFileArgPtr := flag.String("masks", "", "path to write filled masks of closed contours to (optional)")
+	maskModeArgPtr := flag.String("mask-mode", "labeled", "how to write masks: labeled or separate (optional, default: labeled)")
+	minAreaArgPtr := flag.Int("min-area", 50, "minimum area in pixels of closed contours written as masks (optional, default: 50)")
+	contoursFileArgPtr := flag.String("contours", "", "path to write traced contours to as SVG, .png or .jpg for anti-aliased line art (optional)")
+	strokeWidthArgPtr := flag.Float64("stroke-width", 1, "width of the strokes the contours are drawn with (optional, default: 1)")
+	approxEpsilonArgPtr := flag.Float64("approx-epsilon", 0, "simplify contours to polygons within this distance (optional, default: 0 = off)")
+	convexHullFlagPtr := flag.Bool("convex-hull", false, "replace contours by their convex hulls (optional, default: false)")
+	geoJSONFileArgPtr := flag.String("geojson", "", "path to write traced contours to as GeoJSON (optional)")
+	worldFileArgPtr := flag.String("world-file", "", "world file that georeferences the input (optional, default: sidecar of input)")
+	tilesDirArgPtr := flag.String("tiles", "", "directory to write the edge image to as tile pyramid (optional)")
+	tileLayoutArgPtr := flag.String("tile-layout", "dzi", "layout of the tile pyramid: dzi or xyz (optional, default: dzi)")
+	tileSizeArgPtr := flag.Int("tile-size", 256, "edge length of pyramid tiles in pixels (optional, default: 256)")
+	compareOpenCVFlagPtr := flag.Bool("compare-opencv", false, "report agreement with OpenCV's canny, needs build tag gocv (optional, default: false)")
+	stackArgPtr := flag.String("stack", "", "combine the input with the files given after the flags to reduce noise: mean or median (optional)")
+	toneMapArgPtr := flag.String("tone-map", "reinhard", "tone-mapping of Radiance HDR and OpenEXR input: reinhard or drago (optional, default: reinhard)")
+	dcrawFlagPtr := flag.Bool("dcraw", false, "develop camera RAW input with dcraw if installed instead of using the embedded preview (optional, default: false)")
+	maxDimensionArgPtr := flag.Int("max-dimension", 0, "maximum width and height of input images, e.g. 8000 (optional, default: 0 = no limit)")
+	oversizeArgPtr := flag.String("oversize", "reject", "how to handle images exceeding the maximum dimension: reject or downscale (optional, default: reject)")
+	responseArgPtr := flag.String("response", "", "write the signed response of a second-derivative operator instead of edges: log or dog, .pfm keeps float data (optional)")
+	previewScaleArgPtr := flag.Float64("preview-scale", 0, "write a preview detected at this scale, e.g. 0.25, before the full resolution pass (optional, default: 0 = off)")
+	minDensityArgPtr := flag.Float64("fail-if-edge-density-lt", 0, "exit with an error if the ratio of edge pixels is lower (optional, default: 0)")
+	maxDensityArgPtr := flag.Float64("fail-if-edge-density-gt", 1, "exit with an error if the ratio of edge pixels is higher (optional, default: 1)")
+	autocropArgPtr := flag.String("autocrop", "", "crop the output to the bounding box of the edges: edges or original to write the cropped input (optional)")
+	autocropPaddingArgPtr := flag.Int("autocrop-padding", 10, "padding in pixels around the bounding box of the edges (optional, default: 10)")
+	autocropDensityArgPtr := flag.Float64("autocrop-density", 0.01, "minimum ratio of edge pixels of rows and columns in the bounding box (optional, default: 0.01)")
+	softFlagPtr := flag.Bool("soft", false, "edge pixels keep their gradient magnitude scaled to the strongest edge instead of the detection strength (optional, default: false)")
+	confidenceFileArgPtr := flag.String("confidence", "", "path to write the ratio of every edge pixel to the upper threshold to, .pfm keeps the values (optional)")
+	layersFileArgPtr := flag.String("layers", "", "path to write the results of all stages to as multi-page TIFF (optional)")
+	manifestFileArgPtr := flag.String("manifest", "", "path to write a JSON description of the detection pipeline to (optional)")
+	showParamsArgPtr := flag.String("show-params", "", "print the parameters embedded into the given output image and exit (optional)")
+	strictFlagPtr := flag.Bool("strict", false, "exit with an error if options would be ignored or conflict (optional, default: false)")
+	configFileArgPtr := flag.String("config", "", "path to a JSON config file with values for flags that aren't given (optional, default: $EDGEEFY_CONFIG)")
+	cornersFileArgPtr := flag.String("corners", "corners.json", "path to JSON file for FAST corners (optional, default: corners.json)")
+	// parse command line flags and arguments, the config subcommand shares the flags of the detection
+	args := os.Args[1:]
+	configCommand, explain := len(args) > 0 && args[0] == "config", false
+	if configCommand {
+		args = args[1:]
+		if len(args) > 0 && (args[0] == "-explain" || args[0] == "--explain") {
+			explain, args = true, args[1:]
+		}
+	}
+	// print the completion script for the given shell if requested
+	if len(args) > 0 && args[0] == "completion" {
+		if len(args) != 2 || !slices.Contains(COMPLETION_SHELLS, args[1]) {
+			fmt.Println("Invalid value for shell given, exiting.")
+			return
+		}
+		writeCompletion(os.Stdout, args[1], flag.CommandLine)
+		return
+	}
+	// suggest the closest command for a mistyped one
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		names := []string{"config", "completion"}
+		for name := range subcommands {
+			names = append(names, name)
+		}
+		if suggestion := closestName(args[0], names); suggestion != "" {
+			fmt.Printf("Unknown command %s, did you mean %s? Exiting.\n", args[0], suggestion)
+			return
+		}
+	}
+	checkFlags(flag.CommandLine, args)
+	flag.CommandLine.Parse(args)
+	// fill in the flags that weren't given from the config file and the environment
+	sources, err := resolveConfiguration(flag.CommandLine, *configFileArgPtr)
+	if err != nil {
+		fmt.Printf("%v, exiting.\n", err)
+		return
+	}
+	// print the resolved configuration if requested
+	if configCommand {
+		if err := writeConfiguration(os.Stdout, flag.CommandLine, sources, explain); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// in strict mode options that would be ignored or resolved silently are errors
+	if *strictFlagPtr {
+		if conflicts := strictConflicts(flag.CommandLine, sources); len(conflicts) > 0 {
+			for _, conflict := range conflicts {
+				fmt.Println(conflict)
+			}
+			fmt.Println("Conflicting options given in strict mode, exiting.")
+			os.Exit(2)
+		}
+	}
+	// print the parameters of a previous run if requested
+	if *showParamsArgPtr != "" {
+		if err := showParameters(*showParamsArgPtr); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	// check for required arguments, exit if empty path is provided
 	if *inputFileArgPtr == "" {	// if no input filepath was specified, print message and exit
 		fmt.Println("No path to input file specified, nothing to do.")
 		return
 	}
 	// check threshold ratio arguments, exit if invalid values are given
-	if !isValidRatioValue(*minThresholdArgPtr) || !isValidRatioValue(*maxThresholdArgPtr) {
+	if *noiseThresholdsFlagPtr {
+		if !isValidNoiseMultiple(*minThresholdArgPtr) || !isValidNoiseMultiple(*maxThresholdArgPtr) {
+			fmt.Println("Invalid value for threshold multiple of the noise given, exiting.")
+			return
+		}
+	} else if !isValidRatioValue(*minThresholdArgPtr) || !isValidRatioValue(*maxThresholdArgPtr) {
 		fmt.Println("Invalid value for threshold ratio given, exiting.")
 		return
 	}
 
-	// register the jpeg and png formats with the image library
-	image.RegisterFormat("jpeg", "jpeg", jpeg.Decode, jpeg.DecodeConfig)
-	image.RegisterFormat("png", "png", png.Decode, png.DecodeConfig)
+	// check defect arguments, exit if the list can't be read or an invalid threshold is given
+	var defects []image.Point
+	if *defectsFileArgPtr != "" {
+		var err error
+		if defects, err = readDefectList(*defectsFileArgPtr); err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			return
+		}
+	}
+	if !(*defectThresholdArgPtr >= 0) || math.IsInf(*defectThresholdArgPtr, 1) {
+		fmt.Println("Invalid value for defect threshold given, exiting.")
+		return
+	}
+
+	// check vignetting argument, exit if it can't be parsed
+	var vignetting []float64
+	var fitVignetting bool
+	if *vignettingArgPtr != "" {
+		var err error
+		if vignetting, fitVignetting, err = parseVignetting(*vignettingArgPtr); err != nil {
+			fmt.Println("Invalid value for vignetting given, exiting.")
+			return
+		}
+	}
+
+	// check background arguments, exit if unknown filter or invalid radius is given
+	backgroundFilter, ok := parseBackgroundFilter(*backgroundArgPtr)
+	if !ok || *backgroundRadiusArgPtr < 0 || (*backgroundRadiusArgPtr > 0 && *backgroundRadiusArgPtr < 1) {
+		fmt.Println("Invalid value for background subtraction given, exiting.")
+		return
+	}
+
+	// check channel arguments, exit if a negative distance is given or the channels can't be detected separately with the
+	// other options
+	if *fringeDistanceArgPtr < 0 || (*fringeDistanceArgPtr > 0 && !*channelsFlagPtr) {
+		fmt.Println("Invalid value for fringe distance given, it needs -channels, exiting.")
+		return
+	}
+	if *channelsFlagPtr && (*mmapFlagPtr || *spillFlagPtr || *depthFlagPtr || *stackArgPtr != "" || *responseArgPtr != "" ||
+		*fastFlagPtr || (*maxDimensionArgPtr > 0 && *oversizeArgPtr == "downscale") || *layersFileArgPtr != "" ||
+		*confidenceFileArgPtr != "" || *reportFileArgPtr != "" || *auditOverflowFlagPtr || *softFlagPtr) {
+		fmt.Println("Invalid value for channels given, it can't be combined with strips, depth, stacking, responses, corners, downscaling or the outputs of stages, exiting.")
+		return
+	}
+
+	// check percentile argument, exit if invalid value is given
+	if !isValidRatioValue(*percentileArgPtr) {
+		fmt.Println("Invalid value for threshold percentile given, exiting.")
+		return
+	}
+
+	// check raw input format, exit if it can't be parsed
+	if *inputRawArgPtr != "" {
+		if _, err := parseRawFormat(*inputRawArgPtr); err != nil {
+			fmt.Println("Invalid raw input format given, exiting.")
+			return
+		}
+	}
+	// check FITS scaling argument, exit if unknown method is given
+	if !isValidFitsScale(*fitsScaleArgPtr) {
+		fmt.Println("Invalid value for FITS scaling given, exiting.")
+		return
+	}
+
+	// check tone-mapping operator, exit if unknown operator is given
+	if !isValidToneMap(*toneMapArgPtr) {
+		fmt.Println("Invalid value for tone-mapping given, exiting.")
+		return
+	}
+
+	// check stroke width, exit if invalid value is given
+	if !(*strokeWidthArgPtr > 0) || math.IsInf(*strokeWidthArgPtr, 0) {
+		fmt.Println("Invalid value for stroke width given, exiting.")
+		return
+	}
+
+	// check strength of the parameter map, exit if invalid value is given
+	if *parameterStrengthArgPtr < 0 || math.IsInf(*parameterStrengthArgPtr, 0) {
+		fmt.Println("Invalid value for parameter map strength given, exiting.")
+		return
+	}
+
+	// check stacking mode, exit if unknown mode is given or files to stack are given without it
+	if !isValidStackMode(*stackArgPtr) || (*stackArgPtr == "" && flag.NArg() > 0) {
+		fmt.Println("Invalid value for stacking given, exiting.")
+		return
+	}
+
+	// check multi-frame output mode, exit if unknown mode is given
+	if !isValidFramesMode(*framesArgPtr) {
+		fmt.Println("Invalid value for frames output mode given, exiting.")
+		return
+	}
+
+	// check frame selection arguments, exit if negative times or an invalid step are given
+	if *startArgPtr < 0 || *durationArgPtr < 0 || *everyArgPtr < 1 {
+		fmt.Println("Invalid value for frame selection given, exiting.")
+		return
+	}
+
+	// check temporal smoothing arguments, exit if unknown mode or negative window is given
+	if !isValidTemporalMode(*temporalModeArgPtr) || *temporalArgPtr < 0 {
+		fmt.Println("Invalid value for temporal smoothing given, exiting.")
+		return
+	}
+	if !isValidRatioValue(*cutThresholdArgPtr) {
+		fmt.Println("Invalid value for scene cut threshold given, exiting.")
+		return
+	}
+
+	// check mask output mode, exit if unknown mode is given
+	if !isValidMaskMode(*maskModeArgPtr) {
+		fmt.Println("Invalid value for mask mode given, exiting.")
+		return
+	}
+
+	// check blur arguments, exit if negative values are given
+	if *sigmaArgPtr < 0 || *boxPassesArgPtr < 0 {
+		fmt.Println("Invalid value for blur given, exiting.")
+		return
+	}
+
+	// check second-derivative operator, exit if unknown operator is given
+	if *responseArgPtr != "" && !isValidResponseOperator(*responseArgPtr) {
+		fmt.Println("Invalid value for response operator given, exiting.")
+		return
+	}
+
+	// check size limit arguments, exit if negative maximum or unknown policy is given
+	if *maxDimensionArgPtr < 0 || !isValidOversizePolicy(*oversizeArgPtr) {
+		fmt.Println("Invalid value for size limit given, exiting.")
+		return
+	}
+
+	// check expected edge density range, exit if it isn't a valid range of ratios
+	if !isValidRatioValue(*minDensityArgPtr) || !isValidRatioValue(*maxDensityArgPtr) || *minDensityArgPtr > *maxDensityArgPtr {
+		fmt.Println("Invalid value for edge density range given, exiting.")
+		return
+	}
+
+	// check autocrop arguments, exit if unknown mode, negative padding or invalid density is given
+	if !isValidAutocropMode(*autocropArgPtr) || *autocropPaddingArgPtr < 0 || !isValidRatioValue(*autocropDensityArgPtr) {
+		fmt.Println("Invalid value for autocrop given, exiting.")
+		return
+	}
+
+	// check preview scale, exit if it isn't smaller than the full resolution
+	if !isValidRatioValue(*previewScaleArgPtr) || *previewScaleArgPtr == 1 {
+		fmt.Println("Invalid value for preview scale given, exiting.")
+		return
+	}
+
+	// check tile pyramid arguments, exit if unknown layout or invalid size is given
+	if !isValidTileLayout(*tileLayoutArgPtr) || *tileSizeArgPtr <= 0 {
+		fmt.Println("Invalid value for tile pyramid given, exiting.")
+		return
+	}
+
+	// check that the OpenCV comparison is available, exit otherwise
+	if *compareOpenCVFlagPtr && compareOpenCV == nil {
+		fmt.Println("Comparison with OpenCV not available, build with -tags gocv. Exiting.")
+		return
+	}
+
+	// DICOM images are windowed into the 16-bit range as requested by the window flags, FITS images scaled and high
+	// dynamic range images tone-mapped as requested
+	formatOptions := FormatOptions{*windowCenterArgPtr, *windowWidthArgPtr, *fitsScaleArgPtr, *toneMapArgPtr, *dcrawFlagPtr}
+	for _, decoder := range formatDecoders(formatOptions) {
+		RegisterDecoder(decoder)
+	}
+
+	// set up the edge detector from the command line parameters
+	detector := NewDetector(blurFlagPtr.enabled, *minThresholdArgPtr, *maxThresholdArgPtr)
+	detector.BlurFilter = blurFlagPtr.filter
+	detector.BoxPasses = *boxPassesArgPtr
+	detector.Sigma = *sigmaArgPtr
+	detector.Workers = *workersArgPtr
+	detector.Percentile = *percentileArgPtr
+	detector.NoiseThresholds = *noiseThresholdsFlagPtr
+	detector.LogIntensity = *logIntensityFlagPtr
+	detector.DefectPixels = defects
+	detector.DefectThreshold = *defectThresholdArgPtr
+	detector.Vignetting = vignetting
+	detector.FitVignetting = fitVignetting
+	detector.BackgroundRadius = *backgroundRadiusArgPtr
+	detector.BackgroundFilter = backgroundFilter
+	detector.Despeckle = *despeckleArgPtr
+	detector.BridgeDistance = *bridgeDistanceArgPtr
+	detector.BridgeAngle = *bridgeAngleArgPtr
+	detector.FringeDistance = *fringeDistanceArgPtr
+	imageMetadata = detectorParameters(detector)
+	if *channelsFlagPtr {
+		imageMetadata["channels"] = "true"
+	}
+	// write the description of the pipeline next to the results if requested
+	if *manifestFileArgPtr != "" {
+		description := detector.Describe()
+		// the maps are read with the input
+		description.Thresholds.Local = *parameterMapArgPtr != ""
+		description.Weighted = *weightMapArgPtr != ""
+		if *channelsFlagPtr {
+			description.Intensity = "channels"
+		}
+		writeJSONFile(description, *manifestFileArgPtr)
+	}
+
+	// oversized images are rejected before they are decoded, downscaling happens after decoding
+	downscale := *maxDimensionArgPtr > 0 && *oversizeArgPtr == "downscale"
+	if *maxDimensionArgPtr > 0 && *oversizeArgPtr == "reject" {
+		if err := checkDimensions(*inputFileArgPtr, *inputRawArgPtr, *maxDimensionArgPtr); err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			return
+		}
+		setDecodeMaxDimension(*maxDimensionArgPtr)
+	}
+
+	// memory-mapped and spilled input is detected strip by strip and written row by row, it is never held in memory
+	// together with the results of the stages
+	if *mmapFlagPtr || *spillFlagPtr {
+		if downscale {
+			fmt.Println("Input detected in strips can't be downscaled, exiting.")
+			return
+		}
+		density, err := writeStripEdges(detector, *inputFileArgPtr, *inputRawArgPtr, *mmapFlagPtr, *spillFlagPtr, *outputFileArgPtr)
+		if err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			os.Exit(1)
+		}
+		checkEdgeDensity(density, *minDensityArgPtr, *maxDensityArgPtr)
+		return
+	}
+
+	// depth maps are read with full precision and pixels holding the invalid value are excluded from detection
+	if *depthFlagPtr {
+		if *invalidArgPtr > math.MaxUint16 {
+			fmt.Println("Invalid value for depth sentinel given, exiting.")
+			return
+		}
+		depth := openDepthImage(*inputFileArgPtr, *inputRawArgPtr)
+		valid := depthMask(depth, uint16(*invalidArgPtr))
+		checkParameters(detector, len(depth[0]), len(depth), valid)
+		if *auditOverflowFlagPtr {
+			fmt.Println(AuditOverflow(detector, depth, valid))
+		}
+		edges := runDetection(detector, depth, valid, *verifyFlagPtr)
+		writeImage(samplesToPixels(edges), *outputFileArgPtr)
+		checkEdgeDensity(edgeDensity(edges), *minDensityArgPtr, *maxDensityArgPtr)
+		return
+	}
+
+	// animated input is processed frame by frame and written as separate files or a single animation
+	if *inputRawArgPtr == "" && !*channelsFlagPtr {
+		selection := FrameSelection{*startArgPtr, *durationArgPtr, *everyArgPtr}
+		if frames, loopCount, decode := openFrames(*inputFileArgPtr, selection); len(frames) > 1 || (frames != nil && selection != ALL_FRAMES) {
+			if len(frames) == 0 {
+				fmt.Println("No frames selected, exiting.")
+				return
+			}
+			// the first frame is decoded ahead to check the parameters, the others as soon as a worker is free
+			frames[0].Pixels = decode(0)
+			checkParameters(detector, len(frames[0].Pixels[0]), len(frames[0].Pixels), nil)
+			// the defect pixels are downscaled like the frames, which all have the size of the first one
+			if downscale {
+				detector.DefectPixels = downscaleDefects(defects, len(frames[0].Pixels[0]), len(frames[0].Pixels), *maxDimensionArgPtr)
+			}
+			// separate frames are written as soon as they are done unless all frames are needed afterwards
+			streamed := *framesArgPtr == "separate" && *temporalArgPtr <= 1 && *sceneCutsFileArgPtr == ""
+			densities := make([]float64, len(frames))
+			detectFrame := func(i int) {
+				if downscale {
+					frames[i].Pixels = downscalePixels(frames[i].Pixels, *maxDimensionArgPtr)
+				}
+				edges := runDetection(detector, pixelsToSamples(frames[i].Pixels), nil, *verifyFlagPtr)
+				frames[i].Pixels = samplesToPixels(edges)
+				densities[i] = edgeDensity(edges)
+			}
+			writeFrame := func(i int) {
+				if streamed {
+					writeImage(frames[i].Pixels, framePath(*outputFileArgPtr, i))
+					frames[i].Pixels = nil
+				}
+			}
+			decodeFrame := func(i int) {
+				if frames[i].Pixels == nil {
+					frames[i].Pixels = decode(i)
+				}
+			}
+			processFrames(len(frames), *frameWorkersArgPtr, decodeFrame, detectFrame, writeFrame)
+			// every frame has to meet the expected edge density
+			defer func() {
+				for _, density := range densities {
+					checkEdgeDensity(density, *minDensityArgPtr, *maxDensityArgPtr)
+				}
+			}()
+			if streamed {
+				return
+			}
+			if *temporalArgPtr > 1 || *sceneCutsFileArgPtr != "" {
+				cuts := findSceneCuts(frames, *cutThresholdArgPtr)
+				if *sceneCutsFileArgPtr != "" {
+					writeJSONFile(cuts, *sceneCutsFileArgPtr)
+				}
+				if *temporalArgPtr > 1 {
+					smoothFrames(frames, *temporalArgPtr, *temporalModeArgPtr, cuts)
+				}
+			}
+			writeFrames(frames, loopCount, *outputFileArgPtr, *framesArgPtr)
+			return
+		}
+	}
+
+	// high dynamic range images are tone-mapped and processed with floating point precision
+	if *inputRawArgPtr == "" && *responseArgPtr == "" && !*fastFlagPtr && !*channelsFlagPtr {
+		if samples := openHDR(*inputFileArgPtr, *toneMapArgPtr); samples != nil {
+			checkParameters(detector, len(samples[0]), len(samples), nil)
+			edges := runDetection(detector, samples, nil, *verifyFlagPtr)
+			writeImage(samplesToPixels(edges), *outputFileArgPtr)
+			checkEdgeDensity(edgeDensity(edges), *minDensityArgPtr, *maxDensityArgPtr)
+			return
+		}
+	}
 
+	// the report times the run from decoding the input on
+	var report *RunReport
+	if *reportFileArgPtr != "" {
+		report = newRunReport(*inputFileArgPtr)
+	}
 	// open the image specified by input argument
-	pixels := openImage(*inputFileArgPtr)
-	// perform Canny edge detection on the pixel array
-	pixels = CannyEdgeDetect(pixels, *blurFlagPtr, *minThresholdArgPtr, *maxThresholdArgPtr)
-	// write result to image file
-	writeImage(pixels, *outputFileArgPtr)
+	pixels := openImage(*inputFileArgPtr, *inputRawArgPtr)
+	// stack the other files of the same scene onto the input to reduce noise
+	if *stackArgPtr != "" {
+		var err error
+		if pixels, err = stackImages(pixels, flag.Args(), *inputRawArgPtr, *stackArgPtr); err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			return
+		}
+	}
+	// the defect pixels are downscaled like the input
+	if downscale {
+		detector.DefectPixels = downscaleDefects(defects, len(pixels[0]), len(pixels), *maxDimensionArgPtr)
+		pixels = downscalePixels(pixels, *maxDimensionArgPtr)
+	}
+	// scale the thresholds locally by the parameter map, which is downscaled like the input
+	if *parameterMapArgPtr != "" {
+		parameterMap := openImage(*parameterMapArgPtr, "")
+		if downscale {
+			parameterMap = downscalePixels(parameterMap, *maxDimensionArgPtr)
+		}
+		detector.ThresholdFactors = ParameterMapFactors(grayMapValues(parameterMap), *parameterStrengthArgPtr)
+	}
+	// weight the edge strength by the weight map, which is downscaled like the input
+	if *weightMapArgPtr != "" {
+		weightMap := openImage(*weightMapArgPtr, "")
+		if downscale {
+			weightMap = downscalePixels(weightMap, *maxDimensionArgPtr)
+		}
+		detector.EdgeWeights = grayMapValues(weightMap)
+	}
+	checkParameters(detector, len(pixels[0]), len(pixels), nil)
+	if report != nil {
+		report.record("decoding")
+	}
+	// in response mode write the signed response of the second-derivative operator
+	if *responseArgPtr != "" {
+		imageMetadata["algorithm"] = *responseArgPtr
+		samples := convertSamples[float64](pixelsToSamples(pixels))
+		writeResponse(detector.Response(*responseArgPtr, samples), *outputFileArgPtr)
+		return
+	}
+	// in corner mode detect FAST corners and write them together with an annotated image
+	if *fastFlagPtr {
+		imageMetadata = nil // corners don't depend on the edge detection parameters
+		corners := FastCorners(pixels, *fastThresholdArgPtr, *fastNmsFlagPtr)
+		writeCorners(corners, *cornersFileArgPtr)
+		writeColorImage(annotateCorners(pixels, corners), *outputFileArgPtr)
+		return
+	}
+	// write a quick preview of large images while the detection at full resolution runs
+	if *previewScaleArgPtr > 0 {
+		defer startPreview(detector, pixels, *previewScaleArgPtr, *outputFileArgPtr)()
+	}
+	// detect edges with OpenCV before the pixels are replaced by the result
+	var reference [][]GrayPixel
+	if *compareOpenCVFlagPtr {
+		var err error
+		if reference, err = compareOpenCV(pixels, detector); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// if nothing but the PNG edge image is written, it is written band by band as the strips of the detection complete
+	// instead of being held in memory next to the input. The suppressed magnitude is kept in memory between the passes,
+	// so it isn't computed twice. The box filter is left out as its strips may differ by rounding.
+	outputs := stageOutputs{*layersFileArgPtr, *confidenceFileArgPtr, report, *auditOverflowFlagPtr, *softFlagPtr}
+	onlyEdges := outputs == (stageOutputs{}) && reference == nil && !*channelsFlagPtr && *autocropArgPtr == "" &&
+		*contoursFileArgPtr == "" && *geoJSONFileArgPtr == "" && *masksFileArgPtr == "" && *tilesDirArgPtr == ""
+	if onlyEdges && filepath.Ext(*outputFileArgPtr) == ".png" && !*floatFlagPtr && !*verifyFlagPtr &&
+		!(detector.Blur && detector.BlurFilter == BOX) && detector.checkLocalStages("streamed output") == nil {
+		density, err := streamStripEdges(detector, pixelStrips(pixels), STRIPS_KEEP, *outputFileArgPtr)
+		if err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			os.Exit(1)
+		}
+		checkEdgeDensity(density, *minDensityArgPtr, *maxDensityArgPtr)
+		return
+	}
+	// perform Canny edge detection on the pixel array, or on the channels of the color image which the gray pixels were
+	// only checked with
+	if *channelsFlagPtr {
+		pixels = detector.DetectChannels(openColorImage(*inputFileArgPtr, *inputRawArgPtr))
+	} else if *floatFlagPtr {
+		pixels = detectPixels(detector, convertSamples[float32](pixelsToSamples(pixels)), *verifyFlagPtr, outputs)
+	} else {
+		pixels = detectPixels(detector, pixelsToSamples(pixels), *verifyFlagPtr, outputs)
+	}
+	// write result to image file, cropped to the edges if requested
+	box := image.Rect(0, 0, len(pixels[0]), len(pixels))
+	if *autocropArgPtr != "" {
+		box = edgeBoundingBox(pixelsToSamples(pixels), *autocropDensityArgPtr, *autocropPaddingArgPtr)
+	}
+	if *autocropArgPtr == "original" {
+		writeCroppedOriginal(*inputFileArgPtr, *inputRawArgPtr, box, len(pixels[0]), len(pixels), *outputFileArgPtr)
+	} else {
+		writeImage(cropPixels(pixels, box), *outputFileArgPtr)
+	}
+	if report != nil {
+		report.record("writing")
+		writeReport(report, *reportFileArgPtr)
+	}
+	// fail after all results are written if the edge density is outside of the expected range
+	defer checkEdgeDensity(edgeDensity(pixelsToSamples(pixels)), *minDensityArgPtr, *maxDensityArgPtr)
+	// report the agreement with OpenCV if requested
+	if reference != nil {
+		fmt.Println(compareEdges(pixels, reference, 1))
+	}
+	// write the traced contours if requested
+	if *contoursFileArgPtr != "" || *geoJSONFileArgPtr != "" {
+		contours := simplifyContours(TraceContours(pixelsToSamples(pixels)), *approxEpsilonArgPtr, *convexHullFlagPtr)
+		if *contoursFileArgPtr != "" {
+			writeContours(contours, len(pixels[0]), len(pixels), *strokeWidthArgPtr, *contoursFileArgPtr)
+		}
+		if *geoJSONFileArgPtr != "" {
+			world := openWorldFile(*worldFileArgPtr, *inputFileArgPtr)
+			writeGeoJSON(contours, world, *geoJSONFileArgPtr)
+		}
+	}
+	// write filled masks of the closed contours if requested
+	if *masksFileArgPtr != "" {
+		labels, count := FindClosedRegions(pixelsToSamples(pixels), *minAreaArgPtr)
+		writeRegionMasks(labels, count, *masksFileArgPtr, *maskModeArgPtr)
+	}
+	// write the edge image as tile pyramid if requested
+	if *tilesDirArgPtr != "" {
+		writeTilePyramid(getImageFromArray(pixels), *tilesDirArgPtr, *tileLayoutArgPtr, *tileSizeArgPtr)
+	}
+
+}
+
+// checkParameters prints the issues of the parameters of the given detector for an image of the given size with the
+// given mask. The program exits with an error if one of them is an error.
+func checkParameters(detector *Detector, width, height int, valid [][]bool) {
+	issues := detector.Validate(width, height, valid)
+	if len(issues) == 0 {
+		return
+	}
+	fmt.Println(formatIssues(issues))
+	if hasValidationError(issues) {
+		fmt.Println("Invalid parameters for the input given, exiting.")
+		os.Exit(1)
+	}
+}
+
+// runDetection performs the edge detection on the given samples. If verify is set it is checked beforehand that the
+// detection gives identical results for different numbers of workers, the program exits with an error otherwise.
+func runDetection[T Sample](detector *Detector, samples [][]T, valid [][]bool, verify bool) [][]T {
+	if verify && !verifyDeterministic(detector, samples, valid) {
+		fmt.Println("Results differ between numbers of workers, exiting.")
+		os.Exit(1)
+	}
+	return DetectSamples(detector, samples, valid)
+}
 
+// stageOutputs holds the outputs that need the results of the stages of a detection besides the edge image.
+type stageOutputs struct {
+	layersPath     string     // path of the multi-page TIFF with the results of all stages
+	confidencePath string     // path of the confidence map
+	report         *RunReport // report the stages are timed and added to
+	audit          bool       // whether the saturated pixels of the stages are reported, see AuditOverflow
+	soft           bool       // whether the edge pixels are set to their gradient magnitude, see SoftEdges
+}
+
+// detectPixels performs the edge detection on the given samples like runDetection and returns the edge image as
+// pixels. The given outputs of the results of the stages are written or collected as well.
+func detectPixels[T Sample](detector *Detector, samples [][]T, verify bool, outputs stageOutputs) [][]GrayPixel {
+	if outputs.audit {
+		fmt.Println(AuditOverflow(detector, samples, nil))
+		outputs.audit = false
+	}
+	if outputs == (stageOutputs{}) {
+		return samplesToPixels(runDetection(detector, samples, nil, verify))
+	}
+	report := outputs.report
+	if verify && !verifyDeterministic(detector, samples, nil) {
+		fmt.Println("Results differ between numbers of workers, exiting.")
+		os.Exit(1)
+	}
+	// the stages of the detection are timed for the report
+	if report != nil {
+		report.record("preparation")
+		detector = detector.Clone()
+		detector.OnStageComplete = func(stage Stage, _ any) { report.record(stage.String()) }
+	}
+	artifacts := DetectArtifacts(detector, samples, nil)
+	if outputs.layersPath != "" {
+		writeLayers(artifacts, outputs.layersPath)
+	}
+	if outputs.confidencePath != "" {
+		writeConfidence(artifacts.Confidence, outputs.confidencePath)
+	}
+	if report != nil {
+		addArtifacts(report, detector, artifacts)
+	}
+	if outputs.soft {
+		return SoftEdges(artifacts)
+	}
+	return samplesToPixels(artifacts.Edges)
 }
 
 // openImage opens the image given by a path string, converts it to grayscale and returns the pixels as a
-// two-dimensional array
-func openImage(path string) [][]GrayPixel {
+// two-dimensional array. If a raw format is given the file is read as headerless raw frame of that format.
+func openImage(path string, rawFormat string) [][]GrayPixel {
 	file, err := os.Open(path)
 	if err != nil {
 		log.Fatal(err)
@@ -79,7 +782,7 @@ func openImage(path string) [][]GrayPixel {
 	defer file.Close() // opened for reading, no error checking needed
 
 	// read the image data and convert to array of GrayPixel objects
-	pixels, err := getPixelArray(file)
+	pixels, err := getPixelArray(file, rawFormat)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -91,36 +794,116 @@ func openImage(path string) [][]GrayPixel {
 // is specified by the path string. Suppoerted formats are png and jpg. If the path string is not detected as png a jpg
 // is written by default.
 func writeImage(pixels [][]GrayPixel, path string) {
+	// png images are written row by row so no copy of the image is needed
+	if filepath.Ext(path) == ".png" {
+		outFile, err := os.Create(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer outFile.Close()
+		buffered := bufio.NewWriter(outFile)
+		if err := writePNGStreamed(buffered, pixels, imageMetadata); err != nil {
+			log.Fatal(err)
+		}
+		if err := buffered.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	// create grayscale image from the pixel array and write it to disk
 	grayImg := getImageFromArray(pixels)
+	writeColorImage(grayImg, path)
+}
+
+// writeColorImage writes the given image to disc. Like writeImage the format is determined by the path string.
+func writeColorImage(img image.Image, path string) {
 	outFile, err := os.Create(path)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer outFile.Close()
 	// determine what image file type it should be
 	ext := filepath.Ext(path)
-	if ext == "png" {
-		err = png.Encode(outFile, grayImg)
+	var encoded bytes.Buffer
+	if ext == ".png" {
+		err = png.Encode(&encoded, img)
 	} else {
-		opts := jpeg.Options{95}
-		err = jpeg.Encode(outFile, grayImg, &opts)
+		opts := jpeg.Options{Quality: 95}
+		err = jpeg.Encode(&encoded, img, &opts)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := writeWithMetadata(outFile, encoded.Bytes(), imageMetadata); err != nil {
+		log.Fatal(err)
 	}
+}
+
+// writeCorners writes the given corners as JSON to the file at the given path.
+func writeCorners(corners []Corner, path string) {
+	outFile, err := os.Create(path)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer outFile.Close()
+	if err := writeCornersJSON(corners, outFile); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeJSONFile writes the given value as indented JSON to the file at the given path.
+func writeJSONFile(value interface{}, path string) {
+	outFile, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer outFile.Close()
+	encoder := json.NewEncoder(outFile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// openColorImage opens the image given by a path string without converting it to grayscale. If a raw format is given
+// the file is read as headerless raw frame of that format.
+func openColorImage(path string, rawFormat string) image.Image {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close() // opened for reading, no error checking needed
+	img, err := decodeInput(file, rawFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return img
 }
 
 // getPixelArray reads the given file as an image and returns a two-dimensional array of GrayPixel objects. The values
 // in the returned array are stored in the way that arr[m][n] refers to the n-th column of the m-th row of the image
-// data.
-func getPixelArray(file io.Reader) ([][]GrayPixel, error) {
-	var pixelArr [][]GrayPixel
-
-	// load the image from given file and determine image bounds
-	img, _, err := image.Decode(file)
+// data. If a raw format is given the file is read as headerless raw frame of that format.
+func getPixelArray(file io.Reader, rawFormat string) ([][]GrayPixel, error) {
+	// load the image from given file
+	img, err := decodeInput(file, rawFormat)
 	if err != nil {
 		return nil, err
 	}
+
+	return imageToPixelArray(img), nil
+}
+
+// imageToPixelArray converts the given image to grayscale and returns it as two-dimensional array of GrayPixel objects
+// in the same layout as getPixelArray.
+func imageToPixelArray(img image.Image) [][]GrayPixel {
+	// common image types are converted from their pixel data instead of pixel by pixel
+	if pixelArr, ok := directToPixelArray(img); ok {
+		return pixelArr
+	}
+
+	var pixelArr [][]GrayPixel
+
+	// determine image bounds
 	height := img.Bounds().Max.Y
 	width := img.Bounds().Max.X
 
@@ -135,7 +918,7 @@ func getPixelArray(file io.Reader) ([][]GrayPixel, error) {
 		pixelArr = append(pixelArr, row)
 	}
 
-	return pixelArr, nil
+	return pixelArr
 }
 
 // getImageFromArray takes pixel information from the given two-dimensional array and creates a corresponding image.
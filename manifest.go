@@ -0,0 +1,133 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+// PipelineDescription is a canonical description of the configuration of a detector. Two detectors with equal
+// descriptions produce identical edge images for the same input with the same version of edgeefy. The number of
+// workers is left out as it doesn't affect results.
+type PipelineDescription struct {
+	Version    string                 `json:"version"`
+	Algorithm  string                 `json:"algorithm"`
+	Intensity  string                 `json:"intensity"` // luma, channels or custom, see Detector.Intensity and DetectChannels
+	LogScale   bool                   `json:"log_intensity"`
+	Defects    *DefectDescription     `json:"defects,omitempty"`    // nil if no defect pixels are masked
+	Vignetting *VignettingDescription `json:"vignetting,omitempty"` // nil if vignetting isn't corrected
+	Background *BackgroundDescription `json:"background,omitempty"` // nil if no background is subtracted
+	Blur       BlurDescription        `json:"blur"`
+	Gradient   GradientDescription    `json:"gradient"`
+	Thresholds ThresholdDescription   `json:"thresholds"`
+	Postproc   PostprocessDescription `json:"postprocessing"`
+	Custom     []string               `json:"custom_stages,omitempty"`   // stages replaced by custom implementations
+	Weighted   bool                   `json:"edge_weights,omitempty"`    // whether the edge strength is weighted per pixel
+	Fringes    int                    `json:"fringe_distance,omitempty"` // distance of suppressed chromatic fringes
+}
+
+// DefectDescription describes the defect pixels of the sensor that are excluded from the detection.
+type DefectDescription struct {
+	Pixels    [][2]int `json:"pixels,omitempty"`    // x and y coordinates of known defects
+	Threshold float64  `json:"threshold,omitempty"` // multiple of the noise isolated outliers are found with
+}
+
+// VignettingDescription describes the correction of the radial falloff of the brightness before the detection.
+type VignettingDescription struct {
+	Coefficients []float64 `json:"coefficients,omitempty"` // k1, k2, ... of the falloff 1 + k1 r^2 + k2 r^4 + ...
+	Fit          bool      `json:"fit,omitempty"`          // whether the coefficients are fitted to every image
+}
+
+// BackgroundDescription describes the background that is subtracted from the image before the detection.
+type BackgroundDescription struct {
+	Filter string  `json:"filter"` // ball or gaussian
+	Radius float64 `json:"radius"`
+}
+
+// BlurDescription describes the smoothing applied before the gradients are computed. Kernel holds the weights of the
+// separable filter kernel if one is used.
+type BlurDescription struct {
+	Filter string    `json:"filter"` // none, binomial, gaussian, recursive-gaussian or box
+	Kernel []float64 `json:"kernel,omitempty"`
+	Sigma  float64   `json:"sigma,omitempty"`
+	Passes int       `json:"passes,omitempty"`
+}
+
+// GradientDescription describes the kernels the gradients are computed with, in row major order.
+type GradientDescription struct {
+	Operator string    `json:"operator"`
+	KernelX  []float64 `json:"kernel_x"`
+	KernelY  []float64 `json:"kernel_y"`
+}
+
+// ThresholdDescription describes the hysteresis thresholds as ratios of the gradient at the given percentile.
+type ThresholdDescription struct {
+	Low        float64 `json:"low"`
+	High       float64 `json:"high"`
+	Percentile float64 `json:"percentile"`
+	Local      bool    `json:"local,omitempty"` // whether the thresholds are scaled per pixel
+	Noise      bool    `json:"noise,omitempty"` // whether low and high are multiples of the noise instead of ratios
+}
+
+// PostprocessDescription describes the cleanup of the edge image after hysteresis.
+type PostprocessDescription struct {
+	Despeckle      int     `json:"despeckle"`
+	BridgeDistance float64 `json:"bridge_distance"`
+	BridgeAngle    float64 `json:"bridge_angle"`
+}
+
+// Describe returns the canonical description of the pipeline the detector runs, following the choices made by
+// DetectSamples. The kernels are copies, so the description can be modified freely.
+func (d *Detector) Describe() PipelineDescription {
+	description := PipelineDescription{
+		Version:    VERSION,
+		Algorithm:  "canny",
+		Intensity:  "luma",
+		LogScale:   d.LogIntensity,
+		Blur:       BlurDescription{Filter: "none"},
+		Gradient:   GradientDescription{"sobel", append([]float64(nil), SOBEL_X...), append([]float64(nil), SOBEL_Y...)},
+		Thresholds: ThresholdDescription{d.MinRatio, d.MaxRatio, d.Percentile, d.ThresholdFactors != nil, d.NoiseThresholds},
+		Postproc:   PostprocessDescription{d.Despeckle, d.BridgeDistance, d.BridgeAngle},
+	}
+	if d.Intensity != nil {
+		description.Intensity = "custom"
+	}
+	description.Custom = d.customStages()
+	description.Weighted = d.EdgeWeights != nil
+	description.Fringes = d.FringeDistance
+	if d.DefectPixels != nil || d.DefectThreshold > 0 {
+		description.Defects = &DefectDescription{Threshold: d.DefectThreshold}
+		for _, p := range d.DefectPixels {
+			description.Defects.Pixels = append(description.Defects.Pixels, [2]int{p.X, p.Y})
+		}
+	}
+	if d.Vignetting != nil || d.FitVignetting {
+		description.Vignetting = &VignettingDescription{append([]float64(nil), d.Vignetting...), d.FitVignetting}
+	}
+	if d.BackgroundRadius > 0 {
+		description.Background = &BackgroundDescription{d.BackgroundFilter.String(), d.BackgroundRadius}
+	}
+	if d.Blur && d.BlurFilter == BOX {
+		description.Blur = BlurDescription{Filter: "box", Kernel: []float64{1.0 / 3, 1.0 / 3, 1.0 / 3}, Passes: d.BoxPasses}
+	} else if d.Blur && d.Sigma > IIR_SIGMA_THRESHOLD {
+		description.Blur = BlurDescription{Filter: "recursive-gaussian", Sigma: d.Sigma}
+	} else if d.Blur && d.Sigma > 0 {
+		kernel := d.gaussianKernel(d.Sigma)
+		description.Blur = BlurDescription{Filter: "gaussian", Kernel: append([]float64(nil), kernel.RawVector().Data...), Sigma: d.Sigma}
+	} else if d.Blur {
+		kernel := d.binomialKernel(5)
+		description.Blur = BlurDescription{Filter: "binomial", Kernel: append([]float64(nil), kernel.RawVector().Data...)}
+	}
+	return description
+}
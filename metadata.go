@@ -0,0 +1,206 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// VERSION is the version of edgeefy that is recorded in the metadata of written images.
+const VERSION = "0.2.0"
+
+// imageMetadata holds the keyword and text pairs that are embedded into written images, as tEXt chunks in PNG files
+// and as comment in JPEG files. It is empty unless the parameters of a detection are recorded.
+var imageMetadata map[string]string
+
+// detectorParameters returns the parameters of the given detector in the form they are embedded into images.
+func detectorParameters(d *Detector) map[string]string {
+	blur := d.Describe().Blur.Filter
+	if d.Blur && d.BlurFilter == BOX {
+		blur = fmt.Sprintf("box (%d passes)", d.BoxPasses)
+	}
+	parameters := map[string]string{
+		"Software":        "edgeefy " + VERSION,
+		"algorithm":       "canny",
+		"min":             fmt.Sprint(d.MinRatio),
+		"max":             fmt.Sprint(d.MaxRatio),
+		"blur":            blur,
+		"sigma":           fmt.Sprint(d.Sigma),
+		"percentile":      fmt.Sprint(d.Percentile),
+		"log-intensity":   fmt.Sprint(d.LogIntensity),
+		"despeckle":       fmt.Sprint(d.Despeckle),
+		"bridge-distance": fmt.Sprint(d.BridgeDistance),
+		"bridge-angle":    fmt.Sprint(d.BridgeAngle),
+	}
+	// min and max are multiples of the noise instead of ratios
+	if d.NoiseThresholds {
+		parameters["noise-thresholds"] = "true"
+	}
+	if d.DefectPixels != nil {
+		parameters["defects"] = fmt.Sprintf("%d pixels", len(d.DefectPixels))
+	}
+	if d.DefectThreshold > 0 {
+		parameters["defect-threshold"] = fmt.Sprint(d.DefectThreshold)
+	}
+	if d.FitVignetting {
+		parameters["vignetting"] = "fit"
+	} else if d.Vignetting != nil {
+		parameters["vignetting"] = fmt.Sprint(d.Vignetting)
+	}
+	if d.BackgroundRadius > 0 {
+		parameters["background"] = fmt.Sprintf("%s (radius %g)", d.BackgroundFilter, d.BackgroundRadius)
+	}
+	if d.FringeDistance > 0 {
+		parameters["fringe-distance"] = fmt.Sprint(d.FringeDistance)
+	}
+	return parameters
+}
+
+// sortedKeys returns the keys of the given map in lexical order, so metadata is always written the same way.
+func sortedKeys[V any](metadata map[string]V) []string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jpegComment formats the given metadata as the text of a JPEG comment with one key=value pair per line.
+func jpegComment(metadata map[string]string) string {
+	var lines []string
+	for _, key := range sortedKeys(metadata) {
+		lines = append(lines, key+"="+metadata[key])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PNG_HEADER_SIZE is the number of bytes of the signature and the IHDR chunk at the start of every PNG file.
+const PNG_HEADER_SIZE = 33
+
+// writeWithMetadata writes the given encoded PNG or JPEG image with the given metadata embedded. In PNG files the
+// metadata is written as tEXt chunks after the header, in JPEG files as comment after the start of image marker.
+func writeWithMetadata(w io.Writer, encoded []byte, metadata map[string]string) error {
+	if len(metadata) == 0 {
+		_, err := w.Write(encoded)
+		return err
+	}
+	if !bytes.HasPrefix(encoded, []byte(PNG_SIGNATURE)) {
+		return writeJPEGWithComment(w, encoded, jpegComment(metadata))
+	}
+	if _, err := w.Write(encoded[:PNG_HEADER_SIZE]); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(metadata) {
+		if err := writePNGChunk(w, "tEXt", []byte(key+"\x00"+metadata[key])); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(encoded[PNG_HEADER_SIZE:])
+	return err
+}
+
+// writeJPEGWithComment writes the given encoded JPEG image with a comment segment holding the given text inserted
+// after the start of image marker.
+func writeJPEGWithComment(w io.Writer, encoded []byte, comment string) error {
+	if len(comment)+2 > 0xffff {
+		return errors.New("jpeg comment too long")
+	}
+	segment := make([]byte, 4, 4+len(comment))
+	segment[0], segment[1] = 0xff, 0xfe
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(comment)+2))
+	segment = append(segment, comment...)
+	for _, part := range [][]byte{encoded[:2], segment, encoded[2:]} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readImageMetadata reads the tEXt chunks of a PNG file or the key=value lines of the comments of a JPEG file.
+func readImageMetadata(r io.Reader) (map[string]string, error) {
+	data, err := io.ReadAll(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string)
+	switch {
+	case bytes.HasPrefix(data, []byte(PNG_SIGNATURE)):
+		for offset := len(PNG_SIGNATURE); offset+12 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[offset:]))
+			if offset+12+length > len(data) {
+				break
+			}
+			chunkType, content := string(data[offset+4:offset+8]), data[offset+8:offset+8+length]
+			if chunkType == "tEXt" {
+				if key, text, found := bytes.Cut(content, []byte{0}); found {
+					metadata[string(key)] = string(text)
+				}
+			}
+			offset += 12 + length
+		}
+	case bytes.HasPrefix(data, []byte("\xff\xd8")):
+		for offset := 2; offset+4 <= len(data) && data[offset] == 0xff; {
+			marker := data[offset+1]
+			length := int(binary.BigEndian.Uint16(data[offset+2:]))
+			if marker == 0xda || offset+2+length > len(data) {
+				break
+			}
+			if marker == 0xfe {
+				for _, line := range strings.Split(string(data[offset+4:offset+2+length]), "\n") {
+					if key, value, found := strings.Cut(line, "="); found {
+						metadata[key] = value
+					}
+				}
+			}
+			offset += 2 + length
+		}
+	default:
+		return nil, errors.New("metadata can only be read from PNG and JPEG files")
+	}
+	return metadata, nil
+}
+
+// showParameters prints the metadata embedded into the image at the given path.
+func showParameters(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close() // opened for reading, no error checking needed
+	metadata, err := readImageMetadata(file)
+	if err != nil {
+		return err
+	}
+	if len(metadata) == 0 {
+		fmt.Println("No parameters found.")
+	}
+	for _, key := range sortedKeys(metadata) {
+		fmt.Printf("%s: %s\n", key, metadata[key])
+	}
+	return nil
+}
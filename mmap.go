@@ -0,0 +1,273 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/color"
+	"io"
+)
+
+// TAG_PLANAR_CONFIGURATION is the TIFF tag that tells whether the samples of a pixel are stored next to each other.
+const TAG_PLANAR_CONFIGURATION = 0x011c
+
+// MappedImage is an uncompressed image whose pixels are read from a memory mapping of the file. Only the strips that
+// are processed are paged in, so the image doesn't need to fit into memory. Binary PGM files of 8 bits per sample,
+// BMP files of 8 or 24 bits per pixel and uncompressed TIFF files of 8-bit gray or RGB samples can be mapped.
+type MappedImage struct {
+	Width, Height int
+	data          []byte
+	offsets       []int      // offset of every row in the data
+	rgb           [3]int     // positions of the red, green and blue samples of a pixel, unused for gray images
+	channels      int        // number of samples per pixel, one for gray and palette images, three for color images
+	gray          [256]uint8 // gray values of the samples of gray images, e.g. from the palette
+	identity      bool       // whether the gray values are the samples, so rows are returned without copying
+	unmap         func() error
+}
+
+// OpenMappedImage maps the PGM, BMP or TIFF file at the given path into memory. Other formats, compressed files and
+// files of more bits per sample have to be decoded.
+func OpenMappedImage(path string) (*MappedImage, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &MappedImage{data: data, channels: 1, identity: true, unmap: unmap}
+	for i := range m.gray {
+		m.gray[i] = uint8(i)
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("P5")):
+		err = m.parsePGM()
+	case bytes.HasPrefix(data, []byte("BM")):
+		err = m.parseBMP()
+	case bytes.HasPrefix(data, []byte(TIFF_LE_MAGIC)) || bytes.HasPrefix(data, []byte(TIFF_BE_MAGIC)):
+		err = m.parseTIFF()
+	default:
+		err = errors.New("only PGM, BMP and TIFF files can be memory-mapped")
+	}
+	if err == nil {
+		// every row has to lie within the mapping, so reading the strips can't fail
+		rowSize := m.Width * m.channels
+		for _, offset := range m.offsets {
+			if offset < 0 || offset+rowSize > len(data) {
+				err = io.ErrUnexpectedEOF
+				break
+			}
+		}
+	}
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	return m, nil
+}
+
+// parsePGM reads the header of a binary PGM file. Maximum values below 255 are scaled like in decoded images.
+func (m *MappedImage) parsePGM() error {
+	// the header is parsed from the mapping, the pixel data starts where the reader stopped
+	reader := bytes.NewReader(m.data)
+	buffered := bufio.NewReader(reader)
+	header, err := readPNMHeader(buffered)
+	if err != nil {
+		return err
+	}
+	if header.maxval > 255 {
+		return errors.New("only 8-bit PGM files can be memory-mapped")
+	}
+	offset := len(m.data) - reader.Len() - buffered.Buffered()
+	m.Width, m.Height = header.width, header.height
+	m.offsets = make([]int, m.Height)
+	for y := range m.offsets {
+		m.offsets[y] = offset + y*m.Width
+	}
+	if header.maxval != 255 {
+		m.identity = false
+		for i := range m.gray {
+			m.gray[i] = uint8(min(i, header.maxval) * 65535 / header.maxval >> 8)
+		}
+	}
+	return nil
+}
+
+// parseBMP reads the headers of an uncompressed BMP file with a palette of 8 bits per pixel or with 24 bits per pixel.
+// Rows are padded to multiples of four bytes and stored from the bottom up unless the height is negative.
+func (m *MappedImage) parseBMP() error {
+	if len(m.data) < 54 {
+		return io.ErrUnexpectedEOF
+	}
+	offset := int(binary.LittleEndian.Uint32(m.data[10:]))
+	headerSize := int(binary.LittleEndian.Uint32(m.data[14:]))
+	width := int(int32(binary.LittleEndian.Uint32(m.data[18:])))
+	height := int(int32(binary.LittleEndian.Uint32(m.data[22:])))
+	bitsPerPixel := binary.LittleEndian.Uint16(m.data[28:])
+	compression := binary.LittleEndian.Uint32(m.data[30:])
+	if headerSize < 40 || compression != 0 || (bitsPerPixel != 8 && bitsPerPixel != 24) {
+		return errors.New("only uncompressed BMP files of 8 or 24 bits per pixel can be memory-mapped")
+	}
+	bottomUp := height > 0
+	if !bottomUp {
+		height = -height
+	}
+	if width <= 0 || height <= 0 {
+		return errors.New("bmp: invalid dimensions")
+	}
+
+	if bitsPerPixel == 8 {
+		colors := int(binary.LittleEndian.Uint32(m.data[46:]))
+		if colors == 0 || colors > 256 {
+			colors = 256
+		}
+		palette := m.data[min(14+headerSize, len(m.data)):]
+		if len(palette) < 4*colors {
+			return io.ErrUnexpectedEOF
+		}
+		// indices without palette entry are black, palette entries are stored as blue, green, red and a reserved byte
+		m.identity = false
+		m.gray = [256]uint8{}
+		for i := 0; i < colors; i++ {
+			entry := palette[4*i:]
+			m.gray[i] = rgbaToGrayPixel(color.RGBA{entry[2], entry[1], entry[0], 255}).y
+		}
+	} else {
+		m.channels, m.rgb = 3, [3]int{2, 1, 0}
+	}
+	m.Width, m.Height = width, height
+	stride := (int(bitsPerPixel)*width + 31) / 32 * 4
+	m.offsets = make([]int, height)
+	for y := range m.offsets {
+		if bottomUp {
+			m.offsets[y] = offset + (height-1-y)*stride
+		} else {
+			m.offsets[y] = offset + y*stride
+		}
+	}
+	return nil
+}
+
+// parseTIFF reads the first image file directory of an uncompressed TIFF file of 8-bit gray or RGB samples that are
+// stored in strips with the samples of every pixel next to each other.
+func (m *MappedImage) parseTIFF() error {
+	var order binary.ByteOrder = binary.LittleEndian
+	if m.data[0] == 'M' {
+		order = binary.BigEndian
+	}
+	if len(m.data) < 8 {
+		return io.ErrUnexpectedEOF
+	}
+	ifd := int(order.Uint32(m.data[4:]))
+	if ifd < 0 || ifd+2 > len(m.data) {
+		return io.ErrUnexpectedEOF
+	}
+	count := int(order.Uint16(m.data[ifd:]))
+	if ifd+2+12*count > len(m.data) {
+		return io.ErrUnexpectedEOF
+	}
+	// tags that are left out have the default values of the baseline
+	first := map[uint16]uint32{TAG_COMPRESSION: 1, TAG_SAMPLES_PER_PIXEL: 1, TAG_PLANAR_CONFIGURATION: 1,
+		TAG_ROWS_PER_STRIP: 1<<32 - 1}
+	var bitsPerSample, stripOffsets []uint32
+	for i := 0; i < count; i++ {
+		entry := m.data[ifd+2+12*i:]
+		tag := order.Uint16(entry)
+		switch tag {
+		case TAG_BITS_PER_SAMPLE:
+			bitsPerSample = readTIFFValues(m.data, entry, order)
+		case TAG_STRIP_OFFSETS:
+			stripOffsets = readTIFFArray(m.data, entry, order, len(m.data)/2)
+		default:
+			if values := readTIFFValues(m.data, entry, order); len(values) == 1 {
+				first[tag] = values[0]
+			}
+		}
+	}
+
+	width, height := int(first[TAG_IMAGE_WIDTH]), int(first[TAG_IMAGE_LENGTH])
+	channels, photometric := int(first[TAG_SAMPLES_PER_PIXEL]), first[TAG_PHOTOMETRIC]
+	if first[TAG_COMPRESSION] != 1 || first[TAG_PLANAR_CONFIGURATION] != 1 {
+		return errors.New("only uncompressed TIFF files with interleaved samples can be memory-mapped")
+	}
+	if !(channels == 1 && photometric <= 1) && !(channels == 3 && photometric == 2) {
+		return errors.New("only gray and RGB TIFF files can be memory-mapped")
+	}
+	for _, bits := range bitsPerSample {
+		if bits != 8 {
+			return errors.New("only TIFF files of 8 bits per sample can be memory-mapped")
+		}
+	}
+	rowsPerStrip := int(min(first[TAG_ROWS_PER_STRIP], uint32(height)))
+	if width <= 0 || height <= 0 || rowsPerStrip <= 0 || len(stripOffsets) < (height+rowsPerStrip-1)/rowsPerStrip {
+		return errors.New("tiff: invalid dimensions or strips")
+	}
+
+	if photometric == 0 {
+		// white is zero
+		m.identity = false
+		for i := range m.gray {
+			m.gray[i] = uint8(255 - i)
+		}
+	}
+	if channels == 3 {
+		m.channels, m.rgb = 3, [3]int{0, 1, 2}
+	}
+	m.Width, m.Height = width, height
+	m.offsets = make([]int, height)
+	for y := range m.offsets {
+		m.offsets[y] = int(stripOffsets[y/rowsPerStrip]) + y%rowsPerStrip*width*channels
+	}
+	return nil
+}
+
+// Close unmaps the file of the image, the rows returned by the image must not be used afterwards.
+func (m *MappedImage) Close() error {
+	return m.unmap()
+}
+
+// Size returns the width and height of the image.
+func (m *MappedImage) Size() (int, int) {
+	return m.Width, m.Height
+}
+
+// Rows returns the gray values of the rows from y0 up to y1. Rows of gray images whose samples are the gray values are
+// returned without copying, all others are converted like in decoded images.
+func (m *MappedImage) Rows(y0, y1 int) ([][]uint8, error) {
+	rows := make([][]uint8, y1-y0)
+	for y := range rows {
+		row := m.data[m.offsets[y0+y]:][:m.Width*m.channels]
+		if m.channels == 3 {
+			gray := make([]uint8, m.Width)
+			for x := range gray {
+				pixel := row[3*x:]
+				r, g, b := uint32(pixel[m.rgb[0]]), uint32(pixel[m.rgb[1]]), uint32(pixel[m.rgb[2]])
+				gray[x] = lumaPixel(r*0x101, g*0x101, b*0x101, 0xffff).y
+			}
+			row = gray
+		} else if !m.identity {
+			gray := make([]uint8, m.Width)
+			for x, v := range row {
+				gray[x] = m.gray[v]
+			}
+			row = gray
+		}
+		rows[y] = row
+	}
+	return rows, nil
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+)
+
+// MobileOptions are the parameters of DetectEdges. They only use types that gomobile can bind, so apps on Android and
+// iOS can set them directly. NewMobileOptions returns the defaults of the command line.
+type MobileOptions struct {
+	Blur         bool    // blur with a gaussian before detection
+	Sigma        float64 // standard deviation of the blur, zero uses the 5x5 binomial kernel
+	MinRatio     float64 // ratio of the lower threshold
+	MaxRatio     float64 // ratio of the upper threshold
+	Percentile   float64 // percentile of the gradients the ratios refer to, one is the maximum
+	MaxDimension int     // downscale images whose width or height exceeds this, zero disables the limit
+	Workers      int     // number of concurrent workers per stage, zero uses one per CPU
+	Format       string  // encoding of the result: png or jpeg
+}
+
+// NewMobileOptions returns the options of DetectEdges with the defaults of the command line.
+func NewMobileOptions() *MobileOptions {
+	return &MobileOptions{Blur: true, MinRatio: 0.2, MaxRatio: 0.6, Percentile: 1, Format: "png"}
+}
+
+// DetectEdges detects the edges of the encoded image in any supported input format and returns the edge image encoded
+// as PNG or JPEG. Unlike the command line it never exits the process, invalid input and parameters are returned as
+// errors and so are panics of the detection, which would take down the app. The bindings of gomobile are generated
+// from the mobile package, which wraps this function.
+func DetectEdges(data []byte, options *MobileOptions) (result []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("detection failed: %v", r)
+		}
+	}()
+	if options == nil {
+		options = NewMobileOptions()
+	}
+	if options.Format != "png" && options.Format != "jpeg" {
+		return nil, errors.New("format must be png or jpeg")
+	}
+	if options.MaxDimension < 0 || options.Workers < 0 {
+		return nil, errors.New("maximum dimension and workers must not be negative")
+	}
+	pixels, err := getPixelArray(bytes.NewReader(data), "")
+	if err != nil {
+		return nil, err
+	}
+	if len(pixels) == 0 || len(pixels[0]) == 0 {
+		return nil, errors.New("image is empty")
+	}
+	if options.MaxDimension > 0 {
+		pixels = downscalePixels(pixels, options.MaxDimension)
+	}
+
+	detector := NewDetector(options.Blur, options.MinRatio, options.MaxRatio)
+	detector.Sigma = options.Sigma
+	detector.Percentile = options.Percentile
+	if options.Workers > 0 {
+		detector.Workers = options.Workers
+	}
+	if issues := detector.Validate(len(pixels[0]), len(pixels), nil); hasValidationError(issues) {
+		return nil, errors.New(formatIssues(issues))
+	}
+
+	edges := getImageFromArray(detector.Detect(pixels))
+	var encoded bytes.Buffer
+	if options.Format == "png" {
+		err = png.Encode(&encoded, edges)
+	} else {
+		err = jpeg.Encode(&encoded, edges, &jpeg.Options{Quality: 95})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
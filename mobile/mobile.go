@@ -0,0 +1,45 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package mobile is the API of edgeefy for Android and iOS apps, generate the bindings with
+// gomobile bind github.com/slaufmann/edgeefy/mobile. It only uses types that gomobile can bind.
+package mobile
+
+import "github.com/slaufmann/edgeefy"
+
+// Options are the parameters of DetectEdges, see edgeefy.MobileOptions.
+type Options struct {
+	Blur         bool    // blur with a gaussian before detection
+	Sigma        float64 // standard deviation of the blur, zero uses the 5x5 binomial kernel
+	MinRatio     float64 // ratio of the lower threshold
+	MaxRatio     float64 // ratio of the upper threshold
+	Percentile   float64 // percentile of the gradients the ratios refer to, one is the maximum
+	MaxDimension int     // downscale images whose width or height exceeds this, zero disables the limit
+	Workers      int     // number of concurrent workers per stage, zero uses one per CPU
+	Format       string  // encoding of the result: png or jpeg
+}
+
+// NewOptions returns the options of DetectEdges with the defaults of the command line.
+func NewOptions() *Options {
+	return (*Options)(edgeefy.NewMobileOptions())
+}
+
+// DetectEdges detects the edges of the encoded image in any supported input format and returns the edge image encoded
+// as PNG or JPEG. Invalid input and parameters as well as failures of the detection are returned as errors.
+func DetectEdges(data []byte, options *Options) ([]byte, error) {
+	return edgeefy.DetectEdges(data, (*edgeefy.MobileOptions)(options))
+}
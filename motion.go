@@ -0,0 +1,130 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"math"
+)
+
+// motionCommand implements the motion subcommand. Edges are detected on the absolute difference of two frames, so only
+// the boundaries of objects that moved between the frames remain. If alignment is requested a global translation of the
+// camera is estimated first and compensated before the frames are compared.
+func motionCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("motion", flag.ExitOnError)
+	inputFileArgPtr := flags.String("input", "", "path to current frame (required)")
+	previousFileArgPtr := flags.String("previous", "", "path to previous frame (required)")
+	outputFileArgPtr := flags.String("output", "motion.png", "path to output file (optional, default: motion.png)")
+	alignArgPtr := flags.Int("align", 0, "compensate camera shifts of up to N pixels before comparing (optional, default: 0 = off)")
+	minThresholdArgPtr := flags.Float64("min", float64(0.2), "ratio of lower threshold (optional, default: 0.2)")
+	maxThresholdArgPtr := flags.Float64("max", float64(0.6), "ratio of upper threshold (optional, default: 0.6)")
+	return flags, func() {
+		if *inputFileArgPtr == "" || *previousFileArgPtr == "" {
+			fmt.Println("No path to input files specified, nothing to do.")
+			return
+		}
+		if !isValidRatioValue(*minThresholdArgPtr) || !isValidRatioValue(*maxThresholdArgPtr) {
+			fmt.Println("Invalid value for threshold ratio given, exiting.")
+			return
+		}
+		if *alignArgPtr < 0 {
+			fmt.Println("Invalid value for alignment range given, exiting.")
+			return
+		}
+
+		current := pixelsToSamples(openImage(*inputFileArgPtr, ""))
+		previous := pixelsToSamples(openImage(*previousFileArgPtr, ""))
+		if len(current) != len(previous) || len(current[0]) != len(previous[0]) {
+			fmt.Println("Frames differ in size, exiting.")
+			return
+		}
+
+		detector := NewDetector(true, *minThresholdArgPtr, *maxThresholdArgPtr)
+		shift := image.Point{}
+		if *alignArgPtr > 0 {
+			shift = estimateTranslation(previous, current, *alignArgPtr, detector.Workers)
+			fmt.Println("camera shift:", shift)
+		}
+		difference, valid := frameDifference(previous, current, shift)
+		writeImage(samplesToPixels(DetectSamples(detector, difference, valid)), *outputFileArgPtr)
+	}
+}
+
+// estimateTranslation returns the shift of at most maxShift pixels in both directions by which the content of the
+// current frame is displaced against the previous one. The shift with the smallest mean absolute difference of the
+// overlapping parts of both frames is chosen, every second pixel of the overlap is compared.
+func estimateTranslation[T Sample](previous, current [][]T, maxShift, workers int) image.Point {
+	var shifts []image.Point
+	for dy := -maxShift; dy <= maxShift; dy++ {
+		for dx := -maxShift; dx <= maxShift; dx++ {
+			shifts = append(shifts, image.Point{dx, dy})
+		}
+	}
+
+	costs := make([]float64, len(shifts))
+	parallelRows(len(shifts), workers, func(i int) {
+		shift := shifts[i]
+		sum, count := 0.0, 0
+		for y := max(0, shift.Y); y < min(len(current), len(current)+shift.Y); y += 2 {
+			for x := max(0, shift.X); x < min(len(current[y]), len(current[y])+shift.X); x += 2 {
+				sum += math.Abs(float64(current[y][x]) - float64(previous[y-shift.Y][x-shift.X]))
+				count++
+			}
+		}
+		costs[i] = math.Inf(1)
+		if count > 0 {
+			costs[i] = sum / float64(count)
+		}
+	})
+
+	best := len(shifts) / 2 // no shift
+	for i, cost := range costs {
+		if cost < costs[best] {
+			best = i
+		}
+	}
+	return shifts[best]
+}
+
+// frameDifference returns the absolute difference of the current frame and the previous frame displaced by the given
+// shift. Pixels of the current frame without a counterpart in the displaced previous frame are zero and marked as
+// invalid in the returned mask.
+func frameDifference[T Sample](previous, current [][]T, shift image.Point) ([][]T, [][]bool) {
+	difference := make([][]T, len(current))
+	valid := make([][]bool, len(current))
+	for y := range current {
+		difference[y] = make([]T, len(current[y]))
+		valid[y] = make([]bool, len(current[y]))
+		for x := range current[y] {
+			srcX, srcY := x-shift.X, y-shift.Y
+			if srcY < 0 || srcY >= len(previous) || srcX < 0 || srcX >= len(previous[srcY]) {
+				continue
+			}
+			a, b := current[y][x], previous[srcY][srcX]
+			if a > b {
+				difference[y][x] = a - b
+			} else {
+				difference[y][x] = b - a
+			}
+			valid[y][x] = true
+		}
+	}
+	return difference, valid
+}
@@ -0,0 +1,188 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// EDGE_FORMATS are the media types the server responds with edges in, the first one is the default.
+var EDGE_FORMATS = []string{"image/png", "image/jpeg", "image/svg+xml", "application/json"}
+
+// EDGE_FORMAT_NAMES maps the short names of the format query parameter to media types.
+var EDGE_FORMAT_NAMES = map[string]string{"png": "image/png", "jpg": "image/jpeg", "jpeg": "image/jpeg",
+	"svg": "image/svg+xml", "json": "application/json"}
+
+// ErrNotAcceptable is returned if none of the formats a client accepts is supported.
+var ErrNotAcceptable = errors.New("none of the accepted formats is supported")
+
+// EdgeReport is the JSON response of an edge image. Points lists the positions of all edge pixels row by row as
+// pairs of x and y.
+type EdgeReport struct {
+	Width   int      `json:"width"`
+	Height  int      `json:"height"`
+	Edges   int      `json:"edges"`   // number of edge pixels
+	Density float64  `json:"density"` // ratio of edge pixels
+	Points  [][2]int `json:"points"`
+}
+
+// negotiateEdgeFormat returns the media type of the given ones, usually EDGE_FORMATS, the edges are sent to the
+// client in. The format query parameter, a short name like svg or a media type, takes precedence over the Accept
+// header. Of the accepted formats the one with the highest quality wins, ties are decided by the order of the given
+// formats. Without either the first format is sent. ErrNotAcceptable is returned if no given format is accepted.
+func negotiateEdgeFormat(r *http.Request, formats []string) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		mediaType, ok := EDGE_FORMAT_NAMES[strings.ToLower(format)]
+		if !ok {
+			mediaType = strings.ToLower(format)
+		}
+		if !slices.Contains(EDGE_FORMATS, mediaType) {
+			return "", fmt.Errorf("unknown format %s, known are png, jpeg, svg and json", format)
+		}
+		if !slices.Contains(formats, mediaType) {
+			return "", fmt.Errorf("%w, supported are %s", ErrNotAcceptable, strings.Join(formats, ", "))
+		}
+		return mediaType, nil
+	}
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return formats[0], nil
+	}
+
+	best, bestQuality := "", 0.0
+	for _, format := range formats {
+		if quality := acceptQuality(accept, format); quality > bestQuality {
+			best, bestQuality = format, quality
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("%w, supported are %s", ErrNotAcceptable, strings.Join(formats, ", "))
+	}
+	return best, nil
+}
+
+// negotiateEdgeResponse negotiates the format like negotiateEdgeFormat. If it fails an error is sent and false is
+// returned.
+func negotiateEdgeResponse(w http.ResponseWriter, r *http.Request, formats []string) (string, bool) {
+	w.Header().Add("Vary", "Accept")
+	format, err := negotiateEdgeFormat(r, formats)
+	if errors.Is(err, ErrNotAcceptable) {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return "", false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return format, true
+}
+
+// acceptQuality returns the quality the given Accept header values give the given media type, zero if it isn't
+// accepted. The most specific matching range counts, e.g. image/png before image/* before */*.
+func acceptQuality(accept []string, mediaType string) float64 {
+	quality, specificity := 0.0, -1
+	for _, value := range accept {
+		for _, part := range strings.Split(value, ",") {
+			acceptedType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			var matched int
+			switch {
+			case acceptedType == mediaType:
+				matched = 2
+			case acceptedType == "*/*":
+				matched = 0
+			case strings.HasSuffix(acceptedType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(acceptedType, "*")):
+				matched = 1
+			default:
+				continue
+			}
+			if matched <= specificity {
+				continue
+			}
+			specificity, quality = matched, 1
+			if q, ok := params["q"]; ok {
+				if parsed, err := strconv.ParseFloat(q, 64); err == nil && parsed >= 0 && parsed <= 1 {
+					quality = parsed
+				}
+			}
+		}
+	}
+	return quality
+}
+
+// writeEdgeResponse sends the given edges in the given format of EDGE_FORMATS. SVG documents hold the traced contours
+// of the edges and JSON responses an EdgeReport.
+func writeEdgeResponse(w http.ResponseWriter, format string, edges [][]uint8) {
+	var encoded bytes.Buffer
+	var err error
+	switch format {
+	case "image/png":
+		err = png.Encode(&encoded, getImageFromArray(samplesToPixels(edges)))
+	case "image/jpeg":
+		err = jpeg.Encode(&encoded, getImageFromArray(samplesToPixels(edges)), &jpeg.Options{Quality: 95})
+	case "image/svg+xml":
+		err = writeContoursSVG(&encoded, TraceContours(edges), len(edges[0]), len(edges), 1)
+	case "application/json":
+		err = json.NewEncoder(&encoded).Encode(edgeReport(edges))
+	default:
+		err = fmt.Errorf("unsupported format %s", format)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", format)
+	w.Write(encoded.Bytes())
+}
+
+// edgeReport returns the JSON description of the given edges.
+func edgeReport(edges [][]uint8) EdgeReport {
+	report := EdgeReport{Width: len(edges[0]), Height: len(edges), Points: [][2]int{}}
+	for y := range edges {
+		for x, value := range edges[y] {
+			if value > 0 {
+				report.Points = append(report.Points, [2]int{x, y})
+			}
+		}
+	}
+	report.Edges = len(report.Points)
+	report.Density = edgeDensity(edges)
+	return report
+}
+
+// decodeEdges returns the samples of the given PNG encoded edge image.
+func decodeEdges(data []byte) ([][]uint8, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return pixelsToSamples(imageToPixelArray(img)), nil
+}
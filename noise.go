@@ -0,0 +1,200 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+)
+
+// NOISE_SAMPLES is the maximum number of pixels the noise of an image is estimated from. Larger images are sampled
+// on a regular grid.
+const NOISE_SAMPLES = 1 << 20
+
+// MAD_TO_SIGMA converts the median absolute deviation of normally distributed values to their standard deviation.
+const MAD_TO_SIGMA = 1.4826
+
+// NOISE_KERNEL is the high-pass kernel whose response the noise is estimated from. It cancels constant regions and
+// linear ramps, the response to white noise of standard deviation s has the standard deviation 6s.
+var NOISE_KERNEL = []float64{1, -2, 1, -2, 4, -2, 1, -2, 1}
+
+// NoiseEstimate describes the noise of an image.
+type NoiseEstimate struct {
+	Sigma     float64 // standard deviation of the noise in gray values
+	Pixels    int     // number of pixels the estimate is taken from
+	Quantized bool    // whether the image is nearly free of noise and Sigma is the noise of the quantization
+}
+
+// EstimateNoise estimates the noise of the given samples, which noise thresholds refer to. The standard deviation is
+// taken from the median absolute deviation of the response to NOISE_KERNEL. Unlike the mean absolute response the
+// median ignores the strong responses at edges and textures, so the estimate is robust as long as most of the image
+// is smooth. Only pixels whose neighbourhood is valid in the given mask are used, a nil mask marks all pixels as
+// valid. Integer samples are quantized, which adds noise of 1/sqrt(12) even to clean images, so that is the least
+// estimate for them.
+func EstimateNoise[T Sample](samples [][]T, valid [][]bool) NoiseEstimate {
+	height := len(samples)
+	if height < 3 || len(samples[0]) < 3 {
+		return NoiseEstimate{}
+	}
+	width := len(samples[0])
+	step := max(1, int(math.Sqrt(float64((width-2)*(height-2))/NOISE_SAMPLES)))
+	var residuals []float64
+	for y := 1; y < height-1; y += step {
+		for x := 1; x < width-1; x += step {
+			if !validNeighbourhood(valid, x, y) {
+				continue
+			}
+			var residual float64
+			for i, weight := range NOISE_KERNEL {
+				residual += weight * float64(samples[y+i/3-1][x+i%3-1])
+			}
+			residuals = append(residuals, residual)
+		}
+	}
+	estimate := NoiseEstimate{Sigma: MAD_TO_SIGMA * medianAbsoluteDeviation(residuals) / 6, Pixels: len(residuals)}
+	if quantization := 1 / math.Sqrt(12); !math.IsInf(sampleLimit[T](), 1) && estimate.Sigma < quantization {
+		estimate.Sigma, estimate.Quantized = quantization, true
+	}
+	return estimate
+}
+
+// validNeighbourhood checks whether the given pixel and its eight neighbours are valid in the given mask.
+func validNeighbourhood(valid [][]bool, x, y int) bool {
+	if valid == nil {
+		return true
+	}
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if !valid[y+dy][x+dx] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// medianAbsoluteDeviation returns the median of the absolute deviations of the given values from their median, zero
+// for no values. The values are reordered.
+func medianAbsoluteDeviation(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	slices.Sort(values)
+	median := values[len(values)/2]
+	for i, value := range values {
+		values[i] = math.Abs(value - median)
+	}
+	slices.Sort(values)
+	return values[len(values)/2]
+}
+
+// NOISE_GAIN_SIZE is the width and height of the noise the gain of the blur and the gradient is measured on.
+const NOISE_GAIN_SIZE = 128
+
+// noiseGain returns the standard deviation of a component of the gradient the detector computes from white noise of
+// unit standard deviation. The blur isn't linear for every filter, so the gain is measured on a fixed noise pattern
+// around a constant level like that of an image, with the Blurrer and GradientOperator of the detector if it has them.
+// The mean squared magnitude is the sum of the variances of both components of the gradient.
+func (d *Detector) noiseGain() float64 {
+	halo := d.stripHalo()
+	if d.Blur && d.BlurFilter != BOX && d.Sigma > IIR_SIGMA_THRESHOLD {
+		halo += int(math.Ceil(4 * d.Sigma)) // the recursive filter has no kernel to take the size from
+	}
+	random, _ := newRandom(1)
+	noise := make([][]float64, NOISE_GAIN_SIZE+2*halo)
+	for y := range noise {
+		noise[y] = make([]float64, NOISE_GAIN_SIZE+2*halo)
+		for x := range noise[y] {
+			noise[y][x] = 1000 + random.NormFloat64()
+		}
+	}
+	// the border is left out as the mirrored pixels aren't independent, custom stages may reach further than the halo
+	if d.Blurrer != nil && d.Blur {
+		noise = d.Blurrer.Blur(noise, nil)
+	} else {
+		noise = blurSamples(d, noise, nil)
+	}
+	var magnitude [][]float64
+	if d.GradientOperator != nil {
+		magnitude, _ = d.GradientOperator.Gradient(noise, nil)
+	} else {
+		magnitude, _ = sobel(noise, nil, d.Workers)
+	}
+	var sum float64
+	for y := halo; y < halo+NOISE_GAIN_SIZE; y++ {
+		for _, value := range magnitude[y][halo : halo+NOISE_GAIN_SIZE] {
+			sum += value * value
+		}
+	}
+	return math.Sqrt(sum / (2 * NOISE_GAIN_SIZE * NOISE_GAIN_SIZE))
+}
+
+// noiseThresholds returns the thresholds of a detector with noise thresholds for an image with the given noise, the
+// ratios of the detector multiplied by the standard deviation of the noise in the gradient.
+func noiseThresholds(d *Detector, noise float64) (float64, float64) {
+	scale := noise * d.noiseGain()
+	return d.MinRatio * scale, d.MaxRatio * scale
+}
+
+// noiseCommand implements the noise subcommand, which reports the estimated noise of the given image files, e.g. to
+// choose thresholds in multiples of the noise or the blur.
+func noiseCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("noise", flag.ExitOnError)
+	return flags, func() {
+		if flags.NArg() == 0 {
+			fmt.Println("No path to input file specified, nothing to do.")
+			return
+		}
+
+		gain := NewDetector(true, 0, 0).noiseGain()
+		for _, path := range flags.Args() {
+			fmt.Println(path)
+			samples, err := readSamples(path)
+			if err != nil {
+				fmt.Printf("  error: %v\n", err)
+				continue
+			}
+			estimate := EstimateNoise(samples, nil)
+			gray := convertSamples[float64](samples)
+			dark, bright := grayPercentiles(gray)
+			fmt.Printf("  noise: %.2f gray values, from %d pixels\n", estimate.Sigma, estimate.Pixels)
+			fmt.Printf("  contrast: %.0f gray values from the 1st to the 99th percentile, %.0f times the noise\n", bright-dark, (bright-dark)/estimate.Sigma)
+			fmt.Printf("  gradient noise with the default blur: %.2f, -noise-thresholds take -min and -max in multiples of it\n", gain*estimate.Sigma)
+			if estimate.Quantized {
+				fmt.Println("  note: the image is nearly free of noise, the estimate is the noise of the quantization to integers")
+			}
+		}
+	}
+}
+
+// readSamples returns the gray values of the image at the given path.
+func readSamples(path string) ([][]uint8, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // opened for reading, no error checking needed
+	pixels, err := getPixelArray(file, "")
+	if err != nil {
+		return nil, err
+	}
+	return pixelsToSamples(pixels), nil
+}
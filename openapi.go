@@ -0,0 +1,206 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"maps"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pathParameterPattern matches the parameters in the paths of the routes, e.g. {name}.
+var pathParameterPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPIGenerator builds the OpenAPI document of routes. The schemas of named struct types are collected as
+// components and referenced.
+type openAPIGenerator struct {
+	schemas map[string]any
+}
+
+// openAPIDocument returns the OpenAPI 3 document of the given routes. If authenticated is set the routes that aren't
+// public require an API key.
+func openAPIDocument(routes []ServerRoute, authenticated bool) map[string]any {
+	g := openAPIGenerator{schemas: make(map[string]any)}
+	paths := make(map[string]any)
+	for _, route := range routes {
+		operations, ok := paths[route.Path].(map[string]any)
+		if !ok {
+			operations = make(map[string]any)
+			paths[route.Path] = operations
+		}
+		operations[strings.ToLower(route.Method)] = g.operation(route, authenticated)
+	}
+
+	components := map[string]any{"schemas": g.schemas}
+	if authenticated {
+		components["securitySchemes"] = map[string]any{
+			"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+		}
+	}
+	return map[string]any{
+		"openapi":    "3.0.3",
+		"info":       map[string]any{"title": "edgeefy", "version": VERSION},
+		"paths":      paths,
+		"components": components,
+	}
+}
+
+// operation returns the OpenAPI operation of the given route.
+func (g *openAPIGenerator) operation(route ServerRoute, authenticated bool) map[string]any {
+	var parameters []any
+	for _, match := range pathParameterPattern.FindAllStringSubmatch(route.Path, -1) {
+		parameters = append(parameters, map[string]any{"name": match[1], "in": "path", "required": true,
+			"schema": map[string]any{"type": "string"}})
+	}
+	query := route.Query
+	if route.Edges {
+		query = map[string]string{"format": "format of the edges, one of png, jpeg, svg (traced contours) and json (edge points and counts) or their media type, overrides the Accept header"}
+		maps.Copy(query, route.Query)
+	}
+	for _, name := range sortedKeys(query) {
+		parameters = append(parameters, map[string]any{"name": name, "in": "query", "description": query[name],
+			"schema": map[string]any{"type": "string"}})
+	}
+
+	success := map[string]any{"description": http.StatusText(route.Status)}
+	if route.Edges {
+		content := make(map[string]any)
+		for _, format := range EDGE_FORMATS {
+			content[format] = map[string]any{"schema": g.contentSchema(format, EdgeReport{})}
+		}
+		success["content"] = content
+	} else if route.Response != "" {
+		success["content"] = map[string]any{route.Response: map[string]any{"schema": g.contentSchema(route.Response, route.Result)}}
+	}
+	responses := map[string]any{strconv.Itoa(route.Status): success}
+	errors := route.Errors
+	if authenticated && !route.Public {
+		errors = append(errors, http.StatusUnauthorized, http.StatusTooManyRequests)
+	}
+	for _, status := range errors {
+		responses[strconv.Itoa(status)] = map[string]any{"description": http.StatusText(status),
+			"content": map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}}}
+	}
+
+	operation := map[string]any{"summary": route.Summary, "responses": responses,
+		"operationId": operationID(route)}
+	if parameters != nil {
+		operation["parameters"] = parameters
+	}
+	if route.Request != "" {
+		operation["requestBody"] = map[string]any{"required": true,
+			"content": map[string]any{route.Request: map[string]any{"schema": g.contentSchema(route.Request, route.Body)}}}
+	}
+	if authenticated && !route.Public {
+		operation["security"] = []any{map[string]any{"bearer": []any{}}, map[string]any{"apiKey": []any{}}}
+	}
+	return operation
+}
+
+// contentSchema returns the schema of a body of the given content type, JSON bodies are described by the type of the
+// given value, SVG documents as text and others as binary data.
+func (g *openAPIGenerator) contentSchema(contentType string, value any) map[string]any {
+	if contentType == "image/svg+xml" {
+		return map[string]any{"type": "string"}
+	} else if contentType != "application/json" {
+		return map[string]any{"type": "string", "format": "binary"}
+	}
+	if value == nil {
+		return map[string]any{"type": "object"}
+	}
+	return g.schema(reflect.TypeOf(value))
+}
+
+// schema returns the JSON schema of the given type as it is encoded by encoding/json. Named struct types are added to
+// the components and referenced.
+func (g *openAPIGenerator) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(time.Duration(0)):
+		return map[string]any{"type": "integer", "format": "int64", "description": "duration in nanoseconds"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8,
+		reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if _, ok := g.schemas[t.Name()]; !ok {
+			g.schemas[t.Name()] = nil // guards against recursive types
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// structSchema returns the JSON schema of the exported fields of the given struct type.
+func (g *openAPIGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// operationID returns the ID of the operation of the given route, e.g. getSessionsName for GET /sessions/{name}.
+func operationID(route ServerRoute) string {
+	id := strings.ToLower(route.Method)
+	for _, part := range strings.FieldsFunc(route.Path, func(r rune) bool { return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build gocv
+
+package edgeefy
+
+import (
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+func init() {
+	compareOpenCV = openCVCanny
+}
+
+// openCVCanny runs gocv.Canny on the given pixels. The blur of the detector corresponds to a 5x5 gaussian kernel,
+// whose binomial weights OpenCV derives for sigma zero. OpenCV expects absolute thresholds on the L1 norm of the sobel
+// gradients, so the threshold ratios of the detector are applied to the maximum of that norm.
+func openCVCanny(pixels [][]GrayPixel, d *Detector) ([][]GrayPixel, error) {
+	src, err := PixelsToMat(pixels)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	if d.Blur {
+		if err := gocv.GaussianBlur(src, &src, image.Pt(5, 5), 0, 0, gocv.BorderDefault); err != nil {
+			return nil, err
+		}
+	}
+
+	// compute the maximum gradient the threshold ratios refer to
+	gradX, gradY := gocv.NewMat(), gocv.NewMat()
+	defer gradX.Close()
+	defer gradY.Close()
+	if err := gocv.Sobel(src, &gradX, gocv.MatTypeCV32F, 1, 0, 3, 1, 0, gocv.BorderDefault); err != nil {
+		return nil, err
+	}
+	if err := gocv.Sobel(src, &gradY, gocv.MatTypeCV32F, 0, 1, 3, 1, 0, gocv.BorderDefault); err != nil {
+		return nil, err
+	}
+	valuesX, err := gradX.DataPtrFloat32()
+	if err != nil {
+		return nil, err
+	}
+	valuesY, err := gradY.DataPtrFloat32()
+	if err != nil {
+		return nil, err
+	}
+	var maxGradient float32
+	for i := range valuesX {
+		maxGradient = max(maxGradient, float32(math.Abs(float64(valuesX[i]))+math.Abs(float64(valuesY[i]))))
+	}
+
+	edges := gocv.NewMat()
+	defer edges.Close()
+	if err := gocv.Canny(src, &edges, float32(d.MinRatio)*maxGradient, float32(d.MaxRatio)*maxGradient); err != nil {
+		return nil, err
+	}
+	return MatToPixels(edges)
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import "math"
+
+// ParameterMapFactors returns the threshold factors of a parameter map with values from 0 to 1. Brighter regions are
+// more sensitive: mid gray keeps the thresholds, white divides and black multiplies them by 2 to the power of the given
+// strength, so a strength of zero keeps the thresholds global.
+func ParameterMapFactors(values [][]float64, strength float64) [][]float64 {
+	factors := make([][]float64, len(values))
+	for y := range values {
+		factors[y] = make([]float64, len(values[y]))
+		for x, value := range values[y] {
+			factors[y][x] = math.Exp2(strength * (1 - 2*math.Min(math.Max(value, 0), 1)))
+		}
+	}
+	return factors
+}
+
+// grayMapValues returns the gray values of the given map image, such as a parameter or weight map, scaled to values
+// from 0 to 1.
+func grayMapValues(pixels [][]GrayPixel) [][]float64 {
+	values := make([][]float64, len(pixels))
+	for y := range pixels {
+		values[y] = make([]float64, len(pixels[y]))
+		for x := range pixels[y] {
+			values[y][x] = float64(pixels[y][x].y) / 255
+		}
+	}
+	return values
+}
+
+// resampleFactors returns the given threshold factors or weights resampled to the given size by taking the nearest
+// factor, e.g. for a downscaled copy of the image.
+func resampleFactors(factors [][]float64, width, height int) [][]float64 {
+	result := make([][]float64, height)
+	for y := range result {
+		result[y] = make([]float64, width)
+		for x := range result[y] {
+			result[y][x] = factors[y*len(factors)/height][x*len(factors[0])/width]
+		}
+	}
+	return result
+}
+
+// divideByFactors returns the given magnitude divided by the given threshold factors. Comparing the result with the
+// global thresholds is the same as comparing the magnitude with the thresholds multiplied by the factors. Integer
+// samples that would overflow are saturated, which keeps them above every threshold.
+func divideByFactors[T Sample](magnitude [][]T, factors [][]float64) [][]T {
+	limit := sampleLimit[T]()
+	result := make([][]T, len(magnitude))
+	for y := range magnitude {
+		result[y] = make([]T, len(magnitude[y]))
+		for x, value := range magnitude[y] {
+			result[y][x] = T(math.Min(float64(value)/factors[y][x], limit))
+		}
+	}
+	return result
+}
+
+// restoreMagnitude sets the edge pixels of the given edge image, which was tracked on the divided magnitude, back to
+// the given magnitude.
+func restoreMagnitude[T Sample](edges, magnitude [][]T) {
+	for y := range edges {
+		for x := range edges[y] {
+			if edges[y][x] > 0 {
+				edges[y][x] = magnitude[y][x]
+			}
+		}
+	}
+}
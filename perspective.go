@@ -0,0 +1,213 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"sort"
+)
+
+// rectifyCommand implements the rectify subcommand. The largest quadrilateral outline in the edges of a photo, e.g. of
+// a whiteboard or receipt, is found and the area inside it is written perspective corrected as rectangular image.
+func rectifyCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("rectify", flag.ExitOnError)
+	inputFileArgPtr := flags.String("input", "", "path to input file (required)")
+	outputFileArgPtr := flags.String("output", "rectified.png", "path to output file (optional, default: rectified.png)")
+	minAreaArgPtr := flags.Float64("min-area", 0.1, "minimum area of the quadrilateral as ratio of the image area (optional, default: 0.1)")
+	minThresholdArgPtr := flags.Float64("min", float64(0.2), "ratio of lower threshold (optional, default: 0.2)")
+	maxThresholdArgPtr := flags.Float64("max", float64(0.6), "ratio of upper threshold (optional, default: 0.6)")
+	return flags, func() {
+		if *inputFileArgPtr == "" {
+			fmt.Println("No path to input file specified, nothing to do.")
+			return
+		}
+		if !isValidRatioValue(*minThresholdArgPtr) || !isValidRatioValue(*maxThresholdArgPtr) || !isValidRatioValue(*minAreaArgPtr) {
+			fmt.Println("Invalid value for ratio given, exiting.")
+			return
+		}
+
+		file, err := os.Open(*inputFileArgPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close() // opened for reading, no error checking needed
+		img, err := decodeInput(file, "")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		detector := NewDetector(true, *minThresholdArgPtr, *maxThresholdArgPtr)
+		edges := DetectSamples(detector, pixelsToSamples(imageToPixelArray(img)), nil)
+		quad, found := findLargestQuadrilateral(edges)
+		bounds := img.Bounds()
+		if !found || polygonArea(quad[:]) < *minAreaArgPtr*float64(bounds.Dx()*bounds.Dy()) {
+			fmt.Println("No quadrilateral found, exiting.")
+			return
+		}
+		fmt.Println("corners:", quad[0], quad[1], quad[2], quad[3])
+		rectified, err := rectifyQuadrilateral(img, quad)
+		if err != nil {
+			log.Fatal(err)
+		}
+		writeColorImage(rectified, *outputFileArgPtr)
+	}
+}
+
+// findLargestQuadrilateral returns the corners of the largest convex quadrilateral that approximates the convex hull
+// of one of the connected edge segments of the given edge image. Segments are used instead of traced contours since an
+// outline is often traced as several contours. The corners are ordered top left, top right, bottom right, bottom left.
+func findLargestQuadrilateral[T Sample](edges [][]T) ([4]image.Point, bool) {
+	// collect the pixels of every segment
+	labels := labelComponents(edges)
+	segments := make(map[int][]image.Point)
+	for y := range labels {
+		for x, label := range labels[y] {
+			if label > 0 {
+				segments[label] = append(segments[label], image.Point{x, y})
+			}
+		}
+	}
+
+	var best [4]image.Point
+	bestArea := 0.0
+	for label := 1; label <= len(segments); label++ {
+		hull := ConvexHull(Contour{Points: segments[label]})
+		if len(hull.Points) < 4 {
+			continue
+		}
+		// increase the tolerance until the hull is reduced to four corners
+		perimeter := polygonPerimeter(hull.Points)
+		for ratio := 0.01; ratio <= 0.1; ratio += 0.01 {
+			approx := ApproxPolygon(hull, ratio*perimeter)
+			if len(approx.Points) < 4 {
+				break
+			}
+			if len(approx.Points) > 4 {
+				continue
+			}
+			if area := polygonArea(approx.Points); area > bestArea {
+				best, bestArea = orderCorners(approx.Points), area
+			}
+			break
+		}
+	}
+	return best, bestArea > 0
+}
+
+// polygonArea returns the area enclosed by the given points using the shoelace formula.
+func polygonArea(points []image.Point) float64 {
+	sum := 0
+	for i, p := range points {
+		q := points[(i+1)%len(points)]
+		sum += p.X*q.Y - q.X*p.Y
+	}
+	return math.Abs(float64(sum)) / 2
+}
+
+// polygonPerimeter returns the length of the closed polygon through the given points.
+func polygonPerimeter(points []image.Point) float64 {
+	length := 0.0
+	for i, p := range points {
+		length += pointDistance(p, points[(i+1)%len(points)])
+	}
+	return length
+}
+
+// orderCorners orders the four given corners of a convex quadrilateral as top left, top right, bottom right and bottom
+// left. The corners are sorted clockwise by their angle around the centroid, starting at the corner with the smallest
+// sum of coordinates.
+func orderCorners(points []image.Point) [4]image.Point {
+	var centerX, centerY float64
+	for _, p := range points {
+		centerX += float64(p.X) / 4
+		centerY += float64(p.Y) / 4
+	}
+	sorted := append([]image.Point(nil), points...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return math.Atan2(float64(sorted[i].Y)-centerY, float64(sorted[i].X)-centerX) <
+			math.Atan2(float64(sorted[j].Y)-centerY, float64(sorted[j].X)-centerX)
+	})
+	first := 0
+	for i, p := range sorted {
+		if p.X+p.Y < sorted[first].X+sorted[first].Y {
+			first = i
+		}
+	}
+	var corners [4]image.Point
+	for i := range corners {
+		corners[i] = sorted[(first+i)%4]
+	}
+	return corners
+}
+
+// rectifyQuadrilateral maps the area inside the given corners of the image to a rectangle. The size of the rectangle
+// is given by the longer of each pair of opposite sides.
+func rectifyQuadrilateral(img image.Image, corners [4]image.Point) (*image.RGBA, error) {
+	width := int(math.Max(pointDistance(corners[0], corners[1]), pointDistance(corners[3], corners[2])))
+	height := int(math.Max(pointDistance(corners[0], corners[3]), pointDistance(corners[1], corners[2])))
+	target := [4]image.Point{{0, 0}, {width - 1, 0}, {width - 1, height - 1}, {0, height - 1}}
+	homography, err := computeHomography(target, corners)
+	if err != nil {
+		return nil, err
+	}
+
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			srcX, srcY := applyHomography(homography, float64(x), float64(y))
+			result.SetRGBA(x, y, sampleBilinear(img, srcX, srcY, color.White))
+		}
+	}
+	return result, nil
+}
+
+// computeHomography returns the 3x3 projective transformation with the bottom right entry one that maps the four given
+// source points to the four given destination points. The eight remaining entries are obtained from the linear system
+// that the point pairs give.
+func computeHomography(from, to [4]image.Point) ([9]float64, error) {
+	var h [9]float64
+	a := make([][]float64, 8)
+	b := make([]float64, 8)
+	for i := range from {
+		x, y := float64(from[i].X), float64(from[i].Y)
+		u, v := float64(to[i].X), float64(to[i].Y)
+		a[2*i] = []float64{x, y, 1, 0, 0, 0, -u * x, -u * y}
+		a[2*i+1] = []float64{0, 0, 0, x, y, 1, -v * x, -v * y}
+		b[2*i], b[2*i+1] = u, v
+	}
+	solution, err := solveLinear(a, b)
+	if err != nil {
+		return h, errors.New("corners don't span a quadrilateral")
+	}
+	copy(h[:8], solution)
+	h[8] = 1
+	return h, nil
+}
+
+// applyHomography maps the given point with the given projective transformation.
+func applyHomography(h [9]float64, x, y float64) (float64, float64) {
+	w := h[6]*x + h[7]*y + h[8]
+	return (h[0]*x + h[1]*y + h[2]) / w, (h[3]*x + h[4]*y + h[5]) / w
+}
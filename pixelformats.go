@@ -0,0 +1,110 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"image"
+	"image/color"
+)
+
+// directToPixelArray converts the given image like imageToPixelArray, reading the pixel data of common image types
+// directly from their buffers instead of going through the color.Color interface. The second return value is false if
+// the type of the image has no direct conversion or its bounds don't start at the origin.
+func directToPixelArray(img image.Image) ([][]GrayPixel, bool) {
+	pixel, ok := directPixelFunc(img)
+	if !ok {
+		return nil, false
+	}
+	return buildPixelArray(img.Bounds(), pixel), true
+}
+
+// pixelFunc returns the conversion of single pixels of the given image that imageToPixelArray applies, so images can
+// be converted row by row without building the pixel array.
+func pixelFunc(img image.Image) func(x, y int) GrayPixel {
+	if pixel, ok := directPixelFunc(img); ok {
+		return pixel
+	}
+	return func(x, y int) GrayPixel {
+		return rgbaToGrayPixel(img.At(x, y))
+	}
+}
+
+// directPixelFunc returns the conversion of single pixels of directToPixelArray. The second return value is false if
+// the type of the image has no direct conversion or its bounds don't start at the origin.
+func directPixelFunc(img image.Image) (func(x, y int) GrayPixel, bool) {
+	if img.Bounds().Min != (image.Point{}) {
+		return nil, false
+	}
+	switch img := img.(type) {
+	case *image.Paletted:
+		return palettedPixelFunc(img), true
+	case *image.Gray:
+		return func(x, y int) GrayPixel {
+			return GrayPixel{img.Pix[img.PixOffset(x, y)], 255}
+		}, true
+	case *image.RGBA:
+		return func(x, y int) GrayPixel {
+			i := img.PixOffset(x, y)
+			return lumaPixel(color.RGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}.RGBA())
+		}, true
+	case *image.NRGBA:
+		return func(x, y int) GrayPixel {
+			i := img.PixOffset(x, y)
+			return lumaPixel(color.NRGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}.RGBA())
+		}, true
+	case *image.YCbCr:
+		// the Y plane of decoded JPEG images already is the luma, so chroma is ignored and no color conversion is
+		// needed. It only differs from color.GrayModel for saturated colors whose RGB values would be clipped.
+		return func(x, y int) GrayPixel {
+			return GrayPixel{img.Y[img.YOffset(x, y)], 255}
+		}, true
+	}
+	return nil, false
+}
+
+// palettedPixelFunc returns the conversion of single pixels of the given paletted image. Every palette entry is
+// converted to gray only once and the pixels look up their conversion by index, which is much faster for GIF and PNG8
+// images.
+func palettedPixelFunc(img *image.Paletted) func(x, y int) GrayPixel {
+	// indices without palette entry are transparent black
+	var grays [256]GrayPixel
+	for i, c := range img.Palette {
+		grays[i] = rgbaToGrayPixel(c)
+	}
+	return func(x, y int) GrayPixel {
+		return grays[img.Pix[img.PixOffset(x, y)]]
+	}
+}
+
+// buildPixelArray returns a pixel array in the layout of imageToPixelArray for the given bounds, filled with the
+// results of the given function for every position.
+func buildPixelArray(bounds image.Rectangle, pixel func(x, y int) GrayPixel) [][]GrayPixel {
+	pixelArr := make([][]GrayPixel, bounds.Max.Y)
+	for y := range pixelArr {
+		pixelArr[y] = make([]GrayPixel, bounds.Max.X)
+		for x := range pixelArr[y] {
+			pixelArr[y][x] = pixel(x, y)
+		}
+	}
+	return pixelArr
+}
+
+// lumaPixel returns the GrayPixel of the given 16-bit color components with the same luma as color.GrayModel.
+func lumaPixel(r, g, b, a uint32) GrayPixel {
+	return GrayPixel{uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24), uint8(a >> 8)}
+}
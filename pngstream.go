@@ -0,0 +1,151 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// PNG_IDAT_SIZE is the amount of compressed data that is collected before it is written as IDAT chunk.
+const PNG_IDAT_SIZE = 1 << 16
+
+// PNGStreamWriter writes an 8-bit grayscale PNG image row by row. Only the compressed data of the current IDAT chunk
+// is buffered, so images can be written while their rows are produced without holding the whole image in memory.
+type PNGStreamWriter struct {
+	w      io.Writer
+	width  int
+	height int
+	rows   int
+	idat   *idatWriter
+	zlib   *zlib.Writer
+	line   []byte
+}
+
+// idatWriter collects compressed image data and writes it as IDAT chunks of PNG_IDAT_SIZE bytes.
+type idatWriter struct {
+	w      io.Writer
+	buffer []byte
+}
+
+// Write appends the given data to the current chunk and writes all complete chunks.
+func (c *idatWriter) Write(data []byte) (int, error) {
+	c.buffer = append(c.buffer, data...)
+	for len(c.buffer) >= PNG_IDAT_SIZE {
+		if err := writePNGChunk(c.w, "IDAT", c.buffer[:PNG_IDAT_SIZE]); err != nil {
+			return 0, err
+		}
+		c.buffer = append(c.buffer[:0], c.buffer[PNG_IDAT_SIZE:]...)
+	}
+	return len(data), nil
+}
+
+// flush writes the remaining data as last IDAT chunk.
+func (c *idatWriter) flush() error {
+	if len(c.buffer) == 0 {
+		return nil
+	}
+	err := writePNGChunk(c.w, "IDAT", c.buffer)
+	c.buffer = c.buffer[:0]
+	return err
+}
+
+// NewPNGStreamWriter writes the PNG signature and header for a grayscale image of the given size to w and returns a
+// writer that accepts the rows of the image.
+func NewPNGStreamWriter(w io.Writer, width, height int) (*PNGStreamWriter, error) {
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("image dimensions must be positive")
+	}
+	if _, err := io.WriteString(w, PNG_SIGNATURE); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 13)
+	binary.BigEndian.PutUint32(header[0:], uint32(width))
+	binary.BigEndian.PutUint32(header[4:], uint32(height))
+	header[8] = 8 // bit depth, the color type zero denotes grayscale
+	if err := writePNGChunk(w, "IHDR", header); err != nil {
+		return nil, err
+	}
+
+	idat := &idatWriter{w: w}
+	return &PNGStreamWriter{w: w, width: width, height: height, idat: idat, zlib: zlib.NewWriter(idat),
+		line: make([]byte, width+1)}, nil
+}
+
+// WriteText writes a tEXt chunk with the given keyword and text. Text chunks must be written before the first row.
+func (p *PNGStreamWriter) WriteText(keyword, text string) error {
+	if p.rows > 0 {
+		return errors.New("text must be written before the image data")
+	}
+	return writePNGChunk(p.w, "tEXt", []byte(keyword+"\x00"+text))
+}
+
+// WriteRow compresses the next row of gray values. The row must have the width of the image.
+func (p *PNGStreamWriter) WriteRow(row []uint8) error {
+	if len(row) != p.width {
+		return errors.New("row length doesn't match image width")
+	}
+	if p.rows == p.height {
+		return errors.New("all rows of the image have been written")
+	}
+	p.line[0] = 0 // no filter, edge images are sparse and compress well without
+	copy(p.line[1:], row)
+	p.rows++
+	_, err := p.zlib.Write(p.line)
+	return err
+}
+
+// Close finishes the compressed data and writes the end of the image. All rows must have been written.
+func (p *PNGStreamWriter) Close() error {
+	if p.rows != p.height {
+		return errors.New("image is incomplete")
+	}
+	if err := p.zlib.Close(); err != nil {
+		return err
+	}
+	if err := p.idat.flush(); err != nil {
+		return err
+	}
+	return writePNGChunk(p.w, "IEND", nil)
+}
+
+// writePNGStreamed writes the given pixels as grayscale PNG row by row, without building an image first. The given
+// metadata is written as text chunks.
+func writePNGStreamed(w io.Writer, pixels [][]GrayPixel, metadata map[string]string) error {
+	stream, err := NewPNGStreamWriter(w, len(pixels[0]), len(pixels))
+	if err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(metadata) {
+		if err := stream.WriteText(key, metadata[key]); err != nil {
+			return err
+		}
+	}
+	row := make([]uint8, len(pixels[0]))
+	for y := range pixels {
+		for x := range pixels[y] {
+			row[x] = pixels[y][x].y
+		}
+		if err := stream.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return stream.Close()
+}
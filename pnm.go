@@ -0,0 +1,183 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+)
+
+// pnmHeader holds the fields of the header of a binary PGM or PPM file.
+type pnmHeader struct {
+	magic  string // P5 for gray, P6 for rgb
+	width  int
+	height int
+	maxval int
+}
+
+// readPNMHeader parses the header of a binary PGM or PPM file. The reader is positioned at the start of the pixel
+// data afterwards.
+func readPNMHeader(r *bufio.Reader) (pnmHeader, error) {
+	var header pnmHeader
+	var fields []string
+	for len(fields) < 4 {
+		token, err := readPNMToken(r)
+		if err != nil {
+			return header, err
+		}
+		fields = append(fields, token)
+	}
+	header.magic = fields[0]
+	if header.magic != "P5" && header.magic != "P6" {
+		return header, errors.New("only binary PGM and PPM files are supported")
+	}
+	values := make([]int, 3)
+	for i := range values {
+		value, err := strconv.Atoi(fields[i+1])
+		if err != nil || value <= 0 {
+			return header, errors.New("invalid PNM header")
+		}
+		values[i] = value
+	}
+	header.width, header.height, header.maxval = values[0], values[1], values[2]
+	if header.maxval > 65535 {
+		return header, errors.New("invalid PNM header")
+	}
+	return header, nil
+}
+
+// readPNMToken returns the next whitespace separated token of a PNM header, comments are skipped. The single
+// whitespace character following the token is consumed.
+func readPNMToken(r *bufio.Reader) (string, error) {
+	var token []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case c == '#' && len(token) == 0:
+			if _, err := r.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		default:
+			token = append(token, c)
+		}
+	}
+}
+
+// decodePNM decodes a binary PGM or PPM image. Gray images become Gray or Gray16 images and color images become RGBA
+// or RGBA64 images depending on the maximum value, which is scaled to the full range of the result.
+func decodePNM(r io.Reader) (image.Image, error) {
+	return readPNMImage(bufio.NewReader(r))
+}
+
+// readPNMImage decodes a binary PGM or PPM image like decodePNM from the given buffered reader, which is positioned
+// right after the image afterwards. This allows reading a stream of concatenated images.
+func readPNMImage(buffered *bufio.Reader) (image.Image, error) {
+	header, err := readPNMHeader(buffered)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDecodeSize(header.width, header.height); err != nil {
+		return nil, err
+	}
+	return readPNMRows(buffered, header, header.height)
+}
+
+// readPNMRows decodes the given number of rows of the pixel data of a PNM image with the given header as image of
+// that height. The reader is positioned right after the rows afterwards, so large images can be read in bands.
+func readPNMRows(buffered *bufio.Reader, header pnmHeader, rows int) (image.Image, error) {
+	channels := 1
+	if header.magic == "P6" {
+		channels = 3
+	}
+	bytesPerValue := 1
+	if header.maxval > 255 {
+		bytesPerValue = 2
+	}
+	data := make([]byte, header.width*rows*channels*bytesPerValue)
+	if _, err := io.ReadFull(buffered, data); err != nil {
+		return nil, err
+	}
+
+	// value returns the sample with the given index scaled to 16 bits
+	value := func(i int) uint16 {
+		v := int(data[i])
+		if bytesPerValue == 2 {
+			v = int(data[2*i])<<8 | int(data[2*i+1])
+		}
+		return uint16(v * 65535 / header.maxval)
+	}
+	bounds := image.Rect(0, 0, header.width, rows)
+	switch {
+	case channels == 1 && bytesPerValue == 1:
+		img := image.NewGray(bounds)
+		for i := range img.Pix {
+			img.Pix[i] = uint8(value(i) >> 8)
+		}
+		return img, nil
+	case channels == 1:
+		img := image.NewGray16(bounds)
+		for i := 0; i < header.width*rows; i++ {
+			img.SetGray16(i%header.width, i/header.width, color.Gray16{value(i)})
+		}
+		return img, nil
+	case bytesPerValue == 1:
+		img := image.NewRGBA(bounds)
+		for i := 0; i < header.width*rows; i++ {
+			img.Pix[4*i] = uint8(value(3*i) >> 8)
+			img.Pix[4*i+1] = uint8(value(3*i+1) >> 8)
+			img.Pix[4*i+2] = uint8(value(3*i+2) >> 8)
+			img.Pix[4*i+3] = 255
+		}
+		return img, nil
+	default:
+		img := image.NewRGBA64(bounds)
+		for i := 0; i < header.width*rows; i++ {
+			img.SetRGBA64(i%header.width, i/header.width, color.RGBA64{value(3 * i), value(3*i + 1), value(3*i + 2), 65535})
+		}
+		return img, nil
+	}
+}
+
+// decodePNMConfig returns the dimensions and color model of a binary PGM or PPM image.
+func decodePNMConfig(r io.Reader) (image.Config, error) {
+	header, err := readPNMHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	model := color.GrayModel
+	switch {
+	case header.magic == "P5" && header.maxval > 255:
+		model = color.Gray16Model
+	case header.magic == "P6" && header.maxval > 255:
+		model = color.RGBA64Model
+	case header.magic == "P6":
+		model = color.RGBAModel
+	}
+	return image.Config{ColorModel: model, Width: header.width, Height: header.height}, nil
+}
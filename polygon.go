@@ -0,0 +1,146 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// ApproxPolygon simplifies the given contour with the Ramer-Douglas-Peucker algorithm. All points of the contour lie
+// within the given distance epsilon of the simplified contour. For closed contours the chain is split at the point
+// farthest from the first point so that both halves are simplified as open chains.
+func ApproxPolygon(contour Contour, epsilon float64) Contour {
+	points := contour.Points
+	if len(points) < 3 || epsilon <= 0 {
+		return contour
+	}
+	if !contour.Closed {
+		return Contour{rdp(points, epsilon), false}
+	}
+
+	// split closed contours at the point farthest from the start
+	split := 0
+	farthest := -1.0
+	for i, p := range points {
+		if d := pointDistance(points[0], p); d > farthest {
+			split, farthest = i, d
+		}
+	}
+	first := rdp(points[:split+1], epsilon)
+	second := rdp(append(append([]image.Point(nil), points[split:]...), points[0]), epsilon)
+	simplified := append(first, second[1:len(second)-1]...)
+
+	return Contour{simplified, true}
+}
+
+// rdp simplifies an open chain of points, keeping its first and last point.
+func rdp(points []image.Point, epsilon float64) []image.Point {
+	if len(points) < 3 {
+		return append([]image.Point(nil), points...)
+	}
+	// find the point farthest from the line between the end points
+	index := 0
+	maxDistance := 0.0
+	last := len(points) - 1
+	for i := 1; i < last; i++ {
+		if d := segmentDistance(points[i], points[0], points[last]); d > maxDistance {
+			index, maxDistance = i, d
+		}
+	}
+	if maxDistance <= epsilon {
+		return []image.Point{points[0], points[last]}
+	}
+	// keep the farthest point and simplify both parts recursively
+	left := rdp(points[:index+1], epsilon)
+	right := rdp(points[index:], epsilon)
+	return append(left[:len(left)-1], right...)
+}
+
+// ConvexHull returns the convex hull of the points of the given contour as closed contour in counter-clockwise order
+// (in image coordinates with the y axis pointing down the order appears clockwise). Andrew's monotone chain algorithm
+// is used.
+func ConvexHull(contour Contour) Contour {
+	points := append([]image.Point(nil), contour.Points...)
+	if len(points) < 3 {
+		return Contour{points, false}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].X < points[j].X || (points[i].X == points[j].X && points[i].Y < points[j].Y)
+	})
+
+	var hull []image.Point
+	// build lower hull
+	for _, p := range points {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	// build upper hull
+	lower := len(hull) + 1
+	for i := len(points) - 2; i >= 0; i-- {
+		p := points[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+
+	// the last point equals the first one
+	return Contour{hull[:len(hull)-1], true}
+}
+
+// cross returns the z component of the cross product of the vectors o->a and o->b.
+func cross(o, a, b image.Point) int {
+	return (a.X-o.X)*(b.Y-o.Y) - (a.Y-o.Y)*(b.X-o.X)
+}
+
+// pointDistance returns the euclidean distance between the two given points.
+func pointDistance(p, q image.Point) float64 {
+	return math.Hypot(float64(p.X-q.X), float64(p.Y-q.Y))
+}
+
+// segmentDistance returns the distance of point p to the line segment between a and b.
+func segmentDistance(p, a, b image.Point) float64 {
+	dx, dy := float64(b.X-a.X), float64(b.Y-a.Y)
+	lengthSq := dx*dx + dy*dy
+	if lengthSq == 0 {
+		return pointDistance(p, a)
+	}
+	t := (float64(p.X-a.X)*dx + float64(p.Y-a.Y)*dy) / lengthSq
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(float64(p.X)-(float64(a.X)+t*dx), float64(p.Y)-(float64(a.Y)+t*dy))
+}
+
+// simplifyContours applies the polygon helpers to all given contours. If epsilon is positive the contours are
+// approximated by polygons, if hull is set every contour is replaced by its convex hull.
+func simplifyContours(contours []Contour, epsilon float64, hull bool) []Contour {
+	result := make([]Contour, len(contours))
+	for i, contour := range contours {
+		if hull {
+			contour = ConvexHull(contour)
+		}
+		if epsilon > 0 {
+			contour = ApproxPolygon(contour, epsilon)
+		}
+		result[i] = contour
+	}
+	return result
+}
@@ -0,0 +1,213 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"image"
+	"math"
+)
+
+// despeckle removes all edge pixels that have less than the given number of edge pixels among their eight neighbours.
+// Edge pixels are all pixels with a value above zero. The neighbour counts are taken from the unmodified edge image,
+// so removing a pixel doesn't affect its neighbours. The edge image is modified in place.
+func despeckle[T Sample](edges [][]T, minNeighbours int) {
+	if minNeighbours <= 0 {
+		return
+	}
+	var speckles [][2]int
+	for y := range edges {
+		for x := range edges[y] {
+			if edges[y][x] > 0 && countEdgeNeighbours(edges, x, y) < minNeighbours {
+				speckles = append(speckles, [2]int{x, y})
+			}
+		}
+	}
+	for _, s := range speckles {
+		edges[s[1]][s[0]] = 0
+	}
+}
+
+// countEdgeNeighbours returns the number of edge pixels among the eight neighbours of the given position.
+func countEdgeNeighbours[T Sample](edges [][]T, x, y int) int {
+	count := 0
+	for i := y - 1; i <= y+1; i++ {
+		for j := x - 1; j <= x+1; j++ {
+			if i < 0 || i >= len(edges) || j < 0 || j >= len(edges[i]) || (i == y && j == x) {
+				continue
+			}
+			if edges[i][j] > 0 {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// BRIDGE_TRACE_LENGTH is the number of pixels that are followed along a segment to estimate the direction at its end.
+const BRIDGE_TRACE_LENGTH = 5
+
+// bridgeGaps connects endpoints of edge segments with endpoints of other segments that lie within the given distance.
+// An endpoint is only connected if the direction towards the other endpoint deviates at most by the given angle in
+// degrees from the direction the segment runs out in. The connecting lines are drawn with the maximum value of the
+// edge image, which is modified in place.
+func bridgeGaps[T Sample](edges [][]T, maxDistance, maxAngle float64) {
+	if maxDistance <= 0 {
+		return
+	}
+	labels := labelComponents(edges)
+	endpoints := findEndpoints(edges)
+	value := maxPixelValue(edges)
+	cosLimit := math.Cos(maxAngle * math.Pi / 180)
+
+	for _, p := range endpoints {
+		dirX, dirY := endpointDirection(edges, p)
+		if dirX == 0 && dirY == 0 {
+			continue // single pixel, no direction to extend
+		}
+		dirLength := math.Hypot(dirX, dirY)
+		best := -1
+		bestDistance := maxDistance
+		for i, q := range endpoints {
+			if labels[q.Y][q.X] == labels[p.Y][p.X] {
+				continue // never close a gap within the same segment
+			}
+			dx, dy := float64(q.X-p.X), float64(q.Y-p.Y)
+			distance := math.Hypot(dx, dy)
+			if distance > bestDistance {
+				continue
+			}
+			if (dx*dirX+dy*dirY)/(distance*dirLength) < cosLimit {
+				continue // outside of the angular tolerance
+			}
+			best = i
+			bestDistance = distance
+		}
+		if best >= 0 {
+			drawLine(edges, p, endpoints[best], value)
+		}
+	}
+}
+
+// findEndpoints returns the positions of all edge pixels that have at most one neighbouring edge pixel.
+func findEndpoints[T Sample](edges [][]T) []image.Point {
+	var endpoints []image.Point
+	for y := range edges {
+		for x := range edges[y] {
+			if edges[y][x] > 0 && countEdgeNeighbours(edges, x, y) <= 1 {
+				endpoints = append(endpoints, image.Point{x, y})
+			}
+		}
+	}
+	return endpoints
+}
+
+// endpointDirection estimates the direction in which the segment with the given endpoint runs out. The segment is
+// followed for BRIDGE_TRACE_LENGTH pixels and the vector from the last visited pixel to the endpoint is returned.
+func endpointDirection[T Sample](edges [][]T, endpoint image.Point) (float64, float64) {
+	visited := map[image.Point]bool{endpoint: true}
+	current := endpoint
+	for step := 0; step < BRIDGE_TRACE_LENGTH; step++ {
+		next, found := current, false
+		for i := current.Y - 1; i <= current.Y+1 && !found; i++ {
+			for j := current.X - 1; j <= current.X+1 && !found; j++ {
+				candidate := image.Point{j, i}
+				if i < 0 || i >= len(edges) || j < 0 || j >= len(edges[i]) || visited[candidate] {
+					continue
+				}
+				if edges[i][j] > 0 {
+					next, found = candidate, true
+				}
+			}
+		}
+		if !found {
+			break
+		}
+		visited[next] = true
+		current = next
+	}
+	return float64(endpoint.X - current.X), float64(endpoint.Y - current.Y)
+}
+
+// labelComponents assigns a label to every edge pixel so that two edge pixels have the same label exactly if they are
+// connected by a chain of neighbouring edge pixels. Labels start at one, pixels without edge get the label zero.
+func labelComponents[T Sample](edges [][]T) [][]int {
+	labels := make([][]int, len(edges))
+	for y := range edges {
+		labels[y] = make([]int, len(edges[y]))
+	}
+	next := 0
+	for y := range edges {
+		for x := range edges[y] {
+			if edges[y][x] == 0 || labels[y][x] != 0 {
+				continue
+			}
+			// flood fill the component starting at this pixel
+			next++
+			labels[y][x] = next
+			stack := []image.Point{{x, y}}
+			for len(stack) > 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				for i := p.Y - 1; i <= p.Y+1; i++ {
+					for j := p.X - 1; j <= p.X+1; j++ {
+						if i < 0 || i >= len(edges) || j < 0 || j >= len(edges[i]) {
+							continue
+						}
+						if edges[i][j] > 0 && labels[i][j] == 0 {
+							labels[i][j] = next
+							stack = append(stack, image.Point{j, i})
+						}
+					}
+				}
+			}
+		}
+	}
+	return labels
+}
+
+// drawLine sets all pixels on the line between the two given points to the given value using Bresenham's algorithm.
+func drawLine[T Sample](edges [][]T, from, to image.Point, value T) {
+	dx := abs(to.X - from.X)
+	dy := -abs(to.Y - from.Y)
+	stepX, stepY := 1, 1
+	if from.X > to.X {
+		stepX = -1
+	}
+	if from.Y > to.Y {
+		stepY = -1
+	}
+	err := dx + dy
+	x, y := from.X, from.Y
+	for {
+		if y >= 0 && y < len(edges) && x >= 0 && x < len(edges[y]) {
+			edges[y][x] = value
+		}
+		if x == to.X && y == to.Y {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x += stepX
+		}
+		if e2 <= dx {
+			err += dx
+			y += stepY
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// startPreview detects the edges of a copy of the given pixels downscaled by the given scale in the background and
+// writes them next to the output at the given path. The preview runs alongside the detection at full resolution and
+// gives a first look at the result of large images early. The returned function waits until the preview is written.
+func startPreview(detector *Detector, pixels [][]GrayPixel, scale float64, path string) (wait func()) {
+	maxDimension := max(1, int(math.Round(scale*float64(max(len(pixels), len(pixels[0]))))))
+	// per-pixel parameters and defect pixels are resampled to the size of the preview
+	detector = detector.Clone()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		preview := downscalePixels(pixels, maxDimension)
+		if detector.ThresholdFactors != nil {
+			detector.ThresholdFactors = resampleFactors(detector.ThresholdFactors, len(preview[0]), len(preview))
+		}
+		if detector.EdgeWeights != nil {
+			detector.EdgeWeights = resampleFactors(detector.EdgeWeights, len(preview[0]), len(preview))
+		}
+		detector.DefectPixels = downscaleDefects(detector.DefectPixels, len(pixels[0]), len(pixels), maxDimension)
+		edges := DetectSamples(detector, pixelsToSamples(preview), nil)
+		writeImage(samplesToPixels(edges), previewPath(path))
+		fmt.Printf("Preview written to %s.\n", previewPath(path))
+	}()
+	return func() { <-done }
+}
+
+// previewPath returns the path of the preview for the output at the given path, e.g. out_preview.jpg for out.jpg.
+func previewPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_preview" + ext
+}
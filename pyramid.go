@@ -0,0 +1,126 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+)
+
+// pyramidCommand implements the pyramid subcommand, which writes the levels of the gaussian or laplacian pyramid of an
+// image to separate files numbered from the finest level on.
+func pyramidCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("pyramid", flag.ExitOnError)
+	inputFileArgPtr := flags.String("input", "", "path to input file (required)")
+	outputFileArgPtr := flags.String("output", "pyramid.png", "path to output files, numbered by level (optional, default: pyramid.png)")
+	levelsArgPtr := flags.Int("levels", 4, "number of levels including the input (optional, default: 4)")
+	laplacianFlagPtr := flags.Bool("laplacian", false, "write the laplacian pyramid, .pfm files keep the signed values (optional, default: false)")
+	return flags, func() {
+		if *inputFileArgPtr == "" {
+			fmt.Println("No path to input file specified, nothing to do.")
+			return
+		}
+		if *levelsArgPtr < 1 {
+			fmt.Println("Invalid value for pyramid levels given, exiting.")
+			return
+		}
+
+		detector := NewDetector(true, 0, 0)
+		samples := convertSamples[float64](pixelsToSamples(openImage(*inputFileArgPtr, "")))
+		if !*laplacianFlagPtr {
+			for i, level := range detector.GaussianPyramid(samples, *levelsArgPtr) {
+				writeImage(samplesToPixels(roundSamples(level)), framePath(*outputFileArgPtr, i))
+			}
+			return
+		}
+		pyramid := detector.LaplacianPyramid(samples, *levelsArgPtr)
+		for i, level := range pyramid {
+			// the coarsest level is the residual gaussian level, which isn't signed
+			if i == len(pyramid)-1 {
+				writeImage(samplesToPixels(roundSamples(level)), framePath(*outputFileArgPtr, i))
+			} else {
+				writeResponse(level, framePath(*outputFileArgPtr, i))
+			}
+		}
+	}
+}
+
+// GaussianPyramid returns up to the given number of levels of the gaussian pyramid of the given samples, the first
+// level being the samples themselves. Every further level is blurred with the 5x5 binomial kernel and has half the
+// width and height of the previous one. No level is built once the previous one is a single pixel wide or high.
+func (d *Detector) GaussianPyramid(samples [][]float64, levels int) [][][]float64 {
+	kernel := d.binomialKernel(5)
+	weights := kernel.RawVector().Data
+	pyramid := [][][]float64{samples}
+	for len(pyramid) < levels {
+		previous := pyramid[len(pyramid)-1]
+		if len(previous) < 2 || len(previous[0]) < 2 {
+			break
+		}
+		pyramid = append(pyramid, halveSamples(separableBlur(previous, weights, d.Workers)))
+	}
+	return pyramid
+}
+
+// LaplacianPyramid returns the laplacian pyramid of the given samples with the same number of levels as the gaussian
+// pyramid. Every level except the coarsest is the difference of the gaussian level and the expanded next coarser level,
+// the signed details lost by downsampling. The coarsest level is the coarsest gaussian level, so the samples are
+// reconstructed by expanding and adding the levels from the coarsest on.
+func (d *Detector) LaplacianPyramid(samples [][]float64, levels int) [][][]float64 {
+	kernel := d.binomialKernel(5)
+	weights := kernel.RawVector().Data
+	gaussian := d.GaussianPyramid(samples, levels)
+	pyramid := make([][][]float64, len(gaussian))
+	for i := range gaussian[:len(gaussian)-1] {
+		expanded := separableBlur(doubleSamples(gaussian[i+1], len(gaussian[i][0]), len(gaussian[i])), weights, d.Workers)
+		pyramid[i] = make([][]float64, len(gaussian[i]))
+		for y := range gaussian[i] {
+			pyramid[i][y] = make([]float64, len(gaussian[i][y]))
+			for x, value := range gaussian[i][y] {
+				pyramid[i][y][x] = value - expanded[y][x]
+			}
+		}
+	}
+	pyramid[len(pyramid)-1] = gaussian[len(gaussian)-1]
+	return pyramid
+}
+
+// halveSamples returns every second sample of every second row, starting with the first.
+func halveSamples(samples [][]float64) [][]float64 {
+	result := make([][]float64, (len(samples)+1)/2)
+	for y := range result {
+		result[y] = make([]float64, (len(samples[2*y])+1)/2)
+		for x := range result[y] {
+			result[y][x] = samples[2*y][2*x]
+		}
+	}
+	return result
+}
+
+// doubleSamples returns the given samples enlarged to the given size by repeating every sample in two rows and two
+// columns.
+func doubleSamples(samples [][]float64, width, height int) [][]float64 {
+	result := make([][]float64, height)
+	for y := range result {
+		result[y] = make([]float64, width)
+		for x := range result[y] {
+			result[y][x] = samples[y/2][x/2]
+		}
+	}
+	return result
+}
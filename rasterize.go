@@ -0,0 +1,84 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import "math"
+
+// rasterizeContours draws the given contours with anti-aliased strokes of the given width and returns the coverage of
+// every pixel of an image of the given size, from zero for untouched pixels to one for fully covered ones. The points
+// of the contours are the centers of their pixels, closed contours are drawn with the segment back to their start.
+func rasterizeContours(contours []Contour, width, height int, strokeWidth float64) [][]float64 {
+	coverage := make([][]float64, height)
+	for y := range coverage {
+		coverage[y] = make([]float64, width)
+	}
+	for _, contour := range contours {
+		points := contour.Points
+		if contour.Closed && len(points) > 2 {
+			points = append(points[:len(points):len(points)], points[0])
+		}
+		if len(points) == 1 {
+			strokeSegment(coverage, float64(points[0].X), float64(points[0].Y), float64(points[0].X), float64(points[0].Y), strokeWidth)
+		}
+		for i := 1; i < len(points); i++ {
+			a, b := points[i-1], points[i]
+			strokeSegment(coverage, float64(a.X), float64(a.Y), float64(b.X), float64(b.Y), strokeWidth)
+		}
+	}
+	return coverage
+}
+
+// strokeSegment draws the segment from (ax, ay) to (bx, by) with round caps and the given width into the given
+// coverage. The coverage of a pixel falls off linearly over one pixel at the border of the stroke, which approximates
+// the area of the pixel covered by the stroke. Overlapping strokes keep the larger coverage, so joints don't darken.
+func strokeSegment(coverage [][]float64, ax, ay, bx, by, strokeWidth float64) {
+	radius := strokeWidth / 2
+	minX := max(0, int(math.Floor(math.Min(ax, bx)-radius-1)))
+	maxX := min(len(coverage[0])-1, int(math.Ceil(math.Max(ax, bx)+radius+1)))
+	minY := max(0, int(math.Floor(math.Min(ay, by)-radius-1)))
+	maxY := min(len(coverage)-1, int(math.Ceil(math.Max(ay, by)+radius+1)))
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			distance := distanceToSegment(float64(x), float64(y), ax, ay, bx, by)
+			value := math.Min(math.Max(radius+0.5-distance, 0), 1)
+			coverage[y][x] = math.Max(coverage[y][x], value)
+		}
+	}
+}
+
+// distanceToSegment returns the distance of the point (px, py) to the segment from (ax, ay) to (bx, by).
+func distanceToSegment(px, py, ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = math.Min(math.Max(((px-ax)*dx+(py-ay)*dy)/length, 0), 1)
+	}
+	return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
+
+// lineArt returns the given coverage as black strokes on a white background.
+func lineArt(coverage [][]float64) [][]GrayPixel {
+	pixels := make([][]GrayPixel, len(coverage))
+	for y := range coverage {
+		pixels[y] = make([]GrayPixel, len(coverage[y]))
+		for x, value := range coverage[y] {
+			pixels[y][x] = GrayPixel{uint8(math.Round(255 * (1 - value))), 255}
+		}
+	}
+	return pixels
+}
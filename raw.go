@@ -0,0 +1,126 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+)
+
+// rawFormat describes the layout of a headerless raw frame.
+type rawFormat struct {
+	width, height int
+	layout        string
+}
+
+// bytesPerPixel returns the number of bytes a single pixel occupies in the raw frame.
+func (f rawFormat) bytesPerPixel() int {
+	switch f.layout {
+	case "gray16":
+		return 2
+	case "rgb24":
+		return 3
+	default:
+		return 1
+	}
+}
+
+// parseRawFormat parses a raw format description of the form WIDTHxHEIGHT:LAYOUT where LAYOUT is one of gray8,
+// gray16 (little endian) or rgb24.
+func parseRawFormat(spec string) (rawFormat, error) {
+	var format rawFormat
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return format, errors.New("raw format must be given as WIDTHxHEIGHT:LAYOUT")
+	}
+	if _, err := fmt.Sscanf(parts[0], "%dx%d", &format.width, &format.height); err != nil {
+		return format, err
+	}
+	if format.width <= 0 || format.height <= 0 {
+		return format, errors.New("raw frame dimensions must be positive")
+	}
+	// the frame of four bytes per pixel the largest layout is decoded to must be addressable
+	if format.height > math.MaxInt32/format.width/4 {
+		return format, errors.New("raw frame dimensions too large")
+	}
+	format.layout = parts[1]
+	switch format.layout {
+	case "gray8", "gray16", "rgb24":
+	default:
+		return format, errors.New("unknown raw layout " + format.layout)
+	}
+
+	return format, nil
+}
+
+// decodeInput decodes the image from the given reader. If a raw format is given the data is interpreted as headerless
+// raw frame of that format, otherwise the format is detected by the registered decoders.
+func decodeInput(r io.Reader, rawFormat string) (image.Image, error) {
+	if rawFormat == "" {
+		img, _, err := DecodeImage(r)
+		return img, err
+	}
+	format, err := parseRawFormat(rawFormat)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRaw(r, format)
+}
+
+// decodeRaw reads a single frame of the given raw format. Only the first frame of the data is used, surplus bytes are
+// ignored. The frame is read before the image is allocated, so a format larger than the data doesn't allocate it.
+func decodeRaw(r io.Reader, format rawFormat) (image.Image, error) {
+	size := int64(format.width) * int64(format.height) * int64(format.bytesPerPixel())
+	data, err := io.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) < size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	bounds := image.Rect(0, 0, format.width, format.height)
+	switch format.layout {
+	case "gray8":
+		img := image.NewGray(bounds)
+		copy(img.Pix, data)
+		return img, nil
+	case "gray16":
+		img := image.NewGray16(bounds)
+		for i := 0; i < format.width*format.height; i++ {
+			gray := binary.LittleEndian.Uint16(data[2*i:])
+			img.SetGray16(i%format.width, i/format.width, color.Gray16{gray})
+		}
+		return img, nil
+	default: // rgb24
+		img := image.NewRGBA(bounds)
+		for i := 0; i < format.width*format.height; i++ {
+			img.Pix[4*i] = data[3*i]
+			img.Pix[4*i+1] = data[3*i+1]
+			img.Pix[4*i+2] = data[3*i+2]
+			img.Pix[4*i+3] = 255
+		}
+		return img, nil
+	}
+}
@@ -0,0 +1,357 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// LATENCY_WINDOW is the number of most recent frames the latency percentiles of a RealtimeDetector are taken over.
+const LATENCY_WINDOW = 1024
+
+// enumeration type for denoting the stages a RealtimeDetector distributes among its workers
+type realtimeStage int
+
+const (
+	REALTIME_BOX_INPUT   realtimeStage = iota // convert the frame to floating point for the box filter
+	REALTIME_BOX_PASS                         // one pass of the box filter
+	REALTIME_BOX_OUTPUT                       // convert the result of the box filter back to samples
+	REALTIME_KERNEL                           // blur by convolution with the kernel
+	REALTIME_SOBEL                            // gradient magnitude and directions
+	REALTIME_SUPPRESSION                      // non-maximum suppression and histogram of the result
+	REALTIME_THRESHOLD                        // blank the pixels that are no edges
+)
+
+// LatencyStats summarizes the time a RealtimeDetector took to detect the edges of its frames.
+// The durations are encoded in nanoseconds as JSON.
+type LatencyStats struct {
+	Frames int           `json:"frames"` // number of frames detected since the last reset
+	P50    time.Duration `json:"p50"`    // median latency of the last LATENCY_WINDOW frames
+	P99    time.Duration `json:"p99"`    // 99th percentile of the latency of the last LATENCY_WINDOW frames
+	Max    time.Duration `json:"max"`    // maximum latency of the last LATENCY_WINDOW frames
+}
+
+// RealtimeDetector detects the edges of a stream of 8-bit frames of a fixed size, e.g. inside the control loop of a
+// robot. All buffers are allocated and the workers are started when it is created, so detecting a frame doesn't
+// allocate any memory and its timing doesn't depend on the garbage collector. The rows are computed by the same
+// functions as in the stages of a Detector, which write into the buffers. The edges are identical to those of the
+// detector it is created from, which must only use the built-in stages without postprocessing and must not be changed
+// afterwards. A RealtimeDetector is not safe for concurrent use and has to be closed to stop its workers.
+type RealtimeDetector struct {
+	detector      *Detector
+	width, height int
+	kernel        []float64 // blur kernel, nil if the frames aren't blurred by convolution with a kernel
+	box           bool      // blur with the box filter
+
+	frame        [][]uint8   // frame that is detected
+	input        [][]uint8   // input of the gradient stage, the frame or the blurred frame
+	values, next [][]float64 // current and next pass of the box filter
+	sums, counts [][]float64 // integral images of the box filter
+	blurred      [][]uint8
+	magnitude    [][]uint8
+	directions   [][]float64
+	edges        [][]uint8
+	histograms   [][256]int // histograms of the suppressed magnitude per band
+	high         float64    // upper threshold of the current frame
+
+	bands [][2]int             // rows from and up to which every worker processes
+	jobs  []chan realtimeStage // stages for the workers, nil if the stages run on the calling goroutine
+	done  sync.WaitGroup
+
+	latencies []time.Duration // ring buffer of the latencies of the last frames
+	sorted    []time.Duration // buffer the latencies are sorted in to compute the percentiles
+	frames    int
+}
+
+// NewRealtimeDetector returns a RealtimeDetector for frames of the given size that detects with the parameters of the
+// given detector. An error is returned if the detector uses stages that aren't supported or its parameters don't fit
+// the frame size. One blank frame is detected to warm up the pipeline, it isn't counted in the latencies.
+func NewRealtimeDetector(d *Detector, width, height int) (*RealtimeDetector, error) {
+	if err := d.checkLocalStages("real-time detection"); err != nil {
+		return nil, err
+	}
+	if width < 1 || height < 1 {
+		return nil, fmt.Errorf("frame size %dx%d is empty", width, height)
+	}
+	if issues := d.Validate(width, height, nil); hasValidationError(issues) {
+		return nil, fmt.Errorf("%s", formatIssues(issues))
+	}
+
+	r := &RealtimeDetector{detector: d, width: width, height: height, box: d.Blur && d.BlurFilter == BOX}
+	if d.Blur && !r.box {
+		kernel := d.binomialKernel(5)
+		if d.Sigma > 0 {
+			kernel = d.gaussianKernel(d.Sigma)
+		}
+		r.kernel = make([]float64, kernel.Len())
+		for i := range r.kernel {
+			r.kernel[i] = kernel.At(i, 0)
+		}
+	}
+	r.blurred = newRealtimeBuffer[uint8](width, height)
+	r.magnitude = newRealtimeBuffer[uint8](width, height)
+	r.directions = newRealtimeBuffer[float64](width, height)
+	r.edges = newRealtimeBuffer[uint8](width, height)
+	if r.box {
+		r.values = newRealtimeBuffer[float64](width, height)
+		r.next = newRealtimeBuffer[float64](width, height)
+		r.sums = newRealtimeBuffer[float64](width+1, height+1)
+		r.counts = newRealtimeBuffer[float64](width+1, height+1)
+	}
+
+	workers := d.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	band := (height + workers - 1) / workers
+	for start := 0; start < height; start += band {
+		r.bands = append(r.bands, [2]int{start, min(start+band, height)})
+	}
+	r.histograms = make([][256]int, len(r.bands))
+	if len(r.bands) > 1 {
+		r.jobs = make([]chan realtimeStage, len(r.bands))
+		for i := range r.jobs {
+			r.jobs[i] = make(chan realtimeStage)
+			go r.work(i)
+		}
+	}
+	r.latencies = make([]time.Duration, LATENCY_WINDOW)
+	r.sorted = make([]time.Duration, 0, LATENCY_WINDOW)
+
+	if _, err := r.Detect(newRealtimeBuffer[uint8](width, height)); err != nil {
+		r.Close()
+		return nil, err
+	}
+	r.ResetLatency()
+	return r, nil
+}
+
+// newRealtimeBuffer returns a two-dimensional array of the given size whose rows share one allocation.
+func newRealtimeBuffer[T Sample](width, height int) [][]T {
+	values := make([]T, width*height)
+	rows := make([][]T, height)
+	for y := range rows {
+		rows[y] = values[y*width : (y+1)*width : (y+1)*width]
+	}
+	return rows
+}
+
+// Close stops the workers of the detector, it must not be used afterwards.
+func (r *RealtimeDetector) Close() {
+	for _, jobs := range r.jobs {
+		close(jobs)
+	}
+	r.jobs = nil
+}
+
+// Detect detects the edges of the given frame, which must have the size the detector was created for. The returned
+// edge image is a buffer of the detector that is overwritten by the next frame.
+func (r *RealtimeDetector) Detect(frame [][]uint8) ([][]uint8, error) {
+	start := time.Now()
+	if len(frame) != r.height {
+		return nil, fmt.Errorf("frame has %d rows instead of %d", len(frame), r.height)
+	}
+	for y := range frame {
+		if len(frame[y]) != r.width {
+			return nil, fmt.Errorf("row %d of frame has %d pixels instead of %d", y, len(frame[y]), r.width)
+		}
+	}
+
+	r.frame, r.input = frame, frame
+	if r.box {
+		r.run(REALTIME_BOX_INPUT)
+		for pass := 0; pass < r.detector.BoxPasses; pass++ {
+			integrateInto(r.values, nil, r.sums, r.counts)
+			r.run(REALTIME_BOX_PASS)
+			r.values, r.next = r.next, r.values
+		}
+		r.run(REALTIME_BOX_OUTPUT)
+		r.input = r.blurred
+	} else if r.kernel != nil {
+		r.run(REALTIME_KERNEL)
+		r.input = r.blurred
+	}
+	r.run(REALTIME_SOBEL)
+	r.run(REALTIME_SUPPRESSION)
+	// the tracking keeps exactly the pixels above the upper threshold, so only that one is needed
+	var histogram [256]int
+	for i := range r.histograms {
+		for value, count := range r.histograms[i] {
+			histogram[value] += count
+		}
+	}
+	r.high = r.detector.MaxRatio * histogramReference(histogram, r.detector.Percentile)
+	r.run(REALTIME_THRESHOLD)
+
+	r.latencies[r.frames%LATENCY_WINDOW] = time.Since(start)
+	r.frames++
+	return r.edges, nil
+}
+
+// Latency returns the statistics of the latencies of the frames detected since the last reset.
+func (r *RealtimeDetector) Latency() LatencyStats {
+	r.sorted = append(r.sorted[:0], r.latencies[:min(r.frames, LATENCY_WINDOW)]...)
+	slices.Sort(r.sorted)
+	stats := LatencyStats{Frames: r.frames}
+	if n := len(r.sorted); n > 0 {
+		stats.P50 = r.sorted[(n-1)/2]
+		stats.P99 = r.sorted[int(0.99*float64(n-1))]
+		stats.Max = r.sorted[n-1]
+	}
+	return stats
+}
+
+// ResetLatency discards the latencies of the frames detected so far, e.g. after warming up.
+func (r *RealtimeDetector) ResetLatency() {
+	r.frames = 0
+}
+
+// run processes the given stage on all rows, distributed among the workers, and returns when all are done.
+func (r *RealtimeDetector) run(stage realtimeStage) {
+	if r.jobs == nil {
+		r.process(stage, 0)
+		return
+	}
+	r.done.Add(len(r.jobs))
+	for _, jobs := range r.jobs {
+		jobs <- stage
+	}
+	r.done.Wait()
+}
+
+// work processes the stages sent to the worker with the given index until the detector is closed.
+func (r *RealtimeDetector) work(index int) {
+	for stage := range r.jobs[index] {
+		r.process(stage, index)
+		r.done.Done()
+	}
+}
+
+// process processes the given stage on the band of rows of the worker with the given index.
+func (r *RealtimeDetector) process(stage realtimeStage, index int) {
+	if stage == REALTIME_SUPPRESSION {
+		r.histograms[index] = [256]int{}
+	}
+	for y := r.bands[index][0]; y < r.bands[index][1]; y++ {
+		switch stage {
+		case REALTIME_BOX_INPUT:
+			for x, value := range r.frame[y] {
+				r.values[y][x] = float64(value)
+			}
+		case REALTIME_BOX_PASS:
+			boxBlurRow(r.values, r.sums, r.counts, nil, 1, y, r.next[y])
+		case REALTIME_BOX_OUTPUT:
+			for x, value := range r.values[y] {
+				r.blurred[y][x] = saturate[uint8](value)
+			}
+		case REALTIME_KERNEL:
+			kernelBlurRow(r.frame, r.kernel, nil, y, r.blurred[y])
+		case REALTIME_SOBEL:
+			sobelRow(r.input, nil, y, r.magnitude[y], r.directions[y])
+		case REALTIME_SUPPRESSION:
+			suppressRow(r.magnitude, r.directions, y, r.edges[y])
+			for _, value := range r.edges[y] {
+				r.histograms[index][value]++
+			}
+		case REALTIME_THRESHOLD:
+			for x, value := range r.edges[y] {
+				if !(float64(value) > r.high) {
+					r.edges[y][x] = 0
+				}
+			}
+		}
+	}
+}
+
+// addRealtimeFlags defines the parameters of real-time detection on the given flag set. The returned function creates
+// the detector from their values once the flags are parsed.
+func addRealtimeFlags(flags *flag.FlagSet) func() *Detector {
+	blurFlagPtr := &blurFlag{enabled: true}
+	flags.Var(blurFlagPtr, "blur", "blur before edge detection: true, false, gaussian, box or none (optional, default: true = gaussian)")
+	minThresholdArgPtr := flags.Float64("min", float64(0.2), "ratio of lower threshold (optional, default: 0.2)")
+	maxThresholdArgPtr := flags.Float64("max", float64(0.6), "ratio of upper threshold (optional, default: 0.6)")
+	sigmaArgPtr := flags.Float64("sigma", 0, "standard deviation of the gaussian blur (optional, default: 0 = 5x5 kernel)")
+	percentileArgPtr := flags.Float64("percentile", 1, "percentile of gradients the threshold ratios refer to (optional, default: 1 = maximum)")
+	workersArgPtr := flags.Int("workers", runtime.NumCPU(), "number of concurrent workers per stage (optional, default: number of CPUs)")
+	return func() *Detector {
+		detector := NewDetector(blurFlagPtr.enabled, *minThresholdArgPtr, *maxThresholdArgPtr)
+		detector.BlurFilter = blurFlagPtr.filter
+		detector.Sigma = *sigmaArgPtr
+		detector.Percentile = *percentileArgPtr
+		detector.Workers = *workersArgPtr
+		return detector
+	}
+}
+
+// latencyCommand implements the latency subcommand, which measures the per-frame latency of real-time detection by
+// detecting the input image repeatedly as frames of a stream after warming up the pipeline.
+func latencyCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("latency", flag.ExitOnError)
+	inputFileArgPtr := flags.String("input", "", "path to input file used as every frame (required)")
+	outputFileArgPtr := flags.String("output", "", "path to write the edges of the last frame to (optional)")
+	newDetector := addRealtimeFlags(flags)
+	framesArgPtr := flags.Int("frames", 1000, "number of frames to measure (optional, default: 1000)")
+	warmupArgPtr := flags.Int("warmup", 10, "number of frames detected before measuring (optional, default: 10)")
+	return flags, func() {
+		if *inputFileArgPtr == "" {
+			fmt.Println("No path to input file specified, nothing to do.")
+			return
+		}
+		if *framesArgPtr < 1 || *warmupArgPtr < 0 {
+			fmt.Println("Invalid value for frames given, exiting.")
+			return
+		}
+
+		frame := pixelsToSamples(openImage(*inputFileArgPtr, ""))
+		if len(frame) == 0 {
+			fmt.Println("Input image is empty, exiting.")
+			return
+		}
+		realtime, err := NewRealtimeDetector(newDetector(), len(frame[0]), len(frame))
+		if err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			return
+		}
+		defer realtime.Close()
+
+		for i := 0; i < *warmupArgPtr; i++ {
+			realtime.Detect(frame)
+		}
+		realtime.ResetLatency()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		var edges [][]uint8
+		for i := 0; i < *framesArgPtr; i++ {
+			edges, _ = realtime.Detect(frame)
+		}
+		runtime.ReadMemStats(&after)
+
+		stats := realtime.Latency()
+		fmt.Printf("frames: %d of %dx%d\n", stats.Frames, len(frame[0]), len(frame))
+		fmt.Printf("latency p50: %v, p99: %v, max: %v\n", stats.P50, stats.P99, stats.Max)
+		fmt.Printf("allocations per frame: %g\n", float64(after.Mallocs-before.Mallocs)/float64(*framesArgPtr))
+		if *outputFileArgPtr != "" {
+			writeImage(samplesToPixels(edges), *outputFileArgPtr)
+		}
+	}
+}
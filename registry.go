@@ -0,0 +1,129 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sync"
+)
+
+// Decoder describes an image format that input files are decoded from. The format of a file is detected by the magic
+// bytes at its start, the file extension is not taken into account.
+type Decoder struct {
+	Name         string // name of the format
+	Magic        string // bytes the data starts with, '?' matches any byte
+	Decode       func(io.Reader) (image.Image, error)
+	DecodeConfig func(io.Reader) (image.Config, error)
+}
+
+var (
+	decoders      []Decoder  // registered decoders in order of registration
+	decodersMutex sync.Mutex // guards decoders
+	builtinOnce   sync.Once  // registers the built-in decoders on first use
+)
+
+// registerBuiltinDecoders registers the formats that are supported out of the box. DICOM, FITS, camera RAW and high
+// dynamic range images are decoded with DEFAULT_FORMAT_OPTIONS, register the decoders of formatDecoders again to change
+// that. Builds with the edgeefy_minimal tag only support JPEG, PNG, GIF and PNM images.
+func registerBuiltinDecoders() {
+	decoders = append(decoders,
+		Decoder{"jpeg", "\xff\xd8", jpeg.Decode, jpeg.DecodeConfig},
+		Decoder{"png", PNG_SIGNATURE, png.Decode, png.DecodeConfig},
+		Decoder{"gif", "GIF8?a", gif.Decode, gif.DecodeConfig},
+		Decoder{"pgm", "P5", decodePNM, decodePNMConfig},
+		Decoder{"ppm", "P6", decodePNM, decodePNMConfig},
+	)
+	decoders = append(decoders, formatDecoders(DEFAULT_FORMAT_OPTIONS)...)
+}
+
+// RegisterDecoder adds the given decoder to the registry. A decoder registered under the name of an existing one
+// replaces it, which allows changing the options of the built-in decoders.
+func RegisterDecoder(decoder Decoder) {
+	builtinOnce.Do(registerBuiltinDecoders)
+	decodersMutex.Lock()
+	defer decodersMutex.Unlock()
+	for i := range decoders {
+		if decoders[i].Name == decoder.Name {
+			decoders[i] = decoder
+			return
+		}
+	}
+	decoders = append(decoders, decoder)
+}
+
+// sniffDecoder returns the decoder whose magic bytes match the start of the given reader.
+func sniffDecoder(r *bufio.Reader) (Decoder, error) {
+	builtinOnce.Do(registerBuiltinDecoders)
+	decodersMutex.Lock()
+	defer decodersMutex.Unlock()
+	for _, decoder := range decoders {
+		start, err := r.Peek(len(decoder.Magic))
+		if err == nil && matchMagic(decoder.Magic, start) {
+			return decoder, nil
+		}
+	}
+	return Decoder{}, image.ErrFormat
+}
+
+// matchMagic checks whether the given bytes match the magic string with '?' as wildcard.
+func matchMagic(magic string, data []byte) bool {
+	for i := range magic {
+		if magic[i] != data[i] && magic[i] != '?' {
+			return false
+		}
+	}
+	return true
+}
+
+// DecodeImage decodes an image of any registered format from the given reader. The name of the format is returned
+// together with the image.
+func DecodeImage(r io.Reader) (image.Image, string, error) {
+	buffered := bufio.NewReader(r)
+	decoder, err := sniffDecoder(buffered)
+	if err != nil {
+		return nil, "", err
+	}
+	// the header is decoded first, so no decoder allocates the pixels of an image beyond the limits
+	var header bytes.Buffer
+	config, err := decoder.DecodeConfig(io.TeeReader(buffered, &header))
+	if err != nil {
+		return nil, decoder.Name, err
+	}
+	if err := checkDecodeSize(config.Width, config.Height); err != nil {
+		return nil, decoder.Name, err
+	}
+	img, err := decoder.Decode(io.MultiReader(&header, buffered))
+	return img, decoder.Name, err
+}
+
+// DecodeImageConfig decodes the dimensions and color model of an image of any registered format from the given reader.
+func DecodeImageConfig(r io.Reader) (image.Config, string, error) {
+	buffered := bufio.NewReader(r)
+	decoder, err := sniffDecoder(buffered)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	config, err := decoder.DecodeConfig(buffered)
+	return config, decoder.Name, err
+}
@@ -0,0 +1,370 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// REMOTE_DETECTION_FLAGS are the parameters of real-time detection the remote subcommand forwards to the server.
+var REMOTE_DETECTION_FLAGS = []string{"blur", "min", "max", "sigma", "percentile"}
+
+func init() {
+	subcommands["remote"] = Command{remoteCommand, "detect edges on a running edgeefy server"}
+}
+
+// RemoteClient sends images to a server started by the serve subcommand and retrieves their edges.
+type RemoteClient struct {
+	Server string // base URL of the server, e.g. https://host:8080
+	APIKey string // sent as bearer token if set
+	client *http.Client
+}
+
+// NewRemoteClient returns a client of the server at the given URL. Requests time out after the given duration and
+// are made with the given TLS configuration, nil uses the system defaults.
+func NewRemoteClient(server, apiKey string, timeout time.Duration, tlsConfig *tls.Config) (*RemoteClient, error) {
+	u, err := url.Parse(server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("server must be an http or https URL, got %q", server)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &RemoteClient{Server: strings.TrimSuffix(server, "/"), APIKey: apiKey,
+		client: &http.Client{Timeout: timeout, Transport: transport}}, nil
+}
+
+// PutSession creates the given session of the server with the given parameters, the flags of the stream subcommand.
+// An existing session of the same name is replaced.
+func (c *RemoteClient) PutSession(session string, parameters map[string]string) error {
+	body, err := json.Marshal(parameters)
+	if err != nil {
+		return err
+	}
+	_, err = c.do("PUT", "/sessions/"+url.PathEscape(session), body, http.StatusOK)
+	return err
+}
+
+// DeleteSession removes the given session of the server.
+func (c *RemoteClient) DeleteSession(session string) error {
+	_, err := c.do("DELETE", "/sessions/"+url.PathEscape(session), nil, http.StatusNoContent)
+	return err
+}
+
+// Detect returns the edges of the given encoded image as PNG, detected by the given session of the server.
+func (c *RemoteClient) Detect(session string, data []byte) ([]byte, error) {
+	return c.do("POST", "/sessions/"+url.PathEscape(session)+"/frames", data, http.StatusOK)
+}
+
+// DetectStrips streams the given encoded image to the given session of the server, which detects it strip by strip,
+// and copies the edges as PNG to the given writer while they arrive. The image is sent with chunked transfer encoding,
+// so it is never held in memory.
+func (c *RemoteClient) DetectStrips(session string, input io.Reader, output io.Writer) error {
+	// the reader is wrapped so its size isn't taken as content length
+	response, err := c.send("POST", "/sessions/"+url.PathEscape(session)+"/strips", io.MultiReader(input), http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(output, response.Body)
+	return err
+}
+
+// Submit queues the detection of the given encoded image by the given session of the server as a job.
+func (c *RemoteClient) Submit(session string, data []byte) (JobInfo, error) {
+	var info JobInfo
+	body, err := c.do("POST", "/sessions/"+url.PathEscape(session)+"/jobs", data, http.StatusAccepted)
+	if err != nil {
+		return info, err
+	}
+	return info, json.Unmarshal(body, &info)
+}
+
+// Wait polls the given job in the given interval until it is done and returns its edges as PNG. It gives up once the
+// job isn't done after the given timeout.
+func (c *RemoteClient) Wait(id string, interval, timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		var info JobInfo
+		body, err := c.do("GET", "/jobs/"+url.PathEscape(id), nil, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &info); err != nil {
+			return nil, err
+		}
+		switch info.Status {
+		case JOB_DONE:
+			return c.do("GET", info.Result, nil, http.StatusOK)
+		case JOB_FAILED:
+			return nil, fmt.Errorf("job %s failed: %s", id, info.Error)
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return nil, fmt.Errorf("job %s not done after %v", id, timeout)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// do sends a request to the given path of the server and returns the body of the response. Responses with another
+// than the expected status are returned as error.
+func (c *RemoteClient) do(method, path string, data []byte, status int) ([]byte, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	response, err := c.send(method, path, body, status)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	return io.ReadAll(response.Body)
+}
+
+// send sends a request with the given body, nil for none, to the given path of the server and returns the response.
+// Responses with another than the expected status are returned as error.
+func (c *RemoteClient) send(method, path string, body io.Reader, status int) (*http.Response, error) {
+	request, err := http.NewRequest(method, c.Server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if c.APIKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != status {
+		defer response.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, response.Status, strings.TrimSpace(string(message)))
+	}
+	return response, nil
+}
+
+// clientTLSConfig returns the TLS configuration to connect with, verifying the server with the given CA certificates
+// and authenticating with the given client certificate. Nil is returned if neither is given.
+func clientTLSConfig(caPath, certPath, keyPath string) (*tls.Config, error) {
+	if caPath == "" && certPath == "" {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certPath != "" {
+		certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	if caPath != "" {
+		data, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", caPath)
+		}
+	}
+	return config, nil
+}
+
+// remoteCommand implements the remote subcommand, which detects the edges of an image on a server started by the serve
+// subcommand and writes them like the main command does.
+func remoteCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("remote", flag.ExitOnError)
+	configFileArgPtr := flags.String("config", "", "path to a JSON config file of flag values, which may also be set by EDGEEFY_ variables, e.g. EDGEEFY_API_KEY (optional)")
+	serverArgPtr := flags.String("server", "", "URL of the server, e.g. https://host:8080 (required)")
+	inputFileArgPtr := flags.String("input", "", "path to input file (required)")
+	outputFileArgPtr := flags.String("output", "out.jpg", "path to output file, png or jpg (optional, default: out.jpg)")
+	sessionArgPtr := flags.String("session", DEFAULT_SESSION, "session of the server whose parameters are used, can't be combined with detection flags (optional, default: default)")
+	asyncFlagPtr := flags.Bool("async", false, "submit the image as job and poll for the result, for images that take longer than a request may (optional, default: false)")
+	stripsFlagPtr := flags.Bool("strips", false, "stream the image to the server and the edges back, which detects it strip by strip without holding it in memory, the output has to be png (optional, default: false)")
+	pollArgPtr := flags.Duration("poll", time.Second, "interval in which an asynchronous job is polled (optional, default: 1s)")
+	timeoutArgPtr := flags.Duration("timeout", 5*time.Minute, "time a single request, or waiting for an asynchronous job, may take (optional, default: 5m)")
+	apiKeyArgPtr := flags.String("api-key", "", "API key of the server, better set by config or environment (optional)")
+	tlsCAFileArgPtr := flags.String("tls-ca", "", "path to PEM certificates of the CAs the server certificate is verified with (optional, default: system CAs)")
+	tlsCertFileArgPtr := flags.String("tls-cert", "", "path to the PEM client certificate chain for servers that require one, needs -tls-key (optional)")
+	tlsKeyFileArgPtr := flags.String("tls-key", "", "path to the PEM private key of the client certificate (optional)")
+	// the detection flags are those of sessions, given any of them the image is detected by a session of its own
+	detection := flag.NewFlagSet("detection", flag.ContinueOnError)
+	addRealtimeFlags(detection)
+	for _, name := range REMOTE_DETECTION_FLAGS {
+		f := detection.Lookup(name)
+		flags.Var(f.Value, f.Name, f.Usage)
+	}
+	return flags, func() {
+		if _, err := resolveConfiguration(flags, *configFileArgPtr); err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			return
+		}
+
+		if *serverArgPtr == "" {
+			fmt.Println("No server specified, nothing to do.")
+			return
+		}
+		if *inputFileArgPtr == "" {
+			fmt.Println("No path to input file specified, nothing to do.")
+			return
+		}
+		if *stripsFlagPtr && (*asyncFlagPtr || filepath.Ext(*outputFileArgPtr) != ".png") {
+			fmt.Println("Invalid value for strips given, it needs png output and can't be asynchronous, exiting.")
+			return
+		}
+		if *pollArgPtr <= 0 || *timeoutArgPtr <= 0 {
+			fmt.Println("Invalid value for poll or timeout given, exiting.")
+			return
+		}
+		if (*tlsCertFileArgPtr == "") != (*tlsKeyFileArgPtr == "") {
+			fmt.Println("Invalid value for TLS given, -tls-cert and -tls-key are needed together, exiting.")
+			return
+		}
+		tlsConfig, err := clientTLSConfig(*tlsCAFileArgPtr, *tlsCertFileArgPtr, *tlsKeyFileArgPtr)
+		if err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			os.Exit(1)
+		}
+		parameters := map[string]string{}
+		sessionSet := false
+		flags.Visit(func(f *flag.Flag) {
+			if detection.Lookup(f.Name) != nil {
+				parameters[f.Name] = f.Value.String()
+			}
+			sessionSet = sessionSet || f.Name == "session"
+		})
+		if sessionSet && len(parameters) > 0 {
+			fmt.Println("Invalid value for session given, detection flags use a session of their own, exiting.")
+			return
+		}
+		client, err := NewRemoteClient(*serverArgPtr, *apiKeyArgPtr, *timeoutArgPtr, tlsConfig)
+		if err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			os.Exit(1)
+		}
+		var data []byte
+		if !*stripsFlagPtr {
+			if data, err = os.ReadFile(*inputFileArgPtr); err != nil {
+				fmt.Printf("%v, exiting.\n", err)
+				os.Exit(1)
+			}
+		}
+
+		// the requests are made by a function of their own, so the session of the detection flags is removed before
+		// exiting on failure
+		edges, err := func() ([]byte, error) {
+			session := *sessionArgPtr
+			if len(parameters) > 0 {
+				if session, err = remoteSession(client, parameters); err != nil {
+					return nil, err
+				}
+				defer func() {
+					if err := client.DeleteSession(session); err != nil {
+						fmt.Printf("Session %s not removed: %v\n", session, err)
+					}
+				}()
+			}
+			if *stripsFlagPtr {
+				return nil, remoteStrips(client, session, *inputFileArgPtr, *outputFileArgPtr)
+			}
+			if *asyncFlagPtr {
+				job, err := client.Submit(session, data)
+				if err != nil {
+					return nil, err
+				}
+				fmt.Printf("Submitted job %s\n", job.ID)
+				return client.Wait(job.ID, *pollArgPtr, *timeoutArgPtr)
+			}
+			return client.Detect(session, data)
+		}()
+		if err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			os.Exit(1)
+		}
+		if *stripsFlagPtr {
+			return
+		}
+
+		// the server responds with PNG, which is written as is or converted like the output of the main command
+		if filepath.Ext(*outputFileArgPtr) == ".png" {
+			if err := os.WriteFile(*outputFileArgPtr, edges, 0644); err != nil {
+				fmt.Printf("%v, exiting.\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		img, err := png.Decode(bytes.NewReader(edges))
+		if err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			os.Exit(1)
+		}
+		writeColorImage(img, *outputFileArgPtr)
+	}
+}
+
+// remoteSession creates a session of the server with the given parameters under a random name and returns the name.
+func remoteSession(client *RemoteClient, parameters map[string]string) (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	name := "remote-" + hex.EncodeToString(id)
+	if err := client.PutSession(name, parameters); err != nil {
+		return "", errors.New("detection flags not accepted by the server: " + err.Error())
+	}
+	return name, nil
+}
+
+// remoteStrips streams the input file to the server for strip-wise detection by the given session and writes the
+// edges to the output file while they arrive.
+func remoteStrips(client *RemoteClient, session, input, output string) error {
+	inFile, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close() // opened for reading, no error checking needed
+	outFile, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := client.DetectStrips(session, inFile, outFile); err != nil {
+		outFile.Close()
+		return err
+	}
+	return outFile.Close()
+}
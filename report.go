@@ -0,0 +1,193 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// layout of HTML reports
+const (
+	REPORT_IMAGE_SIZE     = 480 // maximum edge length of the embedded images in pixels
+	REPORT_HISTOGRAM_BINS = 64  // number of bins of the histograms
+)
+
+// RunReport collects the artifacts of a single run of the edge detection for an HTML report.
+type RunReport struct {
+	Input      string
+	Parameters PipelineDescription // parameters of the detection, set with the stages
+	Images     []ReportImage
+	Histograms []ReportHistogram
+	Timings    []ReportTiming
+	start      time.Time // time the run started
+	last       time.Time // time the last timing was recorded
+}
+
+// ReportImage is an image of a report, encoded as PNG.
+type ReportImage struct {
+	Title string
+	PNG   []byte
+}
+
+// ReportHistogram holds the counts of the values of an image in equally sized bins from zero to the maximum value.
+type ReportHistogram struct {
+	Title   string
+	Maximum float64
+	Counts  []int
+}
+
+// ReportTiming is the time spent on a step of the run.
+type ReportTiming struct {
+	Step     string
+	Duration time.Duration
+}
+
+// newRunReport returns an empty report of the run on the given input, which starts now.
+func newRunReport(input string) *RunReport {
+	now := time.Now()
+	return &RunReport{Input: input, start: now, last: now}
+}
+
+// record adds the time since the last recorded step, or since the start of the run, as time spent on the given step.
+func (r *RunReport) record(step string) {
+	now := time.Now()
+	r.Timings = append(r.Timings, ReportTiming{step, now.Sub(r.last)})
+	r.last = now
+}
+
+// addImage adds the given pixels as image to the report, scaled down to at most REPORT_IMAGE_SIZE. Edge images are
+// scaled down by taking the maximum so that thin edges stay visible, all others by taking the mean.
+func (r *RunReport) addImage(title string, pixels [][]GrayPixel, edges bool) {
+	var img *image.Gray
+	if edges {
+		levels := pyramidLevels(getImageFromArray(pixels), REPORT_IMAGE_SIZE)
+		img = levels[len(levels)-1]
+	} else {
+		img = getImageFromArray(downscalePixels(pixels, REPORT_IMAGE_SIZE))
+	}
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		log.Fatal(err)
+	}
+	r.Images = append(r.Images, ReportImage{title, buffer.Bytes()})
+}
+
+// addArtifacts adds the parameters of the given detector, the results of the stages of its detection and the
+// histograms of the intensity and the gradient magnitude to the report.
+func addArtifacts[T Sample](r *RunReport, detector *Detector, artifacts PipelineArtifacts[T]) {
+	r.Parameters = detector.Describe()
+	r.addImage("intensity", samplesToPixels(artifacts.Intensity), false)
+	r.addImage("blurred", samplesToPixels(artifacts.Blurred), false)
+	r.addImage("gradient magnitude", samplesToPixels(artifacts.Magnitude), true)
+	r.addImage("edges", samplesToPixels(artifacts.Edges), true)
+	r.Histograms = append(r.Histograms, histogram("intensity", artifacts.Intensity, false))
+	r.Histograms = append(r.Histograms, histogram("gradient magnitude (non-zero)", artifacts.Magnitude, true))
+}
+
+// histogram returns the histogram of the given samples with REPORT_HISTOGRAM_BINS bins. Zero values can be left out,
+// which keeps the flat regions of gradient images from dominating the histogram.
+func histogram[T Sample](title string, samples [][]T, skipZero bool) ReportHistogram {
+	result := ReportHistogram{Title: title, Maximum: float64(maxPixelValue(samples)), Counts: make([]int, REPORT_HISTOGRAM_BINS)}
+	for y := range samples {
+		for _, value := range samples[y] {
+			if (skipZero && value == 0) || value < 0 {
+				continue
+			}
+			bin := REPORT_HISTOGRAM_BINS - 1
+			if result.Maximum > 0 {
+				bin = min(int(float64(value)/result.Maximum*REPORT_HISTOGRAM_BINS), REPORT_HISTOGRAM_BINS-1)
+			}
+			result.Counts[bin]++
+		}
+	}
+	return result
+}
+
+// writeReport writes the given report as standalone HTML page to the file at the given path, all images are embedded.
+func writeReport(r *RunReport, path string) {
+	outFile, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer outFile.Close()
+	if err := writeReportHTML(outFile, r); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeReportHTML writes the HTML page of the given report. Histograms are drawn as inline SVG.
+func writeReportHTML(w io.Writer, r *RunReport) error {
+	parameters, err := json.MarshalIndent(r.Parameters, "", "  ")
+	if err != nil {
+		return err
+	}
+	var page bytes.Buffer
+	fmt.Fprintf(&page, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>edgeefy report: %s</title>\n"+
+		"<style>body { font-family: sans-serif; } figure { display: inline-block; margin: 8px; vertical-align: top; } "+
+		"td { padding: 0 16px 0 0; } pre { background: #eee; padding: 8px; }</style>\n"+
+		"</head>\n<body>\n<h1>%s</h1>\n", html.EscapeString(r.Input), html.EscapeString(r.Input))
+
+	page.WriteString("<h2>Stages</h2>\n")
+	for _, img := range r.Images {
+		fmt.Fprintf(&page, "<figure><img src=\"data:image/png;base64,%s\" alt=\"%s\"><figcaption>%s</figcaption></figure>\n",
+			base64.StdEncoding.EncodeToString(img.PNG), html.EscapeString(img.Title), html.EscapeString(img.Title))
+	}
+
+	page.WriteString("<h2>Histograms</h2>\n")
+	for _, h := range r.Histograms {
+		fmt.Fprintf(&page, "<figure>%s<figcaption>%s, 0 to %g</figcaption></figure>\n", histogramSVG(h), html.EscapeString(h.Title), h.Maximum)
+	}
+
+	page.WriteString("<h2>Timings</h2>\n<table>\n")
+	for _, timing := range r.Timings {
+		fmt.Fprintf(&page, "<tr><td>%s</td><td>%s</td></tr>\n", html.EscapeString(timing.Step), timing.Duration.Round(time.Microsecond))
+	}
+	fmt.Fprintf(&page, "<tr><td><b>total</b></td><td><b>%s</b></td></tr>\n</table>\n", r.last.Sub(r.start).Round(time.Microsecond))
+
+	fmt.Fprintf(&page, "<h2>Parameters</h2>\n<pre>%s</pre>\n</body>\n</html>\n", html.EscapeString(string(parameters)))
+	_, err = w.Write(page.Bytes())
+	return err
+}
+
+// histogramSVG returns the given histogram as SVG bar chart, the bars are scaled to the largest count.
+func histogramSVG(h ReportHistogram) string {
+	const width, height = 4 * REPORT_HISTOGRAM_BINS, 120
+	largest := 1
+	for _, count := range h.Counts {
+		largest = max(largest, count)
+	}
+	var svg bytes.Buffer
+	fmt.Fprintf(&svg, "<svg width=\"%d\" height=\"%d\" xmlns=\"http://www.w3.org/2000/svg\">", width, height)
+	fmt.Fprintf(&svg, "<rect width=\"%d\" height=\"%d\" fill=\"#eee\"/>", width, height)
+	for i, count := range h.Counts {
+		bar := count * height / largest
+		fmt.Fprintf(&svg, "<rect x=\"%d\" y=\"%d\" width=\"3\" height=\"%d\" fill=\"#357\"/>", 4*i, height-bar, bar)
+	}
+	svg.WriteString("</svg>")
+	return svg.String()
+}
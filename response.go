@@ -0,0 +1,190 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// parameters of the second-derivative operators
+const (
+	RESPONSE_SIGMA  = 1.4 // standard deviation of the gaussian if the detector doesn't specify one
+	DOG_SIGMA_RATIO = 1.6 // ratio of the standard deviations of the two gaussians of the difference of gaussians
+)
+
+// isValidResponseOperator checks whether the given name denotes a supported second-derivative operator.
+func isValidResponseOperator(operator string) bool {
+	return operator == "log" || operator == "dog"
+}
+
+// Response returns the signed response of the given second-derivative operator, log for the laplacian of gaussian or
+// dog for the difference of gaussians, on the given samples. The standard deviation of the gaussian is the Sigma of the
+// detector or RESPONSE_SIGMA if it is zero. Edges lie at the zero crossings of the response, which is why it is kept
+// signed and in floating point.
+func (d *Detector) Response(operator string, samples [][]float64) [][]float64 {
+	sigma := d.Sigma
+	if sigma <= 0 {
+		sigma = RESPONSE_SIGMA
+	}
+	if operator == "dog" {
+		narrow := separableBlur(samples, d.gaussianWeights(sigma), d.Workers)
+		wide := separableBlur(samples, d.gaussianWeights(DOG_SIGMA_RATIO*sigma), d.Workers)
+		for y := range wide {
+			for x := range wide[y] {
+				wide[y][x] -= narrow[y][x]
+			}
+		}
+		return wide
+	}
+	return laplacian(separableBlur(samples, d.gaussianWeights(sigma), d.Workers), d.Workers)
+}
+
+// gaussianWeights returns the weights of the cached gaussian kernel with the given standard deviation.
+func (d *Detector) gaussianWeights(sigma float64) []float64 {
+	kernel := d.gaussianKernel(sigma)
+	return kernel.RawVector().Data
+}
+
+// separableBlur convolves the given samples with the given symmetric kernel horizontally and vertically. Pixels outside
+// of the image repeat the nearest border pixel.
+func separableBlur(samples [][]float64, kernel []float64, workers int) [][]float64 {
+	height, width := len(samples), len(samples[0])
+	radius := len(kernel) / 2
+	horizontal := make([][]float64, height)
+	parallelRows(height, workers, func(y int) {
+		horizontal[y] = make([]float64, width)
+		for x := range horizontal[y] {
+			for i, weight := range kernel {
+				horizontal[y][x] += weight * samples[y][min(max(x+i-radius, 0), width-1)]
+			}
+		}
+	})
+	result := make([][]float64, height)
+	parallelRows(height, workers, func(y int) {
+		result[y] = make([]float64, width)
+		for x := range result[y] {
+			for i, weight := range kernel {
+				result[y][x] += weight * horizontal[min(max(y+i-radius, 0), height-1)][x]
+			}
+		}
+	})
+	return result
+}
+
+// laplacian returns the discrete laplacian of the given samples using the four direct neighbours of every pixel.
+// Pixels outside of the image repeat the nearest border pixel.
+func laplacian(samples [][]float64, workers int) [][]float64 {
+	height, width := len(samples), len(samples[0])
+	result := make([][]float64, height)
+	parallelRows(height, workers, func(y int) {
+		result[y] = make([]float64, width)
+		for x := range result[y] {
+			result[y][x] = samples[max(y-1, 0)][x] + samples[min(y+1, height-1)][x] +
+				samples[y][max(x-1, 0)] + samples[y][min(x+1, width-1)] - 4*samples[y][x]
+		}
+	})
+	return result
+}
+
+// divergingColormap maps the given signed response to colors from blue for negative over white for zero to red for
+// positive values. The colors are scaled by the largest absolute value of the response.
+func divergingColormap(response [][]float64) *image.RGBA {
+	scale := 0.0
+	for y := range response {
+		for x := range response[y] {
+			scale = math.Max(scale, math.Abs(response[y][x]))
+		}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, len(response[0]), len(response)))
+	for y := range response {
+		for x, value := range response[y] {
+			strength := 0.0
+			if scale > 0 {
+				strength = math.Abs(value) / scale
+			}
+			faded := uint8(math.Round(255 * (1 - strength)))
+			if value < 0 {
+				img.SetRGBA(x, y, color.RGBA{faded, faded, 255, 255})
+			} else {
+				img.SetRGBA(x, y, color.RGBA{255, faded, faded, 255})
+			}
+		}
+	}
+	return img
+}
+
+// writePFM writes the given values as grayscale portable float map. The rows are stored bottom to top as little
+// endian 32-bit floats, as the format requires.
+func writePFM(w io.Writer, values [][]float64) error {
+	if _, err := fmt.Fprintf(w, "Pf\n%d %d\n-1.0\n", len(values[0]), len(values)); err != nil {
+		return err
+	}
+	row := make([]byte, 4*len(values[0]))
+	for y := len(values) - 1; y >= 0; y-- {
+		for x, value := range values[y] {
+			binary.LittleEndian.PutUint32(row[4*x:], math.Float32bits(float32(value)))
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeResponse writes the given signed response to the file at the given path. PFM files keep the values as floating
+// point data, all other formats hold the response mapped to a diverging colormap.
+func writeResponse(response [][]float64, path string) {
+	if filepath.Ext(path) != ".pfm" {
+		writeColorImage(divergingColormap(response), path)
+		return
+	}
+	outFile, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer outFile.Close()
+	if err := writePFM(outFile, response); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeConfidence writes the given confidence map to the file at the given path. PFM files keep the ratios, all other
+// formats map them to gray values so that an edge pixel at the upper threshold is mid gray and twice the threshold or
+// more is white.
+func writeConfidence(confidence [][]float64, path string) {
+	if filepath.Ext(path) == ".pfm" {
+		writeResponse(confidence, path)
+		return
+	}
+	pixels := make([][]GrayPixel, len(confidence))
+	for y := range confidence {
+		pixels[y] = make([]GrayPixel, len(confidence[y]))
+		for x, value := range confidence[y] {
+			pixels[y][x] = GrayPixel{uint8(math.Round(255 * math.Min(value, 2) / 2)), 255}
+		}
+	}
+	writeImage(pixels, path)
+}
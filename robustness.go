@@ -0,0 +1,137 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// NoiseSpec describes the kind of noise and the levels the robustness of a detection is tested with.
+type NoiseSpec struct {
+	Kind   string    // gaussian or saltpepper
+	Levels []float64 // standard deviations in gray values for gaussian noise, fractions of pixels for saltpepper
+}
+
+// parseNoiseSpec parses a noise description of the form KIND:LEVEL,LEVEL,... e.g. gaussian:5,10,20.
+func parseNoiseSpec(spec string) (NoiseSpec, error) {
+	var noise NoiseSpec
+	kind, levels, found := strings.Cut(spec, ":")
+	if !found {
+		return noise, errors.New("noise must be given as KIND:LEVEL,LEVEL,...")
+	}
+	if kind != "gaussian" && kind != "saltpepper" {
+		return noise, errors.New("unknown noise kind " + kind)
+	}
+	noise.Kind = kind
+	for _, level := range strings.Split(levels, ",") {
+		value, err := strconv.ParseFloat(level, 64)
+		if err != nil {
+			return noise, err
+		}
+		if value < 0 || (kind == "saltpepper" && value > 1) {
+			return noise, errors.New("noise level out of range: " + level)
+		}
+		noise.Levels = append(noise.Levels, value)
+	}
+	return noise, nil
+}
+
+// robustnessCommand implements the robustness subcommand. Noise of increasing levels is added to the input image and
+// the edges detected on every noisy copy are compared with the edges of the clean image.
+func robustnessCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("robustness", flag.ExitOnError)
+	inputFileArgPtr := flags.String("input", "", "path to input file (required)")
+	noiseArgPtr := flags.String("noise", "gaussian:5,10,20", "kind and levels of noise, gaussian or saltpepper (optional, default: gaussian:5,10,20)")
+	minThresholdArgPtr := flags.Float64("min", float64(0.2), "ratio of lower threshold (optional, default: 0.2)")
+	maxThresholdArgPtr := flags.Float64("max", float64(0.6), "ratio of upper threshold (optional, default: 0.6)")
+	seedArgPtr := flags.Int64("seed", 0, "seed of the noise generator (optional, default: 0 = derived from the current time)")
+	return flags, func() {
+		if *inputFileArgPtr == "" {
+			fmt.Println("No path to input file specified, nothing to do.")
+			return
+		}
+		if !isValidRatioValue(*minThresholdArgPtr) || !isValidRatioValue(*maxThresholdArgPtr) {
+			fmt.Println("Invalid value for threshold ratio given, exiting.")
+			return
+		}
+		noise, err := parseNoiseSpec(*noiseArgPtr)
+		if err != nil {
+			fmt.Println("Invalid value for noise given, exiting.")
+			return
+		}
+
+		detector := NewDetector(true, *minThresholdArgPtr, *maxThresholdArgPtr)
+		clean := pixelsToSamples(openImage(*inputFileArgPtr, ""))
+		reference := samplesToPixels(DetectSamples(detector, copySamples(clean), nil))
+		_, seed := newRandom(*seedArgPtr)
+		fmt.Println("seed:", seed)
+		for i, level := range noise.Levels {
+			// every level gets a generator of its own, so the noise of a level doesn't depend on the other levels
+			random, _ := newRandom(seed + int64(i))
+			edges := samplesToPixels(DetectSamples(detector, addNoise(clean, noise.Kind, level, random), nil))
+			agreement := compareEdges(edges, reference, 1)
+			fmt.Printf("%s %g: IoU %.4f, F1 %.4f\n", noise.Kind, level, edgeIoU(edges, reference), agreement.F1())
+		}
+	}
+}
+
+// addNoise returns a copy of the given samples with noise of the given kind and level drawn from the given generator
+// added. Gaussian noise adds normally distributed values with the level as standard deviation, salt and pepper noise
+// sets the given fraction of pixels to black or white.
+func addNoise(samples [][]uint8, kind string, level float64, random *rand.Rand) [][]uint8 {
+	noisy := copySamples(samples)
+	for y := range noisy {
+		for x := range noisy[y] {
+			if kind == "saltpepper" {
+				if random.Float64() < level {
+					noisy[y][x] = uint8(255 * random.Intn(2))
+				}
+				continue
+			}
+			value := float64(noisy[y][x]) + random.NormFloat64()*level
+			noisy[y][x] = uint8(math.Round(math.Min(math.Max(value, 0), 255)))
+		}
+	}
+	return noisy
+}
+
+// edgeIoU returns the intersection over union of the edge pixels of two edge images, one if both have no edges.
+func edgeIoU(edges, reference [][]GrayPixel) float64 {
+	var intersection, union int
+	for y := range edges {
+		for x := range edges[y] {
+			isEdge, isReference := edges[y][x].y > 0, reference[y][x].y > 0
+			if isEdge && isReference {
+				intersection++
+			}
+			if isEdge || isReference {
+				union++
+			}
+		}
+	}
+	if union == 0 {
+		return 1
+	}
+	return float64(intersection) / float64(union)
+}
@@ -0,0 +1,303 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"math"
+	"sort"
+)
+
+// Sample is the constraint for the types of gray values the detection stages operate on. 8-bit images, 16-bit images
+// such as depth maps and floating point data all share the same implementation of the stages.
+type Sample interface {
+	~uint8 | ~uint16 | ~float32 | ~float64
+}
+
+// DetectSamples performs canny edge detection with the parameters of the given detector on a two-dimensional array of
+// gray values. Only the pixels that are marked as valid in the given mask are taken into account, a nil mask marks
+// all pixels as valid. The edge image is returned with the same sample type.
+func DetectSamples[T Sample](d *Detector, samples [][]T, valid [][]bool) [][]T {
+	return detectStages(d, samples, valid, nil)
+}
+
+// PipelineArtifacts holds the intermediate results of the stages of a detection.
+type PipelineArtifacts[T Sample] struct {
+	Intensity  [][]T       // input of the detection, after the corrections and the logarithm if enabled
+	Blurred    [][]T       // result of the blur, the intensity if blurring is disabled
+	Magnitude  [][]T       // gradient magnitude
+	Directions [][]float64 // gradient directions in degrees
+	Edges      [][]T       // final edge image
+	// ratio of the strength of every edge pixel to the upper threshold at the pixel, zero for non-edges. The tracking
+	// keeps the pixels from the upper threshold on and clears the weak ones, so edge pixels have a ratio of at least
+	// one unless a custom tracker keeps others. Pixels drawn by bridging gaps have no magnitude of their own and keep
+	// zero.
+	Confidence [][]float64
+}
+
+// DetectArtifacts performs the detection like DetectSamples and returns the results of all stages.
+func DetectArtifacts[T Sample](d *Detector, samples [][]T, valid [][]bool) PipelineArtifacts[T] {
+	var artifacts PipelineArtifacts[T]
+	artifacts.Edges = detectStages(d, samples, valid, &artifacts)
+	return artifacts
+}
+
+// detectStages runs the stages of the detection and returns the edge image. The intermediate results are stored in
+// the given artifacts unless they are nil, otherwise they can be freed as soon as the next stage is done.
+func detectStages[T Sample](d *Detector, samples [][]T, valid [][]bool, artifacts *PipelineArtifacts[T]) [][]T {
+	if d.DefectPixels != nil || d.DefectThreshold > 0 {
+		valid = maskDefects(d, samples, valid)
+	}
+	samples = correctIntensity(d, samples, valid)
+	d.stageComplete(STAGE_INTENSITY, samples)
+	if artifacts != nil {
+		artifacts.Intensity = samples
+	}
+	// the noise is estimated before the blur removes most of it
+	var noise float64
+	if d.NoiseThresholds && d.Thresholder == nil {
+		noise = EstimateNoise(samples, valid).Sigma
+	}
+	// custom stages work on floating point samples, the built-in ones on the sample type of the detection
+	if d.Blurrer != nil && d.Blur {
+		samples = convertSamples[T](d.Blurrer.Blur(convertSamples[float64](samples), valid))
+	} else {
+		samples = blurSamples(d, samples, valid)
+	}
+	d.stageComplete(STAGE_BLUR, samples)
+	if artifacts != nil {
+		artifacts.Blurred = samples
+	}
+	var angles [][]float64
+	if d.GradientOperator != nil {
+		var magnitude [][]float64
+		magnitude, angles = d.GradientOperator.Gradient(convertSamples[float64](samples), valid)
+		samples = convertSamples[T](magnitude)
+	} else {
+		samples, angles = sobel(samples, valid, d.Workers)
+	}
+	d.stageComplete(STAGE_GRADIENT, samples)
+	if artifacts != nil {
+		artifacts.Magnitude, artifacts.Directions = samples, angles
+	}
+	if d.Suppressor != nil {
+		samples = convertSamples[T](d.Suppressor.Suppress(convertSamples[float64](samples), angles))
+	} else {
+		samples = nonMaximumSuppression(samples, angles, d.Workers)
+	}
+	if d.EdgeWeights != nil {
+		multiplyByWeights(samples, d.EdgeWeights)
+	}
+	d.stageComplete(STAGE_SUPPRESSION, samples)
+	var low, high float64
+	if d.Thresholder != nil {
+		low, high = d.Thresholder.Thresholds(convertSamples[float64](samples))
+	} else if d.NoiseThresholds {
+		low, high = noiseThresholds(d, noise)
+	} else {
+		low, high = ratioThresholds(samples, d.MinRatio, d.MaxRatio, d.Percentile)
+	}
+	// local thresholds are applied by dividing the magnitude, the edges keep the original magnitude
+	magnitude := samples
+	if d.ThresholdFactors != nil {
+		samples = divideByFactors(samples, d.ThresholdFactors)
+	}
+	if d.Tracker != nil {
+		samples = convertSamples[T](d.Tracker.Track(convertSamples[float64](samples), low, high))
+	} else {
+		samples = trackEdges(samples, low, high)
+	}
+	if d.ThresholdFactors != nil {
+		restoreMagnitude(samples, magnitude)
+	}
+	d.stageComplete(STAGE_TRACKING, samples)
+	// the confidence is taken from the tracked magnitude, the postprocessing draws bridges with the maximum value
+	var confidence [][]float64
+	if artifacts != nil {
+		confidence = edgeConfidence(samples, high, d.ThresholdFactors)
+	}
+	despeckle(samples, d.Despeckle)
+	bridgeGaps(samples, d.BridgeDistance, d.BridgeAngle)
+	d.stageComplete(STAGE_POSTPROCESS, samples)
+	if artifacts != nil {
+		for y := range confidence {
+			for x := range confidence[y] {
+				if samples[y][x] == 0 {
+					confidence[y][x] = 0 // removed by despeckling
+				}
+			}
+		}
+		artifacts.Confidence = confidence
+	}
+
+	return samples
+}
+
+// correctIntensity applies the corrections of the illumination and the logarithm the detector is configured with to
+// the given samples, which become the input of the blur.
+func correctIntensity[T Sample](d *Detector, samples [][]T, valid [][]bool) [][]T {
+	if d.Vignetting != nil || d.FitVignetting {
+		samples = correctVignetting(d, samples, valid)
+	}
+	if d.BackgroundRadius > 0 {
+		samples = subtractBackground(d, samples, valid)
+	}
+	if d.LogIntensity {
+		samples = logIntensity(samples, d.Workers)
+	}
+	return samples
+}
+
+// edgeConfidence returns the ratio of the strength of every edge pixel of the given edge image to the given upper
+// threshold, multiplied by the threshold factors if there are any.
+func edgeConfidence[T Sample](edges [][]T, high float64, factors [][]float64) [][]float64 {
+	confidence := make([][]float64, len(edges))
+	for y := range edges {
+		confidence[y] = make([]float64, len(edges[y]))
+		for x, value := range edges[y] {
+			threshold := high
+			if factors != nil {
+				threshold *= factors[y][x]
+			}
+			if value > 0 && threshold > 0 {
+				confidence[y][x] = float64(value) / threshold
+			}
+		}
+	}
+	return confidence
+}
+
+// logIntensity returns the logarithm log(1+I) of the given samples scaled so that the maximum keeps its value, which
+// keeps the resolution of integer samples. Negative values are treated as zero.
+func logIntensity[T Sample](samples [][]T, workers int) [][]T {
+	maximum := math.Max(float64(maxPixelValue(samples)), 0)
+	scale := 0.0
+	if maximum > 0 {
+		scale = maximum / math.Log1p(maximum)
+	}
+	result := make([][]T, len(samples))
+	parallelRows(len(samples), workers, func(y int) {
+		result[y] = make([]T, len(samples[y]))
+		for x, value := range samples[y] {
+			result[y][x] = T(scale * math.Log1p(math.Max(float64(value), 0)))
+		}
+	})
+	return result
+}
+
+// thresholdReference returns the value the threshold ratios refer to. This is the given percentile of the non-zero
+// values of the array, for a percentile outside of (0, 1) it is the maximum value.
+func thresholdReference[T Sample](samples [][]T, percentile float64) float64 {
+	if percentile <= 0 || percentile >= 1 {
+		return float64(maxPixelValue(samples))
+	}
+	var values []T
+	for y := range samples {
+		for x := range samples[y] {
+			if samples[y][x] > 0 {
+				values = append(values, samples[y][x])
+			}
+		}
+	}
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return float64(values[int(percentile*float64(len(values)-1))])
+}
+
+// sampleLimit returns the largest value of the given sample type, infinity for floating point samples.
+func sampleLimit[T Sample]() float64 {
+	switch any(T(0)).(type) {
+	case uint8:
+		return math.MaxUint8
+	case uint16:
+		return math.MaxUint16
+	}
+	return math.Inf(1)
+}
+
+// clampSample converts the given value to the sample type. Values outside of the range of integer types are clipped
+// and the others are rounded, floating point values are kept.
+func clampSample[T Sample](value float64) T {
+	limit := sampleLimit[T]()
+	if math.IsInf(limit, 1) {
+		return T(value)
+	}
+	return T(math.Round(math.Min(math.Max(value, 0), limit)))
+}
+
+// saturate converts the given value to the sample type like a conversion, which truncates integers, but saturates
+// values outside of the range of integer types at its bounds instead of leaving the result to the platform. Floating
+// point values are kept.
+func saturate[T Sample](value float64) T {
+	limit := sampleLimit[T]()
+	switch {
+	case math.IsInf(limit, 1):
+		return T(value)
+	case value >= limit:
+		return T(limit)
+	case value > 0:
+		return T(value)
+	}
+	return 0 // negative values and NaN
+}
+
+// convertSamples converts the values of the given two-dimensional array to another sample type. Values outside of
+// the range of integer types are saturated.
+func convertSamples[U, T Sample](samples [][]T) [][]U {
+	result := make([][]U, len(samples))
+	for y := range samples {
+		result[y] = make([]U, len(samples[y]))
+		for x := range samples[y] {
+			result[y][x] = saturate[U](float64(samples[y][x]))
+		}
+	}
+	return result
+}
+
+// pixelsToSamples returns the gray values of the given two-dimensional GrayPixel array.
+func pixelsToSamples(pixels [][]GrayPixel) [][]uint8 {
+	samples := make([][]uint8, len(pixels))
+	for y := range pixels {
+		samples[y] = make([]uint8, len(pixels[y]))
+		for x := range pixels[y] {
+			samples[y][x] = pixels[y][x].y
+		}
+	}
+	return samples
+}
+
+// samplesToPixels converts the given gray values to opaque GrayPixel objects. The values are scaled so that the
+// maximum value of the array becomes white, 8-bit samples are taken over unchanged.
+func samplesToPixels[T Sample](samples [][]T) [][]GrayPixel {
+	scale := float64(1)
+	if _, isByte := any(samples).([][]uint8); !isByte {
+		if max := maxPixelValue(samples); max > 0 {
+			scale = 255 / float64(max)
+		}
+	}
+
+	pixels := make([][]GrayPixel, len(samples))
+	for y := range samples {
+		pixels[y] = make([]GrayPixel, len(samples[y]))
+		for x := range samples[y] {
+			pixels[y][x] = GrayPixel{uint8(float64(samples[y][x])*scale + 0.5), 255}
+		}
+	}
+	return pixels
+}
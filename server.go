@@ -0,0 +1,587 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// SERVER_MAX_UPLOAD is the maximum size in bytes of a frame uploaded to the server.
+const SERVER_MAX_UPLOAD = 256 << 20
+
+// SERVER_MAX_STREAMED_UPLOAD is the maximum size in bytes of an image uploaded for strip-wise detection, which is
+// spilled to disk instead of being held in memory.
+const SERVER_MAX_STREAMED_UPLOAD = 16 << 30
+
+// SERVER_MAX_DIMENSION is the default of the maximum width and height of images uploaded to the server, which keeps
+// the memory a single upload can take bounded.
+const SERVER_MAX_DIMENSION = 8000
+
+// SERVER_IDLE_TIMEOUT is the time a kept-alive connection may wait for the next request.
+const SERVER_IDLE_TIMEOUT = 2 * time.Minute
+
+// DEFAULT_SESSION is the name of the session with default parameters the server starts with unless the sessions file
+// configures it.
+const DEFAULT_SESSION = "default"
+
+func init() {
+	subcommands["serve"] = Command{serveCommand, "serve edge detection of named camera sessions over HTTP"}
+}
+
+// Server serves the edge detection of several sessions over HTTP, e.g. one per camera with its own tuning. The
+// endpoints are defined by SERVER_ROUTES, which the OpenAPI document served at /openapi.json is generated from. With an
+// authenticator every request except those for public routes needs a valid API key.
+type Server struct {
+	Sessions     *SessionStore
+	Jobs         *JobQueue
+	Store        *JobStore      // nil keeps the sessions created over the API in memory only
+	Auth         *Authenticator // nil serves all requests without authentication
+	MaxDimension int            // maximum width and height of frames, zero disables the limit
+	// time reading the body of a strip-wise detection and writing its edges may take, which replaces the timeouts of
+	// the HTTP server for these large images, zero keeps them
+	StripsTimeout time.Duration
+
+	openAPI map[string]any // OpenAPI document of the routes, built by Handler
+}
+
+// ServerRoute is an endpoint of the server. Besides the handler it describes the parameters and responses of the
+// endpoint for the OpenAPI document. Path parameters are taken from the pattern of the path.
+type ServerRoute struct {
+	Method   string
+	Path     string
+	Summary  string
+	Query    map[string]string // descriptions of the query parameters by name
+	Request  string            // content type of the request body, empty for none
+	Body     any               // value of the type of JSON request bodies
+	Status   int               // status of a successful response
+	Response string            // content type of a successful response, empty for none
+	Result   any               // value of the type of JSON responses
+	Edges    bool              // edges are sent in the format negotiated by negotiateEdgeFormat instead of Response
+	Errors   []int             // statuses of error responses
+	Public   bool              // served without authentication
+	Handle   func(s *Server, w http.ResponseWriter, r *http.Request)
+}
+
+// SERVER_ROUTES are the endpoints of the server.
+var SERVER_ROUTES = []ServerRoute{
+	{Method: "GET", Path: "/sessions", Summary: "List the sessions with their parameters and counters",
+		Status: http.StatusOK, Response: "application/json", Result: []SessionInfo{}, Handle: (*Server).listSessions},
+	{Method: "GET", Path: "/sessions/{name}", Summary: "Describe a session",
+		Status: http.StatusOK, Response: "application/json", Result: SessionInfo{}, Errors: []int{http.StatusNotFound},
+		Handle: (*Server).getSession},
+	{Method: "PUT", Path: "/sessions/{name}", Summary: "Create or replace a session with the given parameters, the flags of the stream subcommand",
+		Request: "application/json", Body: map[string]string{}, Status: http.StatusOK, Response: "application/json",
+		Result: SessionInfo{}, Errors: []int{http.StatusBadRequest}, Handle: (*Server).putSession},
+	{Method: "DELETE", Path: "/sessions/{name}", Summary: "Remove a session",
+		Status: http.StatusNoContent, Errors: []int{http.StatusNotFound}, Handle: (*Server).deleteSession},
+	{Method: "POST", Path: "/sessions/{name}/frames", Summary: "Detect the edges of the uploaded image",
+		Request: "application/octet-stream", Status: http.StatusOK, Edges: true,
+		Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusRequestEntityTooLarge,
+			http.StatusUnprocessableEntity, http.StatusServiceUnavailable}, Handle: (*Server).postFrame},
+	{Method: "POST", Path: "/sessions/{name}/strips", Summary: "Detect the edges of a large uploaded image strip by strip, which may be sent with chunked transfer encoding. Binary PGM and PPM images are decoded while they arrive, other formats are decoded in memory and limited to 8000x8000 pixels. The edges are streamed back row by row as PNG",
+		Query:   map[string]string{"format": "png, the only format the edges are streamed in, overrides the Accept header"},
+		Request: "application/octet-stream", Status: http.StatusOK, Response: "image/png",
+		Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusRequestEntityTooLarge,
+			http.StatusUnprocessableEntity}, Handle: (*Server).postStrips},
+	{Method: "POST", Path: "/sessions/{name}/jobs", Summary: "Queue the detection of the uploaded image",
+		Query:   map[string]string{"callback": "http or https URL the description of the job is posted to when it is finished"},
+		Request: "application/octet-stream", Status: http.StatusAccepted, Response: "application/json", Result: JobInfo{},
+		Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge,
+			http.StatusServiceUnavailable}, Handle: (*Server).postJob},
+	{Method: "GET", Path: "/jobs/{id}", Summary: "Describe a job",
+		Status: http.StatusOK, Response: "application/json", Result: JobInfo{}, Errors: []int{http.StatusNotFound},
+		Handle: (*Server).getJob},
+	{Method: "GET", Path: "/jobs/{id}/result", Summary: "Get the edges of a finished job",
+		Status: http.StatusOK, Edges: true,
+		Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusConflict},
+		Handle: (*Server).getJobResult},
+	{Method: "GET", Path: "/usage", Summary: "Get the usage of the API key of the request",
+		Status: http.StatusOK, Response: "application/json", Result: APIKeyUsage{}, Errors: []int{http.StatusNotFound},
+		Handle: (*Server).getUsage},
+	{Method: "GET", Path: "/openapi.json", Summary: "Get the OpenAPI document of the server",
+		Status: http.StatusOK, Response: "application/json", Public: true, Handle: (*Server).getOpenAPI},
+}
+
+// Handler returns the HTTP handler of the server.
+func (s *Server) Handler() http.Handler {
+	s.openAPI = openAPIDocument(SERVER_ROUTES, s.Auth != nil)
+	public, protected := http.NewServeMux(), http.NewServeMux()
+	for _, route := range SERVER_ROUTES {
+		handle := route.Handle
+		mux := protected
+		if route.Public || s.Auth == nil {
+			mux = public
+		}
+		mux.HandleFunc(route.Method+" "+route.Path, func(w http.ResponseWriter, r *http.Request) {
+			handle(s, w, r)
+		})
+	}
+	if s.Auth != nil {
+		public.Handle("/", s.Auth.Wrap(protected))
+	}
+	return public
+}
+
+// listSessions responds with the descriptions of all sessions.
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, s.Sessions.List())
+}
+
+// getSession responds with the description of the requested session.
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request) {
+	session := s.Sessions.Get(r.PathValue("name"))
+	if session == nil {
+		http.Error(w, "no such session", http.StatusNotFound)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, session.Info())
+}
+
+// putSession creates the requested session with the parameters in the body, an existing session is replaced. With a
+// job store the session is kept across restarts.
+func (s *Server) putSession(w http.ResponseWriter, r *http.Request) {
+	parameters, err := readSessionParameters(http.MaxBytesReader(w, r.Body, SERVER_MAX_UPLOAD))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	session, err := NewSession(r.PathValue("name"), parameters)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.Store != nil {
+		if err := s.Store.SaveSession(session.Name, session.Parameters); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	s.Sessions.Put(session)
+	writeJSONResponse(w, http.StatusOK, session.Info())
+}
+
+// deleteSession removes the requested session.
+func (s *Server) deleteSession(w http.ResponseWriter, r *http.Request) {
+	if s.Store != nil {
+		if err := s.Store.DeleteSession(r.PathValue("name")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if !s.Sessions.Delete(r.PathValue("name")) {
+		http.Error(w, "no such session", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// postFrame detects the edges of the image in the body with the requested session and responds with them in the
+// negotiated format. Frames dropped by the session are answered with 503 Service Unavailable, frames of a session that
+// was replaced or deleted meanwhile with 410 Gone.
+func (s *Server) postFrame(w http.ResponseWriter, r *http.Request) {
+	session := s.Sessions.Get(r.PathValue("name"))
+	if session == nil {
+		http.Error(w, "no such session", http.StatusNotFound)
+		return
+	}
+	format, ok := negotiateEdgeResponse(w, r, EDGE_FORMATS)
+	if !ok {
+		return
+	}
+	data, ok := s.readUpload(w, r)
+	if !ok {
+		return
+	}
+	img, err := decodeInput(bytes.NewReader(data), "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	edges, err := session.Detect(img)
+	if errors.Is(err, ErrSessionBusy) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if errors.Is(err, ErrSessionClosed) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeEdgeResponse(w, format, edges)
+}
+
+// postStrips responds with the edges of the large image in the body as PNG. The body is read while it arrives and
+// spilled to disk, the edges are detected strip by strip and written as soon as they are known. Since the status is
+// sent with the first row, later errors abort the response.
+func (s *Server) postStrips(w http.ResponseWriter, r *http.Request) {
+	session := s.Sessions.Get(r.PathValue("name"))
+	if session == nil {
+		http.Error(w, "no such session", http.StatusNotFound)
+		return
+	}
+	// only PNG is written row by row
+	if _, ok := negotiateEdgeResponse(w, r, EDGE_FORMATS[:1]); !ok {
+		return
+	}
+	if s.StripsTimeout > 0 {
+		// servers without deadlines, e.g. in tests, don't support setting them and need none
+		deadline, controller := time.Now().Add(s.StripsTimeout), http.NewResponseController(w)
+		controller.SetReadDeadline(deadline)
+		controller.SetWriteDeadline(deadline)
+	}
+	oversize := false
+	spilled, err := spillStream(http.MaxBytesReader(w, r.Body, SERVER_MAX_STREAMED_UPLOAD), func(width, height int) error {
+		if s.MaxDimension > 0 && (width > s.MaxDimension || height > s.MaxDimension) {
+			oversize = true
+			return fmt.Errorf("image size %dx%d exceeds maximum dimension of %d", width, height, s.MaxDimension)
+		}
+		return nil
+	})
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || oversize || errors.Is(err, ErrSpillDecodedTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer spilled.Close()
+	width, height := spilled.Size()
+	if issues := session.detector.Validate(width, height, nil); hasValidationError(issues) {
+		http.Error(w, formatIssues(issues), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var stream *PNGStreamWriter
+	err = session.DetectStrips(spilled, func(edges []uint8) error {
+		if stream == nil {
+			w.Header().Set("Content-Type", "image/png")
+			var err error
+			if stream, err = NewPNGStreamWriter(w, width, height); err != nil {
+				return err
+			}
+		}
+		return stream.WriteRow(edges)
+	})
+	if err == nil {
+		err = stream.Close()
+	}
+	if err != nil && stream == nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	} else if err != nil {
+		panic(http.ErrAbortHandler) // the client sees a truncated response instead of a broken image
+	}
+}
+
+// postJob queues the detection of the image in the body with the requested session and responds with the description
+// of the job. Jobs submitted while the queue is full are answered with 503 Service Unavailable.
+func (s *Server) postJob(w http.ResponseWriter, r *http.Request) {
+	session := s.Sessions.Get(r.PathValue("name"))
+	if session == nil {
+		http.Error(w, "no such session", http.StatusNotFound)
+		return
+	}
+	callback := r.URL.Query().Get("callback")
+	if callback != "" {
+		if u, err := url.Parse(callback); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "callback must be an http or https URL", http.StatusBadRequest)
+			return
+		}
+	}
+	data, ok := s.readUpload(w, r)
+	if !ok {
+		return
+	}
+	job, err := s.Jobs.Submit(session, data, callback)
+	if errors.Is(err, ErrQueueFull) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	info, _ := s.Jobs.Info(job.ID)
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSONResponse(w, http.StatusAccepted, info)
+}
+
+// getJob responds with the description of the requested job.
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.Jobs.Info(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, info)
+}
+
+// getJobResult responds with the edge image of the requested job in the negotiated format, 409 Conflict if the job
+// isn't done.
+func (s *Server) getJobResult(w http.ResponseWriter, r *http.Request) {
+	result, ok := s.Jobs.Result(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return
+	}
+	if result == nil {
+		http.Error(w, "job isn't done", http.StatusConflict)
+		return
+	}
+	format, ok := negotiateEdgeResponse(w, r, EDGE_FORMATS)
+	if !ok {
+		return
+	}
+	// the result is kept as PNG, which is sent as is
+	if format == "image/png" {
+		w.Header().Set("Content-Type", format)
+		w.Write(result)
+		return
+	}
+	edges, err := decodeEdges(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeEdgeResponse(w, format, edges)
+}
+
+// getUsage responds with the usage of the API key of the request.
+func (s *Server) getUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := requestUsage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, usage)
+}
+
+// getOpenAPI responds with the OpenAPI document of the server.
+func (s *Server) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, s.openAPI)
+}
+
+// readUpload reads the image in the body of the given request and checks its size from the header. If the image
+// can't be accepted an error is sent and false is returned.
+func (s *Server) readUpload(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, SERVER_MAX_UPLOAD))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	config, _, err := DecodeImageConfig(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if s.MaxDimension > 0 && (config.Width > s.MaxDimension || config.Height > s.MaxDimension) {
+		message := fmt.Sprintf("image size %dx%d exceeds maximum dimension of %d", config.Width, config.Height, s.MaxDimension)
+		http.Error(w, message, http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	return data, true
+}
+
+// writeJSONResponse writes the given value as indented JSON with the given status code.
+func writeJSONResponse(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value) // the status is already sent, errors can't be reported anymore
+}
+
+// serveCommand implements the serve subcommand, which serves edge detection of named sessions over HTTP, see Server.
+func serveCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFileArgPtr := flags.String("config", "", "path to a JSON config file of flag values, which may also be set by EDGEEFY_ variables, e.g. EDGEEFY_API_KEY (optional)")
+	listenArgPtr := flags.String("listen", ":8080", "address to listen on (optional, default: :8080)")
+	sessionsFileArgPtr := flags.String("sessions", "", "path to a JSON object of the sessions to start with, mapping names to parameters (optional)")
+	maxDimensionArgPtr := flags.Int("max-dimension", SERVER_MAX_DIMENSION, "maximum width and height of frames, 0 = no limit (optional, default: 8000)")
+	timeoutArgPtr := flags.Duration("timeout", 5*time.Minute, "time reading a request and writing its response may take (optional, default: 5m)")
+	stripsTimeoutArgPtr := flags.Duration("strips-timeout", time.Hour, "time reading a large image for strip-wise detection and writing its edges may take (optional, default: 1h)")
+	jobWorkersArgPtr := flags.Int("job-workers", 2, "number of jobs processed concurrently (optional, default: 2)")
+	jobQueueArgPtr := flags.Int("job-queue", 100, "maximum number of jobs waiting to be processed (optional, default: 100)")
+	jobMemoryArgPtr := flags.Int("job-memory", 1024, "maximum megabytes of uploaded images and results held by jobs (optional, default: 1024)")
+	jobTTLArgPtr := flags.Duration("job-ttl", time.Hour, "time the results of finished jobs are kept (optional, default: 1h)")
+	jobRetriesArgPtr := flags.Int("job-retries", 3, "number of times a failed job is retried with exponential backoff (optional, default: 3)")
+	callbackAllowArgPtr := flags.String("callback-allow", "", "comma-separated hosts job callbacks may be posted to although their addresses are private, e.g. hooks.internal (optional, default: public addresses only)")
+	jobStoreArgPtr := flags.String("job-store", "", "path to a database file that keeps the jobs and the sessions created over the API across restarts (optional, default: memory only)")
+	apiKeyArgPtr := flags.String("api-key", "", "key clients have to send as bearer token or X-API-Key, better set by config or environment (optional)")
+	tlsCertFileArgPtr := flags.String("tls-cert", "", "path to the PEM certificate chain to serve HTTPS with, needs -tls-key (optional)")
+	tlsKeyFileArgPtr := flags.String("tls-key", "", "path to the PEM private key of the certificate (optional)")
+	tlsClientCAFileArgPtr := flags.String("tls-client-ca", "", "path to PEM certificates of the CAs client certificates are required from and verified with (optional)")
+	apiKeysFileArgPtr := flags.String("api-keys", "", "path to a JSON object mapping names to API keys with rate limits, e.g. {\"ci\": {\"key\": \"...\", \"rate\": 5, \"burst\": 10}} (optional)")
+	return flags, func() {
+		if _, err := resolveConfiguration(flags, *configFileArgPtr); err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			return
+		}
+
+		if *maxDimensionArgPtr < 0 {
+			fmt.Println("Invalid value for size limit given, exiting.")
+			return
+		}
+		if *timeoutArgPtr <= 0 || *stripsTimeoutArgPtr <= 0 {
+			fmt.Println("Invalid value for timeout given, exiting.")
+			return
+		}
+		if (*tlsCertFileArgPtr == "") != (*tlsKeyFileArgPtr == "") || (*tlsClientCAFileArgPtr != "" && *tlsCertFileArgPtr == "") {
+			fmt.Println("Invalid value for TLS given, -tls-cert and -tls-key are needed together and by -tls-client-ca, exiting.")
+			return
+		}
+		tlsConfig, err := serverTLSConfig(*tlsCertFileArgPtr, *tlsKeyFileArgPtr, *tlsClientCAFileArgPtr)
+		if err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			return
+		}
+		if *jobWorkersArgPtr < 1 || *jobQueueArgPtr < 1 || *jobMemoryArgPtr < 1 || *jobTTLArgPtr <= 0 || *jobRetriesArgPtr < 0 {
+			fmt.Println("Invalid value for job queue given, exiting.")
+			return
+		}
+		server := Server{Sessions: NewSessionStore(), MaxDimension: *maxDimensionArgPtr, StripsTimeout: *stripsTimeoutArgPtr}
+		if *maxDimensionArgPtr > 0 {
+			setDecodeMaxDimension(*maxDimensionArgPtr)
+		}
+		if *apiKeyArgPtr != "" || *apiKeysFileArgPtr != "" {
+			keys := make(map[string]APIKey)
+			if *apiKeysFileArgPtr != "" {
+				if keys, err = readAPIKeys(*apiKeysFileArgPtr); err != nil {
+					fmt.Printf("%v, exiting.\n", err)
+					return
+				}
+			}
+			if *apiKeyArgPtr != "" {
+				if _, ok := keys[FLAG_API_KEY_NAME]; ok {
+					fmt.Printf("Invalid value for api-key given, the keys file has a key named %s already, exiting.\n",
+						FLAG_API_KEY_NAME)
+					return
+				}
+				keys[FLAG_API_KEY_NAME] = APIKey{Key: *apiKeyArgPtr}
+			}
+			if server.Auth, err = NewAuthenticator(keys); err != nil {
+				fmt.Printf("%v, exiting.\n", err)
+				return
+			}
+		}
+		if *sessionsFileArgPtr != "" {
+			sessions, err := readSessionsFile(*sessionsFileArgPtr)
+			if err != nil {
+				fmt.Printf("%v, exiting.\n", err)
+				return
+			}
+			for _, session := range sessions {
+				server.Sessions.Put(session)
+			}
+		}
+		if server.Sessions.Get(DEFAULT_SESSION) == nil {
+			session, _ := NewSession(DEFAULT_SESSION, nil) // the defaults are always valid
+			server.Sessions.Put(session)
+		}
+		// the sessions created over the API replace those of the file, the jobs are restored once the sessions they
+		// refer to exist
+		if *jobStoreArgPtr != "" {
+			if server.Store, err = OpenJobStore(*jobStoreArgPtr); err != nil {
+				fmt.Printf("%v, exiting.\n", err)
+				return
+			}
+			defer server.Store.Close()
+			err = server.Store.LoadSessions(func(name string, parameters map[string]string) {
+				session, err := NewSession(name, parameters)
+				if err != nil {
+					fmt.Printf("Stored session %s dropped: %v\n", name, err)
+					return
+				}
+				server.Sessions.Put(session)
+			})
+			if err != nil {
+				fmt.Printf("%v, exiting.\n", err)
+				return
+			}
+		}
+		var callbackAllow []string
+		for _, host := range strings.Split(*callbackAllowArgPtr, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				callbackAllow = append(callbackAllow, host)
+			}
+		}
+		server.Jobs, err = NewJobQueue(*jobQueueArgPtr, *jobWorkersArgPtr, int64(*jobMemoryArgPtr)<<20, *jobTTLArgPtr,
+			*jobRetriesArgPtr, callbackAllow, server.Store, server.Sessions)
+		if err != nil {
+			fmt.Printf("%v, exiting.\n", err)
+			return
+		}
+
+		// the timeouts bound the connections slow clients hold, /strips sets deadlines of its own
+		httpServer := &http.Server{Addr: *listenArgPtr, Handler: server.Handler(), ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout: *timeoutArgPtr, WriteTimeout: *timeoutArgPtr, IdleTimeout: SERVER_IDLE_TIMEOUT, TLSConfig: tlsConfig}
+		fmt.Printf("Serving %d sessions on %s\n", len(server.Sessions.List()), *listenArgPtr)
+		if tlsConfig != nil {
+			err = httpServer.ListenAndServeTLS("", "") // the certificate is part of the configuration
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		fmt.Printf("%v, exiting.\n", err)
+	}
+}
+
+// serverTLSConfig returns the TLS configuration of the server with the certificate and key from the PEM files at the
+// given paths, nil to serve plain HTTP if no certificate is given. With the path of a PEM file of CA certificates given
+// clients have to present a certificate issued by one of them.
+func serverTLSConfig(certPath, keyPath, clientCAPath string) (*tls.Config, error) {
+	if certPath == "" {
+		return nil, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{certificate}}
+	if clientCAPath == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(clientCAPath)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", clientCAPath)
+	}
+	config.ClientCAs, config.ClientAuth = pool, tls.RequireAndVerifyClientCert
+	return config, nil
+}
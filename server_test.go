@@ -0,0 +1,339 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer returns a test server of a Server with the default session, whose jobs are processed by the given
+// number of workers.
+func newTestServer(t *testing.T, workers int) (*httptest.Server, *Server) {
+	t.Helper()
+	sessions := NewSessionStore()
+	session, _ := NewSession(DEFAULT_SESSION, nil)
+	sessions.Put(session)
+	jobs, err := NewJobQueue(4, workers, 1<<20, time.Hour, 0, nil, nil, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{Sessions: sessions, Jobs: jobs, MaxDimension: 64}
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(httpServer.Close)
+	return httpServer, server
+}
+
+// testPGM returns the test image of the given size as binary PGM, which /strips decodes while it arrives.
+func testPGM(width, height int) []byte {
+	data := []byte(fmt.Sprintf("P5\n%d %d\n255\n", width, height))
+	for _, row := range testPixels(width, height) {
+		for _, pixel := range row {
+			data = append(data, pixel.y)
+		}
+	}
+	return data
+}
+
+// request sends a request with the given body, if any, and headers given as name and value pairs, of which empty values
+// are left out, and returns the response with its body read.
+func request(t *testing.T, method, url string, body []byte, headers ...string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		if headers[i+1] != "" {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+// TestServerFrames checks the detection of uploaded frames in the negotiated formats and the rejection of uploads the
+// server can't or won't decode.
+func TestServerFrames(t *testing.T) {
+	httpServer, _ := newTestServer(t, 1)
+	frames := httpServer.URL + "/sessions/" + DEFAULT_SESSION + "/frames"
+	image := testPNG(t, 48, 32)
+
+	for _, test := range []struct {
+		name        string
+		query       string
+		accept      string
+		status      int
+		contentType string
+	}{
+		{"default", "", "", http.StatusOK, "image/png"},
+		{"png", "", "image/png", http.StatusOK, "image/png"},
+		{"jpeg", "", "image/jpeg", http.StatusOK, "image/jpeg"},
+		{"svg", "", "image/svg+xml", http.StatusOK, "image/svg+xml"},
+		{"json", "", "application/json", http.StatusOK, "application/json"},
+		{"preferred", "", "image/png;q=0.5, application/json", http.StatusOK, "application/json"},
+		{"wildcard", "", "image/*;q=0.9, text/plain", http.StatusOK, "image/png"},
+		{"query overrides accept", "?format=json", "image/png", http.StatusOK, "application/json"},
+		{"not acceptable", "", "image/gif", http.StatusNotAcceptable, ""},
+		{"unknown format", "?format=bmp", "", http.StatusBadRequest, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := request(t, "POST", frames+test.query, image, "Accept", test.accept)
+			if resp.StatusCode != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, resp.StatusCode, body)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			if contentType := resp.Header.Get("Content-Type"); contentType != test.contentType {
+				t.Errorf("expected %s, got %s", test.contentType, contentType)
+			}
+			if !strings.Contains(resp.Header.Get("Vary"), "Accept") {
+				t.Errorf("expected the response to vary by Accept")
+			}
+			if test.contentType == "application/json" {
+				var report EdgeReport
+				if err := json.Unmarshal(body, &report); err != nil || report.Width != 48 || report.Height != 32 ||
+					report.Edges == 0 || report.Edges != len(report.Points) {
+					t.Errorf("unexpected report %+v: %v", report, err)
+				}
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		name   string
+		url    string
+		body   []byte
+		status int
+	}{
+		{"no such session", httpServer.URL + "/sessions/missing/frames", image, http.StatusNotFound},
+		{"undecodable", frames, []byte("not an image"), http.StatusBadRequest},
+		{"empty", frames, nil, http.StatusBadRequest},
+		{"beyond the maximum dimension", frames, testPNG(t, 65, 8), http.StatusRequestEntityTooLarge},
+		{"header beyond the maximum dimension", frames, []byte("P5\n100000 1\n255\n"), http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if resp, body := request(t, "POST", test.url, test.body); resp.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d: %s", test.status, resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+// TestServerStrips checks that the edges streamed by /strips equal those of a frame, and that only PNG is streamed.
+func TestServerStrips(t *testing.T) {
+	httpServer, _ := newTestServer(t, 1)
+	session := httpServer.URL + "/sessions/" + DEFAULT_SESSION
+	resp, frame := request(t, "POST", session+"/frames", testPGM(48, 32))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("frame failed with status %d: %s", resp.StatusCode, frame)
+	}
+	for _, test := range []struct {
+		name, contentType string
+	}{
+		{"pgm", "image/x-portable-graymap"},
+		{"png", "image/png"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			image := testPGM(48, 32)
+			if test.name == "png" {
+				image = testPNG(t, 48, 32)
+			}
+			resp, strips := request(t, "POST", session+"/strips", image, "Content-Type", test.contentType)
+			if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" {
+				t.Fatalf("expected PNG, got status %d of %s: %s", resp.StatusCode, resp.Header.Get("Content-Type"),
+					strips)
+			}
+			expected, _ := decodeEdges(frame)
+			if edges, err := decodeEdges(strips); err != nil || !equalSamples(edges, expected) {
+				t.Errorf("expected the edges of the frame, got %v", err)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		name   string
+		url    string
+		body   []byte
+		accept string
+		status int
+	}{
+		{"no such session", httpServer.URL + "/sessions/missing/strips", testPGM(8, 8), "", http.StatusNotFound},
+		{"json", session + "/strips", testPGM(8, 8), "application/json", http.StatusNotAcceptable},
+		{"truncated", session + "/strips", testPGM(48, 32)[:100], "", http.StatusBadRequest},
+		{"beyond the maximum dimension", session + "/strips", testPGM(65, 8), "", http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := request(t, "POST", test.url, test.body, "Accept", test.accept)
+			if resp.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d: %s", test.status, resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+// TestServerStripsTimeout checks that the deadlines of /strips replace the timeouts of the HTTP server, so large
+// images may take longer to upload than frames.
+func TestServerStripsTimeout(t *testing.T) {
+	sessions := NewSessionStore()
+	session, _ := NewSession(DEFAULT_SESSION, nil)
+	sessions.Put(session)
+	server := &Server{Sessions: sessions, StripsTimeout: time.Minute}
+	httpServer := httptest.NewUnstartedServer(server.Handler())
+	httpServer.Config.ReadTimeout = 200 * time.Millisecond
+	httpServer.Start()
+	defer httpServer.Close()
+
+	// the body is sent in two parts with a pause longer than the read timeout of the server in between
+	slowUpload := func(path string) (int, error) {
+		image := testPGM(48, 32)
+		reader, writer := io.Pipe()
+		go func() {
+			writer.Write(image[:100])
+			time.Sleep(500 * time.Millisecond)
+			writer.Write(image[100:])
+			writer.Close()
+		}()
+		resp, err := http.Post(httpServer.URL+"/sessions/"+DEFAULT_SESSION+path, "application/octet-stream", reader)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, nil
+	}
+	if status, err := slowUpload("/frames"); err == nil && status == http.StatusOK {
+		t.Errorf("expected the slow frame to time out")
+	}
+	if status, err := slowUpload("/strips"); err != nil || status != http.StatusOK {
+		t.Errorf("expected the slow strips to be detected, got status %d: %v", status, err)
+	}
+}
+
+// TestServerJobs checks the life cycle of a job from its submission to its result in the negotiated formats.
+func TestServerJobs(t *testing.T) {
+	httpServer, _ := newTestServer(t, 1)
+	jobs := httpServer.URL + "/sessions/" + DEFAULT_SESSION + "/jobs"
+	resp, body := request(t, "POST", jobs, testPNG(t, 48, 32))
+	var info JobInfo
+	if resp.StatusCode != http.StatusAccepted || json.Unmarshal(body, &info) != nil {
+		t.Fatalf("expected the job to be accepted, got status %d: %s", resp.StatusCode, body)
+	}
+	if location := resp.Header.Get("Location"); location != "/jobs/"+info.ID {
+		t.Errorf("expected the location of the job, got %s", location)
+	}
+	waitFor(t, "the job", func() bool {
+		_, body := request(t, "GET", httpServer.URL+"/jobs/"+info.ID, nil)
+		return json.Unmarshal(body, &info) == nil && info.Status == JOB_DONE
+	})
+
+	result := httpServer.URL + "/jobs/" + info.ID + "/result"
+	resp, png := request(t, "GET", result, nil)
+	if edges, err := decodeEdges(png); resp.StatusCode != http.StatusOK || err != nil || len(edges) != 32 {
+		t.Errorf("expected the edges as PNG, got status %d: %v", resp.StatusCode, err)
+	}
+	resp, body = request(t, "GET", result, nil, "Accept", "application/json")
+	var report EdgeReport
+	if resp.StatusCode != http.StatusOK || json.Unmarshal(body, &report) != nil || report.Width != 48 {
+		t.Errorf("expected the edges as JSON, got status %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := request(t, "GET", result, nil, "Accept", "image/gif"); resp.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("expected status %d, got %d", http.StatusNotAcceptable, resp.StatusCode)
+	}
+
+	for _, test := range []struct {
+		name, method, url string
+		body              []byte
+		status            int
+	}{
+		{"no such job", "GET", httpServer.URL + "/jobs/missing", nil, http.StatusNotFound},
+		{"no such result", "GET", httpServer.URL + "/jobs/missing/result", nil, http.StatusNotFound},
+		{"no such session", "POST", httpServer.URL + "/sessions/missing/jobs", testPNG(t, 8, 8), http.StatusNotFound},
+		{"invalid callback", "POST", jobs + "?callback=ftp://example.com/", testPNG(t, 8, 8), http.StatusBadRequest},
+		{"undecodable", "POST", jobs, []byte("not an image"), http.StatusBadRequest},
+		{"beyond the maximum dimension", "POST", jobs, testPNG(t, 8, 65), http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if resp, body := request(t, test.method, test.url, test.body); resp.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d: %s", test.status, resp.StatusCode, body)
+			}
+		})
+	}
+
+	// without workers the job stays queued
+	idle, _ := newTestServer(t, 0)
+	_, body = request(t, "POST", idle.URL+"/sessions/"+DEFAULT_SESSION+"/jobs", testPNG(t, 8, 8))
+	if err := json.Unmarshal(body, &info); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := request(t, "GET", idle.URL+"/jobs/"+info.ID+"/result", nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected status %d for the result of a queued job, got %d", http.StatusConflict, resp.StatusCode)
+	}
+}
+
+// TestServerSessions checks creating, describing and removing sessions.
+func TestServerSessions(t *testing.T) {
+	httpServer, _ := newTestServer(t, 1)
+	session := httpServer.URL + "/sessions/camera"
+	for _, test := range []struct {
+		name, method, url string
+		body              string
+		status            int
+	}{
+		{"missing", "GET", session, "", http.StatusNotFound},
+		{"invalid parameters", "PUT", session, `{"sigma": "-1"}`, http.StatusBadRequest},
+		{"unknown parameter", "PUT", session, `{"color": "red"}`, http.StatusBadRequest},
+		{"not JSON", "PUT", session, `sigma=1`, http.StatusBadRequest},
+		{"create", "PUT", session, `{"sigma": "1.5"}`, http.StatusOK},
+		{"describe", "GET", session, "", http.StatusOK},
+		{"detect", "POST", session + "/frames", "", http.StatusBadRequest},
+		{"remove", "DELETE", session, "", http.StatusNoContent},
+		{"remove again", "DELETE", session, "", http.StatusNotFound},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := request(t, test.method, test.url, []byte(test.body))
+			if resp.StatusCode != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, resp.StatusCode, body)
+			}
+			if test.name == "describe" {
+				var info SessionInfo
+				if err := json.Unmarshal(body, &info); err != nil || info.Parameters["sigma"] != "1.5" {
+					t.Errorf("expected the parameters of the session, got %s", body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !edgeefy_minimal
+
+package edgeefy
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrSessionBusy is returned for frames that are dropped because the session is still detecting the previous one.
+	ErrSessionBusy = errors.New("session is busy, frame dropped")
+	// ErrSessionClosed is returned for frames of a session that was replaced or deleted meanwhile.
+	ErrSessionClosed = errors.New("session is closed")
+)
+
+// Session is a named stream of frames served by the server, e.g. from one camera. Every session has parameters of its
+// own and keeps the real-time detector for the size of its frames between them. The frames of a session are detected
+// one at a time, with drop-frames set frames that arrive meanwhile are dropped instead of waiting.
+type Session struct {
+	Name       string
+	Parameters map[string]string // flags of real-time detection and drop-frames
+
+	detector   *Detector
+	dropFrames bool
+	mutex      sync.Mutex        // held while a frame is detected
+	realtime   *RealtimeDetector // detector for the size of the last frame, nil before the first frame
+	closed     bool              // set by Close, no real-time detector is created afterwards
+	counters   StreamCounters
+	// latencies of the real-time detector as of the last frame, published so that reading them never holds up frames
+	latency atomic.Pointer[LatencyStats]
+}
+
+// SessionInfo describes a session in the responses of the server.
+type SessionInfo struct {
+	Name       string            `json:"name"`
+	Parameters map[string]string `json:"parameters"`
+	Stats      StreamStats       `json:"stats"`
+}
+
+// NewSession returns a session with the given name and parameters, which are the flags of the stream subcommand that
+// set up the detection and the frame policy, e.g. min, blur or drop-frames.
+func NewSession(name string, parameters map[string]string) (*Session, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	newDetector := addRealtimeFlags(flags)
+	dropFramesFlagPtr := flags.Bool("drop-frames", false, "")
+	for _, parameter := range sortedKeys(parameters) {
+		if flags.Lookup(parameter) == nil {
+			return nil, fmt.Errorf("unknown parameter %s", parameter)
+		}
+		if err := flags.Set(parameter, parameters[parameter]); err != nil {
+			return nil, fmt.Errorf("invalid value for %s", parameter)
+		}
+	}
+	detector := newDetector()
+	if issues := detector.Validate(0, 0, nil); hasValidationError(issues) {
+		return nil, errors.New(formatIssues(issues))
+	}
+	if err := detector.checkLocalStages("sessions"); err != nil {
+		return nil, err
+	}
+	if parameters == nil {
+		parameters = map[string]string{}
+	}
+	return &Session{Name: name, Parameters: parameters, detector: detector, dropFrames: *dropFramesFlagPtr}, nil
+}
+
+// Detect detects the edges of the given frame with the parameters of the session. The real-time detector of the
+// session is reused if the frame has the size of the previous one. If the session is busy with another frame the
+// frame either waits or, with drop-frames set, is dropped with ErrSessionBusy.
+func (s *Session) Detect(img image.Image) ([][]uint8, error) {
+	return s.detect(img, !s.dropFrames)
+}
+
+// detect detects the edges of the given frame like Detect. If the session is busy the frame waits if wait is set and
+// is dropped otherwise.
+func (s *Session) detect(img image.Image, wait bool) ([][]uint8, error) {
+	s.counters.received.Add(1)
+	if wait {
+		s.mutex.Lock()
+	} else if !s.mutex.TryLock() {
+		s.counters.dropped.Add(1)
+		return nil, ErrSessionBusy
+	}
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil, ErrSessionClosed
+	}
+
+	frame := pixelsToSamples(imageToPixelArray(img))
+	if len(frame) == 0 || len(frame[0]) == 0 {
+		return nil, errors.New("frame is empty")
+	}
+	if s.realtime == nil || s.realtime.width != len(frame[0]) || s.realtime.height != len(frame) {
+		if s.realtime != nil {
+			s.realtime.Close()
+			s.realtime = nil
+		}
+		realtime, err := NewRealtimeDetector(s.detector, len(frame[0]), len(frame))
+		if err != nil {
+			return nil, err
+		}
+		s.realtime = realtime
+	}
+	edges, err := s.realtime.Detect(frame)
+	if err != nil {
+		return nil, err
+	}
+	s.counters.processed.Add(1)
+	latency := s.realtime.Latency()
+	s.latency.Store(&latency)
+	// the edges are a buffer of the real-time detector that the next frame overwrites
+	return copySamples(edges), nil
+}
+
+// DetectStrips detects the edges of the given image strip by strip with the parameters of the session and passes the
+// rows of the edge image to the given function in order, see Detector.DetectStrips. The real-time detector isn't
+// used, so large images neither wait for nor replace it and are never dropped.
+func (s *Session) DetectStrips(source StripSource, row func(edges []uint8) error) error {
+	s.counters.received.Add(1)
+	if err := s.detector.DetectStrips(source, STRIPS_RECOMPUTE, row); err != nil {
+		return err
+	}
+	s.counters.processed.Add(1)
+	return nil
+}
+
+// Info returns the description of the session including the current counters. It doesn't wait for the frame in
+// progress, so it never causes frames to be dropped.
+func (s *Session) Info() SessionInfo {
+	var latency LatencyStats
+	if published := s.latency.Load(); published != nil {
+		latency = *published
+	}
+	return SessionInfo{s.Name, s.Parameters, s.counters.Stats(latency)}
+}
+
+// Close stops the workers of the real-time detector of the session once the frame in progress is done. Frames that
+// arrive afterwards, e.g. queued jobs of a replaced session, fail with ErrSessionClosed instead of starting a new
+// real-time detector that nobody closes.
+func (s *Session) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	if s.realtime != nil {
+		s.realtime.Close()
+		s.realtime = nil
+	}
+}
+
+// SessionStore holds the sessions of the server by name. It is safe for concurrent use.
+type SessionStore struct {
+	mutex    sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewSessionStore returns an empty session store.
+func NewSessionStore() *SessionStore {
+	return &SessionStore{sessions: make(map[string]*Session)}
+}
+
+// Get returns the session with the given name, nil if there is none.
+func (s *SessionStore) Get(name string) *Session {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.sessions[name]
+}
+
+// Put adds the given session, a session of the same name is replaced and closed.
+func (s *SessionStore) Put(session *Session) {
+	s.mutex.Lock()
+	previous := s.sessions[session.Name]
+	s.sessions[session.Name] = session
+	s.mutex.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+}
+
+// Delete removes and closes the session with the given name and reports whether it existed.
+func (s *SessionStore) Delete(name string) bool {
+	s.mutex.Lock()
+	session := s.sessions[name]
+	delete(s.sessions, name)
+	s.mutex.Unlock()
+	if session != nil {
+		session.Close()
+	}
+	return session != nil
+}
+
+// List returns the descriptions of all sessions sorted by name.
+func (s *SessionStore) List() []SessionInfo {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	infos := []SessionInfo{}
+	for _, name := range sortedKeys(s.sessions) {
+		infos = append(infos, s.sessions[name].Info())
+	}
+	return infos
+}
+
+// readSessionParameters reads a JSON object that maps parameter names to values, given as strings, numbers or
+// booleans.
+func readSessionParameters(r io.Reader) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid parameters: %v", err)
+	}
+	return configValues(raw)
+}
+
+// readSessionsFile reads the sessions the server starts with from a JSON object that maps session names to their
+// parameters.
+func readSessionsFile(path string) ([]*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid sessions file %s: %v", path, err)
+	}
+	var sessions []*Session
+	for _, name := range sortedKeys(raw) {
+		parameters, err := configValues(raw[name])
+		if err != nil {
+			return nil, fmt.Errorf("session %s in %s: %v", name, path, err)
+		}
+		session, err := NewSession(name, parameters)
+		if err != nil {
+			return nil, fmt.Errorf("session %s in %s: %v", name, path, err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
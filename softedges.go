@@ -0,0 +1,49 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import "math"
+
+// SoftEdges returns the edge image of the given results of a detection with every edge pixel set to its gradient
+// magnitude instead of the strength it was detected with, scaled so that the strongest edge pixel is white. Weak edges
+// get darker values, but never black. The result looks anti-aliased and is better suited for compositing. The results
+// are taken from DetectArtifacts, so all stages and settings of the detector apply, and floating point samples keep
+// the magnitude beyond the range of 8-bit samples.
+func SoftEdges[T Sample](artifacts PipelineArtifacts[T]) [][]GrayPixel {
+	edges, magnitude := artifacts.Edges, artifacts.Magnitude
+	strongest := 0.0
+	for y := range edges {
+		for x := range edges[y] {
+			if edges[y][x] > 0 {
+				strongest = math.Max(strongest, float64(magnitude[y][x]))
+			}
+		}
+	}
+	result := make([][]GrayPixel, len(edges))
+	for y := range edges {
+		result[y] = make([]GrayPixel, len(edges[y]))
+		for x := range edges[y] {
+			result[y][x] = GrayPixel{0, 255}
+			if edges[y][x] > 0 && strongest > 0 {
+				// edge pixels stay non-zero so that they are still recognized as edges
+				result[y][x].y = uint8(max(1, math.Round(255*float64(magnitude[y][x])/strongest)))
+			}
+		}
+	}
+	return result
+}
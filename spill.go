@@ -0,0 +1,186 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+)
+
+// SPILL_MAX_DECODED_PIXELS is the maximum number of pixels of streamed images in formats other than binary PGM and PPM,
+// which are decoded in memory as a whole. 8000x8000 pixels take 256MB as RGBA.
+const SPILL_MAX_DECODED_PIXELS = 8000 * 8000
+
+// ErrSpillDecodedTooLarge is returned for streamed images that would have to be decoded in memory but exceed
+// SPILL_MAX_DECODED_PIXELS.
+var ErrSpillDecodedTooLarge = errors.New("image too large to be decoded in memory, send it as binary PGM or PPM")
+
+// SpillFile is an 8-bit image that is held in a temporary file instead of memory. Rows are written and read by their
+// position, so strips can be stored and read back in any order.
+type SpillFile struct {
+	file          *os.File
+	width, height int
+}
+
+// NewSpillFile creates a spill file for an image of the given size in the directory for temporary files.
+func NewSpillFile(width, height int) (*SpillFile, error) {
+	file, err := os.CreateTemp("", "edgeefy-spill-*")
+	if err != nil {
+		return nil, err
+	}
+	return &SpillFile{file, width, height}, nil
+}
+
+// Size returns the width and height of the image.
+func (s *SpillFile) Size() (int, int) {
+	return s.width, s.height
+}
+
+// WriteRows writes the given rows to the image starting at row y0.
+func (s *SpillFile) WriteRows(y0 int, rows [][]uint8) error {
+	for i, row := range rows {
+		if _, err := s.file.WriteAt(row, int64(y0+i)*int64(s.width)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rows reads the rows from y0 up to y1 of the image.
+func (s *SpillFile) Rows(y0, y1 int) ([][]uint8, error) {
+	data := make([]uint8, (y1-y0)*s.width)
+	if _, err := s.file.ReadAt(data, int64(y0)*int64(s.width)); err != nil {
+		return nil, err
+	}
+	rows := make([][]uint8, y1-y0)
+	for y := range rows {
+		rows[y] = data[y*s.width : (y+1)*s.width]
+	}
+	return rows, nil
+}
+
+// Close closes and removes the spill file.
+func (s *SpillFile) Close() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(s.file.Name())
+}
+
+// spillImage decodes the image file at the given path and writes its gray values to a spill file row by row. The
+// decoded image is held while it is converted, but neither the pixel array nor any result of the detection.
+func spillImage(path, rawFormat string) (*SpillFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // opened for reading, no error checking needed
+	img, err := decodeInput(file, rawFormat)
+	if err != nil {
+		return nil, err
+	}
+	spilled, err := NewSpillFile(img.Bounds().Max.X, img.Bounds().Max.Y)
+	if err != nil {
+		return nil, err
+	}
+	if err := spilled.writeImage(0, img); err != nil {
+		spilled.Close()
+		return nil, err
+	}
+	return spilled, nil
+}
+
+// spillStream reads an image from the given stream and writes its gray values to a spill file. The size of the image
+// is passed to check before its pixels are read, an error of check is returned. Binary PGM and PPM images are read in
+// bands of STRIP_HEIGHT rows, so neither the image nor its encoding is ever held in memory. Other formats are decoded
+// while they are read, which holds the decoded image but not the encoded data, so they are refused with
+// ErrSpillDecodedTooLarge above SPILL_MAX_DECODED_PIXELS.
+func spillStream(r io.Reader, check func(width, height int) error) (*SpillFile, error) {
+	buffered := bufio.NewReader(r)
+	if magic, _ := buffered.Peek(2); string(magic) == "P5" || string(magic) == "P6" {
+		header, err := readPNMHeader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		if err := check(header.width, header.height); err != nil {
+			return nil, err
+		}
+		spilled, err := NewSpillFile(header.width, header.height)
+		if err != nil {
+			return nil, err
+		}
+		for y0 := 0; y0 < header.height; y0 += STRIP_HEIGHT {
+			band, err := readPNMRows(buffered, header, min(STRIP_HEIGHT, header.height-y0))
+			if err == nil {
+				err = spilled.writeImage(y0, band)
+			}
+			if err != nil {
+				spilled.Close()
+				return nil, err
+			}
+		}
+		return spilled, nil
+	}
+
+	// the header is kept while the size is decoded and read again by the decoder
+	var header bytes.Buffer
+	config, _, err := DecodeImageConfig(io.TeeReader(buffered, &header))
+	if err != nil {
+		return nil, err
+	}
+	if err := check(config.Width, config.Height); err != nil {
+		return nil, err
+	}
+	if int64(config.Width)*int64(config.Height) > SPILL_MAX_DECODED_PIXELS {
+		return nil, fmt.Errorf("%w: %dx%d", ErrSpillDecodedTooLarge, config.Width, config.Height)
+	}
+	img, err := decodeInput(io.MultiReader(&header, buffered), "")
+	if err != nil {
+		return nil, err
+	}
+	spilled, err := NewSpillFile(img.Bounds().Max.X, img.Bounds().Max.Y)
+	if err != nil {
+		return nil, err
+	}
+	if err := spilled.writeImage(0, img); err != nil {
+		spilled.Close()
+		return nil, err
+	}
+	return spilled, nil
+}
+
+// writeImage converts the rows of the given image to gray values and writes them starting at row y0.
+func (s *SpillFile) writeImage(y0 int, img image.Image) error {
+	// the layout follows imageToPixelArray
+	pixel := pixelFunc(img)
+	row := make([]uint8, img.Bounds().Max.X)
+	for y := 0; y < img.Bounds().Max.Y; y++ {
+		for x := range row {
+			row[x] = pixel(x, y).y
+		}
+		if err := s.WriteRows(y0+y, [][]uint8{row}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
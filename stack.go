@@ -0,0 +1,72 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"errors"
+	"image"
+	"sort"
+)
+
+// isValidStackMode checks whether the given name denotes a supported way of stacking input files, the empty string
+// disables stacking.
+func isValidStackMode(mode string) bool {
+	return mode == "" || mode == "mean" || mode == "median"
+}
+
+// stackImages combines the given pixels with those of the images at the given paths by the given mode. The mean
+// reduces random noise, the median also removes outliers such as hot pixels, satellite trails or passing objects. The
+// images have to show the same scene without camera movement.
+func stackImages(pixels [][]GrayPixel, paths []string, rawFormat string, mode string) ([][]GrayPixel, error) {
+	frames := [][][]uint8{pixelsToSamples(pixels)}
+	for _, path := range paths {
+		frame := pixelsToSamples(openImage(path, rawFormat))
+		if len(frame) != len(pixels) || len(frame[0]) != len(pixels[0]) {
+			return nil, errors.New("input files differ in size")
+		}
+		frames = append(frames, frame)
+	}
+	if mode == "median" {
+		return samplesToPixels(MedianFrames(frames)), nil
+	}
+	return samplesToPixels(roundSamples(AverageFrames(frames, make([]image.Point, len(frames))))), nil
+}
+
+// MedianFrames returns the per-pixel median of the given frames of identical size. For an even number of frames the
+// mean of the two middle values is taken.
+func MedianFrames[T Sample](frames [][][]T) [][]T {
+	height, width := len(frames[0]), len(frames[0][0])
+	median := make([][]T, height)
+	values := make([]float64, len(frames))
+	for y := range median {
+		median[y] = make([]T, width)
+		for x := range median[y] {
+			for i, frame := range frames {
+				values[i] = float64(frame[y][x])
+			}
+			sort.Float64s(values)
+			middle := len(values) / 2
+			if len(values)%2 == 0 {
+				median[y][x] = T((values[middle-1] + values[middle]) / 2)
+			} else {
+				median[y][x] = T(values[middle])
+			}
+		}
+	}
+	return median
+}
@@ -0,0 +1,178 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+// enumeration type for denoting the stages of the detection pipeline
+type Stage int
+
+const (
+	STAGE_INTENSITY   Stage = iota // input of the detection, after the logarithm if enabled
+	STAGE_BLUR                     // blurred samples, also reported if blurring is disabled
+	STAGE_GRADIENT                 // gradient magnitude
+	STAGE_SUPPRESSION              // gradient magnitude after non-maximum suppression
+	STAGE_TRACKING                 // edges after double thresholding and hysteresis tracking
+	STAGE_POSTPROCESS              // edges after despeckling and gap bridging
+)
+
+// stageNames maps the stages to the names returned by String.
+var stageNames = map[Stage]string{
+	STAGE_INTENSITY:   "intensity",
+	STAGE_BLUR:        "blur",
+	STAGE_GRADIENT:    "gradient",
+	STAGE_SUPPRESSION: "suppression",
+	STAGE_TRACKING:    "tracking",
+	STAGE_POSTPROCESS: "postprocess",
+}
+
+// String returns the name of the stage.
+func (s Stage) String() string {
+	return stageNames[s]
+}
+
+// stageComplete passes the buffer of the given stage to the hook of the detector if one is set.
+func (d *Detector) stageComplete(stage Stage, buffer any) {
+	if d.OnStageComplete != nil {
+		d.OnStageComplete(stage, buffer)
+	}
+}
+
+// Blurrer smooths the samples before the gradients are computed. Pixels that are not marked in the given mask should
+// be left out of the blur, a nil mask marks all pixels as valid.
+type Blurrer interface {
+	Blur(samples [][]float64, valid [][]bool) [][]float64
+}
+
+// GradientOperator computes the gradient magnitude and the gradient directions in degrees of the samples. Pixels that
+// are not marked in the given mask must get a magnitude of zero.
+type GradientOperator interface {
+	Gradient(samples [][]float64, valid [][]bool) (magnitude [][]float64, directions [][]float64)
+}
+
+// Suppressor thins the gradient magnitude to the ridges of the edges, based on the gradient directions.
+type Suppressor interface {
+	Suppress(magnitude [][]float64, directions [][]float64) [][]float64
+}
+
+// Thresholder chooses the low and high hysteresis thresholds for the suppressed gradient magnitude.
+type Thresholder interface {
+	Thresholds(magnitude [][]float64) (low, high float64)
+}
+
+// Tracker returns the edge image of the suppressed gradient magnitude for the given hysteresis thresholds. Edge pixels
+// are non-zero.
+type Tracker interface {
+	Track(magnitude [][]float64, low, high float64) [][]float64
+}
+
+// DefaultBlurrer is the Blurrer the detection uses by default, it blurs with the filter configured in the detector.
+type DefaultBlurrer struct {
+	Detector *Detector
+}
+
+// Blur blurs the given samples like the detection of the detector does.
+func (b DefaultBlurrer) Blur(samples [][]float64, valid [][]bool) [][]float64 {
+	return blurSamples(b.Detector, samples, valid)
+}
+
+// SobelOperator is the GradientOperator the detection uses by default.
+type SobelOperator struct {
+	Workers int
+}
+
+// Gradient returns the magnitude and direction of the sobel gradients of the given samples.
+func (o SobelOperator) Gradient(samples [][]float64, valid [][]bool) ([][]float64, [][]float64) {
+	return sobel(samples, valid, o.Workers)
+}
+
+// NonMaximumSuppressor is the Suppressor the detection uses by default.
+type NonMaximumSuppressor struct {
+	Workers int
+}
+
+// Suppress keeps the pixels of the given magnitude that are not smaller than their neighbours in gradient direction.
+func (s NonMaximumSuppressor) Suppress(magnitude [][]float64, directions [][]float64) [][]float64 {
+	return nonMaximumSuppression(magnitude, directions, s.Workers)
+}
+
+// RatioThresholder is the Thresholder the detection uses by default. The thresholds are the given ratios of the
+// magnitude at the given percentile of the non-zero magnitudes.
+type RatioThresholder struct {
+	MinRatio, MaxRatio, Percentile float64
+}
+
+// Thresholds returns the low and high thresholds for the given magnitude.
+func (t RatioThresholder) Thresholds(magnitude [][]float64) (float64, float64) {
+	return ratioThresholds(magnitude, t.MinRatio, t.MaxRatio, t.Percentile)
+}
+
+// HysteresisTracker is the Tracker the detection uses by default. Pixels above the high threshold are edges, pixels
+// above the low threshold only if they are connected to such a pixel.
+type HysteresisTracker struct{}
+
+// Track returns the edge image of the given magnitude, the given buffer is modified.
+func (HysteresisTracker) Track(magnitude [][]float64, low, high float64) [][]float64 {
+	return trackEdges(magnitude, low, high)
+}
+
+// blurSamples blurs the given samples with the filter configured in the given detector. Without blur the samples are
+// returned unchanged.
+func blurSamples[T Sample](d *Detector, samples [][]T, valid [][]bool) [][]T {
+	if d.Blur && d.BlurFilter == BOX {
+		return boxBlur(samples, 1, d.BoxPasses, valid, d.Workers)
+	} else if d.Blur && d.Sigma > IIR_SIGMA_THRESHOLD {
+		return recursiveGaussianBlur(samples, d.Sigma, valid, d.Workers)
+	} else if d.Blur && d.Sigma > 0 {
+		return kernelBlur(samples, d.gaussianKernel(d.Sigma), valid, d.Workers)
+	} else if d.Blur {
+		return kernelBlur(samples, d.binomialKernel(5), valid, d.Workers)
+	}
+	return samples
+}
+
+// ratioThresholds returns the given ratios of the magnitude at the given percentile of the non-zero magnitudes.
+func ratioThresholds[T Sample](magnitude [][]T, minRatio, maxRatio, percentile float64) (float64, float64) {
+	reference := thresholdReference(magnitude, percentile)
+	return minRatio * reference, maxRatio * reference
+}
+
+// trackEdges applies the double threshold and the hysteresis tracking to the given magnitude in place.
+func trackEdges[T Sample](magnitude [][]T, low, high float64) [][]T {
+	strong, weak := doublethreshold(magnitude, high, low)
+	edgeTracking(magnitude, strong, weak)
+	return magnitude
+}
+
+// customStages returns the names of the stages of the given detector that are replaced by custom implementations.
+func (d *Detector) customStages() []string {
+	var names []string
+	for _, stage := range []struct {
+		name   string
+		custom bool
+	}{
+		{"blur", d.Blurrer != nil},
+		{"gradient", d.GradientOperator != nil},
+		{"suppression", d.Suppressor != nil},
+		{"thresholds", d.Thresholder != nil},
+		{"tracking", d.Tracker != nil},
+	} {
+		if stage.custom {
+			names = append(names, stage.name)
+		}
+	}
+	return names
+}
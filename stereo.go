@@ -0,0 +1,179 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// StereoConsistency describes how well the edges of a rectified stereo pair correspond to each other.
+type StereoConsistency struct {
+	LeftEdges    int      // number of edge pixels of the left image
+	RightEdges   int      // number of edge pixels of the right image
+	LeftMatched  int      // number of left edge pixels with a right edge pixel within the disparity window
+	RightMatched int      // number of right edge pixels with a left edge pixel within the disparity window
+	LeftOnly     [][]bool // left edge pixels without a counterpart in the right image
+	RightOnly    [][]bool // right edge pixels without a counterpart in the left image
+}
+
+// LeftRatio returns the fraction of the left edge pixels that are consistent, one if there are no edge pixels.
+func (c StereoConsistency) LeftRatio() float64 {
+	if c.LeftEdges == 0 {
+		return 1
+	}
+	return float64(c.LeftMatched) / float64(c.LeftEdges)
+}
+
+// RightRatio returns the fraction of the right edge pixels that are consistent, one if there are no edge pixels.
+func (c StereoConsistency) RightRatio() float64 {
+	if c.RightEdges == 0 {
+		return 1
+	}
+	return float64(c.RightMatched) / float64(c.RightEdges)
+}
+
+// stereoCommand implements the stereo subcommand, which detects the edges of both images of a rectified stereo pair and
+// reports the edges that appear in only one of them. The visualization shows both edge images side by side with the
+// consistent edges in white and the others in red, e.g. to find occlusions, reflections or a misaligned rig.
+func stereoCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("stereo", flag.ExitOnError)
+	leftFileArgPtr := flags.String("left", "", "path to left image (required)")
+	rightFileArgPtr := flags.String("right", "", "path to right image (required)")
+	outputFileArgPtr := flags.String("output", "stereo.png", "path to output file (optional, default: stereo.png)")
+	minDisparityArgPtr := flags.Int("min-disparity", 0, "smallest disparity in pixels of matching edges (optional, default: 0)")
+	maxDisparityArgPtr := flags.Int("max-disparity", 64, "largest disparity in pixels of matching edges (optional, default: 64)")
+	rowToleranceArgPtr := flags.Int("row-tolerance", 1, "rows matching edges may be apart due to rectification errors (optional, default: 1)")
+	minThresholdArgPtr := flags.Float64("min", float64(0.2), "ratio of lower threshold (optional, default: 0.2)")
+	maxThresholdArgPtr := flags.Float64("max", float64(0.6), "ratio of upper threshold (optional, default: 0.6)")
+	return flags, func() {
+		if *leftFileArgPtr == "" || *rightFileArgPtr == "" {
+			fmt.Println("No path to input files specified, nothing to do.")
+			return
+		}
+		if !isValidRatioValue(*minThresholdArgPtr) || !isValidRatioValue(*maxThresholdArgPtr) {
+			fmt.Println("Invalid value for threshold ratio given, exiting.")
+			return
+		}
+		if *minDisparityArgPtr < 0 || *maxDisparityArgPtr < *minDisparityArgPtr || *rowToleranceArgPtr < 0 {
+			fmt.Println("Invalid value for disparity window given, exiting.")
+			return
+		}
+
+		left := openImage(*leftFileArgPtr, "")
+		right := openImage(*rightFileArgPtr, "")
+		if len(left) != len(right) || len(left[0]) != len(right[0]) {
+			fmt.Println("Images differ in size, exiting.")
+			return
+		}
+
+		detector := NewDetector(true, *minThresholdArgPtr, *maxThresholdArgPtr)
+		leftEdges, rightEdges := detector.Detect(left), detector.Detect(right)
+		consistency := CompareStereoEdges(leftEdges, rightEdges, *minDisparityArgPtr, *maxDisparityArgPtr, *rowToleranceArgPtr)
+		fmt.Printf("left edges: %d, consistent %.4f\n", consistency.LeftEdges, consistency.LeftRatio())
+		fmt.Printf("right edges: %d, consistent %.4f\n", consistency.RightEdges, consistency.RightRatio())
+		writeColorImage(stereoImage(leftEdges, rightEdges, consistency), *outputFileArgPtr)
+	}
+}
+
+// CompareStereoEdges matches the edges of a rectified stereo pair. A point at x in the left image appears at x-d in the
+// right image for a disparity d, so a left edge pixel is consistent if the right image has an edge pixel with a
+// disparity from minDisparity to maxDisparity in a row at most rowTolerance rows away, and vice versa.
+func CompareStereoEdges(left, right [][]GrayPixel, minDisparity, maxDisparity, rowTolerance int) StereoConsistency {
+	height := len(left)
+	leftCounts, rightCounts := edgeRowCounts(left), edgeRowCounts(right)
+	consistency := StereoConsistency{LeftOnly: make([][]bool, height), RightOnly: make([][]bool, height)}
+	for y := range left {
+		consistency.LeftOnly[y] = make([]bool, len(left[y]))
+		consistency.RightOnly[y] = make([]bool, len(right[y]))
+		for x := range left[y] {
+			if left[y][x].y > 0 {
+				consistency.LeftEdges++
+				if hasEdgeInWindow(rightCounts, x-maxDisparity, x-minDisparity, y, rowTolerance) {
+					consistency.LeftMatched++
+				} else {
+					consistency.LeftOnly[y][x] = true
+				}
+			}
+			if right[y][x].y > 0 {
+				consistency.RightEdges++
+				if hasEdgeInWindow(leftCounts, x+minDisparity, x+maxDisparity, y, rowTolerance) {
+					consistency.RightMatched++
+				} else {
+					consistency.RightOnly[y][x] = true
+				}
+			}
+		}
+	}
+	return consistency
+}
+
+// edgeRowCounts returns the cumulative number of edge pixels of every row, the element x of a row holds the number of
+// edge pixels left of x. This answers the number of edge pixels in a range of a row in constant time.
+func edgeRowCounts(edges [][]GrayPixel) [][]int {
+	counts := make([][]int, len(edges))
+	for y := range edges {
+		counts[y] = make([]int, len(edges[y])+1)
+		for x := range edges[y] {
+			counts[y][x+1] = counts[y][x]
+			if edges[y][x].y > 0 {
+				counts[y][x+1]++
+			}
+		}
+	}
+	return counts
+}
+
+// hasEdgeInWindow checks whether the rows at most rowTolerance rows away from y have an edge pixel in the columns from
+// start to end according to the given cumulative counts. Columns outside of the image are skipped.
+func hasEdgeInWindow(counts [][]int, start, end, y, rowTolerance int) bool {
+	for i := max(0, y-rowTolerance); i <= min(len(counts)-1, y+rowTolerance); i++ {
+		from, to := max(start, 0), min(end+1, len(counts[i])-1)
+		if from < to && counts[i][to] > counts[i][from] {
+			return true
+		}
+	}
+	return false
+}
+
+// stereoImage returns the given edge images side by side, the left one first. Consistent edges are white, edges
+// without a counterpart in the other image are red.
+func stereoImage(left, right [][]GrayPixel, consistency StereoConsistency) *image.RGBA {
+	height, width := len(left), len(left[0])
+	img := image.NewRGBA(image.Rect(0, 0, 2*width, height))
+	for y := range left {
+		for x := range left[y] {
+			img.SetRGBA(x, y, stereoColor(left[y][x], consistency.LeftOnly[y][x]))
+			img.SetRGBA(width+x, y, stereoColor(right[y][x], consistency.RightOnly[y][x]))
+		}
+	}
+	return img
+}
+
+// stereoColor returns the color of the given edge pixel in the visualization of a stereo pair.
+func stereoColor(pixel GrayPixel, inconsistent bool) color.RGBA {
+	if pixel.y == 0 {
+		return color.RGBA{0, 0, 0, 255}
+	}
+	if inconsistent {
+		return color.RGBA{255, 0, 0, 255}
+	}
+	return color.RGBA{255, 255, 255, 255}
+}
@@ -0,0 +1,250 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// StreamStats holds the counters of a live stream of frames, see StreamCounters.
+type StreamStats struct {
+	Received  int64        `json:"received"`  // frames read from the input
+	Processed int64        `json:"processed"` // frames whose edges were written
+	Dropped   int64        `json:"dropped"`   // frames discarded because the detection didn't keep up
+	Latency   LatencyStats `json:"latency"`   // time taken to detect the edges of the frames
+}
+
+// StreamCounters counts the frames of a live stream. The counters can be updated and read concurrently.
+type StreamCounters struct {
+	received, processed, dropped atomic.Int64
+}
+
+// Stats returns the current values of the counters together with the given latencies.
+func (c *StreamCounters) Stats(latency LatencyStats) StreamStats {
+	return StreamStats{c.received.Load(), c.processed.Load(), c.dropped.Load(), latency}
+}
+
+// FrameQueue passes the frames of a live stream from the goroutine reading them to the one processing them. If the
+// processing doesn't keep up the queue either drops the oldest queued frame, so the producer is never slowed down and
+// the latest frame is processed next, or it blocks until there is room, which slows the producer down.
+type FrameQueue struct {
+	frames   chan [][]uint8
+	drop     bool
+	counters *StreamCounters
+}
+
+// NewFrameQueue returns a queue that holds up to the given number of frames and drops frames if drop is set.
+func NewFrameQueue(size int, drop bool, counters *StreamCounters) *FrameQueue {
+	return &FrameQueue{make(chan [][]uint8, size), drop, counters}
+}
+
+// Push adds the given frame to the queue. It may only be called by a single goroutine.
+func (q *FrameQueue) Push(frame [][]uint8) {
+	q.counters.received.Add(1)
+	if !q.drop {
+		q.frames <- frame
+		return
+	}
+	select {
+	case q.frames <- frame:
+	default:
+		// the queue is full, make room by dropping the oldest frame unless it was taken meanwhile
+		select {
+		case <-q.frames:
+			q.counters.dropped.Add(1)
+		default:
+		}
+		q.frames <- frame
+	}
+}
+
+// Close marks the end of the stream, frames that are still queued can be taken afterwards.
+func (q *FrameQueue) Close() {
+	close(q.frames)
+}
+
+// Frames returns the channel the queued frames are taken from, it is closed at the end of the stream.
+func (q *FrameQueue) Frames() <-chan [][]uint8 {
+	return q.frames
+}
+
+// isValidFramePolicy checks whether at most one of the policies for frames the detection can't keep up with is set.
+func isValidFramePolicy(dropFrames, backpressure bool) bool {
+	return !(dropFrames && backpressure)
+}
+
+// readPNMFrames reads binary PGM or PPM images concatenated in the given reader, e.g. produced by a camera or by
+// ffmpeg with -f image2pipe -c:v pgm, and pushes their gray values to the given queue until the stream ends. The queue
+// is closed afterwards, the end of the stream between two frames isn't an error.
+func readPNMFrames(r io.Reader, queue *FrameQueue) error {
+	defer queue.Close()
+	buffered := bufio.NewReader(r)
+	for {
+		if _, err := buffered.Peek(1); err == io.EOF {
+			return nil
+		}
+		img, err := readPNMImage(buffered)
+		if err != nil {
+			return err
+		}
+		queue.Push(pixelsToSamples(imageToPixelArray(img)))
+	}
+}
+
+// writePGMFrame writes the given edges as binary PGM image to the given writer.
+func writePGMFrame(w io.Writer, edges [][]uint8) error {
+	if _, err := fmt.Fprintf(w, "P5\n%d %d\n255\n", len(edges[0]), len(edges)); err != nil {
+		return err
+	}
+	for _, row := range edges {
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamCommand implements the stream subcommand, which detects the edges of a live stream of PGM or PPM frames, e.g.
+// piped from a camera, with the real-time detector and writes them as a stream of PGM frames. Frames that arrive while
+// the detection is busy are queued, the policy decides what happens when the queue is full.
+func streamCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("stream", flag.ExitOnError)
+	inputFileArgPtr := flags.String("input", "-", "path to read the frames from, - for standard input (optional, default: -)")
+	outputFileArgPtr := flags.String("output", "-", "path to write the edge frames to, - for standard output (optional, default: -)")
+	newDetector := addRealtimeFlags(flags)
+	dropFramesFlagPtr := flags.Bool("drop-frames", false, "drop the oldest queued frame if the detection doesn't keep up, for live capture (optional, default: false)")
+	backpressureFlagPtr := flags.Bool("backpressure", false, "stop reading if the detection doesn't keep up, which slows down the producer (optional, default: true unless -drop-frames is set)")
+	queueArgPtr := flags.Int("queue", 1, "number of frames that are queued for detection (optional, default: 1)")
+	statsFileArgPtr := flags.String("stats", "", "path to write the frame counters and latencies to as JSON (optional)")
+	statsIntervalArgPtr := flags.Duration("stats-interval", 0, "interval the stats are written in while streaming, e.g. 5s (optional, default: 0 = at the end only)")
+	return flags, func() {
+		if !isValidFramePolicy(*dropFramesFlagPtr, *backpressureFlagPtr) {
+			fmt.Println("Invalid value for frame policy given, -drop-frames and -backpressure exclude each other, exiting.")
+			return
+		}
+		if *queueArgPtr < 1 {
+			fmt.Println("Invalid value for queue given, exiting.")
+			return
+		}
+		if *statsIntervalArgPtr < 0 {
+			fmt.Println("Invalid value for stats interval given, exiting.")
+			return
+		}
+		input := os.Stdin
+		if *inputFileArgPtr != "-" {
+			file, err := os.Open(*inputFileArgPtr)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			defer file.Close()
+			input = file
+		}
+		output := os.Stdout
+		if *outputFileArgPtr != "-" {
+			file, err := os.Create(*outputFileArgPtr)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			defer file.Close()
+			output = file
+		}
+
+		var counters StreamCounters
+		queue := NewFrameQueue(*queueArgPtr, *dropFramesFlagPtr, &counters)
+		readErr := make(chan error, 1)
+		go func() {
+			readErr <- readPNMFrames(input, queue)
+		}()
+		err := detectStream(newDetector(), queue, bufio.NewWriter(output), &counters, *statsFileArgPtr, *statsIntervalArgPtr)
+		if err == nil {
+			err = <-readErr
+		}
+		if err != nil {
+			// the edge frames may be written to standard output, so messages go to standard error
+			fmt.Fprintf(os.Stderr, "%v, exiting.\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// detectStream detects the edges of the frames taken from the given queue until it is closed and writes them to the
+// given writer. A real-time detector is created for the size of the first frame and replaced if the size changes. The
+// stats are written to the given path, if any, every interval and at the end, and printed to standard error at the
+// end.
+func detectStream(d *Detector, queue *FrameQueue, w *bufio.Writer, counters *StreamCounters, statsPath string, interval time.Duration) error {
+	var realtime *RealtimeDetector
+	var latency LatencyStats
+	defer func() {
+		if realtime != nil {
+			realtime.Close()
+		}
+	}()
+	writeStats := func() {
+		if realtime != nil {
+			latency = realtime.Latency()
+		}
+		if statsPath != "" {
+			writeJSONFile(counters.Stats(latency), statsPath)
+		}
+	}
+	lastStats := time.Now()
+	for frame := range queue.Frames() {
+		if len(frame) == 0 {
+			return errors.New("frame is empty")
+		}
+		if realtime == nil || realtime.width != len(frame[0]) || realtime.height != len(frame) {
+			if realtime != nil {
+				realtime.Close()
+			}
+			var err error
+			if realtime, err = NewRealtimeDetector(d, len(frame[0]), len(frame)); err != nil {
+				return err
+			}
+		}
+		edges, err := realtime.Detect(frame)
+		if err != nil {
+			return err
+		}
+		// frames are flushed right away so they don't wait in the buffer for the next one
+		if err := writePGMFrame(w, edges); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		counters.processed.Add(1)
+		if interval > 0 && time.Since(lastStats) >= interval {
+			writeStats()
+			lastStats = time.Now()
+		}
+	}
+	writeStats()
+	stats := counters.Stats(latency)
+	fmt.Fprintf(os.Stderr, "frames: %d received, %d processed, %d dropped\n", stats.Received, stats.Processed, stats.Dropped)
+	fmt.Fprintf(os.Stderr, "latency p50: %v, p99: %v, max: %v\n", stats.Latency.P50, stats.Latency.P99, stats.Latency.Max)
+	return nil
+}
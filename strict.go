@@ -0,0 +1,136 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// strictRequirement names a flag that only has an effect if a condition on other flags holds.
+type strictRequirement struct {
+	name        string
+	description string // description of the condition in messages
+	met         func(value func(string) string) bool
+}
+
+// strictRequirements are the flags of the edge detection that are silently ignored unless their condition holds.
+var strictRequirements = []strictRequirement{
+	{"sigma", "gaussian -blur", func(v func(string) string) bool { return v("blur") == "gaussian" }},
+	{"box-passes", "-blur=box", func(v func(string) string) bool { return v("blur") == "box" }},
+	{"temporal-mode", "-temporal", func(v func(string) string) bool { return v("temporal") != "0" && v("temporal") != "1" }},
+	{"cut-threshold", "-scene-cuts or -temporal", func(v func(string) string) bool {
+		return v("scene-cuts") != "" || (v("temporal") != "0" && v("temporal") != "1")
+	}},
+	{"mask-mode", "-masks", func(v func(string) string) bool { return v("masks") != "" }},
+	{"min-area", "-masks", func(v func(string) string) bool { return v("masks") != "" }},
+	{"tile-layout", "-tiles", func(v func(string) string) bool { return v("tiles") != "" }},
+	{"tile-size", "-tiles", func(v func(string) string) bool { return v("tiles") != "" }},
+	{"approx-epsilon", "-contours or -geojson", func(v func(string) string) bool { return v("contours") != "" || v("geojson") != "" }},
+	{"convex-hull", "-contours or -geojson", func(v func(string) string) bool { return v("contours") != "" || v("geojson") != "" }},
+	{"stroke-width", "-contours", func(v func(string) string) bool { return v("contours") != "" }},
+	{"world-file", "-geojson", func(v func(string) string) bool { return v("geojson") != "" }},
+	{"autocrop-padding", "-autocrop", func(v func(string) string) bool { return v("autocrop") != "" }},
+	{"autocrop-density", "-autocrop", func(v func(string) string) bool { return v("autocrop") != "" }},
+	{"oversize", "-max-dimension", func(v func(string) string) bool { return v("max-dimension") != "0" }},
+	{"bridge-angle", "-bridge-distance", func(v func(string) string) bool { return v("bridge-distance") != "0" }},
+	{"fast-threshold", "-fast", func(v func(string) string) bool { return v("fast") == "true" }},
+	{"fast-nms", "-fast", func(v func(string) string) bool { return v("fast") == "true" }},
+	{"corners", "-fast", func(v func(string) string) bool { return v("fast") == "true" }},
+	{"parameter-strength", "-parameter-map", func(v func(string) string) bool { return v("parameter-map") != "" }},
+	{"invalid", "-depth", func(v func(string) string) bool { return v("depth") == "true" }},
+}
+
+// STRICT_EXCLUSIVE_MODES are the flags that replace the edge detection of single images by another kind of output,
+// STRICT_EDGE_OUTPUTS are the flags that only apply to that edge detection.
+var (
+	STRICT_EXCLUSIVE_MODES = []string{"fast", "response", "depth", "mmap", "spill"}
+	STRICT_EDGE_OUTPUTS    = []string{"float", "contours", "geojson", "masks", "tiles", "layers", "autocrop",
+		"preview-scale", "compare-opencv", "parameter-map",
+		"weight-map", "report", "confidence", "soft", "fail-if-edge-density-lt", "fail-if-edge-density-gt"}
+)
+
+// strictConflicts returns a message for every option of the edge detection that would be ignored or resolved silently
+// with the given values of the flags and their sources as returned by resolveConfiguration. Flags that keep their
+// default values never conflict. Environment variables with the prefix of flags that don't set a flag are reported as
+// well.
+func strictConflicts(flags *flag.FlagSet, sources map[string]string) []string {
+	value := func(name string) string {
+		return flags.Lookup(name).Value.String()
+	}
+	given := func(name string) bool {
+		return sources[name] != "default"
+	}
+
+	var conflicts []string
+	for _, requirement := range strictRequirements {
+		if given(requirement.name) && !requirement.met(value) {
+			conflicts = append(conflicts, fmt.Sprintf("-%s has no effect without %s", requirement.name, requirement.description))
+		}
+	}
+
+	// flags that select a mode are considered given if they are not at their zero value
+	active := func(name string) bool {
+		return given(name) && value(name) != "" && value(name) != "false"
+	}
+	var modes []string
+	for _, mode := range STRICT_EXCLUSIVE_MODES {
+		if active(mode) {
+			modes = append(modes, "-"+mode)
+		}
+	}
+	if len(modes) > 1 {
+		conflicts = append(conflicts, strings.Join(modes, " and ")+" can't be combined")
+	}
+	if len(modes) > 0 {
+		for _, output := range STRICT_EDGE_OUTPUTS {
+			// the edge density of depth maps is checked as well
+			if modes[0] == "-depth" && strings.HasPrefix(output, "fail-if-edge-density") {
+				continue
+			}
+			if given(output) {
+				conflicts = append(conflicts, fmt.Sprintf("-%s has no effect together with %s", output, modes[0]))
+			}
+		}
+	}
+
+	// anything else than png is written as jpeg, except for the responses written as pfm
+	switch ext := strings.ToLower(filepath.Ext(value("output"))); {
+	case ext == ".pfm" && active("response"):
+	case ext != ".png" && ext != ".jpg" && ext != ".jpeg":
+		conflicts = append(conflicts, fmt.Sprintf("-output %s would be written as JPEG", value("output")))
+	}
+
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, CONFIG_ENV_PREFIX) || name == CONFIG_ENV_PREFIX+"CONFIG" {
+			continue
+		}
+		known := false
+		flags.VisitAll(func(f *flag.Flag) {
+			known = known || configEnvName(f.Name) == name
+		})
+		if !known {
+			conflicts = append(conflicts, "environment variable "+name+" doesn't set a flag")
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,274 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// STRIP_HEIGHT is the number of rows that are detected at once by strip-wise detection.
+const STRIP_HEIGHT = 256
+
+// enumeration type for denoting where strip-wise detection keeps the suppressed magnitude between its two passes
+type StripBuffer int
+
+const (
+	STRIPS_RECOMPUTE StripBuffer = iota // computed again in the second pass, which needs no memory
+	STRIPS_SPILL                        // kept in a temporary file
+	STRIPS_KEEP                         // kept in memory, one byte per pixel
+)
+
+// StripSource provides the rows of an 8-bit image that is detected strip by strip, see DetectStrips.
+type StripSource interface {
+	Size() (width, height int)
+	Rows(y0, y1 int) ([][]uint8, error)
+}
+
+// checkLocalStages returns an error if the detector uses stages that need more than a fixed neighbourhood of every
+// pixel or that allocate buffers of their own, which the given kind of detection doesn't support.
+func (d *Detector) checkLocalStages(detection string) error {
+	switch {
+	case d.Blurrer != nil || d.GradientOperator != nil || d.Suppressor != nil || d.Thresholder != nil || d.Tracker != nil:
+		return fmt.Errorf("custom stages can't be used with %s", detection)
+	case d.LogIntensity:
+		return fmt.Errorf("log intensity can't be used with %s", detection)
+	case d.DefectPixels != nil || d.DefectThreshold > 0:
+		return fmt.Errorf("defect pixel masking can't be used with %s", detection)
+	case d.Vignetting != nil || d.FitVignetting:
+		return fmt.Errorf("vignetting correction can't be used with %s", detection)
+	case d.BackgroundRadius > 0:
+		return fmt.Errorf("background subtraction can't be used with %s", detection)
+	case d.NoiseThresholds:
+		return fmt.Errorf("noise thresholds can't be used with %s", detection)
+	case d.ThresholdFactors != nil || d.EdgeWeights != nil:
+		return fmt.Errorf("parameter and weight maps can't be used with %s", detection)
+	case d.Blur && d.BlurFilter != BOX && d.Sigma > IIR_SIGMA_THRESHOLD:
+		return fmt.Errorf("blur of standard deviation above %g can't be used with %s", IIR_SIGMA_THRESHOLD, detection)
+	case d.Despeckle > 0 || d.BridgeDistance > 0:
+		return fmt.Errorf("postprocessing can't be used with %s", detection)
+	}
+	return nil
+}
+
+// stripHalo returns the number of rows above and below a strip that the suppressed magnitude of the strip depends on.
+// The box filter grows by one pixel per pass, the gradient and the suppression by one pixel each.
+func (d *Detector) stripHalo() int {
+	if d.Blur && d.BlurFilter == BOX {
+		return d.BoxPasses + 2
+	}
+	return d.blurRadius() + 2
+}
+
+// suppressedRows returns the suppressed gradient magnitude of the rows from y0 up to y1 of the given image. The strip
+// is detected together with the rows its result depends on, so it equals the rows of a detection of the whole image.
+func (d *Detector) suppressedRows(source StripSource, y0, y1 int) ([][]uint8, error) {
+	_, height := source.Size()
+	halo := d.stripHalo()
+	top, bottom := max(y0-halo, 0), min(y1+halo, height)
+	rows, err := source.Rows(top, bottom)
+	if err != nil {
+		return nil, err
+	}
+	magnitude, directions := sobel(blurSamples(d, rows, nil), nil, d.Workers)
+	return nonMaximumSuppression(magnitude, directions, d.Workers)[y0-top : y1-top], nil
+}
+
+// DetectStrips detects the edges of the given image strip by strip and passes the rows of the edge image to the given
+// function in order. Since the tracking keeps exactly the pixels above the upper threshold, every strip only depends
+// on its neighbourhood once the thresholds are known. These are derived from the suppressed magnitude of the whole
+// image in a first pass. The given buffer decides whether the magnitude is computed again in the second pass or kept
+// in between. The box filter sums the rows of a strip in floating point, so single pixels of its result may differ by
+// rounding from a detection of the whole image.
+func (d *Detector) DetectStrips(source StripSource, buffer StripBuffer, row func(edges []uint8) error) error {
+	if err := d.checkLocalStages("strip-wise detection"); err != nil {
+		return err
+	}
+	width, height := source.Size()
+	var spilled *SpillFile
+	var kept [][]uint8
+	switch buffer {
+	case STRIPS_SPILL:
+		var err error
+		if spilled, err = NewSpillFile(width, height); err != nil {
+			return err
+		}
+		defer spilled.Close()
+	case STRIPS_KEEP:
+		kept = make([][]uint8, 0, height)
+	}
+
+	// the reference is taken from a histogram of the magnitude, which gives the same percentile as sorting
+	var histogram [256]int
+	for y0 := 0; y0 < height; y0 += STRIP_HEIGHT {
+		magnitude, err := d.suppressedRows(source, y0, min(y0+STRIP_HEIGHT, height))
+		if err != nil {
+			return err
+		}
+		for _, magnitudeRow := range magnitude {
+			for _, value := range magnitudeRow {
+				histogram[value]++
+			}
+		}
+		if spilled != nil {
+			if err := spilled.WriteRows(y0, magnitude); err != nil {
+				return err
+			}
+		} else if kept != nil {
+			kept = append(kept, magnitude...)
+		}
+	}
+	reference := histogramReference(histogram, d.Percentile)
+	low, high := d.MinRatio*reference, d.MaxRatio*reference
+
+	for y0 := 0; y0 < height; y0 += STRIP_HEIGHT {
+		var magnitude [][]uint8
+		var err error
+		if spilled != nil {
+			magnitude, err = spilled.Rows(y0, min(y0+STRIP_HEIGHT, height))
+		} else if kept != nil {
+			magnitude = kept[y0:min(y0+STRIP_HEIGHT, height)]
+		} else {
+			magnitude, err = d.suppressedRows(source, y0, min(y0+STRIP_HEIGHT, height))
+		}
+		if err != nil {
+			return err
+		}
+		for _, edges := range trackEdges(magnitude, low, high) {
+			if err := row(edges); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// histogramReference returns the magnitude the threshold ratios refer to like thresholdReference, from the histogram
+// of the magnitude.
+func histogramReference(histogram [256]int, percentile float64) float64 {
+	maximum, count := 0, 0
+	for value := 1; value < len(histogram); value++ {
+		if histogram[value] > 0 {
+			maximum = value
+			count += histogram[value]
+		}
+	}
+	if percentile <= 0 || percentile >= 1 || count == 0 {
+		return float64(maximum)
+	}
+	index := int(percentile * float64(count-1))
+	for value := 1; value < len(histogram); value++ {
+		if index < histogram[value] {
+			return float64(value)
+		}
+		index -= histogram[value]
+	}
+	return float64(maximum)
+}
+
+// writeStripEdges detects the edges of the input file strip by strip and writes them to the PNG file at the given
+// output path row by row. With mapped set the input has to be an uncompressed PGM, BMP or TIFF file, which is read
+// from a memory mapping, see MappedImage. Otherwise it is decoded and spilled to a temporary file. With spill set the
+// intermediate results are spilled as well instead of being computed twice. The ratio of edge pixels is returned.
+func writeStripEdges(d *Detector, input, rawFormat string, mapped, spill bool, output string) (float64, error) {
+	if filepath.Ext(output) != ".png" {
+		return 0, errors.New("edges of strip-wise detection can only be written as PNG")
+	}
+	var source StripSource
+	if mapped {
+		if rawFormat != "" {
+			return 0, errors.New("raw input can't be memory-mapped")
+		}
+		m, err := OpenMappedImage(input)
+		if err != nil {
+			return 0, err
+		}
+		defer m.Close()
+		source = m
+	} else {
+		s, err := spillImage(input, rawFormat)
+		if err != nil {
+			return 0, err
+		}
+		defer s.Close()
+		source = s
+	}
+	buffer := STRIPS_RECOMPUTE
+	if spill {
+		buffer = STRIPS_SPILL
+	}
+	return streamStripEdges(d, source, buffer, output)
+}
+
+// pixelStrips provides the rows of an image that is already decoded to the strip-wise detection.
+type pixelStrips [][]GrayPixel
+
+// Size returns the width and height of the image.
+func (p pixelStrips) Size() (width, height int) {
+	return len(p[0]), len(p)
+}
+
+// Rows returns the gray values of the rows from y0 up to y1.
+func (p pixelStrips) Rows(y0, y1 int) ([][]uint8, error) {
+	return pixelsToSamples(p[y0:y1]), nil
+}
+
+// streamStripEdges detects the edges of the given source strip by strip, keeping the intermediate results in the given
+// buffer, and writes them to the PNG file at the given output path as the strips complete, so the edge image is never
+// held in memory. The ratio of edge pixels is returned.
+func streamStripEdges(d *Detector, source StripSource, buffer StripBuffer, output string) (float64, error) {
+	width, height := source.Size()
+	if issues := d.Validate(width, height, nil); hasValidationError(issues) {
+		return 0, errors.New(formatIssues(issues))
+	}
+
+	outFile, err := os.Create(output)
+	if err != nil {
+		return 0, err
+	}
+	defer outFile.Close()
+	buffered := bufio.NewWriter(outFile)
+	stream, err := NewPNGStreamWriter(buffered, width, height)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range sortedKeys(imageMetadata) {
+		if err := stream.WriteText(key, imageMetadata[key]); err != nil {
+			return 0, err
+		}
+	}
+	edgeCount := 0
+	err = d.DetectStrips(source, buffer, func(edges []uint8) error {
+		for _, value := range edges {
+			if value > 0 {
+				edgeCount++
+			}
+		}
+		return stream.WriteRow(edges)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := stream.Close(); err != nil {
+		return 0, err
+	}
+	return float64(edgeCount) / float64(width*height), buffered.Flush()
+}
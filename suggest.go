@@ -0,0 +1,186 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+)
+
+// parameters of the suggestions
+const (
+	SUGGEST_NOISE_FREE   = 2.0  // noise level below which the default 5x5 kernel is suggested
+	SUGGEST_MAX_SIGMA    = 4.0  // largest standard deviation of the blur that is suggested
+	SUGGEST_LOW_RATIO    = 0.4  // ratio of the lower to the upper threshold
+	SUGGEST_LOW_CONTRAST = 64.0 // range of the gray values below which an image counts as low contrast
+	SUGGEST_CANDIDATE    = 0.01 // ratio to the maximum gradient below which gradients don't count as edge candidates
+)
+
+// Suggestion holds the parameters suggested for an image together with the statistics they are derived from.
+type Suggestion struct {
+	Noise      float64 // estimated standard deviation of the noise of the gray values
+	Dark       float64 // gray value at the 1st percentile
+	Bright     float64 // gray value at the 99th percentile
+	Sigma      float64 // standard deviation of the blur, zero for the default 5x5 kernel
+	MinRatio   float64 // ratio of the lower threshold
+	MaxRatio   float64 // ratio of the upper threshold
+	LogScale   bool    // whether taking gradients of log(1+I) is suggested
+	StrongEdge float64 // ratio of the edge candidates that are strong edges with the suggested thresholds
+}
+
+// suggestCommand implements the suggest subcommand, which analyzes the given images and prints the parameters it
+// recommends for them together with the reasoning.
+func suggestCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("suggest", flag.ExitOnError)
+	strongArgPtr := flags.Float64("strong", 0.1, "ratio of the edge candidates that are kept as strong edges (optional, default: 0.1)")
+	workersArgPtr := flags.Int("workers", runtime.NumCPU(), "number of concurrent workers (optional, default: number of CPUs)")
+	return flags, func() {
+		if flags.NArg() == 0 {
+			fmt.Println("No path to input file specified, nothing to do.")
+			return
+		}
+		if *strongArgPtr <= 0 || *strongArgPtr >= 1 || *workersArgPtr < 1 {
+			fmt.Println("Invalid value for suggestion given, exiting.")
+			return
+		}
+
+		for _, path := range flags.Args() {
+			fmt.Println(path)
+			suggestion, err := suggestFile(path, *strongArgPtr, *workersArgPtr)
+			if err != nil {
+				fmt.Printf("  error: %v\n", err)
+				continue
+			}
+			printSuggestion(suggestion)
+		}
+	}
+}
+
+// suggestFile returns the parameters suggested for the image at the given path.
+func suggestFile(path string, strong float64, workers int) (Suggestion, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Suggestion{}, err
+	}
+	defer file.Close() // opened for reading, no error checking needed
+	pixels, err := getPixelArray(file, "")
+	if err != nil {
+		return Suggestion{}, err
+	}
+	return SuggestParameters(pixelsToSamples(pixels), strong, workers), nil
+}
+
+// printSuggestion prints the given suggestion as flags and explains how they were chosen.
+func printSuggestion(s Suggestion) {
+	fmt.Printf("  noise: %.1f, gray values: %.0f to %.0f\n", s.Noise, s.Dark, s.Bright)
+	command := fmt.Sprintf("-min %.2f -max %.2f", s.MinRatio, s.MaxRatio)
+	if s.Sigma > 0 {
+		command = fmt.Sprintf("-sigma %.1f %s", s.Sigma, command)
+	}
+	if s.LogScale {
+		command += " -log-intensity"
+	}
+	fmt.Printf("  suggested: %s\n", command)
+	if s.Sigma > 0 {
+		fmt.Printf("  -sigma: the noise with a standard deviation of %.1f gray values is smoothed out before the gradients are taken\n", s.Noise)
+	} else {
+		fmt.Println("  blur: the image is nearly free of noise, the default 5x5 kernel keeps fine edges")
+	}
+	fmt.Printf("  -max: the strongest %.0f%% of the edge candidates start edges, -max is their weakest gradient relative to the strongest one\n", 100*s.StrongEdge)
+	fmt.Printf("  -min: edges are followed down to %.0f%% of -max, which connects them across weaker parts\n", 100*SUGGEST_LOW_RATIO)
+	if s.LogScale {
+		fmt.Println("  -log-intensity: most of the image is dark, so edges in dark regions are equalized with those in bright ones")
+	} else if s.Bright-s.Dark < SUGGEST_LOW_CONTRAST {
+		fmt.Println("  note: the image has low contrast, the thresholds are relative so they still apply")
+	}
+}
+
+// SuggestParameters analyzes the given gray values and suggests the parameters of the detection. The noise level
+// determines the blur, the thresholds are chosen so that the given ratio of the edge candidates, the gradients that
+// remain after non-maximum suppression, are strong edges. The gradients are computed with the given sample type, which
+// has to be the one of the detection the parameters are used for.
+func SuggestParameters[T Sample](samples [][]T, strong float64, workers int) Suggestion {
+	gray := convertSamples[float64](samples)
+	s := Suggestion{Noise: EstimateNoise(samples, nil).Sigma, StrongEdge: strong}
+	s.Dark, s.Bright = grayPercentiles(gray)
+	if s.Noise >= SUGGEST_NOISE_FREE {
+		s.Sigma = math.Min(math.Round(10*(1+s.Noise/5))/10, SUGGEST_MAX_SIGMA)
+	}
+	// a median in the darkest quarter of the range suggests that dark regions hold much of the content
+	s.LogScale = grayMedian(gray) < s.Dark+(s.Bright-s.Dark)/4
+
+	detector := NewDetector(true, 0, 1)
+	detector.Sigma = s.Sigma
+	detector.Workers = workers
+	if s.LogScale {
+		samples = logIntensity(samples, workers)
+	}
+	magnitude, directions := sobel(blurSamples(detector, samples, nil), nil, workers)
+	candidates := nonMaximumSuppression(magnitude, directions, workers)
+	if maximum := thresholdReference(candidates, 1); maximum > 0 {
+		// strong edges have to exceed the upper threshold, so the ratio is rounded down below the percentile
+		ratio := candidatePercentile(candidates, SUGGEST_CANDIDATE*maximum, 1-strong) / maximum
+		s.MaxRatio = math.Max(math.Ceil(100*ratio)-1, 0) / 100
+	}
+	s.MinRatio = math.Round(100*SUGGEST_LOW_RATIO*s.MaxRatio) / 100
+	return s
+}
+
+// candidatePercentile returns the given percentile of the values of the array above the given floor, which excludes
+// the numerical residue of flat regions. If no value exceeds the floor it is returned.
+func candidatePercentile[T Sample](candidates [][]T, floor, percentile float64) float64 {
+	var values []float64
+	for y := range candidates {
+		for _, value := range candidates[y] {
+			if float64(value) > floor {
+				values = append(values, float64(value))
+			}
+		}
+	}
+	if len(values) == 0 {
+		return floor
+	}
+	sort.Float64s(values)
+	return values[int(percentile*float64(len(values)-1))]
+}
+
+// grayPercentiles returns the gray values at the 1st and 99th percentile of the given samples.
+func grayPercentiles(samples [][]float64) (float64, float64) {
+	values := sortedSampleValues(samples)
+	return values[int(0.01*float64(len(values)-1))], values[int(0.99*float64(len(values)-1))]
+}
+
+// grayMedian returns the median of the given samples.
+func grayMedian(samples [][]float64) float64 {
+	values := sortedSampleValues(samples)
+	return values[len(values)/2]
+}
+
+// sortedSampleValues returns all values of the given samples in ascending order.
+func sortedSampleValues(samples [][]float64) []float64 {
+	values := make([]float64, 0, len(samples)*len(samples[0]))
+	for y := range samples {
+		values = append(values, samples[y]...)
+	}
+	sort.Float64s(values)
+	return values
+}
@@ -0,0 +1,136 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+)
+
+// thumbnailCommand implements the thumbnail subcommand, which writes a thumbnail of the given size that shows the most
+// detailed part of the image. The crop with the aspect ratio of the thumbnail is placed where the edges are strongest
+// instead of at the center, e.g. for gallery previews.
+func thumbnailCommand() (*flag.FlagSet, func()) {
+	flags := flag.NewFlagSet("thumbnail", flag.ExitOnError)
+	inputFileArgPtr := flags.String("input", "", "path to input file (required)")
+	outputFileArgPtr := flags.String("output", "thumbnail.jpg", "path to output file (optional, default: thumbnail.jpg)")
+	widthArgPtr := flags.Int("width", 160, "width of the thumbnail (optional, default: 160)")
+	heightArgPtr := flags.Int("height", 160, "height of the thumbnail (optional, default: 160)")
+	minThresholdArgPtr := flags.Float64("min", float64(0.2), "ratio of lower threshold (optional, default: 0.2)")
+	maxThresholdArgPtr := flags.Float64("max", float64(0.6), "ratio of upper threshold (optional, default: 0.6)")
+	return flags, func() {
+		if *inputFileArgPtr == "" {
+			fmt.Println("No path to input file specified, nothing to do.")
+			return
+		}
+		if *widthArgPtr < 1 || *heightArgPtr < 1 {
+			fmt.Println("Invalid value for thumbnail size given, exiting.")
+			return
+		}
+		if !isValidRatioValue(*minThresholdArgPtr) || !isValidRatioValue(*maxThresholdArgPtr) {
+			fmt.Println("Invalid value for threshold ratio given, exiting.")
+			return
+		}
+
+		file, err := os.Open(*inputFileArgPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		img, err := decodeInput(file, "")
+		file.Close() // opened for reading, no error checking needed
+		if err != nil {
+			log.Fatal(err)
+		}
+		detector := NewDetector(true, *minThresholdArgPtr, *maxThresholdArgPtr)
+		edges := pixelsToSamples(detector.Detect(imageToPixelArray(img)))
+		box := EdgeWeightedCrop(edges, float64(*widthArgPtr)/float64(*heightArgPtr))
+		writeColorImage(resizeArea(img, box.Add(img.Bounds().Min), *widthArgPtr, *heightArgPtr), *outputFileArgPtr)
+	}
+}
+
+// EdgeWeightedCrop returns the largest crop of the given edge image with the given ratio of width to height. It spans
+// the full width or height and is moved along the other direction to where the sum of the edge strength is largest,
+// ties are resolved in favour of the crop closest to the center.
+func EdgeWeightedCrop[T Sample](edges [][]T, aspect float64) image.Rectangle {
+	height, width := len(edges), len(edges[0])
+	cropWidth := min(width, max(1, int(math.Round(float64(height)*aspect))))
+	cropHeight := min(height, max(1, int(math.Round(float64(cropWidth)/aspect))))
+
+	// cumulative edge strength of the columns or rows along the direction the crop can move
+	horizontal := cropWidth < width
+	length, size := height, cropHeight
+	if horizontal {
+		length, size = width, cropWidth
+	}
+	sums := make([]float64, length+1)
+	for y := range edges {
+		for x, value := range edges[y] {
+			if horizontal {
+				sums[x+1] += float64(value)
+			} else {
+				sums[y+1] += float64(value)
+			}
+		}
+	}
+	for i := range length {
+		sums[i+1] += sums[i]
+	}
+
+	center := (length - size) / 2
+	best := center
+	for offset := 0; offset <= length-size; offset++ {
+		strength, bestStrength := sums[offset+size]-sums[offset], sums[best+size]-sums[best]
+		if strength > bestStrength || (strength == bestStrength && abs(offset-center) < abs(best-center)) {
+			best = offset
+		}
+	}
+	if horizontal {
+		return image.Rect(best, 0, best+cropWidth, cropHeight)
+	}
+	return image.Rect(0, best, cropWidth, best+cropHeight)
+}
+
+// resizeArea returns the given region of the image scaled to the given size. Every pixel of the result is the mean of
+// the pixels of the region it covers, which avoids aliasing when shrinking. Regions smaller than the result are
+// enlarged by repeating pixels.
+func resizeArea(img image.Image, region image.Rectangle, width, height int) *image.RGBA {
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		top := region.Min.Y + y*region.Dy()/height
+		bottom := max(top+1, region.Min.Y+(y+1)*region.Dy()/height)
+		for x := range width {
+			left := region.Min.X + x*region.Dx()/width
+			right := max(left+1, region.Min.X+(x+1)*region.Dx()/width)
+			var r, g, b, a, count uint64
+			for i := top; i < bottom; i++ {
+				for j := left; j < right; j++ {
+					pr, pg, pb, pa := img.At(j, i).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					count++
+				}
+			}
+			result.SetRGBA(x, y, color.RGBA{uint8(r / count >> 8), uint8(g / count >> 8), uint8(b / count >> 8), uint8(a / count >> 8)})
+		}
+	}
+	return result
+}
@@ -0,0 +1,210 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"os"
+)
+
+// TIFF tags written for every page of a multi-page TIFF file, the strip tags are read by the camera RAW decoder and
+// memory-mapped input as well
+const (
+	TAG_NEW_SUBFILE_TYPE  = 0x00fe
+	TAG_IMAGE_WIDTH       = 0x0100
+	TAG_IMAGE_LENGTH      = 0x0101
+	TAG_BITS_PER_SAMPLE   = 0x0102
+	TAG_COMPRESSION       = 0x0103
+	TAG_PHOTOMETRIC       = 0x0106
+	TAG_STRIP_OFFSETS     = 0x0111
+	TAG_SAMPLES_PER_PIXEL = 0x0115
+	TAG_ROWS_PER_STRIP    = 0x0116
+	TAG_STRIP_BYTE_COUNTS = 0x0117
+	TAG_PAGE_NAME         = 0x011d
+	TAG_PAGE_NUMBER       = 0x0129
+	TAG_SAMPLE_FORMAT     = 0x0153
+)
+
+// magic bytes of little and big endian TIFF files, which camera RAW files and memory-mapped input are recognized by
+const (
+	TIFF_LE_MAGIC = "II*\x00"
+	TIFF_BE_MAGIC = "MM\x00*"
+)
+
+// TIFF field types
+const (
+	TIFF_ASCII = 2
+	TIFF_SHORT = 3
+	TIFF_LONG  = 4
+)
+
+// TIFFLayer is a page of a multi-page TIFF file. Layers that are not float are written with 8-bit samples clamped to
+// [0, 255], float layers keep their values as 32-bit floating point samples.
+type TIFFLayer struct {
+	Name   string
+	Values [][]float64
+	Float  bool
+}
+
+// tiffEntry is an entry of an image file directory. Values of at most four bytes are stored in the entry itself,
+// longer values are referenced by their offset.
+type tiffEntry struct {
+	tag, kind uint16
+	count     uint32
+	value     uint32
+}
+
+// writeLayers writes the intermediate results of a detection as layers of a multi-page TIFF file at the given path.
+func writeLayers[T Sample](artifacts PipelineArtifacts[T], path string) {
+	layers := []TIFFLayer{
+		{"intensity", convertSamples[float64](artifacts.Intensity), false},
+		{"blurred", convertSamples[float64](artifacts.Blurred), false},
+		{"magnitude", convertSamples[float64](artifacts.Magnitude), true},
+		{"direction", artifacts.Directions, true},
+		{"edges", convertSamples[float64](artifacts.Edges), false},
+	}
+	outFile, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer outFile.Close()
+	if err := writeMultiPageTIFF(outFile, layers); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeMultiPageTIFF writes the given layers as uncompressed grayscale pages of a little endian TIFF file. Every page
+// is named after its layer by the PageName tag.
+func writeMultiPageTIFF(w io.Writer, layers []TIFFLayer) error {
+	var buf bytes.Buffer
+	buf.WriteString("II\x2a\x00")
+	nextOffset := buf.Len() // position of the offset of the next image file directory
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+
+	for page, layer := range layers {
+		height, width := len(layer.Values), len(layer.Values[0])
+		bits, format := uint32(8), uint32(1)
+		if layer.Float {
+			bits, format = 32, 3
+		}
+
+		// pixel data in a single strip, followed by the name unless it fits into its entry
+		dataOffset := buf.Len()
+		for y := range layer.Values {
+			for _, value := range layer.Values[y] {
+				if layer.Float {
+					binary.Write(&buf, binary.LittleEndian, float32(value))
+				} else {
+					buf.WriteByte(uint8(math.Round(math.Min(math.Max(value, 0), 255))))
+				}
+			}
+		}
+		byteCount := buf.Len() - dataOffset
+		alignTIFF(&buf)
+		name := append([]byte(layer.Name), 0)
+		nameValue := uint32(buf.Len())
+		if len(name) <= 4 {
+			nameValue = binary.LittleEndian.Uint32(append(name, 0, 0, 0))
+		} else {
+			buf.Write(name)
+			alignTIFF(&buf)
+		}
+
+		entries := []tiffEntry{
+			{TAG_NEW_SUBFILE_TYPE, TIFF_LONG, 1, 2}, // page of a multi-page image
+			{TAG_IMAGE_WIDTH, TIFF_LONG, 1, uint32(width)},
+			{TAG_IMAGE_LENGTH, TIFF_LONG, 1, uint32(height)},
+			{TAG_BITS_PER_SAMPLE, TIFF_SHORT, 1, bits},
+			{TAG_COMPRESSION, TIFF_SHORT, 1, 1},
+			{TAG_PHOTOMETRIC, TIFF_SHORT, 1, 1}, // black is zero
+			{TAG_STRIP_OFFSETS, TIFF_LONG, 1, uint32(dataOffset)},
+			{TAG_SAMPLES_PER_PIXEL, TIFF_SHORT, 1, 1},
+			{TAG_ROWS_PER_STRIP, TIFF_LONG, 1, uint32(height)},
+			{TAG_STRIP_BYTE_COUNTS, TIFF_LONG, 1, uint32(byteCount)},
+			{TAG_PAGE_NAME, TIFF_ASCII, uint32(len(name)), nameValue},
+			{TAG_PAGE_NUMBER, TIFF_SHORT, 2, uint32(page) | uint32(len(layers))<<16},
+			{TAG_SAMPLE_FORMAT, TIFF_SHORT, 1, format},
+		}
+
+		// link the directory from the previous one and write it
+		binary.LittleEndian.PutUint32(buf.Bytes()[nextOffset:], uint32(buf.Len()))
+		binary.Write(&buf, binary.LittleEndian, uint16(len(entries)))
+		for _, entry := range entries {
+			binary.Write(&buf, binary.LittleEndian, entry)
+		}
+		nextOffset = buf.Len()
+		binary.Write(&buf, binary.LittleEndian, uint32(0))
+	}
+	if int64(buf.Len()) > math.MaxUint32 {
+		return errors.New("tiff: layers exceed 4 GB")
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// alignTIFF pads the given buffer to an even length, as TIFF offsets are word aligned.
+func alignTIFF(buf *bytes.Buffer) {
+	if buf.Len()%2 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+// readTIFFValues returns the values of the given directory entry if they are of type SHORT, LONG or IFD. Values that
+// don't fit into the entry are read from the offset it holds. Entries of more than 1024 values are left out.
+func readTIFFValues(data []byte, entry []byte, order binary.ByteOrder) []uint32 {
+	return readTIFFArray(data, entry, order, 1024)
+}
+
+// readTIFFArray returns the values of the given directory entry like readTIFFValues, up to the given number of values.
+// This allows reading the offsets of all strips of an image.
+func readTIFFArray(data []byte, entry []byte, order binary.ByteOrder, limit int) []uint32 {
+	valueType := order.Uint16(entry[2:])
+	count := order.Uint32(entry[4:])
+	size := uint32(4)
+	if valueType == 3 {
+		size = 2
+	} else if valueType != 4 && valueType != 13 {
+		return nil
+	}
+	if count == 0 || uint64(count) > uint64(limit) {
+		return nil
+	}
+	values := entry[8:12]
+	if count*size > 4 {
+		offset := order.Uint32(entry[8:])
+		if uint64(offset)+uint64(count*size) > uint64(len(data)) {
+			return nil
+		}
+		values = data[offset : offset+count*size]
+	}
+	result := make([]uint32, count)
+	for i := range result {
+		if size == 2 {
+			result[i] = uint32(order.Uint16(values[2*i:]))
+		} else {
+			result[i] = order.Uint32(values[4*i:])
+		}
+	}
+	return result
+}
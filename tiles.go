@@ -0,0 +1,128 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isValidTileLayout checks whether the given name denotes a supported layout of tile pyramids.
+func isValidTileLayout(layout string) bool {
+	return layout == "dzi" || layout == "xyz"
+}
+
+// halveImage returns the given gray image scaled down to half its size, rounding up. Every pixel of the result takes
+// the maximum of the pixels it covers so that one pixel wide edges stay visible on all levels of a pyramid.
+func halveImage(img *image.Gray) *image.Gray {
+	bounds := img.Bounds()
+	width := (bounds.Dx() + 1) / 2
+	height := (bounds.Dy() + 1) / 2
+	result := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var value uint8
+			for i := 2 * y; i < min(2*y+2, bounds.Dy()); i++ {
+				for j := 2 * x; j < min(2*x+2, bounds.Dx()); j++ {
+					value = max(value, img.Pix[i*img.Stride+j])
+				}
+			}
+			result.Pix[y*result.Stride+x] = value
+		}
+	}
+	return result
+}
+
+// pyramidLevels returns the levels of an image pyramid, starting with the given image and halving it until both
+// dimensions are at most the given size.
+func pyramidLevels(img *image.Gray, size int) []*image.Gray {
+	levels := []*image.Gray{img}
+	for level := img; level.Bounds().Dx() > size || level.Bounds().Dy() > size; {
+		level = halveImage(level)
+		levels = append(levels, level)
+	}
+	return levels
+}
+
+// writeTiles cuts the given image into tiles of the given size and writes them as PNG files. The path of every tile is
+// determined by the given function from its column and row. Tiles at the right and bottom border are padded with
+// black if pad is set and cropped otherwise.
+func writeTiles(img *image.Gray, size int, pad bool, path func(col, row int) string) {
+	bounds := img.Bounds()
+	for row := 0; row*size < bounds.Dy(); row++ {
+		for col := 0; col*size < bounds.Dx(); col++ {
+			rect := image.Rect(col*size, row*size, (col+1)*size, (row+1)*size)
+			var tile *image.Gray
+			if pad {
+				tile = image.NewGray(image.Rect(0, 0, size, size))
+				for y := rect.Min.Y; y < min(rect.Max.Y, bounds.Dy()); y++ {
+					copy(tile.Pix[(y-rect.Min.Y)*tile.Stride:], img.Pix[y*img.Stride+rect.Min.X:y*img.Stride+min(rect.Max.X, bounds.Dx())])
+				}
+			} else {
+				tile = img.SubImage(rect.Intersect(bounds)).(*image.Gray)
+			}
+			tilePath := path(col, row)
+			if err := os.MkdirAll(filepath.Dir(tilePath), 0755); err != nil {
+				log.Fatal(err)
+			}
+			writeColorImage(tile, tilePath)
+		}
+	}
+}
+
+// writeTilePyramid writes the given image as tile pyramid so that large results can be viewed in map and deep zoom
+// viewers. In dzi layout a Deep Zoom descriptor name.dzi is written to the given directory together with the
+// directory name_files holding a level for every halving of the image down to a single pixel, as expected by
+// OpenSeadragon. In xyz layout the tiles are written as z/x/y.png where zoom level zero fits into one tile, as used by
+// Leaflet with a simple coordinate reference system.
+func writeTilePyramid(img *image.Gray, dir string, layout string, size int) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	if layout == "xyz" {
+		levels := pyramidLevels(img, size)
+		for i, level := range levels {
+			zoom := len(levels) - 1 - i
+			writeTiles(level, size, true, func(col, row int) string {
+				return filepath.Join(dir, fmt.Sprint(zoom), fmt.Sprint(col), fmt.Sprint(row)+".png")
+			})
+		}
+		return
+	}
+
+	// deep zoom pyramids go down to a single pixel
+	name := strings.TrimSuffix(filepath.Base(dir), filepath.Ext(dir))
+	levels := pyramidLevels(img, 1)
+	for i, level := range levels {
+		number := len(levels) - 1 - i
+		writeTiles(level, size, false, func(col, row int) string {
+			return filepath.Join(dir, name+"_files", fmt.Sprint(number), fmt.Sprintf("%d_%d.png", col, row))
+		})
+	}
+	descriptor := fmt.Sprintf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n"+
+		"<Image xmlns=\"http://schemas.microsoft.com/deepzoom/2008\" TileSize=\"%d\" Overlap=\"0\" Format=\"png\">\n"+
+		"  <Size Width=\"%d\" Height=\"%d\"/>\n</Image>\n", size, img.Bounds().Dx(), img.Bounds().Dy())
+	if err := os.WriteFile(filepath.Join(dir, name+".dzi"), []byte(descriptor), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// enumeration type for denoting how severe a problem with the parameters of a detection is
+type Severity int
+
+const (
+	WARNING Severity = iota // the detection runs but likely not as intended
+	ERROR                   // the detection fails or panics
+)
+
+// ValidationIssue is a problem with the parameters of a detector for an image.
+type ValidationIssue struct {
+	Severity Severity
+	Field    string // name of the parameter of the detector, or image and mask for the input
+	Message  string
+}
+
+// Error returns the issue as message prefixed by its severity.
+func (i ValidationIssue) Error() string {
+	if i.Severity == ERROR {
+		return "error: " + i.Field + ": " + i.Message
+	}
+	return "warning: " + i.Field + ": " + i.Message
+}
+
+// Validate checks the parameters of the detector for an image of the given size with the given mask, a nil mask marks
+// all pixels as valid. All issues found are returned, the detection of the image only succeeds if none of them is an
+// error. Parameters that don't depend on the image are checked if both dimensions are zero.
+func (d *Detector) Validate(width, height int, valid [][]bool) []ValidationIssue {
+	var issues []ValidationIssue
+	add := func(severity Severity, field, format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{severity, field, fmt.Sprintf(format, args...)})
+	}
+
+	// thresholds
+	if d.NoiseThresholds {
+		if !isValidNoiseMultiple(d.MinRatio) {
+			add(ERROR, "MinRatio", "%g is not a non-negative multiple of the noise", d.MinRatio)
+		}
+		if !isValidNoiseMultiple(d.MaxRatio) {
+			add(ERROR, "MaxRatio", "%g is not a non-negative multiple of the noise", d.MaxRatio)
+		}
+	} else {
+		if !isValidRatioValue(d.MinRatio) {
+			add(ERROR, "MinRatio", "%g is not a ratio between 0 and 1", d.MinRatio)
+		}
+		if !isValidRatioValue(d.MaxRatio) {
+			add(ERROR, "MaxRatio", "%g is not a ratio between 0 and 1", d.MaxRatio)
+		}
+	}
+	if d.MinRatio > d.MaxRatio {
+		add(ERROR, "MinRatio", "lower threshold %g is above the upper threshold %g", d.MinRatio, d.MaxRatio)
+	} else if d.MinRatio == d.MaxRatio {
+		add(WARNING, "MinRatio", "equal thresholds disable hysteresis tracking")
+	}
+	if d.NoiseThresholds && d.Percentile != 1 {
+		add(WARNING, "Percentile", "the percentile isn't used by noise thresholds")
+	} else if d.Percentile < 0 || d.Percentile > 1 {
+		add(WARNING, "Percentile", "%g is outside of (0, 1], the maximum gradient is used", d.Percentile)
+	}
+
+	// defects
+	if d.DefectThreshold < 0 {
+		add(ERROR, "DefectThreshold", "multiple of the noise %g is negative", d.DefectThreshold)
+	}
+
+	// background
+	if d.BackgroundRadius < 0 {
+		add(ERROR, "BackgroundRadius", "radius %g is negative", d.BackgroundRadius)
+	} else if d.BackgroundRadius > 0 && d.BackgroundRadius < 1 {
+		add(ERROR, "BackgroundRadius", "radius %g is below one pixel", d.BackgroundRadius)
+	}
+
+	// blur
+	if d.Sigma < 0 {
+		add(ERROR, "Sigma", "standard deviation %g is negative", d.Sigma)
+	}
+	if d.BoxPasses < 0 {
+		add(ERROR, "BoxPasses", "number of passes %d is negative", d.BoxPasses)
+	} else if d.Blur && d.BlurFilter == BOX && d.BoxPasses == 0 {
+		add(WARNING, "BoxPasses", "no passes of the box filter, the image isn't blurred")
+	}
+
+	// postprocessing
+	if d.FringeDistance < 0 {
+		add(ERROR, "FringeDistance", "distance %d is negative", d.FringeDistance)
+	}
+	if d.Despeckle < 0 {
+		add(ERROR, "Despeckle", "minimum number of neighbours %d is negative", d.Despeckle)
+	} else if d.Despeckle > 8 {
+		add(WARNING, "Despeckle", "a pixel has at most 8 neighbours, all edges are removed")
+	}
+	if d.BridgeDistance < 0 {
+		add(ERROR, "BridgeDistance", "distance %g is negative", d.BridgeDistance)
+	}
+	if d.BridgeAngle < 0 || d.BridgeAngle > 180 {
+		add(WARNING, "BridgeAngle", "angle %g is outside of [0, 180] degrees", d.BridgeAngle)
+	}
+
+	if width == 0 && height == 0 {
+		return issues
+	}
+
+	// image size, the mirrored borders of the kernels must lie inside the image
+	size := min(width, height)
+	if width <= 0 || height <= 0 {
+		add(ERROR, "image", "dimensions %dx%d are empty", width, height)
+		return issues
+	}
+	if size < 2 {
+		add(ERROR, "image", "dimensions %dx%d are smaller than the 3x3 gradient kernel", width, height)
+	}
+	if radius := d.blurRadius(); radius >= size {
+		add(ERROR, "Sigma", "blur kernel of radius %d doesn't fit into the image of %dx%d", radius, width, height)
+	} else if d.Blur && d.BlurFilter != BOX && d.Sigma > IIR_SIGMA_THRESHOLD && 3*d.Sigma >= float64(size)/2 {
+		add(WARNING, "Sigma", "blur of standard deviation %g spans most of the image of %dx%d", d.Sigma, width, height)
+	}
+
+	// defect pixels
+	if outside := defectsOutside(d.DefectPixels, width, height); outside > 0 {
+		add(WARNING, "DefectPixels", "%d defect pixels lie outside of the image of %dx%d", outside, width, height)
+	}
+
+	// threshold factors
+	if d.ThresholdFactors != nil {
+		if !matchesImage(d.ThresholdFactors, width, height) {
+			add(ERROR, "ThresholdFactors", "dimensions don't match the image of %dx%d", width, height)
+		} else if !positiveFactors(d.ThresholdFactors) {
+			add(ERROR, "ThresholdFactors", "factors must be positive")
+		}
+	}
+
+	// edge weights
+	if d.EdgeWeights != nil {
+		if !matchesImage(d.EdgeWeights, width, height) {
+			add(ERROR, "EdgeWeights", "dimensions don't match the image of %dx%d", width, height)
+		} else if !nonNegativeWeights(d.EdgeWeights) {
+			add(ERROR, "EdgeWeights", "weights must be non-negative")
+		}
+	}
+
+	// mask
+	if valid != nil {
+		if !matchesImage(valid, width, height) {
+			add(ERROR, "mask", "dimensions don't match the image of %dx%d", width, height)
+		} else if !containsValidPixel(valid) {
+			add(WARNING, "mask", "no pixel is marked as valid, the result has no edges")
+		}
+	}
+	return issues
+}
+
+// matchesImage checks whether the given per pixel values have the given height and every row has the given width.
+func matchesImage[T any](values [][]T, width, height int) bool {
+	if len(values) != height {
+		return false
+	}
+	for _, row := range values {
+		if len(row) != width {
+			return false
+		}
+	}
+	return true
+}
+
+// blurRadius returns the radius of the blur kernel that is convolved with the image, zero if the image isn't blurred
+// by convolution with a kernel.
+func (d *Detector) blurRadius() int {
+	switch {
+	case !d.Blur:
+		return 0
+	case d.BlurFilter == BOX:
+		return 1
+	case d.Sigma > IIR_SIGMA_THRESHOLD:
+		return 0 // the recursive filter has no kernel
+	case d.Sigma > 0:
+		return int(math.Ceil(3 * d.Sigma))
+	}
+	return 2 // 5x5 binomial kernel
+}
+
+// isValidNoiseMultiple checks whether the given value is a valid threshold in multiples of the noise.
+func isValidNoiseMultiple(x float64) bool {
+	return x >= 0 && !math.IsInf(x, 1)
+}
+
+// defectsOutside returns the number of the given defect pixels that lie outside of an image of the given size.
+func defectsOutside(defects []image.Point, width, height int) int {
+	var outside int
+	for _, p := range defects {
+		if !p.In(image.Rect(0, 0, width, height)) {
+			outside++
+		}
+	}
+	return outside
+}
+
+// positiveFactors checks whether all of the given factors are positive and finite.
+func positiveFactors(factors [][]float64) bool {
+	for y := range factors {
+		for _, factor := range factors[y] {
+			if !(factor > 0) || math.IsInf(factor, 1) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// nonNegativeWeights checks whether all of the given weights are non-negative and finite.
+func nonNegativeWeights(weights [][]float64) bool {
+	for y := range weights {
+		for _, weight := range weights[y] {
+			if !(weight >= 0) || math.IsInf(weight, 1) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// containsValidPixel checks whether the given mask marks at least one pixel as valid.
+func containsValidPixel(valid [][]bool) bool {
+	for y := range valid {
+		for _, ok := range valid[y] {
+			if ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasValidationError checks whether any of the given issues is an error.
+func hasValidationError(issues []ValidationIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == ERROR {
+			return true
+		}
+	}
+	return false
+}
+
+// formatIssues returns the given issues one per line.
+func formatIssues(issues []ValidationIssue) string {
+	lines := make([]string, len(issues))
+	for i, issue := range issues {
+		lines[i] = issue.Error()
+	}
+	return strings.Join(lines, "\n")
+}
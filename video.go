@@ -0,0 +1,121 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import "math"
+
+// ECR_TOLERANCE is the distance in pixels up to which an edge pixel counts as present in the other frame when the edge
+// change ratio is computed.
+const ECR_TOLERANCE = 2
+
+// SceneCut is a shot boundary of a multi-frame input. Frame is the index of the first frame of the new shot among the
+// processed frames and Time its start in seconds.
+type SceneCut struct {
+	Frame int     `json:"frame"`
+	Time  float64 `json:"time"`
+	Ratio float64 `json:"ratio"`
+}
+
+// isValidTemporalMode checks whether the given name denotes a supported way of smoothing edges over time.
+func isValidTemporalMode(mode string) bool {
+	return mode == "vote" || mode == "average"
+}
+
+// edgeChangeRatio returns the edge change ratio between two consecutive edge images. It is the larger of the fraction
+// of edge pixels of the current frame that have no edge pixel nearby in the previous frame and the fraction of edge
+// pixels of the previous frame that have no edge pixel nearby in the current frame. Frames without edges don't change.
+func edgeChangeRatio(previous, current [][]GrayPixel) float64 {
+	var entering, exiting, currentCount, previousCount int
+	for y := range current {
+		for x := range current[y] {
+			if current[y][x].y > 0 {
+				currentCount++
+				if !hasEdgeNear(previous, x, y, ECR_TOLERANCE) {
+					entering++
+				}
+			}
+			if previous[y][x].y > 0 {
+				previousCount++
+				if !hasEdgeNear(current, x, y, ECR_TOLERANCE) {
+					exiting++
+				}
+			}
+		}
+	}
+	ratio := 0.0
+	if currentCount > 0 {
+		ratio = float64(entering) / float64(currentCount)
+	}
+	if previousCount > 0 {
+		ratio = max(ratio, float64(exiting)/float64(previousCount))
+	}
+	return ratio
+}
+
+// findSceneCuts returns the scene cuts of the given edge frames, which are all frames whose edge change ratio to their
+// predecessor reaches the given threshold.
+func findSceneCuts(frames []Frame, threshold float64) []SceneCut {
+	cuts := []SceneCut{}
+	for i := 1; i < len(frames); i++ {
+		if ratio := edgeChangeRatio(frames[i-1].Pixels, frames[i].Pixels); ratio >= threshold {
+			cuts = append(cuts, SceneCut{i, frames[i].Time, ratio})
+		}
+	}
+	return cuts
+}
+
+// smoothFrames suppresses flickering edges of the given edge frames by combining every frame with its predecessors
+// within a window of the given number of frames. In vote mode a pixel is an edge if it is one in the majority of these
+// frames, in average mode the fraction of frames it is an edge in is written as gray value. The window starts over at
+// the given scene cuts so edges of different shots are never combined.
+func smoothFrames(frames []Frame, window int, mode string, cuts []SceneCut) {
+	edges := make([][][]GrayPixel, len(frames))
+	for i := range frames {
+		edges[i] = frames[i].Pixels
+	}
+
+	start := 0
+	for i := range frames {
+		for _, cut := range cuts {
+			if cut.Frame == i {
+				start = i
+			}
+		}
+		history := edges[max(start, i-window+1) : i+1]
+		smoothed := make([][]GrayPixel, len(edges[i]))
+		for y := range smoothed {
+			smoothed[y] = make([]GrayPixel, len(edges[i][y]))
+			for x := range smoothed[y] {
+				count := 0
+				for _, frame := range history {
+					if frame[y][x].y > 0 {
+						count++
+					}
+				}
+				var value uint8
+				if mode == "average" {
+					value = uint8(math.Round(255 * float64(count) / float64(len(history))))
+				} else if 2*count > len(history) {
+					value = 255
+				}
+				smoothed[y][x] = GrayPixel{value, 255}
+			}
+		}
+		frames[i].Pixels = smoothed
+	}
+}
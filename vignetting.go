@@ -0,0 +1,146 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// VIGNETTING_BINS is the number of rings the radial profile of an image is divided into to fit its vignetting.
+const VIGNETTING_BINS = 32
+
+// VIGNETTING_MIN_FALLOFF is the least falloff the samples are divided by, which keeps the correction of the corners
+// finite for coefficients that describe more shading than the image has.
+const VIGNETTING_MIN_FALLOFF = 0.1
+
+// parseVignetting parses the value of the vignetting flag, either fit or the comma-separated coefficients k1, k2, ...
+// of the falloff, see Detector.Vignetting.
+func parseVignetting(value string) ([]float64, bool, error) {
+	if value == "fit" {
+		return nil, true, nil
+	}
+	var coefficients []float64
+	for _, field := range strings.Split(value, ",") {
+		coefficient, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || math.IsInf(coefficient, 0) || math.IsNaN(coefficient) {
+			return nil, false, errors.New("expected fit or comma-separated coefficients of the falloff, e.g. -0.3,0.05")
+		}
+		coefficients = append(coefficients, coefficient)
+	}
+	return coefficients, false, nil
+}
+
+// vignettingFalloff returns the falloff 1 + k1 r^2 + k2 r^4 + ... of the brightness at the given radius for the given
+// coefficients.
+func vignettingFalloff(coefficients []float64, radius float64) float64 {
+	falloff, power := 1.0, 1.0
+	for _, k := range coefficients {
+		power *= radius * radius
+		falloff += k * power
+	}
+	return math.Max(falloff, VIGNETTING_MIN_FALLOFF)
+}
+
+// vignettingRadius returns a function that gives the distance of a pixel from the center of an image of the given
+// size, relative to half of its diagonal.
+func vignettingRadius(width, height int) func(x, y int) float64 {
+	cx, cy := float64(width-1)/2, float64(height-1)/2
+	halfDiagonal := math.Max(math.Hypot(cx, cy), 1)
+	return func(x, y int) float64 {
+		return math.Hypot(float64(x)-cx, float64(y)-cy) / halfDiagonal
+	}
+}
+
+// FitVignetting returns the coefficients k1 and k2 of the falloff 1 + k1 r^2 + k2 r^4 that fits the radial profile of
+// the given samples best. The profile is the median of the rings of the image, which the content of a few objects
+// doesn't shift, and is fitted by least squares weighted by the number of pixels of the rings. Only valid pixels are
+// used, nil is returned if there are too few of them.
+func FitVignetting[T Sample](samples [][]T, valid [][]bool) []float64 {
+	height := len(samples)
+	if height == 0 || len(samples[0]) == 0 {
+		return nil
+	}
+	width := len(samples[0])
+	radius := vignettingRadius(width, height)
+	step := max(1, int(math.Sqrt(float64(width*height)/NOISE_SAMPLES)))
+	rings := make([][]float64, VIGNETTING_BINS)
+	for y := 0; y < height; y += step {
+		for x := 0; x < width; x += step {
+			if isValidPixel(valid, x, y) {
+				ring := min(int(radius(x, y)*VIGNETTING_BINS), VIGNETTING_BINS-1)
+				rings[ring] = append(rings[ring], float64(samples[y][x]))
+			}
+		}
+	}
+
+	// the profile is fitted as c + c k1 r^2 + c k2 r^4, which is linear in c, c k1 and c k2
+	var a [3][3]float64
+	var b [3]float64
+	used := 0
+	for ring, values := range rings {
+		if len(values) == 0 {
+			continue
+		}
+		slices.Sort(values)
+		r2 := math.Pow((float64(ring)+0.5)/VIGNETTING_BINS, 2)
+		terms := [3]float64{1, r2, r2 * r2}
+		weight := float64(len(values))
+		for i := range terms {
+			for j := range terms {
+				a[i][j] += weight * terms[i] * terms[j]
+			}
+			b[i] += weight * terms[i] * values[len(values)/2]
+		}
+		used++
+	}
+	if used < 3 {
+		return nil
+	}
+	solution, err := solveLinear([][]float64{a[0][:], a[1][:], a[2][:]}, b[:])
+	if err != nil || solution[0] <= 0 {
+		return nil
+	}
+	return []float64{solution[1] / solution[0], solution[2] / solution[0]}
+}
+
+// correctVignetting divides the given samples by the radial falloff of the detector, which is fitted to the samples
+// if the detector asks for it. The result is clipped to the range of integer sample types, invalid pixels keep their
+// value.
+func correctVignetting[T Sample](d *Detector, samples [][]T, valid [][]bool) [][]T {
+	coefficients := d.Vignetting
+	if d.FitVignetting {
+		coefficients = FitVignetting(samples, valid)
+	}
+	radius := vignettingRadius(len(samples[0]), len(samples))
+	result := make([][]T, len(samples))
+	parallelRows(len(samples), d.Workers, func(y int) {
+		result[y] = make([]T, len(samples[y]))
+		for x, value := range samples[y] {
+			if isValidPixel(valid, x, y) {
+				result[y][x] = clampSample[T](float64(value) / vignettingFalloff(coefficients, radius(x, y)))
+			} else {
+				result[y][x] = value
+			}
+		}
+	})
+	return result
+}
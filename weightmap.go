@@ -0,0 +1,31 @@
+// Copyright (C) 2019 Stefan Laufmann
+//
+// This file is part of edgeefy.
+//
+// edgeefy is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// edgeefy is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with edgeefy.  If not, see <https://www.gnu.org/licenses/>.
+
+package edgeefy
+
+import "math"
+
+// multiplyByWeights multiplies the given magnitude by the given weights in place. Integer samples that would overflow
+// are saturated.
+func multiplyByWeights[T Sample](magnitude [][]T, weights [][]float64) {
+	limit := sampleLimit[T]()
+	for y := range magnitude {
+		for x, value := range magnitude[y] {
+			magnitude[y][x] = T(math.Min(float64(value)*weights[y][x], limit))
+		}
+	}
+}